@@ -0,0 +1,245 @@
+package auditlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/filelock"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+var ErrAuditChainBroken = errors.New("audit chain broken")
+
+const (
+	OperationBootstrap = "bootstrap"
+	OperationSign      = "sign"
+	OperationRevoke    = "revoke"
+	OperationCrlUpdate = "crl_update"
+	OperationRestore   = "restore"
+	OperationSubmit    = "submit"
+	OperationApprove   = "approve"
+	OperationReject    = "reject"
+	OperationTimestamp = "timestamp"
+
+	ResultSuccess = "success"
+	ResultDenied  = "denied"
+	ResultError   = "error"
+)
+
+// The first entry of every chain links to this value.
+const genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+const appendLockTimeout = 30 * time.Second
+
+type EntryType struct {
+	Time      time.Time           `json:"time"`
+	Operation string              `json:"operation"`
+	Requester types.RequesterType `json:"requester"`
+	Result    string              `json:"result"`
+	Error     string              `json:"error,omitempty"`
+	Serial    string              `json:"serial,omitempty"`
+	Detail    string              `json:"detail,omitempty"`
+	PrevHash  string              `json:"prev_hash"`
+	Hash      string              `json:"hash"`
+}
+
+type AuditLogType struct {
+	filename string
+
+	mu sync.Mutex
+}
+
+func Open(filename string) (*AuditLogType, error) {
+	auditLog := &AuditLogType{
+		filename: filename,
+	}
+	if _, _, err := readChain(filename, ""); err != nil {
+		return nil, err
+	}
+	return auditLog, nil
+}
+
+// Append links entry to the last one in the file. Other processes may append
+// to the same file, so the file is locked and its last hash read every time.
+func (auditLog *AuditLogType) Append(entry EntryType) error {
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+
+	fileLock, _, err := filelock.Lock(context.Background(), auditLog.filename+".lock", appendLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer fileLock.Unlock()
+
+	lastHash, err := readLastHash(auditLog.filename)
+	if err != nil {
+		return err
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	entry.PrevHash = lastHash
+	hash, err := computeHash(entry)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	auditFile, err := os.OpenFile(auditLog.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, os.FileMode(0o600))
+	if err != nil {
+		return err
+	}
+	if _, err := auditFile.Write(line); err != nil {
+		auditFile.Close()
+		return err
+	}
+	if err := auditFile.Sync(); err != nil {
+		auditFile.Close()
+		return err
+	}
+	return auditFile.Close()
+}
+
+// Head returns the hash of the last entry, to be recorded where the log
+// cannot be truncated and later given to Verify.
+func (auditLog *AuditLogType) Head() (string, error) {
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+
+	fileLock, _, err := filelock.Lock(context.Background(), auditLog.filename+".lock", appendLockTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer fileLock.Unlock()
+
+	return readLastHash(auditLog.filename)
+}
+
+// readLastHash returns the hash of the last entry without verifying the chain,
+// reading the file backwards from its end.
+func readLastHash(filename string) (string, error) {
+	auditFile, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return genesisHash, nil
+		}
+		return "", err
+	}
+	defer auditFile.Close()
+
+	fileInfo, err := auditFile.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	const chunkSize = 4096
+	tail := []byte{}
+	offset := fileInfo.Size()
+	for offset > 0 {
+		readSize := int64(chunkSize)
+		if offset < readSize {
+			readSize = offset
+		}
+		offset -= readSize
+		chunk := make([]byte, readSize)
+		if _, err := auditFile.ReadAt(chunk, offset); err != nil {
+			return "", err
+		}
+		tail = append(chunk, tail...)
+		trimmedTail := bytes.TrimRight(tail, "\n")
+		if newLineIndex := bytes.LastIndexByte(trimmedTail, '\n'); newLineIndex >= 0 || offset == 0 {
+			var entry EntryType
+			if err := json.Unmarshal(trimmedTail[newLineIndex+1:], &entry); err != nil {
+				return "", fmt.Errorf("%w: last line: %v", ErrAuditChainBroken, err)
+			}
+			return entry.Hash, nil
+		}
+	}
+	return genesisHash, nil
+}
+
+// Verify checks every entry of the log against its hash and the hash of the
+// previous entry, returning the number of entries and the chain head. The
+// chain must reach anchoredHash, a head returned by Head earlier, so that a
+// truncated log is detected; an empty anchoredHash is not checked.
+func Verify(filename string, anchoredHash string) (int, string, error) {
+	return readChain(filename, anchoredHash)
+}
+
+func readChain(filename string, anchoredHash string) (int, string, error) {
+	anchorFound := anchoredHash == "" || anchoredHash == genesisHash
+	auditFile, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, genesisHash, checkAnchor(anchoredHash, anchorFound)
+		}
+		return 0, "", err
+	}
+	defer auditFile.Close()
+
+	scanner := bufio.NewScanner(auditFile)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	count := 0
+	lastHash := genesisHash
+	for scanner.Scan() {
+		lineNumber := count + 1
+		line := scanner.Bytes()
+		var entry EntryType
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&entry); err != nil {
+			return count, lastHash, fmt.Errorf("%w: line %d: %v", ErrAuditChainBroken, lineNumber, err)
+		}
+		if entry.PrevHash != lastHash {
+			return count, lastHash, fmt.Errorf("%w: line %d: previous hash mismatch", ErrAuditChainBroken, lineNumber)
+		}
+		expectedHash, err := computeHash(entry)
+		if err != nil {
+			return count, lastHash, err
+		}
+		if entry.Hash != expectedHash {
+			return count, lastHash, fmt.Errorf("%w: line %d: entry hash mismatch", ErrAuditChainBroken, lineNumber)
+		}
+		lastHash = entry.Hash
+		anchorFound = anchorFound || entry.Hash == anchoredHash
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, lastHash, err
+	}
+	return count, lastHash, checkAnchor(anchoredHash, anchorFound)
+}
+
+func checkAnchor(anchoredHash string, anchorFound bool) error {
+	if !anchorFound {
+		return fmt.Errorf("%w: anchored head %s not found, the log was truncated", ErrAuditChainBroken, anchoredHash)
+	}
+	return nil
+}
+
+func computeHash(entry EntryType) (string, error) {
+	entry.Hash = ""
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(entryBytes)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,130 @@
+package auditlog_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/auditlog"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func writeTestChain(t *testing.T, filename string) {
+	t.Helper()
+	auditLog, err := auditlog.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, serial := range []string{"10", "11", "12"} {
+		if err := auditLog.Append(auditlog.EntryType{
+			Operation: auditlog.OperationSign,
+			Requester: types.RequesterType{Identity: "tester"},
+			Result:    auditlog.ResultSuccess,
+			Serial:    serial,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAuditLogVerify(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeTestChain(t, filename)
+
+	count, head, err := auditlog.Verify(filename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("invalid count %d", count)
+	}
+
+	reopened, err := auditlog.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Append(auditlog.EntryType{
+		Operation: auditlog.OperationRevoke,
+		Result:    auditlog.ResultDenied,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	count, newHead, err := auditlog.Verify(filename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 || newHead == head {
+		t.Fatalf("chain not extended: %d %s", count, newHead)
+	}
+}
+
+func TestAuditLogDetectsEdit(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeTestChain(t, filename)
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = bytes.Replace(content, []byte(`"serial":"11"`), []byte(`"serial":"99"`), 1)
+	if err := os.WriteFile(filename, content, os.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := auditlog.Verify(filename, ""); !errors.Is(err, auditlog.ErrAuditChainBroken) {
+		t.Fatalf("expected broken chain, got %v", err)
+	}
+}
+
+func TestAuditLogDetectsRemoval(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeTestChain(t, filename)
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	content = append(append([]byte{}, lines[0]...), lines[2]...)
+	if err := os.WriteFile(filename, content, os.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := auditlog.Verify(filename, ""); !errors.Is(err, auditlog.ErrAuditChainBroken) {
+		t.Fatalf("expected broken chain, got %v", err)
+	}
+}
+
+func TestAuditLogDetectsTruncation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeTestChain(t, filename)
+
+	auditLog, err := auditlog.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchoredHash, err := auditLog.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, head, err := auditlog.Verify(filename, anchoredHash); err != nil || head != anchoredHash {
+		t.Fatalf("unexpected verification %s %v", head, err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if err := os.WriteFile(filename, bytes.Join(lines[:2], nil), os.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := auditlog.Verify(filename, ""); err != nil {
+		t.Fatalf("truncated chain is consistent without anchor, got %v", err)
+	}
+	if _, _, err := auditlog.Verify(filename, anchoredHash); !errors.Is(err, auditlog.ErrAuditChainBroken) {
+		t.Fatalf("expected broken chain, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package caissuingprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+// CrossSignAll issues the cross certificates declared in cross_signing by
+// the CAs of allCa, both the issuer and the subject must be loaded.
+func CrossSignAll(ctx context.Context, allCa map[string]*OneCaType) error {
+	allErrors := []error{}
+	for _, caId := range slices.Sorted(maps.Keys(allCa)) {
+		subjectCa := allCa[caId]
+		for _, crossSigning := range subjectCa.caConfig.CrossSigning {
+			issuerCa, found := allCa[crossSigning.Issuer]
+			if !found {
+				allErrors = append(allErrors, fmt.Errorf("cross-signing %s: %w %#v", caId, types.ErrInvalidCaId, crossSigning.Issuer))
+				continue
+			}
+			if err := issuerCa.CrossSign(ctx, subjectCa, crossSigning.Validity); err != nil {
+				allErrors = append(allErrors, fmt.Errorf("cross-signing %s by %s: %w", caId, crossSigning.Issuer, err))
+			}
+		}
+	}
+	if len(allErrors) > 0 {
+		return errors.Join(allErrors...)
+	}
+	return nil
+}
@@ -4,33 +4,41 @@ import (
 	"context"
 	"crypto"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/tomaluca95/simple-ca/internal/auditlog"
+	"github.com/tomaluca95/simple-ca/internal/metrics"
 	"github.com/tomaluca95/simple-ca/internal/pemhelper"
 	"github.com/tomaluca95/simple-ca/internal/types"
+	"golang.org/x/crypto/ssh"
 )
 
 var ErrUnknownSerial = errors.New("unknown serial")
+var ErrNotSupportedByStorage = errors.New("operation not supported by storage")
+var ErrNotSupportedByCaKind = errors.New("operation not supported by CA kind")
 
 type OneCaType struct {
-	caConfig              types.CertificateAuthorityType
-	caPrivateKey          crypto.Signer
-	caCertificate         *x509.Certificate
-	caDir                 string
-	dataDir               string
-	crlIndexFilename      string
-	csrSpoolDir           string
-	issuedCertificatesDir string
-	caFilenameCrl         string
-	caFilenamePrivateKey  string
-
-	mu sync.Mutex
+	caId                 string
+	caConfig             types.CertificateAuthorityType
+	caPrivateKey         crypto.Signer
+	caCertificate        *x509.Certificate
+	sshSigner            ssh.Signer
+	tsaPrivateKey        crypto.Signer
+	tsaCertificate       *x509.Certificate
+	tsaMu                sync.Mutex
+	caDir                string
+	caFilenamePrivateKey string
+
+	storage  caStorageType
+	auditLog *auditlog.AuditLogType
 
 	logger types.Logger
 }
@@ -44,12 +52,19 @@ func LoadOneCa(
 ) (*OneCaType, error) {
 	var oneCa OneCaType
 	oneCa.logger = logger
-	e := regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
-	if !e.MatchString(caId) {
+	if !types.CaIdPattern.MatchString(caId) {
 		return nil, fmt.Errorf("%w %#v", types.ErrInvalidCaId, caId)
 	}
 
+	oneCa.caId = caId
 	oneCa.caConfig = caConfig
+	switch caConfig.Kind {
+	case "":
+		oneCa.caConfig.Kind = types.CaKindX509
+	case types.CaKindX509, types.CaKindSsh:
+	default:
+		return nil, fmt.Errorf("%w: %q", types.ErrInvalidCaKind, caConfig.Kind)
+	}
 
 	absDataDirectory, err := filepath.Abs(dataDirectory)
 	if err != nil {
@@ -62,63 +77,94 @@ func LoadOneCa(
 
 	oneCa.caDir = filepath.Join(absDataDirectory, caId)
 
-	oneCa.dataDir = filepath.Join(oneCa.caDir, "data")
-
-	oneCa.crlIndexFilename = filepath.Join(oneCa.dataDir, "crl.yml")
-	oneCa.csrSpoolDir = filepath.Join(oneCa.dataDir, "csr")
-	oneCa.issuedCertificatesDir = filepath.Join(oneCa.dataDir, "crt")
-
-	oneCa.caFilenameCrl = filepath.Join(oneCa.caDir, "ca.crl.pem")
 	oneCa.caFilenamePrivateKey = filepath.Join(oneCa.caDir, "ca.key.pem")
 
 	if err := os.MkdirAll(oneCa.caDir, os.FileMode(0o711)); err != nil {
 		return nil, fmt.Errorf("%s: %w", oneCa.caDir, err)
 	}
-	if err := os.MkdirAll(oneCa.dataDir, os.FileMode(0o711)); err != nil {
-		return nil, fmt.Errorf("%s: %w", oneCa.dataDir, err)
+
+	auditLog, err := auditlog.Open(auditLogFilename(oneCa.caDir))
+	if err != nil {
+		return nil, err
 	}
-	if err := os.MkdirAll(oneCa.csrSpoolDir, os.FileMode(0o755)); err != nil {
-		return nil, fmt.Errorf("%s: %w", oneCa.csrSpoolDir, err)
+	oneCa.auditLog = auditLog
+
+	gitSigner, err := loadGitCommitSigner(oneCa.caConfig.Git)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading git signing key: %w", err)
 	}
-	if err := os.MkdirAll(oneCa.issuedCertificatesDir, os.FileMode(0o755)); err != nil {
-		return nil, fmt.Errorf("%s: %w", oneCa.issuedCertificatesDir, err)
+
+	storage, err := newCaStorage(logger, caId, oneCa.caDir, oneCa.caConfig, gitSigner)
+	if err != nil {
+		return nil, err
 	}
+	oneCa.storage = storage
 
-	switch keyConfigData := oneCa.caConfig.KeyConfig.Config.(type) {
-	case types.KeyTypeRsaConfigType:
-		caPrivateKey, err := getRsaPrivateKeyOrCreateNew(
-			logger,
-			oneCa.caFilenamePrivateKey,
-			keyConfigData.Size,
-		)
-		if err != nil {
-			return nil, err
-		}
-		oneCa.caPrivateKey = caPrivateKey
-	case types.KeyTypeEcdsaConfigType:
-		caPrivateKey, err := getEcdsaPrivateKeyOrCreateNew(
-			logger,
-			oneCa.caFilenamePrivateKey,
-			keyConfigData.CurveName,
-		)
-		if err != nil {
-			return nil, err
-		}
-		oneCa.caPrivateKey = caPrivateKey
-	default:
-		return nil, fmt.Errorf("%w: %T", types.ErrInvalidKeyType, keyConfigData)
+	if err := oneCa.storage.Recover(ctx); err != nil {
+		return nil, err
 	}
 
-	if err := oneCa.gitSnapshot(
+	bootstrapping := false
+	// not anchoring the audit log, so that loading an existing CA changes
+	// nothing
+	if err := oneCa.storage.Transaction(
+		ctx,
 		"loading root certificate",
-		func() error {
+		func(tx caStorageTxType) error {
+			// The key is loaded inside the transaction so that two processes
+			// bootstrapping the same CA never generate two different keys.
+			if oneCa.caConfig.Kind == types.CaKindSsh {
+				_, err := os.Stat(oneCa.caFilenamePrivateKey)
+				bootstrapping = os.IsNotExist(err)
+			} else {
+				caCertificateContent, err := tx.ReadCertificate(big.NewInt(1))
+				if err != nil {
+					return err
+				}
+				bootstrapping = caCertificateContent == nil
+			}
+
+			switch keyConfigData := oneCa.caConfig.KeyConfig.Config.(type) {
+			case types.KeyTypeRsaConfigType:
+				caPrivateKey, err := getRsaPrivateKeyOrCreateNew(
+					logger,
+					oneCa.caFilenamePrivateKey,
+					keyConfigData.Size,
+				)
+				if err != nil {
+					return err
+				}
+				oneCa.caPrivateKey = caPrivateKey
+			case types.KeyTypeEcdsaConfigType:
+				caPrivateKey, err := getEcdsaPrivateKeyOrCreateNew(
+					logger,
+					oneCa.caFilenamePrivateKey,
+					keyConfigData.CurveName,
+				)
+				if err != nil {
+					return err
+				}
+				oneCa.caPrivateKey = caPrivateKey
+			default:
+				return fmt.Errorf("%w: %T", types.ErrInvalidKeyType, keyConfigData)
+			}
+
+			if oneCa.caConfig.Kind == types.CaKindSsh {
+				sshSigner, err := ssh.NewSignerFromSigner(oneCa.caPrivateKey)
+				if err != nil {
+					return err
+				}
+				oneCa.sshSigner = sshSigner
+				return nil
+			}
+
 			caCertificateTpl, err := getx509CaCertificateTpl(oneCa.caConfig)
 			if err != nil {
 				return err
 			}
 			caCertificate, err := getCertificateOrCreateNewSelfSigned(
 				logger,
-				oneCa.issuedCertificatesDir,
+				tx,
 				caCertificateTpl,
 				caCertificateTpl,
 				oneCa.caPrivateKey,
@@ -130,75 +176,66 @@ func LoadOneCa(
 			return nil
 		},
 	); err != nil {
+		if bootstrapping {
+			oneCa.audit(ctx, auditlog.OperationBootstrap, nil, err)
+		}
 		return nil, err
 	}
-
-	logger.Debug("Loaded CA: %s", oneCa.caCertificate.Issuer.String())
-
-	return &oneCa, nil
-}
-
-func (oneCa *OneCaType) gitSnapshot(
-	msg string,
-	runner func() error,
-) error {
-	oneCa.mu.Lock()
-	defer oneCa.mu.Unlock()
-
-	gitWorktree, err := gitOpenRepository(oneCa.dataDir)
-	if err != nil {
-		return err
-	}
-	if err := gitAddAndCommitGitWorktree(
-		gitWorktree,
-		"Before "+msg,
-	); err != nil {
-		return err
+	if oneCa.caConfig.Kind == types.CaKindSsh {
+		if bootstrapping {
+			if err := oneCa.audit(ctx, auditlog.OperationBootstrap, nil, nil); err != nil {
+				return nil, err
+			}
+		}
+		logger.Info("Loaded SSH CA", "ca_id", caId, "fingerprint", ssh.FingerprintSHA256(oneCa.sshSigner.PublicKey()))
+		return &oneCa, nil
 	}
-	if err := runner(); err != nil {
-		return err
+	if bootstrapping {
+		if err := oneCa.audit(ctx, auditlog.OperationBootstrap, oneCa.caCertificate.SerialNumber, nil); err != nil {
+			return nil, err
+		}
 	}
-	if err := gitAddAndCommitGitWorktree(
-		gitWorktree,
-		"After "+msg,
-	); err != nil {
-		return err
+
+	if oneCa.caConfig.Tsa != nil {
+		if err := oneCa.loadTsa(ctx); err != nil {
+			return nil, err
+		}
 	}
-	return nil
+
+	logger.Info("Loaded CA", "ca_id", caId, "issuer", oneCa.caCertificate.Issuer.String())
+
+	return &oneCa, nil
 }
 
-func (oneCa *OneCaType) UpdateCrl() error {
-	if err := oneCa.gitSnapshot(
+func (oneCa *OneCaType) UpdateCrl(ctx context.Context) error {
+	if err := oneCa.transaction(
+		ctx,
 		"crl update",
-		func() error {
-			if err := updateCrl(
-				oneCa.crlIndexFilename,
-				oneCa.caFilenameCrl,
-				oneCa.caConfig.CrlTtl,
-				oneCa.caCertificate,
-				oneCa.caPrivateKey,
-				[]*big.Int{},
-			); err != nil {
+		func(tx caStorageTxType) error {
+			if err := oneCa.updateRevocationList(tx, []*big.Int{}); err != nil {
 				return err
 			}
-			return nil
+			// the restored anchor is older than the log
+			return oneCa.anchorAuditLog(tx)
 		},
 	); err != nil {
+		oneCa.audit(ctx, auditlog.OperationCrlUpdate, nil, err)
 		return err
 	}
-	return nil
+	return oneCa.audit(ctx, auditlog.OperationCrlUpdate, nil, nil)
 }
 
-func (oneCa *OneCaType) IssueAllCsrInQueue() error {
-	csrItems, err := os.ReadDir(oneCa.csrSpoolDir)
+// IssueAllCsrInQueue processes the CSR spool once, the CSRs that were
+// rejected or failed are reported in the returned error.
+func (oneCa *OneCaType) IssueAllCsrInQueue(ctx context.Context) error {
+	results, err := oneCa.ProcessCsrSpool(ctx, 0)
 	if err != nil {
 		return err
 	}
 	allErrors := []error{}
-	for _, csrItem := range csrItems {
-		csrFilename := filepath.Join(oneCa.csrSpoolDir, csrItem.Name())
-		if _, err := oneCa.SignCsrFile(csrFilename); err != nil {
-			allErrors = append(allErrors, err)
+	for _, result := range results {
+		if result.Err != nil {
+			allErrors = append(allErrors, fmt.Errorf("%s: %w", result.Name, result.Err))
 		}
 	}
 	if len(allErrors) > 0 {
@@ -207,75 +244,385 @@ func (oneCa *OneCaType) IssueAllCsrInQueue() error {
 	return nil
 }
 
-func (oneCa *OneCaType) SignCsrFile(csrFilename string) ([]byte, error) {
+// SignCsrFile signs the CSR in csrFilename and removes the file once the
+// certificate is stored.
+func (oneCa *OneCaType) SignCsrFile(ctx context.Context, csrFilename string) ([]byte, error) {
+	return oneCa.signCsr(ctx, "issuing "+csrFilename, func(tx caStorageTxType) (csrToSignType, error) {
+		csrContent, err := os.ReadFile(csrFilename)
+		return csrToSignType{content: csrContent}, err
+	}, func(tx caStorageTxType, serialNumber *big.Int) error {
+		return os.Remove(csrFilename)
+	})
+}
+
+func (oneCa *OneCaType) SignCsr(ctx context.Context, csrContent []byte) ([]byte, error) {
+	return oneCa.SignCsrWithProfile(ctx, csrContent, "", nil)
+}
+
+// SignCsrWithProfile signs csrContent with the rules of the named profile,
+// the default profile when empty; subjectValues are given to the subject
+// template of the profile.
+func (oneCa *OneCaType) SignCsrWithProfile(ctx context.Context, csrContent []byte, profileName string, subjectValues map[string]string) ([]byte, error) {
+	return oneCa.signCsr(ctx, "issuing csr", func(tx caStorageTxType) (csrToSignType, error) {
+		return csrToSignType{content: csrContent, profile: profileName, subjectValues: subjectValues}, nil
+	}, func(tx caStorageTxType, serialNumber *big.Int) error {
+		return nil
+	})
+}
+
+// CertificateSubject returns the subject of the certificate SignCsrWithProfile
+// would issue for csrContent, for the policy to authorize.
+func (oneCa *OneCaType) CertificateSubject(csrContent []byte, profileName string, subjectValues map[string]string) (pkix.Name, error) {
+	profile, err := oneCa.Profile(profileName)
+	if err != nil {
+		return pkix.Name{}, err
+	}
+	csr, err := pemhelper.FromAnyToCertificateRequest(csrContent)
+	if err != nil {
+		return pkix.Name{}, fmt.Errorf("%w: %v", ErrInvalidCsr, err)
+	}
+	return rewriteSubject(oneCa.caCertificate.Subject, csr, profile.Subject, subjectValues)
+}
+
+// csrToSignType is a CSR with the profile and the subject values it is
+// signed with.
+type csrToSignType struct {
+	content       []byte
+	profile       string
+	subjectValues map[string]string
+}
+
+func (oneCa *OneCaType) signCsr(
+	ctx context.Context,
+	msg string,
+	readCsr func(tx caStorageTxType) (csrToSignType, error),
+	afterSign func(tx caStorageTxType, serialNumber *big.Int) error,
+) ([]byte, error) {
+	if oneCa.caConfig.Kind == types.CaKindSsh {
+		return nil, fmt.Errorf("%w: CSR on a CA of kind ssh", ErrNotSupportedByCaKind)
+	}
+	startTime := time.Now()
 	var pemBytes []byte
-	if err := oneCa.gitSnapshot(
-		"issuing "+csrFilename,
-		func() error {
+	var serialNumber *big.Int
+	if err := oneCa.transaction(
+		ctx,
+		msg,
+		func(tx caStorageTxType) error {
+			csrToSign, err := readCsr(tx)
+			if err != nil {
+				return err
+			}
+			if csrToSign.content == nil {
+				return fmt.Errorf("%w: csr not found", ErrInvalidCsr)
+			}
+			profile, err := oneCa.Profile(csrToSign.profile)
+			if err != nil {
+				return err
+			}
 
-			newPemBytes, err := signOneCsr(
-				oneCa.logger,
+			newPemBytes, newSerialNumber, err := signOneCsr(
+				types.LoggerFromContext(ctx, oneCa.logger),
 				oneCa.caCertificate,
 				oneCa.caPrivateKey,
-				csrFilename,
-				oneCa.issuedCertificatesDir,
+				csrToSign.content,
+				profile,
+				csrToSign.subjectValues,
+				oneCa.maxActivePerSan(),
+				oneCa.embedSct(),
+				tx,
 			)
 			if err != nil {
 				return err
 			}
 			pemBytes = newPemBytes
-			return nil
+			serialNumber = newSerialNumber
+			return afterSign(tx, serialNumber)
 		},
 	); err != nil {
+		if errors.Is(err, ErrInvalidCsr) {
+			metrics.InvalidCsrTotal.WithLabelValues(oneCa.caId).Inc()
+		} else if errors.Is(err, ErrQuotaExceeded) {
+			metrics.ThrottledTotal.WithLabelValues(oneCa.caId, metrics.ThrottledReasonSanQuota).Inc()
+		}
+		oneCa.audit(ctx, auditlog.OperationSign, serialNumber, err)
+		return nil, err
+	}
+	metrics.SignDuration.WithLabelValues(oneCa.caId).Observe(time.Since(startTime).Seconds())
+	metrics.CertificatesIssuedTotal.WithLabelValues(oneCa.caId).Inc()
+	if err := oneCa.audit(ctx, auditlog.OperationSign, serialNumber, nil); err != nil {
 		return nil, err
 	}
 	return pemBytes, nil
 }
 
-func (oneCa *OneCaType) RevokeOneSerial(crtSerial *big.Int) error {
-	if err := oneCa.gitSnapshot(
+func (oneCa *OneCaType) RevokeOneSerial(ctx context.Context, crtSerial *big.Int) error {
+	if err := oneCa.transaction(
+		ctx,
 		"revoking "+crtSerial.String(),
-		func() error {
-			certificateFilename := filepath.Join(oneCa.issuedCertificatesDir, crtSerial.String()+".crt.pem")
-			if _, err := os.Stat(certificateFilename); err != nil {
-				if os.IsNotExist(err) {
-					return fmt.Errorf("%w: %s", ErrUnknownSerial, crtSerial.String())
-				}
-				return err
+		func(tx caStorageTxType) error {
+			readCertificate := tx.ReadCertificate
+			if oneCa.caConfig.Kind == types.CaKindSsh {
+				readCertificate = tx.ReadSshCertificate
 			}
-			if err := updateCrl(
-				oneCa.crlIndexFilename,
-				oneCa.caFilenameCrl,
-				oneCa.caConfig.CrlTtl,
-				oneCa.caCertificate,
-				oneCa.caPrivateKey,
-				[]*big.Int{crtSerial},
-			); err != nil {
+			certificateContent, err := readCertificate(crtSerial)
+			if err != nil {
 				return err
 			}
-			return nil
+			if certificateContent == nil {
+				return fmt.Errorf("%w: %s", ErrUnknownSerial, crtSerial.String())
+			}
+			return oneCa.updateRevocationList(tx, []*big.Int{crtSerial})
 		},
 	); err != nil {
+		oneCa.audit(ctx, auditlog.OperationRevoke, crtSerial, err)
 		return err
 	}
-	return nil
+	metrics.RevocationsTotal.WithLabelValues(oneCa.caId).Inc()
+	return oneCa.audit(ctx, auditlog.OperationRevoke, crtSerial, nil)
+}
+
+func (oneCa *OneCaType) History() ([]HistoryEntryType, error) {
+	storageWithHistory, ok := oneCa.storage.(caStorageWithHistoryType)
+	if !ok {
+		return nil, ErrNotSupportedByStorage
+	}
+	return storageWithHistory.History()
+}
+
+// RestoreTo reverts the CA data to the content of a previous commit. Issued
+// certificates, published revocations, the CSR spool and the sign requests
+// are never reverted and the CRL is signed again with a number higher than
+// the one currently published.
+func (oneCa *OneCaType) RestoreTo(ctx context.Context, revision string) error {
+	storageWithHistory, ok := oneCa.storage.(caStorageWithHistoryType)
+	if !ok {
+		return ErrNotSupportedByStorage
+	}
+	if err := storageWithHistory.RestoreTo(
+		ctx,
+		revision,
+		func(tx caStorageTxType) error {
+			return oneCa.updateRevocationList(tx, []*big.Int{})
+		},
+	); err != nil {
+		oneCa.audit(ctx, auditlog.OperationRestore, nil, err)
+		return err
+	}
+	return oneCa.audit(ctx, auditlog.OperationRestore, nil, nil)
 }
 
 func (oneCa *OneCaType) GetCrlPem() ([]byte, error) {
-	fileContent, err := os.ReadFile(oneCa.caFilenameCrl)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
+	if oneCa.caConfig.Kind == types.CaKindSsh {
+		return nil, fmt.Errorf("%w: CRL of a CA of kind ssh", ErrNotSupportedByCaKind)
+	}
+	var fileContent []byte
+	if err := oneCa.storage.View(func(tx caStorageTxType) error {
+		content, err := tx.ReadCrl()
+		fileContent = content
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return fileContent, nil
+}
+
+// Status reads the state of the CA that is worth monitoring.
+func (oneCa *OneCaType) Status() (metrics.CaStatusType, error) {
+	if oneCa.caConfig.Kind == types.CaKindSsh {
+		return oneCa.sshStatus()
+	}
+	status := metrics.CaStatusType{
+		CertificateNotAfter: oneCa.caCertificate.NotAfter,
+	}
+	if err := oneCa.storage.View(func(tx caStorageTxType) error {
+		crlPemBytes, err := tx.ReadCrl()
+		if err != nil {
+			return err
+		}
+		if crlPemBytes != nil {
+			crl, err := parseCrlPem(crlPemBytes)
+			if err != nil {
+				return err
+			}
+			status.CrlNextUpdate = crl.NextUpdate
+			status.RevokedEntries = len(crl.RevokedCertificateEntries)
+		}
+		csrNames, err := tx.ListCsrQueue()
+		if err != nil {
+			return err
+		}
+		status.CsrQueueDepth = len(csrNames)
+		signRequestIds, err := tx.ListSignRequestIds()
+		if err != nil {
+			return err
+		}
+		for _, id := range signRequestIds {
+			signRequest, err := readSignRequest(tx, id)
+			if err != nil {
+				return err
+			}
+			if signRequest.Status == SignRequestPending {
+				status.PendingSignRequests++
+			}
 		}
+		return nil
+	}); err != nil {
+		return status, err
+	}
+	return status, nil
+}
+
+// GetCertificatePem returns an issued certificate.
+func (oneCa *OneCaType) GetCertificatePem(serial *big.Int) ([]byte, error) {
+	var fileContent []byte
+	if err := oneCa.storage.View(func(tx caStorageTxType) error {
+		content, err := tx.ReadCertificate(serial)
+		fileContent = content
+		return err
+	}); err != nil {
 		return nil, err
 	}
+	if fileContent == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSerial, serial.String())
+	}
 	return fileContent, nil
 }
 
 func (oneCa *OneCaType) GetIssuerPem() ([]byte, error) {
+	if oneCa.caConfig.Kind == types.CaKindSsh {
+		return nil, fmt.Errorf("%w: issuer certificate of a CA of kind ssh", ErrNotSupportedByCaKind)
+	}
 	fileContent, err := pemhelper.ToPem(oneCa.caCertificate)
 	if err != nil {
 		return nil, err
 	}
 	return fileContent, nil
 }
+
+// AuditRecord appends an entry for an attempt that did not reach the CA, such
+// as a request denied by OPA.
+func (oneCa *OneCaType) AuditRecord(
+	ctx context.Context,
+	operation string,
+	result string,
+	serial *big.Int,
+	reason error,
+) error {
+	entry := auditlog.EntryType{
+		Operation: operation,
+		Requester: types.RequesterFromContext(ctx),
+		Result:    result,
+	}
+	if serial != nil {
+		entry.Serial = serial.String()
+	}
+	if reason != nil {
+		entry.Error = reason.Error()
+	}
+	if err := oneCa.auditLog.Append(entry); err != nil {
+		types.LoggerFromContext(ctx, oneCa.logger).Error("Failed writing audit entry", "ca_id", oneCa.caId, "operation", operation, "err", err)
+		return err
+	}
+	return nil
+}
+
+// transaction runs runner in a transaction of the storage that also records
+// the head of the audit log, so that a truncated log fails VerifyAuditLog.
+// The head is the one before the operation, whose entry is appended once the
+// transaction is over.
+func (oneCa *OneCaType) transaction(
+	ctx context.Context,
+	msg string,
+	runner func(tx caStorageTxType) error,
+) error {
+	return oneCa.storage.Transaction(ctx, msg, func(tx caStorageTxType) error {
+		if err := runner(tx); err != nil {
+			return err
+		}
+		return oneCa.anchorAuditLog(tx)
+	})
+}
+
+func (oneCa *OneCaType) anchorAuditLog(tx caStorageTxType) error {
+	head, err := oneCa.auditLog.Head()
+	if err != nil {
+		return err
+	}
+	return tx.WriteAuditHead([]byte(head + "\n"))
+}
+
+func (oneCa *OneCaType) audit(
+	ctx context.Context,
+	operation string,
+	serial *big.Int,
+	operationErr error,
+) error {
+	if errors.Is(operationErr, ErrQuotaExceeded) {
+		return oneCa.AuditRecord(ctx, operation, auditlog.ResultDenied, serial, operationErr)
+	}
+	if operationErr != nil {
+		return oneCa.AuditRecord(ctx, operation, auditlog.ResultError, serial, operationErr)
+	}
+	return oneCa.AuditRecord(ctx, operation, auditlog.ResultSuccess, serial, nil)
+}
+
+// updateRevocationList adds serials to the revocations and signs the CRL, or
+// the KRL for a CA of kind ssh.
+func (oneCa *OneCaType) updateRevocationList(tx caStorageTxType, addSerialsToRevoked []*big.Int) error {
+	if oneCa.caConfig.Kind == types.CaKindSsh {
+		return updateKrl(tx, oneCa.sshSigner.PublicKey(), "simple-ca "+oneCa.caId, addSerialsToRevoked)
+	}
+	return updateCrl(
+		tx,
+		oneCa.caConfig.CrlTtl,
+		oneCa.caCertificate,
+		oneCa.caPrivateKey,
+		addSerialsToRevoked,
+	)
+}
+
+func (oneCa *OneCaType) maxActivePerSan() int {
+	if oneCa.caConfig.Limits == nil {
+		return 0
+	}
+	return oneCa.caConfig.Limits.MaxActiveCertificatesPerSan
+}
+
+func (oneCa *OneCaType) embedSct() bool {
+	return oneCa.caConfig.TransparencyLog != nil && oneCa.caConfig.TransparencyLog.EmbedSct
+}
+
+func auditLogFilename(caDir string) string {
+	return filepath.Join(caDir, "audit.jsonl")
+}
+
+// VerifyAuditLog checks the hash chain of the audit log of one CA against the
+// head recorded in its storage, without loading the CA itself.
+func VerifyAuditLog(
+	logger types.Logger,
+	dataDirectory string,
+	caId string,
+	caConfig types.CertificateAuthorityType,
+) (int, string, error) {
+	absDataDirectory, err := filepath.Abs(dataDirectory)
+	if err != nil {
+		return 0, "", err
+	}
+	caDir := filepath.Join(absDataDirectory, caId)
+	if _, err := os.Stat(caDir); os.IsNotExist(err) {
+		return auditlog.Verify(auditLogFilename(caDir), "")
+	}
+	storage, err := newCaStorage(logger, caId, caDir, caConfig, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	var anchoredHash []byte
+	if err := storage.View(func(tx caStorageTxType) error {
+		var err error
+		anchoredHash, err = tx.ReadAuditHead()
+		return err
+	}); err != nil {
+		return 0, "", err
+	}
+	return auditlog.Verify(auditLogFilename(caDir), strings.TrimSpace(string(anchoredHash)))
+}
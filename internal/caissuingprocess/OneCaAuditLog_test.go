@@ -0,0 +1,51 @@
+package caissuingprocess_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/auditlog"
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func TestVerifyAuditLogDetectsTruncation(t *testing.T) {
+	for _, storageType := range []string{caissuingprocess.StorageTypeFilesystem, caissuingprocess.StorageTypeBbolt} {
+		t.Run(storageType, func(t *testing.T) {
+			ctx := context.Background()
+			dataDirectory := t.TempDir()
+			caConfig := gitTestCaConfig(nil)
+			caConfig.Storage = &types.StorageConfigType{Type: storageType}
+			oneCa, err := caissuingprocess.LoadOneCa(ctx, &types.StdLogger{}, "test_ca_1", dataDirectory, caConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				if err := oneCa.UpdateCrl(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if count, _, err := caissuingprocess.VerifyAuditLog(&types.StdLogger{}, dataDirectory, "test_ca_1", caConfig); err != nil || count != 3 {
+				t.Fatalf("unexpected verification %d %v", count, err)
+			}
+
+			// only the bootstrap entry is left, the chain itself is still consistent
+			auditFilename := filepath.Join(dataDirectory, "test_ca_1", "audit.jsonl")
+			content, err := os.ReadFile(auditFilename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := bytes.SplitAfter(content, []byte("\n"))
+			if err := os.WriteFile(auditFilename, lines[0], os.FileMode(0o600)); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := caissuingprocess.VerifyAuditLog(&types.StdLogger{}, dataDirectory, "test_ca_1", caConfig); !errors.Is(err, auditlog.ErrAuditChainBroken) {
+				t.Fatalf("expected broken chain, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package caissuingprocess_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func TestBboltSignRevokeAndReload(t *testing.T) {
+	logger := &types.StdLogger{}
+	dataDirectory := t.TempDir()
+	caId := "test_ca_1"
+
+	configData := types.CertificateAuthorityType{
+		Subject: types.CertificateAuthoritySubjectType{
+			CommonName: "test_ca_1",
+		},
+		KeyConfig: types.KeyConfigType{
+			Type: "ecdsa",
+			Config: types.KeyTypeEcdsaConfigType{
+				CurveName: "P-256",
+			},
+		},
+		CrlTtl: 12 * time.Hour,
+		Storage: &types.StorageConfigType{
+			Type: caissuingprocess.StorageTypeBbolt,
+		},
+	}
+	oneCa, err := caissuingprocess.LoadOneCa(context.Background(), logger, caId, dataDirectory, configData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerPem, err := oneCa.GetIssuerPem()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "name 1"},
+	}, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes, err := oneCa.SignCsr(context.Background(), pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE REQUEST", Bytes: csr,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := pemhelper.FromPemToCertificate(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := oneCa.RevokeOneSerial(context.Background(), crt.SerialNumber); err != nil {
+		t.Fatal(err)
+	}
+	if err := oneCa.RevokeOneSerial(context.Background(), big.NewInt(12345)); !errors.Is(err, caissuingprocess.ErrUnknownSerial) {
+		t.Fatalf("expected unknown serial, got %v", err)
+	}
+	if _, err := oneCa.History(); !errors.Is(err, caissuingprocess.ErrNotSupportedByStorage) {
+		t.Fatalf("expected unsupported history, got %v", err)
+	}
+
+	reloadedCa, err := caissuingprocess.LoadOneCa(context.Background(), logger, caId, dataDirectory, configData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloadedIssuerPem, err := reloadedCa.GetIssuerPem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reloadedIssuerPem) != string(issuerPem) {
+		t.Fatal("CA certificate changed after reload")
+	}
+
+	crlPem, err := reloadedCa.GetCrlPem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBlock, _ := pem.Decode(crlPem)
+	if pemBlock == nil {
+		t.Fatal("no CRL")
+	}
+	crl, err := x509.ParseRevocationList(pemBlock.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, r := range crl.RevokedCertificateEntries {
+		found = found || r.SerialNumber.Cmp(crt.SerialNumber) == 0
+	}
+	if !found {
+		t.Fatal("crl not containing")
+	}
+}
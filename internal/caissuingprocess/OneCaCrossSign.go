@@ -0,0 +1,102 @@
+package caissuingprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/tomaluca95/simple-ca/internal/auditlog"
+	"github.com/tomaluca95/simple-ca/internal/metrics"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+var ErrUnknownCrossCertificate = errors.New("unknown cross certificate")
+
+// CrossSign issues, or renews when needed, the cross certificate of subjectCa
+// signed by this CA. The certificate is recorded as issued by this CA and
+// kept in the directory of subjectCa.
+func (oneCa *OneCaType) CrossSign(
+	ctx context.Context,
+	subjectCa *OneCaType,
+	validity types.CertificateAuthorityValidityType,
+) error {
+	if oneCa.caConfig.Kind == types.CaKindSsh || subjectCa.caConfig.Kind == types.CaKindSsh {
+		return fmt.Errorf("%w: cross-signing with a CA of kind ssh", ErrNotSupportedByCaKind)
+	}
+	var crossCertificateSerial *big.Int
+	err := oneCa.transaction(
+		ctx,
+		"cross signing "+subjectCa.caId,
+		func(tx caStorageTxType) error {
+			_, newSerial, err := getCrossCertificateOrCreateNew(
+				types.LoggerFromContext(ctx, oneCa.logger),
+				tx,
+				subjectCa.crossCertificateFilename(oneCa.caId),
+				validity,
+				oneCa.expiryWarning(),
+				oneCa.caCertificate,
+				oneCa.caPrivateKey,
+				subjectCa.caCertificate,
+			)
+			crossCertificateSerial = newSerial
+			return err
+		},
+	)
+	if err != nil {
+		oneCa.audit(ctx, auditlog.OperationSign, crossCertificateSerial, err)
+		return err
+	}
+	if crossCertificateSerial == nil {
+		return nil
+	}
+	metrics.CertificatesIssuedTotal.WithLabelValues(oneCa.caId).Inc()
+	types.LoggerFromContext(ctx, oneCa.logger).Info("Cross-signed CA", "ca_id", subjectCa.caId, "issuer", oneCa.caId, "serial", crossCertificateSerial.String())
+	return oneCa.audit(ctx, auditlog.OperationSign, crossCertificateSerial, nil)
+}
+
+// GetCrossCertificatePem returns the certificate of this CA signed by the CA
+// issuerCaId, as declared in cross_signing.
+func (oneCa *OneCaType) GetCrossCertificatePem(issuerCaId string) ([]byte, error) {
+	found := false
+	for _, crossSigning := range oneCa.caConfig.CrossSigning {
+		found = found || crossSigning.Issuer == issuerCaId
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCrossCertificate, issuerCaId)
+	}
+	fileContent, err := os.ReadFile(oneCa.crossCertificateFilename(issuerCaId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %q not issued yet", ErrUnknownCrossCertificate, issuerCaId)
+		}
+		return nil, err
+	}
+	return fileContent, nil
+}
+
+// GetChainCrossCertificatesPem returns the issued cross certificates that are
+// included in chains, in the order of cross_signing.
+func (oneCa *OneCaType) GetChainCrossCertificatesPem() ([][]byte, error) {
+	allPem := [][]byte{}
+	for _, crossSigning := range oneCa.caConfig.CrossSigning {
+		if !crossSigning.IncludeInChain {
+			continue
+		}
+		fileContent, err := oneCa.GetCrossCertificatePem(crossSigning.Issuer)
+		if err != nil {
+			if errors.Is(err, ErrUnknownCrossCertificate) {
+				continue
+			}
+			return nil, err
+		}
+		allPem = append(allPem, fileContent)
+	}
+	return allPem, nil
+}
+
+func (oneCa *OneCaType) crossCertificateFilename(issuerCaId string) string {
+	return filepath.Join(oneCa.caDir, "cross", issuerCaId+".crt.pem")
+}
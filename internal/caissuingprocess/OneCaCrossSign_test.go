@@ -0,0 +1,118 @@
+package caissuingprocess_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func TestCrossSign(t *testing.T) {
+	ctx := context.Background()
+	dataDirectory := t.TempDir()
+	caConfigs := map[string]types.CertificateAuthorityType{
+		"old_root": {
+			Subject:  types.CertificateAuthoritySubjectType{CommonName: "Old Root"},
+			Validity: types.CertificateAuthorityValidityType{Years: 2},
+			KeyConfig: types.KeyConfigType{
+				Type:   "rsa",
+				Config: types.KeyTypeRsaConfigType{Size: 2048},
+			},
+			CrlTtl: 12 * time.Hour,
+		},
+		"new_root": {
+			Subject:  types.CertificateAuthoritySubjectType{CommonName: "New Root"},
+			Validity: types.CertificateAuthorityValidityType{Years: 5},
+			KeyConfig: types.KeyConfigType{
+				Type:   "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{CurveName: "P-256"},
+			},
+			CrlTtl: 12 * time.Hour,
+			CrossSigning: []types.CrossSigningType{{
+				Issuer:         "old_root",
+				Validity:       types.CertificateAuthorityValidityType{Years: 1},
+				IncludeInChain: true,
+			}},
+		},
+	}
+	loadAll := func() map[string]*caissuingprocess.OneCaType {
+		t.Helper()
+		allCa := map[string]*caissuingprocess.OneCaType{}
+		for caId, caConfig := range caConfigs {
+			oneCa, err := caissuingprocess.LoadOneCa(ctx, &types.StdLogger{}, caId, dataDirectory, caConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			allCa[caId] = oneCa
+		}
+		if err := caissuingprocess.CrossSignAll(ctx, allCa); err != nil {
+			t.Fatal(err)
+		}
+		return allCa
+	}
+	allCa := loadAll()
+
+	certificateOf := func(pemBytes []byte, err error) *x509.Certificate {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		certificate, err := pemhelper.FromPemToCertificate(pemBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return certificate
+	}
+	oldRootCertificate := certificateOf(allCa["old_root"].GetIssuerPem())
+	newRootCertificate := certificateOf(allCa["new_root"].GetIssuerPem())
+	crossCertificate := certificateOf(allCa["new_root"].GetCrossCertificatePem("old_root"))
+
+	if crossCertificate.Subject.String() != newRootCertificate.Subject.String() ||
+		!crossCertificate.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(newRootCertificate.PublicKey) ||
+		!crossCertificate.IsCA {
+		t.Fatalf("unexpected cross certificate %s", crossCertificate.Subject)
+	}
+	if err := crossCertificate.CheckSignatureFrom(oldRootCertificate); err != nil {
+		t.Fatal(err)
+	}
+	if crossCertificate.NotAfter.After(time.Now().AddDate(1, 0, 1)) {
+		t.Fatalf("cross certificate valid until %s", crossCertificate.NotAfter)
+	}
+	if _, err := allCa["old_root"].GetCertificatePem(crossCertificate.SerialNumber); err != nil {
+		t.Fatalf("cross certificate not recorded by its issuer: %v", err)
+	}
+
+	// a certificate of the new root is trusted by clients of the old root
+	leafCertificate := certificateOf(allCa["new_root"].SignCsr(ctx, quotaTestCsr(t, "www.example.com")))
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(crossCertificate)
+	roots := x509.NewCertPool()
+	roots.AddCert(oldRootCertificate)
+	if _, err := leafCertificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	chainPem, err := allCa["new_root"].GetChainCrossCertificatesPem()
+	if err != nil || len(chainPem) != 1 {
+		t.Fatalf("expected the cross certificate in chains, got %d %v", len(chainPem), err)
+	}
+	if _, err := allCa["new_root"].GetCrossCertificatePem("other"); !errors.Is(err, caissuingprocess.ErrUnknownCrossCertificate) {
+		t.Fatalf("expected ErrUnknownCrossCertificate, got %v", err)
+	}
+
+	// the cross certificate is kept as long as it is valid
+	allCa = loadAll()
+	if reloaded := certificateOf(allCa["new_root"].GetCrossCertificatePem("old_root")); reloaded.SerialNumber.Cmp(crossCertificate.SerialNumber) != 0 {
+		t.Fatalf("cross certificate issued again: %s", reloaded.SerialNumber)
+	}
+}
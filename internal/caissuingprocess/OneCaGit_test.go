@@ -0,0 +1,177 @@
+package caissuingprocess_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func gitTestCaConfig(gitConfig *types.GitRepositoryType) types.CertificateAuthorityType {
+	return types.CertificateAuthorityType{
+		Subject: types.CertificateAuthoritySubjectType{
+			CommonName: "test_ca_1",
+		},
+		KeyConfig: types.KeyConfigType{
+			Type: "ecdsa",
+			Config: types.KeyTypeEcdsaConfigType{
+				CurveName: "P-256",
+			},
+		},
+		CrlTtl: 12 * time.Hour,
+		Git:    gitConfig,
+	}
+}
+
+func gitRemoteHeadCommit(t *testing.T, remoteDir string) (*git.Repository, plumbing.Hash) {
+	t.Helper()
+	remoteRepo, err := git.PlainOpen(remoteDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := remoteRepo.Reference(plumbing.NewBranchReferenceName("master"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return remoteRepo, ref.Hash()
+}
+
+func TestGitOpenpgpSignedCommitAndPush(t *testing.T) {
+	logger := &types.StdLogger{}
+	dataDirectory := t.TempDir()
+	remoteDir := t.TempDir()
+	if _, err := git.PlainInit(remoteDir, true); err != nil {
+		t.Fatal(err)
+	}
+
+	entity, err := openpgp.NewEntity("Simple CA", "", "ca@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var privateKeyArmored bytes.Buffer
+	{
+		w, err := armor.Encode(&privateKeyArmored, openpgp.PrivateKeyType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := entity.SerializePrivate(w, nil); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+	var publicKeyArmored bytes.Buffer
+	{
+		w, err := armor.Encode(&publicKeyArmored, openpgp.PublicKeyType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := entity.Serialize(w); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+	keyFilename := filepath.Join(t.TempDir(), "signing.asc")
+	if err := os.WriteFile(keyFilename, privateKeyArmored.Bytes(), os.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+
+	remoteUrl := "file://" + remoteDir
+	authorEmail := "simple-ca@example.com"
+	oneCa, err := caissuingprocess.LoadOneCa(
+		context.Background(),
+		logger,
+		"test_ca_1",
+		dataDirectory,
+		gitTestCaConfig(&types.GitRepositoryType{
+			AuthorEmail:         &authorEmail,
+			AuthorFromRequester: true,
+			SigningKeyType:      "openpgp",
+			SigningKeyFile:      &keyFilename,
+			RemoteUrl:           &remoteUrl,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := types.ContextWithRequester(context.Background(), types.RequesterType{Identity: "alice@example.com"})
+	if err := oneCa.UpdateCrl(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	remoteRepo, headHash := gitRemoteHeadCommit(t, remoteDir)
+	commit, err := remoteRepo.CommitObject(headHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Author.Email != "alice@example.com" {
+		t.Fatalf("invalid author %#v", commit.Author)
+	}
+	if commit.Committer.Email != authorEmail {
+		t.Fatalf("invalid committer %#v", commit.Committer)
+	}
+	if _, err := commit.Verify(publicKeyArmored.String()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGitSshSignedCommit(t *testing.T) {
+	logger := &types.StdLogger{}
+	dataDirectory := t.TempDir()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBlock, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFilename := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFilename, pem.EncodeToMemory(pemBlock), os.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := caissuingprocess.LoadOneCa(
+		context.Background(),
+		logger,
+		"test_ca_1",
+		dataDirectory,
+		gitTestCaConfig(&types.GitRepositoryType{
+			SigningKeyType: "ssh",
+			SigningKeyFile: &keyFilename,
+		}),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	repoGit, err := git.PlainOpen(filepath.Join(dataDirectory, "test_ca_1", "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repoGit.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repoGit.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(commit.PGPSignature, "-----BEGIN SSH SIGNATURE-----") {
+		t.Fatalf("commit not signed: %q", commit.PGPSignature)
+	}
+}
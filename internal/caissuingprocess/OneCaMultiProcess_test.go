@@ -0,0 +1,107 @@
+//go:build unix
+
+package caissuingprocess_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+const multiProcessUpdates = 5
+
+// TestHelperProcess is not a real test, it is run in a child process by
+// TestMultiProcessSharedDataDirectory.
+func TestHelperProcess(t *testing.T) {
+	dataDirectory := os.Getenv("SIMPLE_CA_HELPER_DATA_DIRECTORY")
+	if dataDirectory == "" {
+		t.Skip("helper process")
+	}
+	storageConfig := &types.StorageConfigType{Type: os.Getenv("SIMPLE_CA_HELPER_STORAGE")}
+	caConfig := gitTestCaConfig(nil)
+	caConfig.Storage = storageConfig
+
+	ctx := context.Background()
+	oneCa, err := caissuingprocess.LoadOneCa(ctx, &types.StdLogger{}, "test_ca_1", dataDirectory, caConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < multiProcessUpdates; i++ {
+		if err := oneCa.UpdateCrl(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMultiProcessSharedDataDirectory(t *testing.T) {
+	for _, storageType := range []string{
+		caissuingprocess.StorageTypeFilesystem,
+		caissuingprocess.StorageTypeBbolt,
+	} {
+		t.Run(storageType, func(t *testing.T) {
+			dataDirectory := t.TempDir()
+			caConfig := gitTestCaConfig(nil)
+			caConfig.Storage = &types.StorageConfigType{Type: storageType}
+			oneCa, err := caissuingprocess.LoadOneCa(
+				context.Background(),
+				&types.StdLogger{},
+				"test_ca_1",
+				dataDirectory,
+				caConfig,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := oneCa.UpdateCrl(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			initialCrlNumber := restoreTestCrlNumber(t, oneCa)
+
+			const processes = 3
+			commands := []*exec.Cmd{}
+			outputs := []*bytes.Buffer{}
+			for i := 0; i < processes; i++ {
+				cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$", "-test.count=1")
+				cmd.Env = append(
+					os.Environ(),
+					"SIMPLE_CA_HELPER_DATA_DIRECTORY="+dataDirectory,
+					"SIMPLE_CA_HELPER_STORAGE="+storageType,
+				)
+				output := &bytes.Buffer{}
+				cmd.Stdout = output
+				cmd.Stderr = output
+				if err := cmd.Start(); err != nil {
+					t.Fatal(err)
+				}
+				commands = append(commands, cmd)
+				outputs = append(outputs, output)
+			}
+			for i, cmd := range commands {
+				if err := cmd.Wait(); err != nil {
+					t.Fatalf("process %d: %v\n%s", i, err, outputs[i].String())
+				}
+			}
+
+			// Every update must have started from the CRL written by the
+			// previous one, whatever process wrote it.
+			expectedCrlNumber := initialCrlNumber + processes*multiProcessUpdates
+			if crlNumber := restoreTestCrlNumber(t, oneCa); crlNumber != expectedCrlNumber {
+				t.Fatalf("expected CRL number %d, got %d", expectedCrlNumber, crlNumber)
+			}
+
+			count, _, err := caissuingprocess.VerifyAuditLog(&types.StdLogger{}, dataDirectory, "test_ca_1", caConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The bootstrap, the initial update and one entry per update.
+			if expected := 2 + processes*multiProcessUpdates; count != expected {
+				t.Fatalf("expected %d audit entries, got %d", expected, count)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package caissuingprocess_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func TestNamePolicy(t *testing.T) {
+	ctx := context.Background()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_policy",
+		t.TempDir(),
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_policy",
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Days: 1,
+			},
+			KeyConfig: types.KeyConfigType{
+				Type: "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{
+					CurveName: "P-256",
+				},
+			},
+			CrlTtl: 12 * time.Hour,
+			Profiles: map[string]types.ProfileType{
+				"strict":   {SanRules: &types.SanRulesType{MaxSans: 2, RequireCnInSan: true}},
+				"wildcard": {SanRules: &types.SanRulesType{WildcardAllowed: true}},
+				"manual":   {ApprovalRequired: true, SanRules: &types.SanRulesType{}},
+			},
+			PermittedDNSDomains:     []string{"example.com", "example.net", "foo.com"},
+			ExcludedDNSDomains:      []string{"secret.example.com", "b.example.com", "bar.com"},
+			PermittedIPRanges:       []string{"10.0.0.0/8"},
+			PermittedEmailAddresses: []string{"example.com"},
+			PermittedURIDomains:     []string{".example.com"},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCsr := func(template x509.CertificateRequest) []byte {
+		t.Helper()
+		privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.CreateCertificateRequest(rand.Reader, &template, privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	}
+	mustParseUrl := func(rawUrl string) *url.URL {
+		u, err := url.Parse(rawUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+
+	type expectedViolationType struct {
+		name     string
+		nameType string
+	}
+	for _, testCase := range []struct {
+		name       string
+		profile    string
+		csr        x509.CertificateRequest
+		violations []expectedViolationType
+	}{
+		{
+			name:    "allowed",
+			profile: "",
+			csr: x509.CertificateRequest{
+				Subject: pkix.Name{CommonName: "www.example.com"},
+				// the wildcards cannot cover b.example.com or bar.com
+				DNSNames:       []string{"www.example.com", "*.example.net", "*.a.example.com", "*.foo.com"},
+				IPAddresses:    []net.IP{net.ParseIP("10.1.2.3")},
+				EmailAddresses: []string{"admin@example.com"},
+				URIs:           []*url.URL{mustParseUrl("spiffe://cluster.example.com/api")},
+			},
+		},
+		{
+			name:    "constraints",
+			profile: "",
+			csr: x509.CertificateRequest{
+				Subject:        pkix.Name{CommonName: "John Doe"},
+				DNSNames:       []string{"www.example.com", "www.example.org", "db.secret.example.com", "*.example.com"},
+				IPAddresses:    []net.IP{net.ParseIP("192.168.1.1")},
+				EmailAddresses: []string{"admin@example.org"},
+				URIs:           []*url.URL{mustParseUrl("spiffe://example.com/api")},
+			},
+			violations: []expectedViolationType{
+				{"www.example.org", caissuingprocess.NameTypeDns},
+				{"db.secret.example.com", caissuingprocess.NameTypeDns},
+				// it would cover secret.example.com
+				{"*.example.com", caissuingprocess.NameTypeDns},
+				{"192.168.1.1", caissuingprocess.NameTypeIp},
+				{"admin@example.org", caissuingprocess.NameTypeEmail},
+				{"spiffe://example.com/api", caissuingprocess.NameTypeUri},
+			},
+		},
+		{
+			name:    "common name",
+			profile: "",
+			csr: x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "www.example.org"},
+				DNSNames: []string{"www.example.com"},
+			},
+			violations: []expectedViolationType{
+				{"www.example.org", caissuingprocess.NameTypeCommonName},
+			},
+		},
+		{
+			name:    "profile rules",
+			profile: "strict",
+			csr: x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "api.example.com"},
+				DNSNames: []string{"www.example.com", "app.example.com", "*.example.net"},
+			},
+			violations: []expectedViolationType{
+				{"*.example.net", caissuingprocess.NameTypeDns},
+				{"*.example.net", caissuingprocess.NameTypeDns},
+				{"api.example.com", caissuingprocess.NameTypeCommonName},
+			},
+		},
+		{
+			name:    "uri common name",
+			profile: "strict",
+			csr: x509.CertificateRequest{
+				Subject: pkix.Name{CommonName: "spiffe://cluster.example.com/api"},
+				URIs:    []*url.URL{mustParseUrl("spiffe://cluster.example.com/api")},
+			},
+		},
+		{
+			name:    "wildcard allowed",
+			profile: "wildcard",
+			csr: x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "*.example.net"},
+				DNSNames: []string{"*.example.net"},
+			},
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := oneCa.SignCsrWithProfile(ctx, newCsr(testCase.csr), testCase.profile, nil)
+			if len(testCase.violations) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var namePolicyErr *caissuingprocess.NamePolicyError
+			if !errors.Is(err, caissuingprocess.ErrInvalidCsr) || !errors.As(err, &namePolicyErr) {
+				t.Fatalf("expected a name policy error, got %v", err)
+			}
+			violations := []expectedViolationType{}
+			for _, violation := range namePolicyErr.Violations {
+				if violation.Reason == "" {
+					t.Fatalf("violation without reason %#v", violation)
+				}
+				violations = append(violations, expectedViolationType{violation.Name, violation.Type})
+			}
+			if !reflect.DeepEqual(violations, testCase.violations) {
+				t.Fatalf("expected %v, got %v", testCase.violations, namePolicyErr.Violations)
+			}
+		})
+	}
+
+	// a request that can never be signed is not queued for approval
+	var namePolicyErr *caissuingprocess.NamePolicyError
+	if _, err := oneCa.SubmitSignRequest(ctx, quotaTestCsr(t, "*.example.net"), "manual", nil); !errors.As(err, &namePolicyErr) {
+		t.Fatalf("expected a name policy error, got %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package caissuingprocess_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func TestCertificatePoliciesAndStaticExtensions(t *testing.T) {
+	ctx := context.Background()
+	zero := 0
+	one := 1
+	text := "issued for testing"
+	integer := int64(42)
+	null := "05:00"
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_policies",
+		t.TempDir(),
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_policies",
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Days: 1,
+			},
+			KeyConfig: types.KeyConfigType{
+				Type: "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{
+					CurveName: "P-256",
+				},
+			},
+			CrlTtl: 12 * time.Hour,
+			CertificatePolicies: []types.CertificatePolicyType{
+				{Oid: types.AnyPolicyOid},
+			},
+			PolicyMappings: []types.PolicyMappingType{
+				{IssuerDomainPolicy: "1.3.6.1.4.1.99999.1", SubjectDomainPolicy: "1.3.6.1.4.1.88888.1"},
+			},
+			PolicyConstraints: &types.PolicyConstraintsType{RequireExplicitPolicy: &zero, InhibitPolicyMapping: &one},
+			InhibitAnyPolicy:  &one,
+			StaticExtensions: []types.StaticExtensionType{
+				{Oid: "1.3.6.1.4.1.99999.10", Critical: true, Integer: &integer},
+			},
+			Profiles: map[string]types.ProfileType{
+				"server": {
+					CertificatePolicies: []types.CertificatePolicyType{{
+						Oid:     "1.3.6.1.4.1.99999.1",
+						CpsUris: []string{"https://pki.example.com/cps"},
+						UserNotices: []types.UserNoticeType{
+							{Organization: "Example Corp", NoticeNumbers: []int{1, 2}, ExplicitText: "see the CPS"},
+						},
+					}},
+					StaticExtensions: []types.StaticExtensionType{
+						{Oid: "1.3.6.1.4.1.99999.11", Critical: true, Utf8String: &text},
+						{Oid: "1.3.6.1.4.1.99999.12", Der: &null},
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPem, err := oneCa.GetIssuerPem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCertificate, err := pemhelper.FromPemToCertificate(caPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caCertificate.Policies) != 1 || caCertificate.Policies[0].String() != types.AnyPolicyOid {
+		t.Fatalf("unexpected CA policies %v", caCertificate.Policies)
+	}
+	if len(caCertificate.PolicyMappings) != 1 ||
+		caCertificate.PolicyMappings[0].IssuerDomainPolicy.String() != "1.3.6.1.4.1.99999.1" ||
+		caCertificate.PolicyMappings[0].SubjectDomainPolicy.String() != "1.3.6.1.4.1.88888.1" {
+		t.Fatalf("unexpected CA policy mappings %v", caCertificate.PolicyMappings)
+	}
+	if !caCertificate.RequireExplicitPolicyZero || caCertificate.InhibitPolicyMapping != 1 || caCertificate.InhibitAnyPolicy != 1 {
+		t.Fatalf("unexpected CA policy constraints %+v", caCertificate)
+	}
+	if extension := findExtension(caCertificate, "1.3.6.1.4.1.99999.10"); extension == nil || !bytes.Equal(extension.Value, []byte{0x02, 0x01, 42}) {
+		t.Fatalf("unexpected CA static extension %v", extension)
+	}
+
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the extension requested in the CSR is replaced by the one of the profile
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "www.example.com"},
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 11}, Value: []byte{0x0c, 0x01, 'x'}},
+		},
+	}, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes, err := oneCa.SignCsrWithProfile(ctx, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), "server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := pemhelper.FromPemToCertificate(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var policies []struct {
+		Id         asn1.ObjectIdentifier
+		Qualifiers []struct {
+			Id        asn1.ObjectIdentifier
+			Qualifier asn1.RawValue
+		} `asn1:"optional"`
+	}
+	policiesExtension := findExtension(crt, "2.5.29.32")
+	if policiesExtension == nil {
+		t.Fatal("certificate policies missing")
+	}
+	if _, err := asn1.Unmarshal(policiesExtension.Value, &policies); err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 1 || policies[0].Id.String() != "1.3.6.1.4.1.99999.1" || len(policies[0].Qualifiers) != 2 {
+		t.Fatalf("unexpected certificate policies %+v", policies)
+	}
+	if cps := policies[0].Qualifiers[0]; cps.Id.String() != "1.3.6.1.5.5.7.2.1" || string(cps.Qualifier.Bytes) != "https://pki.example.com/cps" {
+		t.Fatalf("unexpected CPS qualifier %+v", cps)
+	}
+	var userNotice struct {
+		NoticeRef struct {
+			Organization  string `asn1:"utf8"`
+			NoticeNumbers []int
+		}
+		ExplicitText string `asn1:"utf8"`
+	}
+	if _, err := asn1.Unmarshal(policies[0].Qualifiers[1].Qualifier.FullBytes, &userNotice); err != nil {
+		t.Fatal(err)
+	}
+	if userNotice.NoticeRef.Organization != "Example Corp" || !slices.Equal(userNotice.NoticeRef.NoticeNumbers, []int{1, 2}) || userNotice.ExplicitText != "see the CPS" {
+		t.Fatalf("unexpected user notice %+v", userNotice)
+	}
+
+	if extension := findExtension(crt, "1.3.6.1.4.1.99999.11"); extension == nil || !extension.Critical || string(extension.Value[2:]) != text {
+		t.Fatalf("unexpected static extension %v", extension)
+	}
+	if extension := findExtension(crt, "1.3.6.1.4.1.99999.12"); extension == nil || !bytes.Equal(extension.Value, []byte{0x05, 0x00}) {
+		t.Fatalf("unexpected static extension %v", extension)
+	}
+	if extension := findExtension(crt, "1.3.6.1.4.1.99999.10"); extension != nil {
+		t.Fatal("static extension of the CA copied in the certificate")
+	}
+}
+
+func findExtension(certificate *x509.Certificate, oid string) *pkix.Extension {
+	found := (*pkix.Extension)(nil)
+	for _, extension := range certificate.Extensions {
+		if extension.Id.String() == oid {
+			if found != nil {
+				return nil
+			}
+			found = &extension
+		}
+	}
+	return found
+}
+
+func TestStaticExtensionValues(t *testing.T) {
+	str := func(value string) *string {
+		return &value
+	}
+	for name, staticExtension := range map[string]types.StaticExtensionType{
+		"reserved":         {Oid: "2.5.29.19", Der: str("3000")},
+		"invalid oid":      {Oid: "1", Der: str("0500")},
+		"no value":         {Oid: "1.2.3"},
+		"two values":       {Oid: "1.2.3", Der: str("0500"), Utf8String: str("x")},
+		"trailing der":     {Oid: "1.2.3", Der: str("050000")},
+		"not printable":    {Oid: "1.2.3", PrintableString: str("not_printable")},
+		"not hex":          {Oid: "1.2.3", Der: str("zz")},
+		"ia5 not in ascii": {Oid: "1.2.3", Ia5String: str("è")},
+	} {
+		if _, err := staticExtension.Extension(); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestReservedExtensionsOfCsrNotCopied(t *testing.T) {
+	ctx := context.Background()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_reserved",
+		t.TempDir(),
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_reserved",
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Days: 1,
+			},
+			KeyConfig: types.KeyConfigType{
+				Type: "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{
+					CurveName: "P-256",
+				},
+			},
+			CrlTtl: 12 * time.Hour,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	basicConstraints, err := asn1.Marshal(struct {
+		IsCA bool `asn1:"optional"`
+	}{IsCA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := asn1.Marshal([]struct {
+		Id asn1.ObjectIdentifier
+	}{{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "www.example.com"},
+		DNSNames: []string{"www.example.com"},
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Critical: true, Value: basicConstraints},
+			{Id: asn1.ObjectIdentifier{2, 5, 29, 32}, Value: policies},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 13}, Value: []byte{0x05, 0x00}},
+		},
+	}, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes, err := oneCa.SignCsr(ctx, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := pemhelper.FromPemToCertificate(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crt.IsCA {
+		t.Fatal("basic constraints of the CSR copied in the certificate")
+	}
+	if extension := findExtension(crt, "2.5.29.32"); extension != nil {
+		t.Fatalf("certificate policies of the CSR copied in the certificate: %v", crt.PolicyIdentifiers)
+	}
+	if !slices.Equal(crt.DNSNames, []string{"www.example.com"}) {
+		t.Fatalf("unexpected names %v", crt.DNSNames)
+	}
+	if extension := findExtension(crt, "1.3.6.1.4.1.99999.13"); extension == nil {
+		t.Fatal("extension of the CSR not copied in the certificate")
+	}
+}
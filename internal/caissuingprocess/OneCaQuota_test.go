@@ -0,0 +1,168 @@
+package caissuingprocess_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func quotaTestCsr(t *testing.T, dnsNames ...string) []byte {
+	t.Helper()
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: dnsNames[0]},
+		DNSNames: dnsNames,
+	}, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+}
+
+func TestMaxActiveCertificatesPerSan(t *testing.T) {
+	ctx := context.Background()
+	dataDirectory := t.TempDir()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_ca_1",
+		dataDirectory,
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_ca_1",
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Days: 1,
+			},
+			KeyConfig: types.KeyConfigType{
+				Type: "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{
+					CurveName: "P-256",
+				},
+			},
+			CrlTtl: 12 * time.Hour,
+			Limits: &types.LimitsType{
+				MaxActiveCertificatesPerSan: 2,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var firstCertificate *x509.Certificate
+	for range 2 {
+		pemBytes, err := oneCa.SignCsr(ctx, quotaTestCsr(t, "www.example.com"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if firstCertificate == nil {
+			firstCertificate, err = pemhelper.FromPemToCertificate(pemBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// SANs are compared without case, each one has its own quota.
+	if _, err := oneCa.SignCsr(ctx, quotaTestCsr(t, "other.example.com", "WWW.example.com")); !errors.Is(err, caissuingprocess.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if _, err := oneCa.SignCsr(ctx, quotaTestCsr(t, "other.example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revoked certificates are not active, and are dropped from the SAN index.
+	if err := oneCa.RevokeOneSerial(ctx, firstCertificate.SerialNumber); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oneCa.SignCsr(ctx, quotaTestCsr(t, "www.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	sanIndexFilename := filepath.Join(dataDirectory, "test_ca_1", "data", "san.yml")
+	sanIndexContent, err := os.ReadFile(sanIndexFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sanIndexContent, []byte(firstCertificate.SerialNumber.String())) {
+		t.Fatalf("revoked certificate in the SAN index:\n%s", sanIndexContent)
+	}
+
+	// The SAN index of a CA that has none is built from its certificates.
+	if err := os.Remove(sanIndexFilename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oneCa.SignCsr(ctx, quotaTestCsr(t, "www.example.com")); !errors.Is(err, caissuingprocess.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+}
+
+func TestSanIndexOnlyWithQuota(t *testing.T) {
+	ctx := context.Background()
+	dataDirectory := t.TempDir()
+	caConfig := types.CertificateAuthorityType{
+		Subject: types.CertificateAuthoritySubjectType{
+			CommonName: "test_ca_1",
+		},
+		Validity: types.CertificateAuthorityValidityType{
+			Days: 1,
+		},
+		KeyConfig: types.KeyConfigType{
+			Type: "ecdsa",
+			Config: types.KeyTypeEcdsaConfigType{
+				CurveName: "P-256",
+			},
+		},
+		CrlTtl: 12 * time.Hour,
+	}
+	oneCa, err := caissuingprocess.LoadOneCa(ctx, &types.StdLogger{}, "test_ca_1", dataDirectory, caConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oneCa.SignCsr(ctx, quotaTestCsr(t, "www.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	sanIndexFilename := filepath.Join(dataDirectory, "test_ca_1", "data", "san.yml")
+	if _, err := os.Stat(sanIndexFilename); !os.IsNotExist(err) {
+		t.Fatalf("SAN index kept without a quota: %v", err)
+	}
+
+	// The certificates issued before the quota was configured count.
+	caConfig.Limits = &types.LimitsType{
+		MaxActiveCertificatesPerSan: 3,
+	}
+	oneCa, err = caissuingprocess.LoadOneCa(ctx, &types.StdLogger{}, "test_ca_1", dataDirectory, caConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oneCa.SignCsr(ctx, quotaTestCsr(t, "www.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sanIndexFilename); err != nil {
+		t.Fatalf("SAN index not built with a quota: %v", err)
+	}
+	// the certificate issued while building the index is in it once
+	if _, err := oneCa.SignCsr(ctx, quotaTestCsr(t, "www.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oneCa.SignCsr(ctx, quotaTestCsr(t, "www.example.com")); !errors.Is(err, caissuingprocess.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package caissuingprocess_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func TestRecoverInterruptedOperation(t *testing.T) {
+	dataDirectory := t.TempDir()
+	dataDir := filepath.Join(dataDirectory, "test_ca_1", "data")
+	oneCa, err := caissuingprocess.LoadOneCa(
+		context.Background(),
+		&types.StdLogger{},
+		"test_ca_1",
+		dataDirectory,
+		gitTestCaConfig(nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt := restoreTestSign(t, oneCa)
+	issuedFilename := filepath.Join(dataDir, "crt", crt.SerialNumber.String()+".crt.pem")
+
+	// State left by a process killed between the "Before" and "After" commits.
+	truncatedFilename := filepath.Join(dataDir, "crt", "999.crt.pem")
+	if err := os.WriteFile(truncatedFilename, []byte("-----BEGIN CERTIFICATE-----\nMIIB"), os.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(issuedFilename); err != nil {
+		t.Fatal(err)
+	}
+	crlIndexContent := "- serial_number: \"" + crt.SerialNumber.String() + "\"\n  revocation_time: 1714575000000\n"
+	if err := os.WriteFile(filepath.Join(dataDir, "crl.yml"), []byte(crlIndexContent), os.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	queuedCsrFilename := filepath.Join(dataDir, "csr", "queued.csr.pem")
+	if err := os.WriteFile(queuedCsrFilename, []byte("queued"), os.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if checkErr := oneCa.SelfCheck(context.Background())[caissuingprocess.SelfCheckStorage]; checkErr == nil {
+		t.Fatal("interrupted operation not reported by the self check")
+	}
+
+	if _, err := caissuingprocess.LoadOneCa(
+		context.Background(),
+		&types.StdLogger{},
+		"test_ca_1",
+		dataDirectory,
+		gitTestCaConfig(nil),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(truncatedFilename); !os.IsNotExist(err) {
+		t.Errorf("truncated certificate not removed: %v", err)
+	}
+	if _, err := os.Stat(issuedFilename); err != nil {
+		t.Errorf("deleted certificate not restored: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(dataDir, "crl.yml")); err != nil || string(content) != crlIndexContent {
+		t.Errorf("complete revocation index not kept: %q %v", content, err)
+	}
+	if _, err := os.Stat(queuedCsrFilename); err != nil {
+		t.Errorf("queued CSR removed: %v", err)
+	}
+	if checkErr := oneCa.SelfCheck(context.Background())[caissuingprocess.SelfCheckStorage]; checkErr != nil {
+		t.Errorf("storage not consistent after recovery: %v", checkErr)
+	}
+
+	repoGit, err := git.PlainOpen(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repoGit.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := repoGit.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(headCommit.Message, "Recovered interrupted operation") {
+		history, _ := oneCa.History()
+		t.Errorf("recovery commit missing, head is %q: %+v", headCommit.Message, history)
+	}
+}
@@ -0,0 +1,197 @@
+package caissuingprocess_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func restoreTestHead(t *testing.T, dataDir string) string {
+	t.Helper()
+	repoGit, err := git.PlainOpen(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repoGit.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return head.Hash().String()
+}
+
+func restoreTestSign(t *testing.T, oneCa *caissuingprocess.OneCaType) *x509.Certificate {
+	t.Helper()
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "name 1"},
+	}, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrFilename := filepath.Join(t.TempDir(), "request.csr.pem")
+	if err := os.WriteFile(csrFilename, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE REQUEST", Bytes: csr,
+	}), os.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	pemBytes, err := oneCa.SignCsrFile(context.Background(), csrFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := pemhelper.FromPemToCertificate(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt
+}
+
+func restoreTestCrlNumber(t *testing.T, oneCa *caissuingprocess.OneCaType) int64 {
+	t.Helper()
+	crlPem, err := oneCa.GetCrlPem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBlock, _ := pem.Decode(crlPem)
+	if pemBlock == nil {
+		t.Fatal("no CRL")
+	}
+	crl, err := x509.ParseRevocationList(pemBlock.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crl.Number.Int64()
+}
+
+func TestRestoreRevertsUnpublishedChanges(t *testing.T) {
+	dataDirectory := t.TempDir()
+	dataDir := filepath.Join(dataDirectory, "test_ca_1", "data")
+	oneCa, err := caissuingprocess.LoadOneCa(
+		context.Background(),
+		&types.StdLogger{},
+		"test_ca_1",
+		dataDirectory,
+		gitTestCaConfig(nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := oneCa.UpdateCrl(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	targetRevision := restoreTestHead(t, dataDir)
+	crlNumberBefore := restoreTestCrlNumber(t, oneCa)
+
+	strayFilename := filepath.Join(dataDir, "stray.txt")
+	if err := os.WriteFile(strayFilename, []byte("manual edit"), os.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	if err := oneCa.RestoreTo(context.Background(), targetRevision); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(strayFilename); !os.IsNotExist(err) {
+		t.Fatalf("stray file not removed: %v", err)
+	}
+	if crlNumberAfter := restoreTestCrlNumber(t, oneCa); crlNumberAfter <= crlNumberBefore {
+		t.Fatalf("CRL number not increased: %d <= %d", crlNumberAfter, crlNumberBefore)
+	}
+
+	historyEntries, err := oneCa.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(historyEntries) == 0 {
+		t.Fatal("empty history")
+	}
+}
+
+func TestRestoreRefusesToUnissueAndUnrevoke(t *testing.T) {
+	dataDirectory := t.TempDir()
+	dataDir := filepath.Join(dataDirectory, "test_ca_1", "data")
+	oneCa, err := caissuingprocess.LoadOneCa(
+		context.Background(),
+		&types.StdLogger{},
+		"test_ca_1",
+		dataDirectory,
+		gitTestCaConfig(nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeIssuing := restoreTestHead(t, dataDir)
+
+	crt := restoreTestSign(t, oneCa)
+	if err := oneCa.RestoreTo(context.Background(), beforeIssuing); !errors.Is(err, caissuingprocess.ErrRestoreWouldUnissue) {
+		t.Fatalf("expected unissue error, got %v", err)
+	}
+
+	beforeRevoking := restoreTestHead(t, dataDir)
+	if err := oneCa.RevokeOneSerial(context.Background(), crt.SerialNumber); err != nil {
+		t.Fatal(err)
+	}
+	if err := oneCa.RestoreTo(context.Background(), beforeRevoking); !errors.Is(err, caissuingprocess.ErrRestoreWouldUnrevoke) {
+		t.Fatalf("expected unrevoke error, got %v", err)
+	}
+}
+
+func TestRestoreKeepsSpoolAndSignRequests(t *testing.T) {
+	dataDirectory := t.TempDir()
+	dataDir := filepath.Join(dataDirectory, "test_ca_1", "data")
+	oneCa, err := caissuingprocess.LoadOneCa(
+		context.Background(),
+		&types.StdLogger{},
+		"test_ca_1",
+		dataDirectory,
+		gitTestCaConfig(nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetRevision := restoreTestHead(t, dataDir)
+
+	spooledFilename := filepath.Join(dataDir, "csr", "queued.csr.pem")
+	if err := os.WriteFile(spooledFilename, []byte("queued"), os.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "name 1"},
+	}, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signRequest, err := oneCa.SubmitSignRequest(context.Background(), pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE REQUEST", Bytes: csr,
+	}), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := oneCa.RestoreTo(context.Background(), targetRevision); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(spooledFilename); err != nil || string(content) != "queued" {
+		t.Fatalf("spooled CSR not kept: %q %v", content, err)
+	}
+	if restoredSignRequest, err := oneCa.SignRequest(signRequest.Id); err != nil || restoredSignRequest.Status != caissuingprocess.SignRequestPending {
+		t.Fatalf("sign request not kept: %+v %v", restoredSignRequest, err)
+	}
+}
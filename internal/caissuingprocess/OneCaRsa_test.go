@@ -252,7 +252,7 @@ func TestRsaSupportsEcdsaCsr(t *testing.T) {
 		t.Error(err)
 	}
 
-	if err := ca.IssueAllCsrInQueue(); err != nil {
+	if err := ca.IssueAllCsrInQueue(context.Background()); err != nil {
 		t.Error(err)
 	}
 }
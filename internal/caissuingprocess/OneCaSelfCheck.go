@@ -0,0 +1,106 @@
+package caissuingprocess
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+var ErrKeyCannotSign = errors.New("CA key cannot sign")
+var ErrCaCertificateExpiring = errors.New("CA certificate expired or expiring")
+var ErrCrlExpired = errors.New("CRL past NextUpdate")
+var ErrKrlInvalid = errors.New("KRL missing or invalid")
+
+const defaultExpiryWarning = 30 * 24 * time.Hour
+
+const (
+	SelfCheckKey         = "key"
+	SelfCheckCertificate = "certificate"
+	SelfCheckCrl         = "crl"
+	SelfCheckKrl         = "krl"
+	SelfCheckTsa         = "tsa"
+	SelfCheckStorage     = "storage"
+)
+
+// SelfCheck verifies that the CA is able to serve requests, every check is
+// reported with a nil error when it passed.
+func (oneCa *OneCaType) SelfCheck(ctx context.Context) map[string]error {
+	if oneCa.caConfig.Kind == types.CaKindSsh {
+		return map[string]error{
+			SelfCheckKey:     checkKeyCanSign(oneCa.caPrivateKey, oneCa.caPrivateKey.Public()),
+			SelfCheckKrl:     oneCa.checkKrl(),
+			SelfCheckStorage: oneCa.storage.Check(ctx),
+		}
+	}
+	checks := map[string]error{
+		SelfCheckKey:         checkKeyCanSign(oneCa.caPrivateKey, oneCa.caCertificate.PublicKey),
+		SelfCheckCertificate: oneCa.checkCertificateValidity(),
+		SelfCheckCrl:         oneCa.checkCrl(),
+		SelfCheckStorage:     oneCa.storage.Check(ctx),
+	}
+	if oneCa.caConfig.Tsa != nil {
+		checks[SelfCheckTsa] = oneCa.checkTsaCertificate()
+	}
+	return checks
+}
+
+func checkKeyCanSign(caPrivateKey crypto.Signer, caPublicKey any) error {
+	digest := sha256.Sum256([]byte("simple-ca self check " + time.Now().String()))
+	signature, err := caPrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrKeyCannotSign, err)
+	}
+	switch publicKey := caPublicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("%w: signature not valid for the CA certificate: %v", ErrKeyCannotSign, err)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+			return fmt.Errorf("%w: signature not valid for the CA certificate", ErrKeyCannotSign)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported public key %T", ErrKeyCannotSign, publicKey)
+	}
+	return nil
+}
+
+func (oneCa *OneCaType) expiryWarning() time.Duration {
+	if oneCa.caConfig.ExpiryWarning > 0 {
+		return oneCa.caConfig.ExpiryWarning
+	}
+	return defaultExpiryWarning
+}
+
+func (oneCa *OneCaType) checkCertificateValidity() error {
+	if notAfter := oneCa.caCertificate.NotAfter; time.Now().Add(oneCa.expiryWarning()).After(notAfter) {
+		return fmt.Errorf("%w: not after %s", ErrCaCertificateExpiring, notAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (oneCa *OneCaType) checkCrl() error {
+	crlPemBytes, err := oneCa.GetCrlPem()
+	if err != nil {
+		return err
+	}
+	if crlPemBytes == nil {
+		return fmt.Errorf("%w: no CRL", ErrCrlExpired)
+	}
+	crl, err := parseCrlPem(crlPemBytes)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(crl.NextUpdate) {
+		return fmt.Errorf("%w: next update %s", ErrCrlExpired, crl.NextUpdate.Format(time.RFC3339))
+	}
+	return nil
+}
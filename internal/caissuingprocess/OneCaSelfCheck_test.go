@@ -0,0 +1,44 @@
+package caissuingprocess_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func TestSelfCheckReportsExpiringCertificate(t *testing.T) {
+	// Without validity the CA certificate expires as soon as it is created.
+	oneCa, err := caissuingprocess.LoadOneCa(
+		context.Background(),
+		&types.StdLogger{},
+		"test_ca_1",
+		t.TempDir(),
+		gitTestCaConfig(nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkErrors := oneCa.SelfCheck(context.Background())
+	if err := checkErrors[caissuingprocess.SelfCheckKey]; err != nil {
+		t.Errorf("key check failed: %v", err)
+	}
+	if err := checkErrors[caissuingprocess.SelfCheckStorage]; err != nil {
+		t.Errorf("storage check failed: %v", err)
+	}
+	if err := checkErrors[caissuingprocess.SelfCheckCertificate]; !errors.Is(err, caissuingprocess.ErrCaCertificateExpiring) {
+		t.Errorf("expected expiring certificate, got %v", err)
+	}
+	if err := checkErrors[caissuingprocess.SelfCheckCrl]; !errors.Is(err, caissuingprocess.ErrCrlExpired) {
+		t.Errorf("expected missing CRL, got %v", err)
+	}
+
+	if err := oneCa.UpdateCrl(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := oneCa.SelfCheck(context.Background())[caissuingprocess.SelfCheckCrl]; err != nil {
+		t.Errorf("CRL check failed after update: %v", err)
+	}
+}
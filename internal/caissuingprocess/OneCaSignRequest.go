@@ -0,0 +1,317 @@
+package caissuingprocess
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/auditlog"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+var ErrUnknownProfile = errors.New("unknown profile")
+var ErrUnknownSignRequest = errors.New("unknown sign request")
+var ErrSignRequestNotPending = errors.New("sign request not pending")
+
+const (
+	SignRequestPending  = "pending"
+	SignRequestApproved = "approved"
+	SignRequestRejected = "rejected"
+)
+
+var signRequestIdPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// SignRequestType is a CSR waiting for the approval of an administrator, or
+// the decision taken on it.
+type SignRequestType struct {
+	Id      string `json:"id"`
+	Status  string `json:"status"`
+	Profile string `json:"profile,omitempty"`
+	Subject string `json:"subject"`
+	Csr     string `json:"csr"`
+	// SubjectValues are given to the subject template of the profile when
+	// the request is approved.
+	SubjectValues map[string]string   `json:"subject_values,omitempty"`
+	RequestedBy   types.RequesterType `json:"requested_by"`
+	RequestedAt   time.Time           `json:"requested_at"`
+
+	DecidedBy *types.RequesterType `json:"decided_by,omitempty"`
+	DecidedAt *time.Time           `json:"decided_at,omitempty"`
+	Reason    string               `json:"reason,omitempty"`
+	Serial    string               `json:"serial,omitempty"`
+}
+
+// Profile returns the configuration of a profile, the empty name is the
+// default profile.
+func (oneCa *OneCaType) Profile(name string) (types.ProfileType, error) {
+	if name == "" {
+		return types.ProfileType{}, nil
+	}
+	profile, found := oneCa.caConfig.Profiles[name]
+	if !found {
+		return types.ProfileType{}, fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+	}
+	return profile, nil
+}
+
+// SubmitSignRequest stores a CSR to be signed once approved, with the
+// subjectValues for the subject template of the profile.
+func (oneCa *OneCaType) SubmitSignRequest(ctx context.Context, csrContent []byte, profile string, subjectValues map[string]string) (SignRequestType, error) {
+	return oneCa.submitSignRequest(ctx, func(tx caStorageTxType) (csrToSignType, error) {
+		return csrToSignType{content: csrContent, profile: profile, subjectValues: subjectValues}, nil
+	}, nil)
+}
+
+// submitSignRequest queues the CSR returned by readCsr, afterQueued runs in
+// the same transaction once the sign request is written.
+func (oneCa *OneCaType) submitSignRequest(
+	ctx context.Context,
+	readCsr func(tx caStorageTxType) (csrToSignType, error),
+	afterQueued func(tx caStorageTxType, signRequest SignRequestType) error,
+) (SignRequestType, error) {
+	if oneCa.caConfig.Kind == types.CaKindSsh {
+		return SignRequestType{}, fmt.Errorf("%w: CSR on a CA of kind ssh", ErrNotSupportedByCaKind)
+	}
+	idBytes := make([]byte, 16)
+	if _, err := cryptorand.Read(idBytes); err != nil {
+		return SignRequestType{}, err
+	}
+	signRequestId := hex.EncodeToString(idBytes)
+
+	var signRequest SignRequestType
+	queued := false
+	err := oneCa.transaction(
+		ctx,
+		"queuing sign request "+signRequestId,
+		func(tx caStorageTxType) error {
+			csrToSign, err := readCsr(tx)
+			if err != nil {
+				return err
+			}
+			profileConfig, err := oneCa.Profile(csrToSign.profile)
+			if err != nil {
+				return err
+			}
+			csr, err := pemhelper.FromAnyToCertificateRequest(csrToSign.content)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidCsr, err)
+			}
+			if err := csr.CheckSignature(); err != nil {
+				return fmt.Errorf("%w: invalid CSR signature: %v", ErrInvalidCsr, err)
+			}
+			// refused now rather than after an administrator approves it
+			csr.Subject, err = rewriteSubject(oneCa.caCertificate.Subject, csr, profileConfig.Subject, csrToSign.subjectValues)
+			if err != nil {
+				return err
+			}
+			if err := checkNamePolicy(oneCa.caCertificate, csr, profileConfig.SanRules); err != nil {
+				return err
+			}
+			// stored as PEM whatever the format it was sent in
+			csrPem, err := pemhelper.ToPem(csr)
+			if err != nil {
+				return err
+			}
+			signRequest = SignRequestType{
+				Id:            signRequestId,
+				Status:        SignRequestPending,
+				Profile:       csrToSign.profile,
+				Subject:       csr.Subject.String(),
+				Csr:           string(csrPem),
+				SubjectValues: csrToSign.subjectValues,
+				RequestedBy:   types.RequesterFromContext(ctx),
+				RequestedAt:   time.Now().UTC(),
+			}
+			queued = true
+			if err := writeSignRequest(tx, signRequest); err != nil {
+				return err
+			}
+			if afterQueued != nil {
+				return afterQueued(tx, signRequest)
+			}
+			return nil
+		},
+	)
+	// a CSR refused before being queued is not an operation on a sign request
+	if queued {
+		oneCa.auditSignRequest(ctx, auditlog.OperationSubmit, signRequest.Id, nil, err)
+	}
+	if err != nil {
+		return SignRequestType{}, err
+	}
+	return signRequest, nil
+}
+
+func (oneCa *OneCaType) SignRequest(id string) (SignRequestType, error) {
+	var signRequest SignRequestType
+	if err := oneCa.storage.View(func(tx caStorageTxType) error {
+		var err error
+		signRequest, err = readSignRequest(tx, id)
+		return err
+	}); err != nil {
+		return SignRequestType{}, err
+	}
+	return signRequest, nil
+}
+
+// ListSignRequests returns the sign requests with status, all of them when
+// status is empty, oldest first.
+func (oneCa *OneCaType) ListSignRequests(status string) ([]SignRequestType, error) {
+	signRequests := []SignRequestType{}
+	if err := oneCa.storage.View(func(tx caStorageTxType) error {
+		ids, err := tx.ListSignRequestIds()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			signRequest, err := readSignRequest(tx, id)
+			if err != nil {
+				return err
+			}
+			if status == "" || signRequest.Status == status {
+				signRequests = append(signRequests, signRequest)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(signRequests, func(i, j int) bool {
+		return signRequests[i].RequestedAt.Before(signRequests[j].RequestedAt)
+	})
+	return signRequests, nil
+}
+
+// ApproveSignRequest signs a pending request, the approver is the requester
+// of ctx.
+func (oneCa *OneCaType) ApproveSignRequest(ctx context.Context, id string) (SignRequestType, error) {
+	var signRequest SignRequestType
+	_, err := oneCa.signCsr(ctx, "approving sign request "+id, func(tx caStorageTxType) (csrToSignType, error) {
+		var err error
+		signRequest, err = readPendingSignRequest(tx, id)
+		if err != nil {
+			return csrToSignType{}, err
+		}
+		return csrToSignType{
+			content:       []byte(signRequest.Csr),
+			profile:       signRequest.Profile,
+			subjectValues: signRequest.SubjectValues,
+		}, nil
+	}, func(tx caStorageTxType, serialNumber *big.Int) error {
+		decideSignRequest(ctx, &signRequest, SignRequestApproved, "")
+		signRequest.Serial = serialNumber.String()
+		return writeSignRequest(tx, signRequest)
+	})
+	var serialNumber *big.Int
+	if err == nil {
+		serialNumber, _ = new(big.Int).SetString(signRequest.Serial, 10)
+	}
+	oneCa.auditSignRequest(ctx, auditlog.OperationApprove, id, serialNumber, err)
+	if err != nil {
+		return SignRequestType{}, err
+	}
+	return signRequest, nil
+}
+
+// RejectSignRequest refuses a pending request, the administrator is the
+// requester of ctx.
+func (oneCa *OneCaType) RejectSignRequest(ctx context.Context, id string, reason string) (SignRequestType, error) {
+	var signRequest SignRequestType
+	err := oneCa.transaction(
+		ctx,
+		"rejecting sign request "+id,
+		func(tx caStorageTxType) error {
+			var err error
+			signRequest, err = readPendingSignRequest(tx, id)
+			if err != nil {
+				return err
+			}
+			decideSignRequest(ctx, &signRequest, SignRequestRejected, reason)
+			return writeSignRequest(tx, signRequest)
+		},
+	)
+	oneCa.auditSignRequest(ctx, auditlog.OperationReject, id, nil, err)
+	if err != nil {
+		return SignRequestType{}, err
+	}
+	return signRequest, nil
+}
+
+func (oneCa *OneCaType) auditSignRequest(
+	ctx context.Context,
+	operation string,
+	id string,
+	serial *big.Int,
+	operationErr error,
+) {
+	entry := auditlog.EntryType{
+		Operation: operation,
+		Requester: types.RequesterFromContext(ctx),
+		Result:    auditlog.ResultSuccess,
+		Detail:    "sign request " + id,
+	}
+	if serial != nil {
+		entry.Serial = serial.String()
+	}
+	if operationErr != nil {
+		entry.Result = auditlog.ResultError
+		entry.Error = operationErr.Error()
+	}
+	if err := oneCa.auditLog.Append(entry); err != nil {
+		types.LoggerFromContext(ctx, oneCa.logger).Error("Failed writing audit entry", "ca_id", oneCa.caId, "operation", operation, "err", err)
+	}
+}
+
+func decideSignRequest(ctx context.Context, signRequest *SignRequestType, status string, reason string) {
+	decidedBy := types.RequesterFromContext(ctx)
+	decidedAt := time.Now().UTC()
+	signRequest.Status = status
+	signRequest.DecidedBy = &decidedBy
+	signRequest.DecidedAt = &decidedAt
+	signRequest.Reason = reason
+}
+
+func readSignRequest(tx caStorageTxType, id string) (SignRequestType, error) {
+	if !signRequestIdPattern.MatchString(id) {
+		return SignRequestType{}, fmt.Errorf("%w: %q", ErrUnknownSignRequest, id)
+	}
+	content, err := tx.ReadSignRequest(id)
+	if err != nil {
+		return SignRequestType{}, err
+	}
+	if content == nil {
+		return SignRequestType{}, fmt.Errorf("%w: %q", ErrUnknownSignRequest, id)
+	}
+	var signRequest SignRequestType
+	if err := json.Unmarshal(content, &signRequest); err != nil {
+		return SignRequestType{}, fmt.Errorf("sign request %s: %w", id, err)
+	}
+	return signRequest, nil
+}
+
+func readPendingSignRequest(tx caStorageTxType, id string) (SignRequestType, error) {
+	signRequest, err := readSignRequest(tx, id)
+	if err != nil {
+		return SignRequestType{}, err
+	}
+	if signRequest.Status != SignRequestPending {
+		return SignRequestType{}, fmt.Errorf("%w: %s is %s", ErrSignRequestNotPending, id, signRequest.Status)
+	}
+	return signRequest, nil
+}
+
+func writeSignRequest(tx caStorageTxType, signRequest SignRequestType) error {
+	content, err := json.MarshalIndent(signRequest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return tx.WriteSignRequest(signRequest.Id, append(content, '\n'))
+}
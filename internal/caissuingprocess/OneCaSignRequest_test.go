@@ -0,0 +1,114 @@
+package caissuingprocess_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func TestSignRequestApproval(t *testing.T) {
+	ctx := context.Background()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_ca_1",
+		t.TempDir(),
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_ca_1",
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Days: 1,
+			},
+			KeyConfig: types.KeyConfigType{
+				Type: "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{
+					CurveName: "P-256",
+				},
+			},
+			CrlTtl: 12 * time.Hour,
+			Profiles: map[string]types.ProfileType{
+				"server": {ApprovalRequired: true},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := oneCa.SubmitSignRequest(ctx, quotaTestCsr(t, "www.example.com"), "unknown", nil); !errors.Is(err, caissuingprocess.ErrUnknownProfile) {
+		t.Fatalf("expected ErrUnknownProfile, got %v", err)
+	}
+	if _, err := oneCa.SubmitSignRequest(ctx, []byte("not a CSR"), "", nil); !errors.Is(err, caissuingprocess.ErrInvalidCsr) {
+		t.Fatalf("expected ErrInvalidCsr, got %v", err)
+	}
+
+	requesterCtx := types.ContextWithRequester(ctx, types.RequesterType{Identity: "team-a"})
+	approved, err := oneCa.SubmitSignRequest(requesterCtx, quotaTestCsr(t, "www.example.com"), "server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected, err := oneCa.SubmitSignRequest(requesterCtx, quotaTestCsr(t, "other.example.com"), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := oneCa.ListSignRequests(caissuingprocess.SignRequestPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Id != approved.Id || pending[0].RequestedBy.Identity != "team-a" {
+		t.Fatalf("unexpected pending requests %#v", pending)
+	}
+	if status, err := oneCa.Status(); err != nil || status.PendingSignRequests != 2 {
+		t.Fatalf("expected 2 pending requests, got %d %v", status.PendingSignRequests, err)
+	}
+
+	adminCtx := types.ContextWithRequester(ctx, types.RequesterType{Identity: "admin"})
+	signRequest, err := oneCa.ApproveSignRequest(adminCtx, approved.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signRequest.Status != caissuingprocess.SignRequestApproved || signRequest.DecidedBy.Identity != "admin" {
+		t.Fatalf("unexpected approved request %#v", signRequest)
+	}
+	serialNumber, _ := new(big.Int).SetString(signRequest.Serial, 10)
+	certificatePem, err := oneCa.GetCertificatePem(serialNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := pemhelper.FromPemToCertificate(certificatePem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certificate.Subject.CommonName != "www.example.com" {
+		t.Fatalf("unexpected subject %s", certificate.Subject)
+	}
+
+	if _, err := oneCa.RejectSignRequest(adminCtx, rejected.Id, "not ours"); err != nil {
+		t.Fatal(err)
+	}
+	signRequest, err = oneCa.SignRequest(rejected.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signRequest.Status != caissuingprocess.SignRequestRejected || signRequest.Reason != "not ours" {
+		t.Fatalf("unexpected rejected request %#v", signRequest)
+	}
+
+	if _, err := oneCa.ApproveSignRequest(adminCtx, rejected.Id); !errors.Is(err, caissuingprocess.ErrSignRequestNotPending) {
+		t.Fatalf("expected ErrSignRequestNotPending, got %v", err)
+	}
+	if _, err := oneCa.RejectSignRequest(adminCtx, "../../ca", ""); !errors.Is(err, caissuingprocess.ErrUnknownSignRequest) {
+		t.Fatalf("expected ErrUnknownSignRequest, got %v", err)
+	}
+	if pending, err := oneCa.ListSignRequests(caissuingprocess.SignRequestPending); err != nil || len(pending) != 0 {
+		t.Fatalf("expected no pending requests, got %#v %v", pending, err)
+	}
+}
@@ -0,0 +1,164 @@
+package caissuingprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/auditlog"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+var ErrCsrNotQueued = errors.New("csr not in spool")
+
+// SpoolResultType is the outcome of one CSR of the spool. A CSR is either
+// issued, queued for approval, rejected or, when Err is set but Rejected is
+// not, left in the spool to be retried.
+type SpoolResultType struct {
+	Name          string
+	Profile       string
+	Serial        *big.Int
+	SignRequestId string
+	Rejected      bool
+	Err           error
+}
+
+// ProcessCsrSpool signs the CSRs of the spool that were not modified for at
+// least minAge, so that a file still being written is left for a later run.
+//
+// For a CSR named www.csr.pem the certificate is written to out/www.crt.pem
+// and the certificate followed by the CA certificate and the cross
+// certificates included in chains to out/www.chain.pem. With a profile that
+// requires approval, the id of the sign request is written to
+// out/www.request instead. A CSR that cannot be signed is moved to rejected/
+// next to a www.csr.pem.error file with the reason.
+func (oneCa *OneCaType) ProcessCsrSpool(ctx context.Context, minAge time.Duration) ([]SpoolResultType, error) {
+	logger := types.LoggerFromContext(ctx, oneCa.logger)
+	var csrNames []string
+	if err := oneCa.storage.View(func(tx caStorageTxType) error {
+		names, err := tx.ListCsrQueue()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			modTime, err := tx.CsrModTime(name)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+			if time.Since(modTime) >= minAge {
+				csrNames = append(csrNames, name)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	results := []SpoolResultType{}
+	for _, csrName := range csrNames {
+		result := oneCa.processSpooledCsr(ctx, csrName)
+		if errors.Is(result.Err, ErrCsrNotQueued) {
+			continue
+		}
+		if result.Err != nil && isSpoolRejection(result.Err) {
+			if err := oneCa.transaction(ctx, "rejecting "+csrName, func(tx caStorageTxType) error {
+				return tx.RejectCsr(csrName, result.Err.Error())
+			}); err != nil {
+				result.Err = errors.Join(result.Err, fmt.Errorf("failed to reject: %w", err))
+			} else {
+				result.Rejected = true
+			}
+		}
+		switch {
+		case result.Rejected:
+			logger.Warn("Rejected spooled CSR", "ca_id", oneCa.caId, "csr", csrName, "err", result.Err)
+		case result.Err != nil:
+			logger.Error("Failed processing spooled CSR", "ca_id", oneCa.caId, "csr", csrName, "err", result.Err)
+		case result.SignRequestId != "":
+			logger.Info("Queued spooled CSR for approval", "ca_id", oneCa.caId, "csr", csrName, "sign_request", result.SignRequestId)
+		default:
+			logger.Info("Issued spooled CSR", "ca_id", oneCa.caId, "csr", csrName, "serial", result.Serial.String())
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (oneCa *OneCaType) processSpooledCsr(ctx context.Context, csrName string) SpoolResultType {
+	profileName, stem := splitCsrSpoolName(csrName)
+	result := SpoolResultType{Name: csrName, Profile: profileName}
+	profile, err := oneCa.Profile(profileName)
+	if err != nil {
+		oneCa.audit(ctx, auditlog.OperationSign, nil, err)
+		result.Err = err
+		return result
+	}
+
+	if profile.ApprovalRequired {
+		signRequest, err := oneCa.submitSignRequest(ctx, func(tx caStorageTxType) (csrToSignType, error) {
+			csrContent, err := tx.ReadCsr(csrName)
+			if err == nil && csrContent == nil {
+				return csrToSignType{}, fmt.Errorf("%w: %s", ErrCsrNotQueued, csrName)
+			}
+			return csrToSignType{content: csrContent, profile: profileName}, err
+		}, func(tx caStorageTxType, signRequest SignRequestType) error {
+			if err := tx.WriteCsrOutput(stem+".request", []byte(signRequest.Id+"\n")); err != nil {
+				return err
+			}
+			return tx.RemoveCsr(csrName)
+		})
+		result.SignRequestId = signRequest.Id
+		result.Err = err
+		return result
+	}
+
+	_, result.Err = oneCa.signCsr(ctx, "issuing "+csrName, func(tx caStorageTxType) (csrToSignType, error) {
+		csrContent, err := tx.ReadCsr(csrName)
+		if err == nil && csrContent == nil {
+			return csrToSignType{}, fmt.Errorf("%w: %s", ErrCsrNotQueued, csrName)
+		}
+		return csrToSignType{content: csrContent, profile: profileName}, err
+	}, func(tx caStorageTxType, serialNumber *big.Int) error {
+		certificatePem, err := tx.ReadCertificate(serialNumber)
+		if err != nil {
+			return err
+		}
+		issuerPem, err := oneCa.GetIssuerPem()
+		if err != nil {
+			return err
+		}
+		crossCertificatesPem, err := oneCa.GetChainCrossCertificatesPem()
+		if err != nil {
+			return err
+		}
+		if err := tx.WriteCsrOutput(stem+".crt.pem", certificatePem); err != nil {
+			return err
+		}
+		chainPem := slices.Concat(append([][]byte{certificatePem, issuerPem}, crossCertificatesPem...)...)
+		if err := tx.WriteCsrOutput(stem+".chain.pem", chainPem); err != nil {
+			return err
+		}
+		result.Serial = serialNumber
+		return tx.RemoveCsr(csrName)
+	})
+	if result.Err != nil {
+		result.Serial = nil
+	}
+	return result
+}
+
+// isSpoolRejection tells the errors that retrying the same CSR cannot fix. A
+// CSR over the SAN quota is retried, it is signed once enough certificates
+// expire or are revoked.
+func isSpoolRejection(err error) bool {
+	return errors.Is(err, ErrInvalidCsr) ||
+		errors.Is(err, ErrUnknownProfile) ||
+		errors.Is(err, ErrNotSupportedByCaKind)
+}
@@ -0,0 +1,351 @@
+package caissuingprocess_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func quotaTestCsrDer(t *testing.T, dnsNames ...string) []byte {
+	t.Helper()
+	pemBlock, _ := pem.Decode(quotaTestCsr(t, dnsNames...))
+	return pemBlock.Bytes
+}
+
+func TestProcessCsrSpool(t *testing.T) {
+	t.Run("filesystem", func(t *testing.T) {
+		testProcessCsrSpool(t, caissuingprocess.StorageTypeFilesystem)
+	})
+	t.Run("bbolt", func(t *testing.T) {
+		testProcessCsrSpool(t, caissuingprocess.StorageTypeBbolt)
+	})
+}
+
+func testProcessCsrSpool(t *testing.T, storageType string) {
+	ctx := context.Background()
+	dataDirectory := t.TempDir()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_spool",
+		dataDirectory,
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_spool",
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Days: 1,
+			},
+			KeyConfig: types.KeyConfigType{
+				Type: "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{
+					CurveName: "P-256",
+				},
+			},
+			CrlTtl: 12 * time.Hour,
+			Profiles: map[string]types.ProfileType{
+				"server": {},
+				"manual": {ApprovalRequired: true},
+			},
+			Storage: &types.StorageConfigType{
+				Type: storageType,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spoolDir := filepath.Join(dataDirectory, "test_spool", "data", "csr")
+	for name, content := range map[string][]byte{
+		"www.csr.pem":            quotaTestCsr(t, "www.example.com"),
+		"server/api.csr":         quotaTestCsr(t, "api.example.com"),
+		"legacy.req.der":         quotaTestCsrDer(t, "legacy.example.com"),
+		"manual/admin.csr.pem":   quotaTestCsr(t, "admin.example.com"),
+		"broken.csr.pem":         []byte("not a CSR"),
+		"unknown/other.csr.pem":  quotaTestCsr(t, "other.example.com"),
+		".incomplete.csr.pem":    []byte("-----BEGIN"),
+		"server/.partial.csr":    []byte("-----BEGIN"),
+		"out/ignored.csr.pem":    quotaTestCsr(t, "ignored.example.com"),
+		"rejected/older.csr.pem": []byte("not a CSR"),
+	} {
+		filename := filepath.Join(spoolDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nothing is old enough yet
+	results, err := oneCa.ProcessCsrSpool(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("expected no result, got %#v", results)
+	}
+
+	results, err = oneCa.ProcessCsrSpool(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultByName := map[string]caissuingprocess.SpoolResultType{}
+	for _, result := range results {
+		resultByName[result.Name] = result
+	}
+	if len(resultByName) != 6 {
+		t.Fatalf("expected 6 results, got %#v", results)
+	}
+
+	for name, stem := range map[string]string{
+		"www.csr.pem":    "www",
+		"server/api.csr": "server/api",
+		"legacy.req.der": "legacy",
+	} {
+		result := resultByName[name]
+		if result.Err != nil || result.Serial == nil {
+			t.Fatalf("%s: unexpected result %#v", name, result)
+		}
+		certificatePem, err := os.ReadFile(filepath.Join(spoolDir, "out", stem+".crt.pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		certificate, err := pemhelper.FromPemToCertificate(certificatePem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if certificate.SerialNumber.Cmp(result.Serial) != 0 {
+			t.Fatalf("%s: certificate serial %s, result serial %s", name, certificate.SerialNumber, result.Serial)
+		}
+		chainPem, err := os.ReadFile(filepath.Join(spoolDir, "out", stem+".chain.pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(chainPem, certificatePem) || bytes.Count(chainPem, []byte("-----BEGIN CERTIFICATE-----")) != 2 {
+			t.Fatalf("%s: unexpected chain %s", name, chainPem)
+		}
+		if _, err := os.Stat(filepath.Join(spoolDir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Fatalf("%s: issued CSR still in spool: %v", name, err)
+		}
+	}
+	if resultByName["server/api.csr"].Profile != "server" {
+		t.Fatalf("unexpected profile %q", resultByName["server/api.csr"].Profile)
+	}
+
+	manual := resultByName["manual/admin.csr.pem"]
+	if manual.Err != nil || manual.SignRequestId == "" {
+		t.Fatalf("unexpected result %#v", manual)
+	}
+	requestContent, err := os.ReadFile(filepath.Join(spoolDir, "out", "manual", "admin.request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(requestContent)) != manual.SignRequestId {
+		t.Fatalf("unexpected request file %q", requestContent)
+	}
+	if signRequest, err := oneCa.SignRequest(manual.SignRequestId); err != nil || signRequest.Profile != "manual" {
+		t.Fatalf("unexpected sign request %#v %v", signRequest, err)
+	}
+
+	for name, expectedErr := range map[string]error{
+		"broken.csr.pem":        caissuingprocess.ErrInvalidCsr,
+		"unknown/other.csr.pem": caissuingprocess.ErrUnknownProfile,
+	} {
+		result := resultByName[name]
+		if !result.Rejected || !errors.Is(result.Err, expectedErr) {
+			t.Fatalf("%s: expected rejection with %v, got %#v", name, expectedErr, result)
+		}
+		if _, err := os.Stat(filepath.Join(spoolDir, "rejected", filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+		reason, err := os.ReadFile(filepath.Join(spoolDir, "rejected", filepath.FromSlash(name)+".error"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(reason), expectedErr.Error()) {
+			t.Fatalf("%s: unexpected reason %q", name, reason)
+		}
+	}
+
+	if storageType == caissuingprocess.StorageTypeFilesystem {
+		historyEntries, err := oneCa.History()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.ContainsFunc(historyEntries, func(historyEntry caissuingprocess.HistoryEntryType) bool {
+			return strings.TrimSpace(historyEntry.Message) == "After rejecting broken.csr.pem"
+		}) {
+			t.Fatalf("rejection not committed: %#v", historyEntries)
+		}
+	}
+
+	for _, name := range []string{".incomplete.csr.pem", "server/.partial.csr", "out/ignored.csr.pem", "rejected/older.csr.pem"} {
+		if _, err := os.Stat(filepath.Join(spoolDir, filepath.FromSlash(name))); err != nil {
+			t.Fatalf("%s: expected to be left alone: %v", name, err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(spoolDir, "again.csr.pem"), []byte("not a CSR"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := oneCa.IssueAllCsrInQueue(ctx); !errors.Is(err, caissuingprocess.ErrInvalidCsr) {
+		t.Fatalf("expected ErrInvalidCsr, got %v", err)
+	}
+	if results, err := oneCa.ProcessCsrSpool(ctx, 0); err != nil || len(results) != 0 {
+		t.Fatalf("rejected CSR processed again: %#v %v", results, err)
+	}
+}
+
+func TestProcessCsrSpoolApprovalIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	dataDirectory := t.TempDir()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_spool",
+		dataDirectory,
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_spool",
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Days: 1,
+			},
+			KeyConfig: types.KeyConfigType{
+				Type: "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{
+					CurveName: "P-256",
+				},
+			},
+			CrlTtl: 12 * time.Hour,
+			Profiles: map[string]types.ProfileType{
+				"manual": {ApprovalRequired: true},
+			},
+			// the failed transaction is rolled back
+			Storage: &types.StorageConfigType{
+				Type: caissuingprocess.StorageTypeBbolt,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spoolDir := filepath.Join(dataDirectory, "test_spool", "data", "csr")
+	if err := os.MkdirAll(filepath.Join(spoolDir, "manual"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(spoolDir, "manual", "admin.csr.pem"), quotaTestCsr(t, "admin.example.com"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the request file cannot be written over a directory
+	requestFilename := filepath.Join(spoolDir, "out", "manual", "admin.request")
+	if err := os.MkdirAll(filepath.Join(requestFilename, "blocking"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := oneCa.ProcessCsrSpool(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err == nil || results[0].Rejected {
+		t.Fatalf("expected a failure to retry, got %#v", results)
+	}
+	if signRequests, err := oneCa.ListSignRequests(caissuingprocess.SignRequestPending); err != nil || len(signRequests) != 0 {
+		t.Fatalf("sign request queued without its request file: %#v %v", signRequests, err)
+	}
+
+	if err := os.RemoveAll(requestFilename); err != nil {
+		t.Fatal(err)
+	}
+	results, err = oneCa.ProcessCsrSpool(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results %#v", results)
+	}
+	signRequests, err := oneCa.ListSignRequests(caissuingprocess.SignRequestPending)
+	if err != nil || len(signRequests) != 1 || signRequests[0].Id != results[0].SignRequestId {
+		t.Fatalf("expected a single sign request, got %#v %v", signRequests, err)
+	}
+}
+
+func TestProcessCsrSpoolRetriesOverQuota(t *testing.T) {
+	ctx := context.Background()
+	dataDirectory := t.TempDir()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_spool",
+		dataDirectory,
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_spool",
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Days: 1,
+			},
+			KeyConfig: types.KeyConfigType{
+				Type: "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{
+					CurveName: "P-256",
+				},
+			},
+			CrlTtl: 12 * time.Hour,
+			Limits: &types.LimitsType{
+				MaxActiveCertificatesPerSan: 1,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes, err := oneCa.SignCsr(ctx, quotaTestCsr(t, "www.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := pemhelper.FromPemToCertificate(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spooledFilename := filepath.Join(dataDirectory, "test_spool", "data", "csr", "www.csr.pem")
+	if err := os.WriteFile(spooledFilename, quotaTestCsr(t, "www.example.com"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := oneCa.ProcessCsrSpool(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !errors.Is(results[0].Err, caissuingprocess.ErrQuotaExceeded) || results[0].Rejected {
+		t.Fatalf("expected a quota failure to retry, got %#v", results)
+	}
+	if _, err := os.Stat(spooledFilename); err != nil {
+		t.Fatalf("CSR over quota not left in spool: %v", err)
+	}
+
+	if err := oneCa.RevokeOneSerial(ctx, crt.SerialNumber); err != nil {
+		t.Fatal(err)
+	}
+	results, err = oneCa.ProcessCsrSpool(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err != nil || results[0].Serial == nil {
+		t.Fatalf("expected the CSR to be issued, got %#v", results)
+	}
+}
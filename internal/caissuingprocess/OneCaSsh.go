@@ -0,0 +1,120 @@
+package caissuingprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/auditlog"
+	"github.com/tomaluca95/simple-ca/internal/metrics"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// Kind is x509 or ssh.
+func (oneCa *OneCaType) Kind() string {
+	return oneCa.caConfig.Kind
+}
+
+// SignSshPublicKey issues an OpenSSH certificate, returned in the
+// authorized_keys format.
+func (oneCa *OneCaType) SignSshPublicKey(ctx context.Context, request SshSignRequestType) ([]byte, error) {
+	if oneCa.caConfig.Kind != types.CaKindSsh {
+		return nil, fmt.Errorf("%w: SSH certificate on a CA of kind %s", ErrNotSupportedByCaKind, oneCa.caConfig.Kind)
+	}
+	sshConfig := types.SshConfigType{}
+	if oneCa.caConfig.Ssh != nil {
+		sshConfig = *oneCa.caConfig.Ssh
+	}
+	startTime := time.Now()
+	var content []byte
+	var serialNumber *big.Int
+	if err := oneCa.transaction(
+		ctx,
+		"issuing ssh certificate",
+		func(tx caStorageTxType) error {
+			newContent, newSerialNumber, err := signOneSshPublicKey(
+				types.LoggerFromContext(ctx, oneCa.logger),
+				oneCa.sshSigner,
+				sshConfig,
+				request,
+				tx,
+			)
+			if err != nil {
+				return err
+			}
+			content = newContent
+			serialNumber = newSerialNumber
+			return nil
+		},
+	); err != nil {
+		if errors.Is(err, ErrInvalidSshSignRequest) {
+			metrics.InvalidCsrTotal.WithLabelValues(oneCa.caId).Inc()
+		}
+		oneCa.audit(ctx, auditlog.OperationSign, serialNumber, err)
+		return nil, err
+	}
+	metrics.SignDuration.WithLabelValues(oneCa.caId).Observe(time.Since(startTime).Seconds())
+	metrics.CertificatesIssuedTotal.WithLabelValues(oneCa.caId).Inc()
+	if err := oneCa.audit(ctx, auditlog.OperationSign, serialNumber, nil); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// GetSshPublicKey returns the public key of the CA in the authorized_keys
+// format, as trusted by TrustedUserCAKeys or @cert-authority.
+func (oneCa *OneCaType) GetSshPublicKey() ([]byte, error) {
+	if oneCa.caConfig.Kind != types.CaKindSsh {
+		return nil, fmt.Errorf("%w: SSH public key of a CA of kind %s", ErrNotSupportedByCaKind, oneCa.caConfig.Kind)
+	}
+	return ssh.MarshalAuthorizedKey(oneCa.sshSigner.PublicKey()), nil
+}
+
+// GetKrl returns the key revocation list, as used by RevokedKeys.
+func (oneCa *OneCaType) GetKrl() ([]byte, error) {
+	if oneCa.caConfig.Kind != types.CaKindSsh {
+		return nil, fmt.Errorf("%w: KRL of a CA of kind %s", ErrNotSupportedByCaKind, oneCa.caConfig.Kind)
+	}
+	var fileContent []byte
+	if err := oneCa.storage.View(func(tx caStorageTxType) error {
+		content, err := tx.ReadKrl()
+		fileContent = content
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return fileContent, nil
+}
+
+// sshStatus has no certificate nor CRL expiry, a KRL does not expire.
+func (oneCa *OneCaType) sshStatus() (metrics.CaStatusType, error) {
+	status := metrics.CaStatusType{}
+	if err := oneCa.storage.View(func(tx caStorageTxType) error {
+		revokedCertsInfo, err := tx.ListRevocations()
+		if err != nil {
+			return err
+		}
+		status.RevokedEntries = len(revokedCertsInfo)
+		return nil
+	}); err != nil {
+		return status, err
+	}
+	return status, nil
+}
+
+func (oneCa *OneCaType) checkKrl() error {
+	krl, err := oneCa.GetKrl()
+	if err != nil {
+		return err
+	}
+	if krl == nil {
+		return fmt.Errorf("%w: no KRL", ErrKrlInvalid)
+	}
+	if _, err := getNextKrlVersion(krl); err != nil {
+		return fmt.Errorf("%w: %v", ErrKrlInvalid, err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package caissuingprocess_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func sshTestPublicKey(t *testing.T) string {
+	t.Helper()
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(ssh.MarshalAuthorizedKey(sshPublicKey))
+}
+
+func TestSshCa(t *testing.T) {
+	for _, storageType := range []string{caissuingprocess.StorageTypeFilesystem, caissuingprocess.StorageTypeBbolt} {
+		t.Run(storageType, func(t *testing.T) {
+			testSshCa(t, storageType)
+		})
+	}
+}
+
+func testSshCa(t *testing.T, storageType string) {
+	ctx := context.Background()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_ssh_ca",
+		t.TempDir(),
+		types.CertificateAuthorityType{
+			Kind: types.CaKindSsh,
+			KeyConfig: types.KeyConfigType{
+				Type: "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{
+					CurveName: "P-256",
+				},
+			},
+			Ssh: &types.SshConfigType{
+				MaxValidity: 8 * time.Hour,
+				CriticalOptions: map[string]string{
+					"source-address": "10.0.0.0/8",
+				},
+			},
+			Storage: &types.StorageConfigType{
+				Type: storageType,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := oneCa.UpdateCrl(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oneCa.SignCsr(ctx, quotaTestCsr(t, "www.example.com")); !errors.Is(err, caissuingprocess.ErrNotSupportedByCaKind) {
+		t.Fatalf("expected ErrNotSupportedByCaKind, got %v", err)
+	}
+
+	for _, invalidRequest := range []caissuingprocess.SshSignRequestType{
+		{PublicKey: "not a key", Principals: []string{"alice"}},
+		{PublicKey: sshTestPublicKey(t)},
+		{PublicKey: sshTestPublicKey(t), Principals: []string{"alice"}, Validity: "9h"},
+		{PublicKey: sshTestPublicKey(t), Principals: []string{"alice"}, CertType: "robot"},
+	} {
+		if _, err := oneCa.SignSshPublicKey(ctx, invalidRequest); !errors.Is(err, caissuingprocess.ErrInvalidSshSignRequest) {
+			t.Fatalf("expected ErrInvalidSshSignRequest for %#v, got %v", invalidRequest, err)
+		}
+	}
+
+	certificateContent, err := oneCa.SignSshPublicKey(ctx, caissuingprocess.SshSignRequestType{
+		PublicKey:       sshTestPublicKey(t),
+		KeyId:           "alice@example.com",
+		Principals:      []string{"alice", "admin"},
+		Validity:        "1h",
+		CriticalOptions: map[string]string{"force-command": "/bin/true", "source-address": "0.0.0.0/0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(certificateContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshCertificate := publicKey.(*ssh.Certificate)
+	caPublicKeyContent, err := oneCa.GetSshPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPublicKey, _, _, _, err := ssh.ParseAuthorizedKey(caPublicKeyContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certChecker := &ssh.CertChecker{
+		SupportedCriticalOptions: []string{"force-command", "source-address"},
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return string(auth.Marshal()) == string(caPublicKey.Marshal())
+		},
+	}
+	if err := certChecker.CheckCert("alice", sshCertificate); err != nil {
+		t.Fatal(err)
+	}
+	if sshCertificate.CertType != ssh.UserCert || sshCertificate.KeyId != "alice@example.com" {
+		t.Fatalf("unexpected certificate %d %q", sshCertificate.CertType, sshCertificate.KeyId)
+	}
+	// the options of the CA win over the ones of the request
+	if options := sshCertificate.CriticalOptions; options["source-address"] != "10.0.0.0/8" || options["force-command"] != "/bin/true" {
+		t.Fatalf("unexpected critical options %v", options)
+	}
+	if _, found := sshCertificate.Extensions["permit-pty"]; !found {
+		t.Fatalf("missing default extensions %v", sshCertificate.Extensions)
+	}
+	if validity := time.Duration(sshCertificate.ValidBefore-sshCertificate.ValidAfter) * time.Second; validity != time.Hour {
+		t.Fatalf("unexpected validity %s", validity)
+	}
+
+	krlBefore, err := oneCa.GetKrl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := oneCa.RevokeOneSerial(ctx, new(big.Int).SetUint64(sshCertificate.Serial)); err != nil {
+		t.Fatal(err)
+	}
+	if err := oneCa.RevokeOneSerial(ctx, big.NewInt(12345)); !errors.Is(err, caissuingprocess.ErrUnknownSerial) {
+		t.Fatalf("expected ErrUnknownSerial, got %v", err)
+	}
+	krl, err := oneCa.GetKrl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(krl) == string(krlBefore) {
+		t.Fatal("KRL not updated")
+	}
+	if status, err := oneCa.Status(); err != nil || status.RevokedEntries != 1 {
+		t.Fatalf("expected 1 revoked entry, got %#v %v", status, err)
+	}
+	for checkName, err := range oneCa.SelfCheck(ctx) {
+		if err != nil {
+			t.Fatalf("%s: %v", checkName, err)
+		}
+	}
+
+	sshKeygen, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		t.Skip("ssh-keygen not found, KRL not checked")
+	}
+	testDir := t.TempDir()
+	krlFilename := filepath.Join(testDir, "ca.krl")
+	certificateFilename := filepath.Join(testDir, "id-cert.pub")
+	if err := os.WriteFile(krlFilename, krl, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certificateFilename, certificateContent, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command(sshKeygen, "-Q", "-f", krlFilename, certificateFilename).CombinedOutput()
+	if err == nil {
+		t.Fatalf("certificate not revoked by the KRL: %s", output)
+	}
+	if err := os.WriteFile(krlFilename, krlBefore, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.Command(sshKeygen, "-Q", "-f", krlFilename, certificateFilename).CombinedOutput(); err != nil {
+		t.Fatalf("certificate revoked by the empty KRL: %v %s", err, output)
+	}
+}
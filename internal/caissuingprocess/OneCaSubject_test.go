@@ -0,0 +1,212 @@
+package caissuingprocess_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func TestSubjectPolicy(t *testing.T) {
+	ctx := context.Background()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_subject",
+		t.TempDir(),
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName:         "test_subject",
+				Country:            []string{"IT"},
+				Organization:       []string{"Example Corp"},
+				OrganizationalUnit: []string{"PKI"},
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Days: 1,
+			},
+			KeyConfig: types.KeyConfigType{
+				Type: "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{
+					CurveName: "P-256",
+				},
+			},
+			CrlTtl: 12 * time.Hour,
+			Profiles: map[string]types.ProfileType{
+				"rewrite": {Subject: &types.SubjectPolicyType{
+					AllowedAttributes: []string{"common_name", "organizational_unit"},
+					CommonNameFromSan: true,
+					DefaultFromCa:     []string{"organizational_unit"},
+					ForceFromCa:       []string{"organization", "country"},
+				}},
+				"from san": {Subject: &types.SubjectPolicyType{
+					CommonNameFromSan: true,
+				}},
+				"template": {Subject: &types.SubjectPolicyType{
+					Template: &types.CertificateAuthoritySubjectType{
+						CommonName:         "{{ .FirstSan }}",
+						OrganizationalUnit: []string{"{{ .Values.team }}"},
+						Locality:           []string{"{{ index .Csr.Locality 0 }}", "{{ .Values.site }}"},
+					},
+				}},
+				"manual": {ApprovalRequired: true, Subject: &types.SubjectPolicyType{
+					Template: &types.CertificateAuthoritySubjectType{
+						CommonName: "{{ .Values.team }}.example.com",
+					},
+				}},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCsr := func(template x509.CertificateRequest) []byte {
+		t.Helper()
+		privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.CreateCertificateRequest(rand.Reader, &template, privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	}
+	csrContent := newCsr(x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:         "my laptop",
+			Organization:       []string{"Evil Corp"},
+			OrganizationalUnit: []string{"Sales"},
+			Locality:           []string{"Rome"},
+		},
+		DNSNames: []string{"www.example.com", "example.com"},
+	})
+	// domain component, user id and email address
+	withOtherAttributes := newCsr(x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName: "my laptop",
+			ExtraNames: []pkix.AttributeTypeAndValue{
+				{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}, Value: "example"},
+				{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, Value: "jdoe"},
+				{Type: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}, Value: "jdoe@example.com"},
+			},
+		},
+		DNSNames: []string{"www.example.com"},
+	})
+	withoutOu := newCsr(x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "www.example.com", Locality: []string{"Rome"}},
+		DNSNames: []string{"www.example.com"},
+	})
+
+	for _, testCase := range []struct {
+		name          string
+		csr           []byte
+		profile       string
+		subjectValues map[string]string
+		subject       string
+	}{
+		{
+			name:    "copied",
+			csr:     csrContent,
+			subject: "CN=my laptop,OU=Sales,O=Evil Corp,L=Rome",
+		},
+		{
+			name:    "rewritten",
+			csr:     csrContent,
+			profile: "rewrite",
+			subject: "CN=www.example.com,OU=Sales,O=Example Corp,C=IT",
+		},
+		{
+			name:    "default from CA",
+			csr:     withoutOu,
+			profile: "rewrite",
+			subject: "CN=www.example.com,OU=PKI,O=Example Corp,C=IT",
+		},
+		{
+			name:    "other attributes kept",
+			csr:     withOtherAttributes,
+			profile: "from san",
+			subject: "1.2.840.113549.1.9.1=jdoe@example.com,0.9.2342.19200300.100.1.1=jdoe,0.9.2342.19200300.100.1.25=example,CN=www.example.com",
+		},
+		{
+			name:    "other attributes not allowed",
+			csr:     withOtherAttributes,
+			profile: "rewrite",
+			subject: "CN=www.example.com,OU=PKI,O=Example Corp,C=IT",
+		},
+		{
+			name:          "template",
+			csr:           csrContent,
+			profile:       "template",
+			subjectValues: map[string]string{"team": "team-a", "site": ""},
+			subject:       "CN=www.example.com,OU=team-a,L=Rome",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			subject, err := oneCa.CertificateSubject(testCase.csr, testCase.profile, testCase.subjectValues)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject.String() != testCase.subject {
+				t.Fatalf("expected subject %q, got %q", testCase.subject, subject.String())
+			}
+			pemBytes, err := oneCa.SignCsrWithProfile(ctx, testCase.csr, testCase.profile, testCase.subjectValues)
+			if err != nil {
+				t.Fatal(err)
+			}
+			crt, err := pemhelper.FromPemToCertificate(pemBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// crt.Subject.String() moves the attributes it does not know
+			var rawSubject pkix.RDNSequence
+			if _, err := asn1.Unmarshal(crt.RawSubject, &rawSubject); err != nil {
+				t.Fatal(err)
+			}
+			if rawSubject.String() != testCase.subject {
+				t.Fatalf("expected certificate subject %q, got %q", testCase.subject, rawSubject.String())
+			}
+		})
+	}
+
+	if _, err := oneCa.SignCsrWithProfile(ctx, csrContent, "template", nil); !errors.Is(err, caissuingprocess.ErrInvalidCsr) {
+		t.Fatalf("expected ErrInvalidCsr without the values of the template, got %v", err)
+	}
+
+	// the values returned by OPA are kept until the request is approved
+	signRequest, err := oneCa.SubmitSignRequest(ctx, csrContent, "manual", map[string]string{"team": "team-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signRequest.Subject != "CN=team-b.example.com" {
+		t.Fatalf("unexpected subject of the sign request %q", signRequest.Subject)
+	}
+	approved, err := oneCa.ApproveSignRequest(ctx, signRequest.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialNumber, _ := new(big.Int).SetString(approved.Serial, 10)
+	pemBytes, err := oneCa.GetCertificatePem(serialNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := pemhelper.FromPemToCertificate(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crt.Subject.CommonName != "team-b.example.com" {
+		t.Fatalf("unexpected subject of the approved certificate %q", crt.Subject.String())
+	}
+}
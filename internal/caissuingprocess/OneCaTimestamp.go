@@ -0,0 +1,229 @@
+package caissuingprocess
+
+import (
+	"context"
+	"crypto"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/auditlog"
+	"github.com/tomaluca95/simple-ca/internal/metrics"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+var ErrTsaNotEnabled = errors.New("time-stamping not enabled")
+
+// timestampRecordType is what the data repository keeps of a time-stamp
+// token, enough to prove which token was issued for which digest.
+type timestampRecordType struct {
+	SerialNumber         string    `json:"serial_number"`
+	GenTime              time.Time `json:"gen_time"`
+	Policy               string    `json:"policy"`
+	HashAlgorithm        string    `json:"hash_algorithm"`
+	HashedMessage        string    `json:"hashed_message"`
+	Nonce                string    `json:"nonce,omitempty"`
+	TsaCertificateSerial string    `json:"tsa_certificate_serial"`
+}
+
+// loadTsa reads or generates the TSA key, of the same type as the CA key, and
+// issues the TSA certificate when needed.
+func (oneCa *OneCaType) loadTsa(ctx context.Context) error {
+	tsaCertificateSerial, err := oneCa.withTsaCertificate(ctx, "loading TSA certificate", func(tx caStorageTxType) error {
+		return nil
+	})
+	if tsaCertificateSerial != nil || err != nil {
+		oneCa.audit(ctx, auditlog.OperationSign, tsaCertificateSerial, err)
+	}
+	return err
+}
+
+// withTsaCertificate runs fn in a transaction where the TSA key and a valid
+// TSA certificate are loaded. The serial of a newly issued TSA certificate is
+// returned.
+func (oneCa *OneCaType) withTsaCertificate(
+	ctx context.Context,
+	msg string,
+	fn func(tx caStorageTxType) error,
+) (*big.Int, error) {
+	logger := types.LoggerFromContext(ctx, oneCa.logger)
+	var tsaCertificateSerial *big.Int
+	err := oneCa.transaction(
+		ctx,
+		msg,
+		func(tx caStorageTxType) error {
+			oneCa.tsaMu.Lock()
+			defer oneCa.tsaMu.Unlock()
+			if oneCa.tsaPrivateKey == nil {
+				var tsaPrivateKey crypto.Signer
+				var err error
+				switch keyConfigData := oneCa.caConfig.KeyConfig.Config.(type) {
+				case types.KeyTypeRsaConfigType:
+					tsaPrivateKey, err = getRsaPrivateKeyOrCreateNew(logger, oneCa.tsaFilenamePrivateKey(), keyConfigData.Size)
+				case types.KeyTypeEcdsaConfigType:
+					tsaPrivateKey, err = getEcdsaPrivateKeyOrCreateNew(logger, oneCa.tsaFilenamePrivateKey(), keyConfigData.CurveName)
+				default:
+					err = fmt.Errorf("%w: %T", types.ErrInvalidKeyType, keyConfigData)
+				}
+				if err != nil {
+					return err
+				}
+				oneCa.tsaPrivateKey = tsaPrivateKey
+			}
+
+			tsaCertificate, newSerial, err := getTsaCertificateOrCreateNew(
+				logger,
+				tx,
+				oneCa.tsaFilenameCertificate(),
+				*oneCa.caConfig.Tsa,
+				oneCa.expiryWarning(),
+				oneCa.caCertificate,
+				oneCa.caPrivateKey,
+				oneCa.tsaPrivateKey,
+			)
+			if err != nil {
+				return err
+			}
+			oneCa.tsaCertificate = tsaCertificate
+			tsaCertificateSerial = newSerial
+			return fn(tx)
+		},
+	)
+	if err == nil && tsaCertificateSerial != nil {
+		metrics.CertificatesIssuedTotal.WithLabelValues(oneCa.caId).Inc()
+	}
+	return tsaCertificateSerial, err
+}
+
+// Timestamp answers an RFC 3161 TimeStampReq with a TimeStampResp. A query
+// that cannot be served gets a rejection response together with an error
+// wrapping ErrInvalidTimestampQuery.
+func (oneCa *OneCaType) Timestamp(ctx context.Context, queryContent []byte) ([]byte, error) {
+	if oneCa.caConfig.Tsa == nil || oneCa.caConfig.Kind != types.CaKindX509 {
+		return nil, ErrTsaNotEnabled
+	}
+	policy, err := oneCa.caConfig.Tsa.PolicyOid()
+	if err != nil {
+		return nil, err
+	}
+	request, rejectionContent, err := parseTimestampRequest(queryContent, policy)
+	if err != nil {
+		oneCa.audit(ctx, auditlog.OperationTimestamp, nil, err)
+		return rejectionContent, err
+	}
+
+	serialLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := cryptorand.Int(cryptorand.Reader, serialLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate time-stamp serial: %w", err)
+	}
+
+	var responseContent []byte
+	tsaCertificateSerial, err := oneCa.withTsaCertificate(
+		ctx,
+		"timestamping "+serialNumber.String(),
+		func(tx caStorageTxType) error {
+			genTime := time.Now().UTC().Truncate(time.Second)
+			newResponseContent, err := createTimestampResponse(
+				request,
+				policy,
+				oneCa.caConfig.Tsa.Accuracy,
+				serialNumber,
+				genTime,
+				oneCa.tsaCertificate,
+				oneCa.tsaPrivateKey,
+			)
+			if err != nil {
+				return err
+			}
+			record := timestampRecordType{
+				SerialNumber:         serialNumber.String(),
+				GenTime:              genTime,
+				Policy:               policy.String(),
+				HashAlgorithm:        timestampHashAlgorithms[request.MessageImprint.HashAlgorithm.Algorithm.String()].name,
+				HashedMessage:        hex.EncodeToString(request.MessageImprint.HashedMessage),
+				TsaCertificateSerial: oneCa.tsaCertificate.SerialNumber.String(),
+			}
+			if request.Nonce != nil {
+				record.Nonce = request.Nonce.String()
+			}
+			recordContent, err := json.MarshalIndent(record, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := tx.WriteTimestamp(serialNumber, recordContent); err != nil {
+				return err
+			}
+			types.LoggerFromContext(ctx, oneCa.logger).Info("Generate new time-stamp", "serial", serialNumber.String(), "hash_algorithm", record.HashAlgorithm, "hashed_message", record.HashedMessage)
+			responseContent = newResponseContent
+			return nil
+		},
+	)
+	if tsaCertificateSerial != nil {
+		oneCa.audit(ctx, auditlog.OperationSign, tsaCertificateSerial, nil)
+	}
+	if err != nil {
+		oneCa.audit(ctx, auditlog.OperationTimestamp, serialNumber, err)
+		return nil, err
+	}
+	metrics.TimestampsIssuedTotal.WithLabelValues(oneCa.caId).Inc()
+	if err := oneCa.audit(ctx, auditlog.OperationTimestamp, serialNumber, nil); err != nil {
+		return nil, err
+	}
+	return responseContent, nil
+}
+
+// GetTsaCertificatePem returns the certificate that signs the time-stamp
+// tokens, as needed to verify a token issued without it.
+func (oneCa *OneCaType) GetTsaCertificatePem() ([]byte, error) {
+	if oneCa.caConfig.Tsa == nil || oneCa.caConfig.Kind != types.CaKindX509 {
+		return nil, ErrTsaNotEnabled
+	}
+	oneCa.tsaMu.Lock()
+	tsaCertificate := oneCa.tsaCertificate
+	oneCa.tsaMu.Unlock()
+	return pemhelper.ToPem(tsaCertificate)
+}
+
+// GetTimestampRecord returns what was logged of an issued time-stamp token.
+func (oneCa *OneCaType) GetTimestampRecord(serial *big.Int) ([]byte, error) {
+	var fileContent []byte
+	if err := oneCa.storage.View(func(tx caStorageTxType) error {
+		content, err := tx.ReadTimestamp(serial)
+		fileContent = content
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if fileContent == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSerial, serial.String())
+	}
+	return fileContent, nil
+}
+
+func (oneCa *OneCaType) tsaFilenamePrivateKey() string {
+	return filepath.Join(oneCa.caDir, "tsa.key.pem")
+}
+
+func (oneCa *OneCaType) tsaFilenameCertificate() string {
+	return filepath.Join(oneCa.caDir, "tsa.crt.pem")
+}
+
+func (oneCa *OneCaType) checkTsaCertificate() error {
+	oneCa.tsaMu.Lock()
+	tsaCertificate := oneCa.tsaCertificate
+	oneCa.tsaMu.Unlock()
+	if notAfter := tsaCertificate.NotAfter; time.Now().After(notAfter) {
+		return fmt.Errorf("%w: TSA certificate not after %s", ErrCaCertificateExpiring, notAfter.Format(time.RFC3339))
+	}
+	if err := tsaCertificate.CheckSignatureFrom(oneCa.caCertificate); err != nil {
+		return fmt.Errorf("%w: TSA certificate: %v", ErrKeyCannotSign, err)
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package caissuingprocess_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+type timestampTestImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timestampTestRequest struct {
+	Version        int
+	MessageImprint timestampTestImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+}
+
+type timestampTestResponse struct {
+	Status struct {
+		Status       int
+		StatusString []asn1.RawValue `asn1:"optional"`
+		FailInfo     asn1.BitString  `asn1:"optional"`
+	}
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+func timestampTestQuery(t *testing.T, data []byte, policy asn1.ObjectIdentifier) []byte {
+	t.Helper()
+	digest := sha256.Sum256(data)
+	queryContent, err := asn1.Marshal(timestampTestRequest{
+		Version: 1,
+		MessageImprint: timestampTestImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}},
+			HashedMessage: digest[:],
+		},
+		ReqPolicy: policy,
+		Nonce:     big.NewInt(424242),
+		CertReq:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queryContent
+}
+
+func TestTimestamp(t *testing.T) {
+	t.Run("filesystem_ecdsa", func(t *testing.T) {
+		testTimestamp(t, caissuingprocess.StorageTypeFilesystem, types.KeyConfigType{
+			Type:   "ecdsa",
+			Config: types.KeyTypeEcdsaConfigType{CurveName: "P-256"},
+		})
+	})
+	t.Run("bbolt_rsa", func(t *testing.T) {
+		testTimestamp(t, caissuingprocess.StorageTypeBbolt, types.KeyConfigType{
+			Type:   "rsa",
+			Config: types.KeyTypeRsaConfigType{Size: 2048},
+		})
+	})
+}
+
+func testTimestamp(t *testing.T, storageType string, keyConfig types.KeyConfigType) {
+	ctx := context.Background()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_tsa",
+		t.TempDir(),
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_tsa",
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Years: 1,
+			},
+			KeyConfig: keyConfig,
+			CrlTtl:    12 * time.Hour,
+			Tsa: &types.TsaConfigType{
+				Policy:   "1.3.6.1.4.1.99999.1",
+				Accuracy: 1500 * time.Millisecond,
+			},
+			Storage: &types.StorageConfigType{
+				Type: storageType,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tsaCertificatePem, err := oneCa.GetTsaCertificatePem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsaCertificate, err := pemhelper.FromPemToCertificate(tsaCertificatePem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oneCa.GetCertificatePem(tsaCertificate.SerialNumber); err != nil {
+		t.Fatalf("TSA certificate not stored: %v", err)
+	}
+
+	rejectionContent, err := oneCa.Timestamp(ctx, timestampTestQuery(t, []byte("artifact"), asn1.ObjectIdentifier{1, 2, 3}))
+	if !errors.Is(err, caissuingprocess.ErrInvalidTimestampQuery) {
+		t.Fatalf("expected ErrInvalidTimestampQuery, got %v", err)
+	}
+	var rejection timestampTestResponse
+	if _, err := asn1.Unmarshal(rejectionContent, &rejection); err != nil {
+		t.Fatal(err)
+	}
+	// unacceptedPolicy is bit 15 of PKIFailureInfo
+	if rejection.Status.Status != 2 || rejection.Status.FailInfo.At(15) != 1 {
+		t.Fatalf("unexpected rejection %#v", rejection.Status)
+	}
+
+	artifact := []byte("build artifact content")
+	responseContent, err := oneCa.Timestamp(ctx, timestampTestQuery(t, artifact, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response timestampTestResponse
+	if _, err := asn1.Unmarshal(responseContent, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Status.Status != 0 || len(response.TimeStampToken.FullBytes) == 0 {
+		t.Fatalf("unexpected response %#v", response.Status)
+	}
+
+	if err, found := oneCa.SelfCheck(ctx)[caissuingprocess.SelfCheckTsa]; !found || err != nil {
+		t.Fatalf("TSA self check: %t %v", found, err)
+	}
+
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl not found, token not verified")
+	}
+	testDir := t.TempDir()
+	caPem, err := oneCa.GetIssuerPem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for filename, content := range map[string][]byte{
+		"ca.pem":       caPem,
+		"artifact":     artifact,
+		"response.tsr": responseContent,
+	} {
+		if err := os.WriteFile(filepath.Join(testDir, filename), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if output, err := exec.Command(openssl, "ts", "-verify",
+		"-in", filepath.Join(testDir, "response.tsr"),
+		"-data", filepath.Join(testDir, "artifact"),
+		"-CAfile", filepath.Join(testDir, "ca.pem"),
+	).CombinedOutput(); err != nil {
+		t.Fatalf("openssl ts -verify: %v %s", err, output)
+	}
+}
+
+func TestTimestampRecord(t *testing.T) {
+	ctx := context.Background()
+	dataDirectory := t.TempDir()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_tsa",
+		dataDirectory,
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_tsa",
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Years: 1,
+			},
+			KeyConfig: types.KeyConfigType{
+				Type: "rsa",
+				Config: types.KeyTypeRsaConfigType{
+					Size: 2048,
+				},
+			},
+			CrlTtl: 12 * time.Hour,
+			Tsa: &types.TsaConfigType{
+				Policy: "1.3.6.1.4.1.99999.1",
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oneCa.Timestamp(ctx, timestampTestQuery(t, []byte("artifact"), nil)); err != nil {
+		t.Fatal(err)
+	}
+	recordFilenames, err := filepath.Glob(filepath.Join(dataDirectory, "test_tsa", "data", "tsa", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordFilenames) != 1 {
+		t.Fatalf("expected 1 time-stamp record, got %v", recordFilenames)
+	}
+	content, err := os.ReadFile(recordFilenames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record map[string]string
+	if err := json.Unmarshal(content, &record); err != nil {
+		t.Fatal(err)
+	}
+	serial, isInt := new(big.Int).SetString(record["serial_number"], 10)
+	if !isInt || record["hash_algorithm"] != "sha256" || record["nonce"] != "424242" {
+		t.Fatalf("unexpected record %v", record)
+	}
+	if _, err := oneCa.GetTimestampRecord(serial); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := oneCa.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history[0].Message != "After timestamping "+record["serial_number"] {
+		t.Fatalf("unexpected last commit %q", history[0].Message)
+	}
+}
+
+func TestTsaCertificateNotIssuedNearCaExpiry(t *testing.T) {
+	_, err := caissuingprocess.LoadOneCa(
+		context.Background(),
+		&types.StdLogger{},
+		"test_tsa",
+		t.TempDir(),
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_tsa",
+			},
+			// within the default expiry warning
+			Validity: types.CertificateAuthorityValidityType{
+				Days: 1,
+			},
+			KeyConfig: types.KeyConfigType{
+				Type:   "ecdsa",
+				Config: types.KeyTypeEcdsaConfigType{CurveName: "P-256"},
+			},
+			CrlTtl: 12 * time.Hour,
+			Tsa: &types.TsaConfigType{
+				Policy: "1.3.6.1.4.1.99999.1",
+			},
+		},
+	)
+	if !errors.Is(err, caissuingprocess.ErrCaCertificateExpiring) {
+		t.Fatalf("expected ErrCaCertificateExpiring, got %v", err)
+	}
+}
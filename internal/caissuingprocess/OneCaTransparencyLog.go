@@ -0,0 +1,159 @@
+package caissuingprocess
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/merkletree"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+var ErrUnknownLeaf = errors.New("leaf not in transparency log")
+
+// SignedTreeHeadType is the signed tree head of RFC 6962 section 3.5.
+type SignedTreeHeadType struct {
+	TreeSize  uint64
+	Timestamp uint64
+	RootHash  []byte
+	// Signature is the TLS digitally-signed struct of the TreeHeadSignature,
+	// signed by the CA key.
+	Signature []byte
+}
+
+// TransparencyLogId is the log id of RFC 6962 section 3.2, the hash of the
+// CA public key.
+func (oneCa *OneCaType) TransparencyLogId() []byte {
+	logId := sha256.Sum256(oneCa.caCertificate.RawSubjectPublicKeyInfo)
+	return logId[:]
+}
+
+// TransparencyLogSignedTreeHead signs the current head of the log.
+func (oneCa *OneCaType) TransparencyLogSignedTreeHead() (SignedTreeHeadType, error) {
+	leafHashes, err := oneCa.transparencyLogLeafHashes(nil)
+	if err != nil {
+		return SignedTreeHeadType{}, err
+	}
+	treeHead := SignedTreeHeadType{
+		TreeSize:  uint64(len(leafHashes)),
+		Timestamp: uint64(time.Now().UnixMilli()),
+		RootHash:  merkletree.RootHash(leafHashes),
+	}
+	var b cryptobyte.Builder
+	b.AddUint8(ctVersionV1)
+	b.AddUint8(ctSignatureTypeTreeHash)
+	b.AddUint64(treeHead.Timestamp)
+	b.AddUint64(treeHead.TreeSize)
+	b.AddBytes(treeHead.RootHash)
+	treeHeadSignature, err := b.Bytes()
+	if err != nil {
+		return SignedTreeHeadType{}, err
+	}
+	treeHead.Signature, err = signTransparencyLogStruct(oneCa.caPrivateKey, treeHeadSignature)
+	if err != nil {
+		return SignedTreeHeadType{}, err
+	}
+	return treeHead, nil
+}
+
+// TransparencyLogEntries returns the entries from start to end included,
+// fewer when the log ends before end.
+func (oneCa *OneCaType) TransparencyLogEntries(start uint64, end uint64) ([]TransparencyLogEntryType, error) {
+	if start > end {
+		return nil, fmt.Errorf("%w: entries %d to %d", merkletree.ErrInvalidTreeSize, start, end)
+	}
+	entries := []TransparencyLogEntryType{}
+	if err := oneCa.storage.View(func(tx caStorageTxType) error {
+		size, err := tx.TransparencyLogSize()
+		if err != nil {
+			return err
+		}
+		if start >= size {
+			return fmt.Errorf("%w: entry %d of a log of %d", merkletree.ErrInvalidTreeSize, start, size)
+		}
+		for index := start; index <= end && index < size; index++ {
+			entry, err := readTransparencyLogEntry(tx, index)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// TransparencyLogInclusionProof returns the index and the audit path of the
+// leaf with leafHash in the tree of the first treeSize entries.
+func (oneCa *OneCaType) TransparencyLogInclusionProof(leafHash []byte, treeSize uint64) (uint64, [][]byte, error) {
+	leafHashes, err := oneCa.transparencyLogLeafHashes(&treeSize)
+	if err != nil {
+		return 0, nil, err
+	}
+	for index, candidate := range leafHashes {
+		if bytes.Equal(candidate, leafHash) {
+			proof, err := merkletree.InclusionProof(uint64(index), leafHashes)
+			return uint64(index), proof, err
+		}
+	}
+	return 0, nil, fmt.Errorf("%w: in the first %d entries", ErrUnknownLeaf, treeSize)
+}
+
+// TransparencyLogConsistencyProof proves that the tree of the first entries
+// is a prefix of the tree of the second entries.
+func (oneCa *OneCaType) TransparencyLogConsistencyProof(first uint64, second uint64) ([][]byte, error) {
+	leafHashes, err := oneCa.transparencyLogLeafHashes(&second)
+	if err != nil {
+		return nil, err
+	}
+	return merkletree.ConsistencyProof(first, leafHashes)
+}
+
+// transparencyLogLeafHashes hashes the first treeSize entries, or all of
+// them when treeSize is nil.
+func (oneCa *OneCaType) transparencyLogLeafHashes(treeSize *uint64) ([][]byte, error) {
+	leafHashes := [][]byte{}
+	if err := oneCa.storage.View(func(tx caStorageTxType) error {
+		size, err := tx.TransparencyLogSize()
+		if err != nil {
+			return err
+		}
+		if treeSize != nil {
+			if *treeSize > size {
+				return fmt.Errorf("%w: %d, the log has %d entries", merkletree.ErrInvalidTreeSize, *treeSize, size)
+			}
+			size = *treeSize
+		}
+		for index := range size {
+			entry, err := readTransparencyLogEntry(tx, index)
+			if err != nil {
+				return err
+			}
+			leafHashes = append(leafHashes, merkletree.LeafHash(entry.LeafInput))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return leafHashes, nil
+}
+
+func readTransparencyLogEntry(tx caStorageTxType, index uint64) (TransparencyLogEntryType, error) {
+	var entry TransparencyLogEntryType
+	content, err := tx.ReadTransparencyLogEntry(index)
+	if err != nil {
+		return entry, err
+	}
+	if content == nil {
+		return entry, fmt.Errorf("transparency log entry %d is missing", index)
+	}
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return entry, fmt.Errorf("transparency log entry %d: %w", index, err)
+	}
+	return entry, nil
+}
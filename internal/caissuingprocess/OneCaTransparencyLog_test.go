@@ -0,0 +1,270 @@
+package caissuingprocess_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/merkletree"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyteasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var oidExtensionSctList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+func TestTransparencyLog(t *testing.T) {
+	t.Run("filesystem", func(t *testing.T) {
+		testTransparencyLog(t, caissuingprocess.StorageTypeFilesystem, types.KeyConfigType{
+			Type:   "ecdsa",
+			Config: types.KeyTypeEcdsaConfigType{CurveName: "P-256"},
+		})
+	})
+	t.Run("bbolt", func(t *testing.T) {
+		testTransparencyLog(t, caissuingprocess.StorageTypeBbolt, types.KeyConfigType{
+			Type:   "rsa",
+			Config: types.KeyTypeRsaConfigType{Size: 2048},
+		})
+	})
+}
+
+// verifyDigitallySigned checks a TLS digitally-signed struct as a log client
+// would.
+func verifyDigitallySigned(t *testing.T, publicKey crypto.PublicKey, content []byte, digitallySigned []byte) {
+	t.Helper()
+	input := cryptobyte.String(digitallySigned)
+	var hashAlgorithm, signatureAlgorithm uint8
+	var signature cryptobyte.String
+	if !input.ReadUint8(&hashAlgorithm) || !input.ReadUint8(&signatureAlgorithm) ||
+		!input.ReadUint16LengthPrefixed(&signature) || !input.Empty() || hashAlgorithm != 4 {
+		t.Fatalf("malformed digitally-signed struct %x", digitallySigned)
+	}
+	digest := sha256.Sum256(content)
+	switch publicKey := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if signatureAlgorithm != 3 || !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+			t.Fatal("invalid ECDSA signature")
+		}
+	case *rsa.PublicKey:
+		if signatureAlgorithm != 1 {
+			t.Fatalf("unexpected signature algorithm %d", signatureAlgorithm)
+		}
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("unexpected key %T", publicKey)
+	}
+}
+
+func testTransparencyLog(t *testing.T, storageType string, keyConfig types.KeyConfigType) {
+	ctx := context.Background()
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		&types.StdLogger{},
+		"test_ct",
+		t.TempDir(),
+		types.CertificateAuthorityType{
+			Subject: types.CertificateAuthoritySubjectType{
+				CommonName: "test_ct",
+			},
+			Validity: types.CertificateAuthorityValidityType{
+				Days: 1,
+			},
+			KeyConfig: keyConfig,
+			CrlTtl:    12 * time.Hour,
+			Storage: &types.StorageConfigType{
+				Type: storageType,
+			},
+			TransparencyLog: &types.TransparencyLogType{
+				EmbedSct: true,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerPem, err := oneCa.GetIssuerPem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCertificate, err := pemhelper.FromPemToCertificate(issuerPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedTreeHead := func() caissuingprocess.SignedTreeHeadType {
+		t.Helper()
+		treeHead, err := oneCa.TransparencyLogSignedTreeHead()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b cryptobyte.Builder
+		b.AddUint8(0)
+		b.AddUint8(1)
+		b.AddUint64(treeHead.Timestamp)
+		b.AddUint64(treeHead.TreeSize)
+		b.AddBytes(treeHead.RootHash)
+		verifyDigitallySigned(t, caCertificate.PublicKey, b.BytesOrPanic(), treeHead.Signature)
+		return treeHead
+	}
+
+	// the CA certificate is the first entry
+	firstTreeHead := signedTreeHead()
+	if firstTreeHead.TreeSize != 1 {
+		t.Fatalf("expected the CA certificate in the log, got size %d", firstTreeHead.TreeSize)
+	}
+	entries, err := oneCa.TransparencyLogEntries(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caLeaf := cryptobyte.String(entries[0].LeafInput)
+	var version, leafType uint8
+	var timestamp uint64
+	var entryType uint16
+	var caCertificateDer cryptobyte.String
+	if !caLeaf.ReadUint8(&version) || !caLeaf.ReadUint8(&leafType) || !caLeaf.ReadUint64(&timestamp) ||
+		!caLeaf.ReadUint16(&entryType) || !caLeaf.ReadUint24LengthPrefixed(&caCertificateDer) ||
+		version != 0 || leafType != 0 || entryType != 0 || !bytes.Equal(caCertificateDer, caCertificate.Raw) {
+		t.Fatalf("unexpected CA certificate entry %x", entries[0].LeafInput)
+	}
+
+	certificates := []*x509.Certificate{}
+	for _, dnsName := range []string{"www.example.com", "api.example.com"} {
+		pemBytes, err := oneCa.SignCsr(ctx, quotaTestCsr(t, dnsName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		certificate, err := pemhelper.FromPemToCertificate(pemBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	lastTreeHead := signedTreeHead()
+	if lastTreeHead.TreeSize != 3 {
+		t.Fatalf("expected 3 entries, got %d", lastTreeHead.TreeSize)
+	}
+	entries, err = oneCa.TransparencyLogEntries(1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+
+	issuerKeyHash := sha256.Sum256(caCertificate.RawSubjectPublicKeyInfo)
+	for i, certificate := range certificates {
+		entry := entries[i]
+		if entry.Serial.Cmp(certificate.SerialNumber) != 0 {
+			t.Fatalf("entry of serial %s for certificate %s", entry.Serial, certificate.SerialNumber)
+		}
+
+		// the embedded SCT
+		var sctExtensionValue []byte
+		for _, extension := range certificate.Extensions {
+			if extension.Id.Equal(oidExtensionSctList) {
+				if _, err := asn1.Unmarshal(extension.Value, &sctExtensionValue); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		sctList := cryptobyte.String(sctExtensionValue)
+		var scts, sct, sctExtensions cryptobyte.String
+		var logId []byte
+		var sctTimestamp uint64
+		if !sctList.ReadUint16LengthPrefixed(&scts) || !scts.ReadUint16LengthPrefixed(&sct) ||
+			!sct.ReadUint8(&version) || !sct.ReadBytes(&logId, 32) || !sct.ReadUint64(&sctTimestamp) ||
+			!sct.ReadUint16LengthPrefixed(&sctExtensions) || version != 0 {
+			t.Fatalf("malformed SCT list %x", sctExtensionValue)
+		}
+		if !bytes.Equal(logId, oneCa.TransparencyLogId()) {
+			t.Fatalf("unexpected log id %x", logId)
+		}
+
+		// the logged precertificate is the certificate without the SCT
+		leaf := cryptobyte.String(entry.LeafInput)
+		var entryIssuerKeyHash []byte
+		var tbsCertificate cryptobyte.String
+		if !leaf.ReadUint8(&version) || !leaf.ReadUint8(&leafType) || !leaf.ReadUint64(&timestamp) ||
+			!leaf.ReadUint16(&entryType) || !leaf.ReadBytes(&entryIssuerKeyHash, 32) ||
+			!leaf.ReadUint24LengthPrefixed(&tbsCertificate) || entryType != 1 || timestamp != sctTimestamp ||
+			!bytes.Equal(entryIssuerKeyHash, issuerKeyHash[:]) {
+			t.Fatalf("unexpected precertificate entry %x", entry.LeafInput)
+		}
+		verifyDigitallySigned(t, caCertificate.PublicKey, entry.LeafInput, []byte(sct))
+
+		certificateInput := cryptobyte.String(certificate.Raw)
+		var certificateFields cryptobyte.String
+		if !certificateInput.ReadASN1(&certificateFields, cryptobyteasn1.SEQUENCE) ||
+			!certificateFields.SkipASN1(cryptobyteasn1.SEQUENCE) {
+			t.Fatal("malformed certificate")
+		}
+		var b cryptobyte.Builder
+		b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddBytes(tbsCertificate)
+			b.AddBytes(certificateFields)
+		})
+		precertificate, err := x509.ParseCertificate(b.BytesOrPanic())
+		if err != nil {
+			t.Fatal(err)
+		}
+		extensionsWithoutSct := []any{}
+		for _, extension := range certificate.Extensions {
+			if !extension.Id.Equal(oidExtensionSctList) {
+				extensionsWithoutSct = append(extensionsWithoutSct, extension)
+			}
+		}
+		precertificateExtensions := []any{}
+		for _, extension := range precertificate.Extensions {
+			precertificateExtensions = append(precertificateExtensions, extension)
+		}
+		if precertificate.SerialNumber.Cmp(certificate.SerialNumber) != 0 ||
+			!bytes.Equal(precertificate.RawSubject, certificate.RawSubject) ||
+			!bytes.Equal(precertificate.RawSubjectPublicKeyInfo, certificate.RawSubjectPublicKeyInfo) ||
+			!reflect.DeepEqual(precertificateExtensions, extensionsWithoutSct) {
+			t.Fatalf("precertificate of %s differs from the certificate", certificate.SerialNumber)
+		}
+
+		// inclusion in the last tree head
+		leafHash := merkletree.LeafHash(entry.LeafInput)
+		index, proof, err := oneCa.TransparencyLogInclusionProof(leafHash, lastTreeHead.TreeSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != uint64(i+1) {
+			t.Fatalf("unexpected index %d", index)
+		}
+		if err := merkletree.VerifyInclusion(index, lastTreeHead.TreeSize, leafHash, proof, lastTreeHead.RootHash); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := oneCa.TransparencyLogInclusionProof(leafHash, firstTreeHead.TreeSize); !errors.Is(err, caissuingprocess.ErrUnknownLeaf) {
+			t.Fatalf("expected ErrUnknownLeaf, got %v", err)
+		}
+	}
+
+	proof, err := oneCa.TransparencyLogConsistencyProof(firstTreeHead.TreeSize, lastTreeHead.TreeSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := merkletree.VerifyConsistency(firstTreeHead.TreeSize, lastTreeHead.TreeSize, firstTreeHead.RootHash, lastTreeHead.RootHash, proof); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oneCa.TransparencyLogConsistencyProof(1, lastTreeHead.TreeSize+1); !errors.Is(err, merkletree.ErrInvalidTreeSize) {
+		t.Fatalf("expected ErrInvalidTreeSize, got %v", err)
+	}
+	if _, err := oneCa.TransparencyLogEntries(lastTreeHead.TreeSize, lastTreeHead.TreeSize+1); !errors.Is(err, merkletree.ErrInvalidTreeSize) {
+		t.Fatalf("expected ErrInvalidTreeSize, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package caissuingprocess
+
+import (
+	"context"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/filelock"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+const caLockDefaultTimeout = 30 * time.Second
+
+// caLockType serializes the operations on one CA among all the processes
+// sharing the same data directory.
+type caLockType struct {
+	lockFilename string
+	lockTimeout  time.Duration
+
+	logger types.Logger
+}
+
+func newCaLock(logger types.Logger, lockFilename string, lockTimeout time.Duration) caLockType {
+	if lockTimeout <= 0 {
+		lockTimeout = caLockDefaultTimeout
+	}
+	return caLockType{
+		lockFilename: lockFilename,
+		lockTimeout:  lockTimeout,
+		logger:       logger,
+	}
+}
+
+func (caLock caLockType) acquire(ctx context.Context) (func(), error) {
+	logger := types.LoggerFromContext(ctx, caLock.logger)
+	fileLock, staleHolder, err := filelock.Lock(ctx, caLock.lockFilename, caLock.lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if staleHolder != nil {
+		logger.Warn("Stale lock, the previous holder exited during an operation", "filename", caLock.lockFilename, "holder", staleHolder.String())
+	}
+	return func() {
+		if err := fileLock.Unlock(); err != nil {
+			logger.Warn("Failed releasing lock", "filename", caLock.lockFilename, "err", err)
+		}
+	}, nil
+}
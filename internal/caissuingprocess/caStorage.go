@@ -0,0 +1,140 @@
+package caissuingprocess
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+const (
+	StorageTypeFilesystem = "filesystem"
+	StorageTypeBbolt      = "bbolt"
+)
+
+// caStorageType persists everything a CA issues. Every change happens inside
+// a transaction so that a storage can make it atomic.
+type caStorageType interface {
+	Transaction(ctx context.Context, msg string, runner func(tx caStorageTxType) error) error
+	View(runner func(tx caStorageTxType) error) error
+	// Check reports a storage that is not consistent with the last completed
+	// transaction.
+	Check(ctx context.Context) error
+	// Recover brings back to a consistent state a storage left by an
+	// interrupted process.
+	Recover(ctx context.Context) error
+}
+
+// caStorageTxType reads return nil content when the item does not exist.
+type caStorageTxType interface {
+	ReadCertificate(serial *big.Int) ([]byte, error)
+	WriteCertificate(serial *big.Int, pemBytes []byte) error
+	ListCertificateSerials() ([]*big.Int, error)
+
+	ListRevocations() ([]crlIndexEntryType, error)
+	AddRevocations(entries []crlIndexEntryType) error
+
+	// The SAN index is kept by the caller, see readSanIndex.
+	ReadSanIndex() ([]byte, error)
+	WriteSanIndex(content []byte) error
+	RemoveSanIndex() error
+
+	// The audit head anchors the audit log, see OneCaType.transaction.
+	ReadAuditHead() ([]byte, error)
+	WriteAuditHead(content []byte) error
+
+	ReadCrl() ([]byte, error)
+	WriteCrl(pemBytes []byte) error
+
+	ReadSshCertificate(serial *big.Int) ([]byte, error)
+	WriteSshCertificate(serial *big.Int, content []byte) error
+
+	ReadKrl() ([]byte, error)
+	WriteKrl(content []byte) error
+
+	ReadTimestamp(serial *big.Int) ([]byte, error)
+	WriteTimestamp(serial *big.Int, content []byte) error
+
+	// The transparency log is append only, its entries are numbered from 0.
+	TransparencyLogSize() (uint64, error)
+	ReadTransparencyLogEntry(index uint64) ([]byte, error)
+	AppendTransparencyLogEntry(content []byte) (uint64, error)
+
+	ListCsrQueue() ([]string, error)
+	CsrModTime(name string) (time.Time, error)
+	ReadCsr(name string) ([]byte, error)
+	RemoveCsr(name string) error
+	WriteCsrOutput(name string, content []byte) error
+	RejectCsr(name string, reason string) error
+
+	ListSignRequestIds() ([]string, error)
+	ReadSignRequest(id string) ([]byte, error)
+	WriteSignRequest(id string, content []byte) error
+}
+
+// caStorageWithHistoryType is implemented by storages that keep every
+// previous state of the CA.
+type caStorageWithHistoryType interface {
+	History() ([]HistoryEntryType, error)
+	RestoreTo(ctx context.Context, revision string, afterRestore func(tx caStorageTxType) error) error
+}
+
+type crlIndexEntryType struct {
+	SerialNumber   *big.Int `yaml:"serial_number" json:"serial_number"`
+	RevocationTime int64    `yaml:"revocation_time" json:"revocation_time"`
+}
+
+func newCaStorage(
+	logger types.Logger,
+	caId string,
+	caDir string,
+	caConfig types.CertificateAuthorityType,
+	gitSigner git.Signer,
+) (caStorageType, error) {
+	storageType := StorageTypeFilesystem
+	if caConfig.Storage != nil && caConfig.Storage.Type != "" {
+		storageType = caConfig.Storage.Type
+	}
+	caLock := newCaLock(logger, filepath.Join(caDir, "ca.lock"), caConfig.LockTimeout)
+	switch storageType {
+	case StorageTypeFilesystem:
+		return newCaStorageFilesystemGit(logger, caId, caDir, caLock, caConfig.Git, gitSigner)
+	case StorageTypeBbolt:
+		dbFilename := filepath.Join(caDir, "ca.db")
+		if caConfig.Storage.Path != nil {
+			dbFilename = *caConfig.Storage.Path
+		}
+		return newCaStorageBbolt(caDir, caLock, dbFilename)
+	default:
+		return nil, fmt.Errorf("%w: %q", types.ErrInvalidStorageType, storageType)
+	}
+}
+
+// sortAndDeduplicateRevocations keeps the earliest revocation of every serial.
+func sortAndDeduplicateRevocations(revokedCertsInfo []crlIndexEntryType) []crlIndexEntryType {
+	sort.Slice(revokedCertsInfo, func(i, j int) bool {
+		cmpResult := revokedCertsInfo[i].SerialNumber.Cmp(revokedCertsInfo[j].SerialNumber)
+		if cmpResult < 0 {
+			return true
+		} else if cmpResult == 0 {
+			return revokedCertsInfo[i].RevocationTime < revokedCertsInfo[j].RevocationTime
+		} else {
+			return false
+		}
+	})
+
+	i := 0
+	for i < len(revokedCertsInfo)-1 {
+		if revokedCertsInfo[i].SerialNumber.Cmp(revokedCertsInfo[i+1].SerialNumber) == 0 {
+			revokedCertsInfo = append(revokedCertsInfo[:i+1], revokedCertsInfo[i+2:]...)
+		} else {
+			i++
+		}
+	}
+	return revokedCertsInfo
+}
@@ -0,0 +1,333 @@
+package caissuingprocess
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bboltBucketCertificates    = []byte("certificates")
+	bboltBucketRevocations     = []byte("revocations")
+	bboltBucketCrl             = []byte("crl")
+	bboltBucketSignRequests    = []byte("sign_requests")
+	bboltBucketSshCertificates = []byte("ssh_certificates")
+	bboltBucketTimestamps      = []byte("timestamps")
+	bboltBucketTransparencyLog = []byte("transparency_log")
+
+	bboltKeyCurrentCrl = []byte("current")
+	bboltKeyCurrentKrl = []byte("current_krl")
+	bboltKeySanIndex   = []byte("san_index")
+	bboltKeyAuditHead  = []byte("audit_head")
+)
+
+// The database is opened for each transaction so that other processes using
+// the same CA are not locked out for the lifetime of this one.
+const bboltOpenTimeout = 30 * time.Second
+
+// caStorageBboltType keeps certificates, revocations, the CRL and the KRL in
+// an embedded bbolt database, every transaction is atomic.
+type caStorageBboltType struct {
+	csrSpoolType
+
+	dbFilename string
+
+	caLock caLockType
+	mu     sync.Mutex
+}
+
+func newCaStorageBbolt(
+	caDir string,
+	caLock caLockType,
+	dbFilename string,
+) (*caStorageBboltType, error) {
+	storage := &caStorageBboltType{
+		csrSpoolType: csrSpoolType{
+			csrSpoolDir: filepath.Join(caDir, "data", "csr"),
+		},
+		dbFilename: dbFilename,
+		caLock:     caLock,
+	}
+	if err := os.MkdirAll(storage.csrSpoolDir, os.FileMode(0o755)); err != nil {
+		return nil, fmt.Errorf("%s: %w", storage.csrSpoolDir, err)
+	}
+	if err := storage.withDb(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			for _, bucketName := range [][]byte{
+				bboltBucketCertificates,
+				bboltBucketRevocations,
+				bboltBucketCrl,
+				bboltBucketSignRequests,
+				bboltBucketSshCertificates,
+				bboltBucketTimestamps,
+				bboltBucketTransparencyLog,
+			} {
+				if _, err := tx.CreateBucketIfNotExists(bucketName); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+func (storage *caStorageBboltType) withDb(runner func(db *bolt.DB) error) error {
+	db, err := bolt.Open(storage.dbFilename, os.FileMode(0o600), &bolt.Options{
+		Timeout: bboltOpenTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", storage.dbFilename, err)
+	}
+	if err := runner(db); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+func (storage *caStorageBboltType) Transaction(
+	ctx context.Context,
+	msg string,
+	runner func(tx caStorageTxType) error,
+) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	release, err := storage.caLock.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return storage.withDb(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			return runner(&caStorageBboltTxType{
+				csrSpoolType: storage.csrSpoolType,
+				tx:           tx,
+			})
+		})
+	})
+}
+
+func (storage *caStorageBboltType) View(runner func(tx caStorageTxType) error) error {
+	return storage.withDb(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			return runner(&caStorageBboltTxType{
+				csrSpoolType: storage.csrSpoolType,
+				tx:           tx,
+			})
+		})
+	})
+}
+
+func (storage *caStorageBboltType) Check(ctx context.Context) error {
+	return storage.withDb(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			for _, bucketName := range [][]byte{
+				bboltBucketCertificates,
+				bboltBucketRevocations,
+				bboltBucketCrl,
+				bboltBucketSignRequests,
+				bboltBucketSshCertificates,
+				bboltBucketTimestamps,
+				bboltBucketTransparencyLog,
+			} {
+				if tx.Bucket(bucketName) == nil {
+					return fmt.Errorf("%s: missing bucket %s", storage.dbFilename, bucketName)
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Recover has nothing to do, bbolt transactions are atomic.
+func (storage *caStorageBboltType) Recover(ctx context.Context) error {
+	return nil
+}
+
+type caStorageBboltTxType struct {
+	csrSpoolType
+
+	tx *bolt.Tx
+}
+
+func copyBytes(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
+
+func (boltTx *caStorageBboltTxType) ReadCertificate(serial *big.Int) ([]byte, error) {
+	return copyBytes(boltTx.tx.Bucket(bboltBucketCertificates).Get([]byte(serial.String()))), nil
+}
+
+func (boltTx *caStorageBboltTxType) WriteCertificate(serial *big.Int, pemBytes []byte) error {
+	bucket := boltTx.tx.Bucket(bboltBucketCertificates)
+	key := []byte(serial.String())
+	if bucket.Get(key) != nil {
+		return fmt.Errorf("certificate %s exists", serial.String())
+	}
+	return bucket.Put(key, pemBytes)
+}
+
+func (boltTx *caStorageBboltTxType) ListCertificateSerials() ([]*big.Int, error) {
+	serials := []*big.Int{}
+	if err := boltTx.tx.Bucket(bboltBucketCertificates).ForEach(func(k, v []byte) error {
+		serial, isInt := new(big.Int).SetString(string(k), 10)
+		if !isInt {
+			return fmt.Errorf("invalid certificate key %q", k)
+		}
+		serials = append(serials, serial)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return serials, nil
+}
+
+func (boltTx *caStorageBboltTxType) ListRevocations() ([]crlIndexEntryType, error) {
+	revokedCertsInfo := []crlIndexEntryType{}
+	if err := boltTx.tx.Bucket(bboltBucketRevocations).ForEach(func(k, v []byte) error {
+		var revokedCertInfo crlIndexEntryType
+		if err := json.Unmarshal(v, &revokedCertInfo); err != nil {
+			return err
+		}
+		revokedCertsInfo = append(revokedCertsInfo, revokedCertInfo)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return sortAndDeduplicateRevocations(revokedCertsInfo), nil
+}
+
+func (boltTx *caStorageBboltTxType) AddRevocations(entries []crlIndexEntryType) error {
+	bucket := boltTx.tx.Bucket(bboltBucketRevocations)
+	for _, entry := range sortAndDeduplicateRevocations(entries) {
+		key := []byte(entry.SerialNumber.String())
+		if bucket.Get(key) != nil {
+			continue
+		}
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (boltTx *caStorageBboltTxType) ReadCrl() ([]byte, error) {
+	return copyBytes(boltTx.tx.Bucket(bboltBucketCrl).Get(bboltKeyCurrentCrl)), nil
+}
+
+func (boltTx *caStorageBboltTxType) WriteCrl(pemBytes []byte) error {
+	return boltTx.tx.Bucket(bboltBucketCrl).Put(bboltKeyCurrentCrl, pemBytes)
+}
+
+func (boltTx *caStorageBboltTxType) ReadSanIndex() ([]byte, error) {
+	return copyBytes(boltTx.tx.Bucket(bboltBucketCrl).Get(bboltKeySanIndex)), nil
+}
+
+func (boltTx *caStorageBboltTxType) WriteSanIndex(content []byte) error {
+	return boltTx.tx.Bucket(bboltBucketCrl).Put(bboltKeySanIndex, content)
+}
+
+func (boltTx *caStorageBboltTxType) RemoveSanIndex() error {
+	return boltTx.tx.Bucket(bboltBucketCrl).Delete(bboltKeySanIndex)
+}
+
+func (boltTx *caStorageBboltTxType) ReadAuditHead() ([]byte, error) {
+	return copyBytes(boltTx.tx.Bucket(bboltBucketCrl).Get(bboltKeyAuditHead)), nil
+}
+
+func (boltTx *caStorageBboltTxType) WriteAuditHead(content []byte) error {
+	return boltTx.tx.Bucket(bboltBucketCrl).Put(bboltKeyAuditHead, content)
+}
+
+func (boltTx *caStorageBboltTxType) ListSignRequestIds() ([]string, error) {
+	ids := []string{}
+	if err := boltTx.tx.Bucket(bboltBucketSignRequests).ForEach(func(k, v []byte) error {
+		ids = append(ids, string(k))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (boltTx *caStorageBboltTxType) ReadSignRequest(id string) ([]byte, error) {
+	return copyBytes(boltTx.tx.Bucket(bboltBucketSignRequests).Get([]byte(id))), nil
+}
+
+func (boltTx *caStorageBboltTxType) WriteSignRequest(id string, content []byte) error {
+	return boltTx.tx.Bucket(bboltBucketSignRequests).Put([]byte(id), content)
+}
+
+func (boltTx *caStorageBboltTxType) ReadSshCertificate(serial *big.Int) ([]byte, error) {
+	return copyBytes(boltTx.tx.Bucket(bboltBucketSshCertificates).Get([]byte(serial.String()))), nil
+}
+
+func (boltTx *caStorageBboltTxType) WriteSshCertificate(serial *big.Int, content []byte) error {
+	bucket := boltTx.tx.Bucket(bboltBucketSshCertificates)
+	key := []byte(serial.String())
+	if bucket.Get(key) != nil {
+		return fmt.Errorf("SSH certificate %s exists", serial.String())
+	}
+	return bucket.Put(key, content)
+}
+
+func (boltTx *caStorageBboltTxType) ReadKrl() ([]byte, error) {
+	return copyBytes(boltTx.tx.Bucket(bboltBucketCrl).Get(bboltKeyCurrentKrl)), nil
+}
+
+func (boltTx *caStorageBboltTxType) WriteKrl(content []byte) error {
+	return boltTx.tx.Bucket(bboltBucketCrl).Put(bboltKeyCurrentKrl, content)
+}
+
+func (boltTx *caStorageBboltTxType) ReadTimestamp(serial *big.Int) ([]byte, error) {
+	return copyBytes(boltTx.tx.Bucket(bboltBucketTimestamps).Get([]byte(serial.String()))), nil
+}
+
+func (boltTx *caStorageBboltTxType) WriteTimestamp(serial *big.Int, content []byte) error {
+	bucket := boltTx.tx.Bucket(bboltBucketTimestamps)
+	key := []byte(serial.String())
+	if bucket.Get(key) != nil {
+		return fmt.Errorf("time-stamp %s exists", serial.String())
+	}
+	return bucket.Put(key, content)
+}
+
+func (boltTx *caStorageBboltTxType) TransparencyLogSize() (uint64, error) {
+	lastKey, _ := boltTx.tx.Bucket(bboltBucketTransparencyLog).Cursor().Last()
+	if lastKey == nil {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(lastKey) + 1, nil
+}
+
+func (boltTx *caStorageBboltTxType) ReadTransparencyLogEntry(index uint64) ([]byte, error) {
+	return copyBytes(boltTx.tx.Bucket(bboltBucketTransparencyLog).Get(binary.BigEndian.AppendUint64(nil, index))), nil
+}
+
+func (boltTx *caStorageBboltTxType) AppendTransparencyLogEntry(content []byte) (uint64, error) {
+	index, err := boltTx.TransparencyLogSize()
+	if err != nil {
+		return 0, err
+	}
+	return index, boltTx.tx.Bucket(bboltBucketTransparencyLog).Put(binary.BigEndian.AppendUint64(nil, index), content)
+}
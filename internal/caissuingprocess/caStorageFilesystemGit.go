@@ -0,0 +1,580 @@
+package caissuingprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/tomaluca95/simple-ca/internal/metrics"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+// caStorageFilesystemGitType keeps certificates and the revocation index as
+// files in a git repository, the CRL and the KRL are kept next to the CA
+// private key.
+type caStorageFilesystemGitType struct {
+	csrSpoolType
+
+	caId                  string
+	dataDir               string
+	crlIndexFilename      string
+	sanIndexFilename      string
+	auditHeadFilename     string
+	issuedCertificatesDir string
+	sshCertificatesDir    string
+	timestampsDir         string
+	transparencyLogDir    string
+	signRequestsDir       string
+	caFilenameCrl         string
+	caFilenameKrl         string
+
+	gitConfig *types.GitRepositoryType
+	gitSigner git.Signer
+
+	caLock caLockType
+	mu     sync.Mutex
+
+	logger types.Logger
+}
+
+func newCaStorageFilesystemGit(
+	logger types.Logger,
+	caId string,
+	caDir string,
+	caLock caLockType,
+	gitConfig *types.GitRepositoryType,
+	gitSigner git.Signer,
+) (*caStorageFilesystemGitType, error) {
+	dataDir := filepath.Join(caDir, "data")
+	storage := &caStorageFilesystemGitType{
+		csrSpoolType: csrSpoolType{
+			csrSpoolDir: filepath.Join(dataDir, "csr"),
+		},
+		caId:                  caId,
+		dataDir:               dataDir,
+		crlIndexFilename:      filepath.Join(dataDir, "crl.yml"),
+		sanIndexFilename:      filepath.Join(dataDir, "san.yml"),
+		auditHeadFilename:     filepath.Join(dataDir, "audit.head"),
+		issuedCertificatesDir: filepath.Join(dataDir, "crt"),
+		sshCertificatesDir:    filepath.Join(dataDir, "ssh"),
+		timestampsDir:         filepath.Join(dataDir, "tsa"),
+		transparencyLogDir:    filepath.Join(dataDir, "ct"),
+		signRequestsDir:       filepath.Join(dataDir, "requests"),
+		caFilenameCrl:         filepath.Join(caDir, "ca.crl.pem"),
+		caFilenameKrl:         filepath.Join(caDir, "ca.krl"),
+		gitConfig:             gitConfig,
+		gitSigner:             gitSigner,
+		caLock:                caLock,
+		logger:                logger,
+	}
+
+	if err := os.MkdirAll(storage.dataDir, os.FileMode(0o711)); err != nil {
+		return nil, fmt.Errorf("%s: %w", storage.dataDir, err)
+	}
+	if err := os.MkdirAll(storage.csrSpoolDir, os.FileMode(0o755)); err != nil {
+		return nil, fmt.Errorf("%s: %w", storage.csrSpoolDir, err)
+	}
+	if err := os.MkdirAll(storage.issuedCertificatesDir, os.FileMode(0o755)); err != nil {
+		return nil, fmt.Errorf("%s: %w", storage.issuedCertificatesDir, err)
+	}
+	if err := os.MkdirAll(storage.signRequestsDir, os.FileMode(0o755)); err != nil {
+		return nil, fmt.Errorf("%s: %w", storage.signRequestsDir, err)
+	}
+	return storage, nil
+}
+
+func (storage *caStorageFilesystemGitType) Transaction(
+	ctx context.Context,
+	msg string,
+	runner func(tx caStorageTxType) error,
+) error {
+	return storage.gitSnapshot(ctx, msg, func() error {
+		return runner(storage)
+	})
+}
+
+func (storage *caStorageFilesystemGitType) View(runner func(tx caStorageTxType) error) error {
+	return runner(storage)
+}
+
+var ErrWorktreeDirty = errors.New("git worktree has uncommitted changes")
+
+func (storage *caStorageFilesystemGitType) Check(ctx context.Context) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	release, err := storage.caLock.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, gitWorktree, err := gitOpenRepository(storage.dataDir)
+	if err != nil {
+		return err
+	}
+	changedFilenames, _, err := gitInterruptedChanges(gitWorktree)
+	if err != nil {
+		return err
+	}
+	if len(changedFilenames) > 0 {
+		return fmt.Errorf("%w: %s", ErrWorktreeDirty, describeFilenames(changedFilenames))
+	}
+	return nil
+}
+
+// Recover completes or rolls back an operation interrupted before its "After"
+// commit, for example by a crash during a previous run.
+func (storage *caStorageFilesystemGitType) Recover(ctx context.Context) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	release, err := storage.caLock.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	repoGit, gitWorktree, err := gitOpenRepository(storage.dataDir)
+	if err != nil {
+		return err
+	}
+	changedFilenames, _, err := gitInterruptedChanges(gitWorktree)
+	if err != nil {
+		return err
+	}
+	if len(changedFilenames) == 0 {
+		return nil
+	}
+
+	logger := types.LoggerFromContext(ctx, storage.logger)
+	rolledBackFilenames, err := gitRecoverWorktree(repoGit, gitWorktree, storage.dataDir)
+	if err != nil {
+		return fmt.Errorf("failed recovering interrupted operation: %w", err)
+	}
+	if len(rolledBackFilenames) > 0 {
+		logger.Warn("Rolled back incomplete files of an interrupted operation", "data_dir", storage.dataDir, "files", rolledBackFilenames)
+	}
+	author, committer, err := gitCommitSignatures(storage.gitConfig, types.RequesterFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if err := storage.gitCommit(gitWorktree, "Recovered interrupted operation", author, committer); err != nil {
+		return err
+	}
+	logger.Warn("Recovered interrupted operation", "data_dir", storage.dataDir, "changed_files", changedFilenames)
+	return nil
+}
+
+func (storage *caStorageFilesystemGitType) gitSnapshot(
+	ctx context.Context,
+	msg string,
+	runner func() error,
+) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	release, err := storage.caLock.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	repoGit, gitWorktree, err := gitOpenRepository(storage.dataDir)
+	if err != nil {
+		return err
+	}
+	author, committer, err := gitCommitSignatures(storage.gitConfig, types.RequesterFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if err := storage.gitCommit(gitWorktree, "Before "+msg, author, committer); err != nil {
+		return err
+	}
+	if err := runner(); err != nil {
+		return err
+	}
+	if err := storage.gitCommit(gitWorktree, "After "+msg, author, committer); err != nil {
+		return err
+	}
+	// The snapshot is already committed locally, a failed push is retried
+	// with the next operation.
+	if err := gitPushRepository(repoGit, storage.gitConfig); err != nil {
+		types.LoggerFromContext(ctx, storage.logger).Warn("Failed pushing", "data_dir", storage.dataDir, "err", err)
+	}
+	return nil
+}
+
+func (storage *caStorageFilesystemGitType) gitCommit(
+	gitWorktree *git.Worktree,
+	msg string,
+	author *object.Signature,
+	committer *object.Signature,
+) error {
+	startTime := time.Now()
+	defer func() {
+		metrics.GitCommitDuration.WithLabelValues(storage.caId).Observe(time.Since(startTime).Seconds())
+	}()
+	return gitAddAndCommitGitWorktree(
+		gitWorktree,
+		msg,
+		author,
+		committer,
+		storage.gitSigner,
+	)
+}
+
+func (storage *caStorageFilesystemGitType) certificateFilename(serial *big.Int) string {
+	return filepath.Join(storage.issuedCertificatesDir, serial.String()+".crt.pem")
+}
+
+func (storage *caStorageFilesystemGitType) ReadCertificate(serial *big.Int) ([]byte, error) {
+	content, err := os.ReadFile(storage.certificateFilename(serial))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (storage *caStorageFilesystemGitType) WriteCertificate(serial *big.Int, pemBytes []byte) error {
+	certificateFilename := storage.certificateFilename(serial)
+	if _, err := os.Stat(certificateFilename); err == nil {
+		return fmt.Errorf("file %s exists", certificateFilename)
+	}
+	return os.WriteFile(certificateFilename, pemBytes, os.FileMode(0o644))
+}
+
+func (storage *caStorageFilesystemGitType) ListCertificateSerials() ([]*big.Int, error) {
+	issuedItems, err := os.ReadDir(storage.issuedCertificatesDir)
+	if err != nil {
+		return nil, err
+	}
+	serials := []*big.Int{}
+	for _, issuedItem := range issuedItems {
+		serial, isInt := new(big.Int).SetString(strings.TrimSuffix(issuedItem.Name(), ".crt.pem"), 10)
+		if !isInt {
+			continue
+		}
+		serials = append(serials, serial)
+	}
+	return serials, nil
+}
+
+func (storage *caStorageFilesystemGitType) ListRevocations() ([]crlIndexEntryType, error) {
+	var revokedCertsInfo []crlIndexEntryType
+	crlIndexContent, err := os.ReadFile(storage.crlIndexFilename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	} else {
+		if err := yaml.Unmarshal(crlIndexContent, &revokedCertsInfo); err != nil {
+			return nil, err
+		}
+	}
+	return revokedCertsInfo, nil
+}
+
+func (storage *caStorageFilesystemGitType) AddRevocations(entries []crlIndexEntryType) error {
+	revokedCertsInfo, err := storage.ListRevocations()
+	if err != nil {
+		return err
+	}
+	revokedCertsInfo = sortAndDeduplicateRevocations(append(revokedCertsInfo, entries...))
+
+	commentPrefixToIndex := []byte(`# - serial_number: "1"
+#   revocation_time: 1714575000000 # unix time millis
+`)
+	newCrlIndexYamlContent, err := yaml.Marshal(revokedCertsInfo)
+	if err != nil {
+		return err
+	}
+
+	newCrlIndexContent := append(commentPrefixToIndex, newCrlIndexYamlContent...)
+
+	return atomicWriteFile(storage.crlIndexFilename, newCrlIndexContent, os.FileMode(0o644))
+}
+
+func (storage *caStorageFilesystemGitType) ReadSanIndex() ([]byte, error) {
+	content, err := os.ReadFile(storage.sanIndexFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (storage *caStorageFilesystemGitType) WriteSanIndex(content []byte) error {
+	return atomicWriteFile(storage.sanIndexFilename, content, os.FileMode(0o644))
+}
+
+func (storage *caStorageFilesystemGitType) RemoveSanIndex() error {
+	if err := os.Remove(storage.sanIndexFilename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (storage *caStorageFilesystemGitType) ReadAuditHead() ([]byte, error) {
+	content, err := os.ReadFile(storage.auditHeadFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (storage *caStorageFilesystemGitType) WriteAuditHead(content []byte) error {
+	return atomicWriteFile(storage.auditHeadFilename, content, os.FileMode(0o644))
+}
+
+func (storage *caStorageFilesystemGitType) ReadCrl() ([]byte, error) {
+	content, err := os.ReadFile(storage.caFilenameCrl)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (storage *caStorageFilesystemGitType) WriteCrl(pemBytes []byte) error {
+	return atomicWriteFile(storage.caFilenameCrl, pemBytes, os.FileMode(0o644))
+}
+
+func (storage *caStorageFilesystemGitType) sshCertificateFilename(serial *big.Int) string {
+	return filepath.Join(storage.sshCertificatesDir, serial.String()+"-cert.pub")
+}
+
+func (storage *caStorageFilesystemGitType) ReadSshCertificate(serial *big.Int) ([]byte, error) {
+	content, err := os.ReadFile(storage.sshCertificateFilename(serial))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (storage *caStorageFilesystemGitType) WriteSshCertificate(serial *big.Int, content []byte) error {
+	if err := os.MkdirAll(storage.sshCertificatesDir, os.FileMode(0o755)); err != nil {
+		return err
+	}
+	sshCertificateFilename := storage.sshCertificateFilename(serial)
+	if _, err := os.Stat(sshCertificateFilename); err == nil {
+		return fmt.Errorf("file %s exists", sshCertificateFilename)
+	}
+	return os.WriteFile(sshCertificateFilename, content, os.FileMode(0o644))
+}
+
+func (storage *caStorageFilesystemGitType) ReadKrl() ([]byte, error) {
+	content, err := os.ReadFile(storage.caFilenameKrl)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (storage *caStorageFilesystemGitType) WriteKrl(content []byte) error {
+	return atomicWriteFile(storage.caFilenameKrl, content, os.FileMode(0o644))
+}
+
+func (storage *caStorageFilesystemGitType) timestampFilename(serial *big.Int) string {
+	return filepath.Join(storage.timestampsDir, serial.String()+".json")
+}
+
+func (storage *caStorageFilesystemGitType) ReadTimestamp(serial *big.Int) ([]byte, error) {
+	content, err := os.ReadFile(storage.timestampFilename(serial))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (storage *caStorageFilesystemGitType) WriteTimestamp(serial *big.Int, content []byte) error {
+	if err := os.MkdirAll(storage.timestampsDir, os.FileMode(0o755)); err != nil {
+		return err
+	}
+	timestampFilename := storage.timestampFilename(serial)
+	if _, err := os.Stat(timestampFilename); err == nil {
+		return fmt.Errorf("file %s exists", timestampFilename)
+	}
+	return os.WriteFile(timestampFilename, content, os.FileMode(0o644))
+}
+
+// transparencyLogEntryFilename pads the index so that the entries are listed
+// in order.
+func (storage *caStorageFilesystemGitType) transparencyLogEntryFilename(index uint64) string {
+	return filepath.Join(storage.transparencyLogDir, fmt.Sprintf("%020d.json", index))
+}
+
+func (storage *caStorageFilesystemGitType) TransparencyLogSize() (uint64, error) {
+	entryItems, err := os.ReadDir(storage.transparencyLogDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	size := uint64(0)
+	for _, entryItem := range entryItems {
+		if strings.HasSuffix(entryItem.Name(), ".json") {
+			size++
+		}
+	}
+	return size, nil
+}
+
+func (storage *caStorageFilesystemGitType) ReadTransparencyLogEntry(index uint64) ([]byte, error) {
+	content, err := os.ReadFile(storage.transparencyLogEntryFilename(index))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (storage *caStorageFilesystemGitType) AppendTransparencyLogEntry(content []byte) (uint64, error) {
+	if err := os.MkdirAll(storage.transparencyLogDir, os.FileMode(0o755)); err != nil {
+		return 0, err
+	}
+	index, err := storage.TransparencyLogSize()
+	if err != nil {
+		return 0, err
+	}
+	entryFilename := storage.transparencyLogEntryFilename(index)
+	if _, err := os.Stat(entryFilename); err == nil {
+		return 0, fmt.Errorf("file %s exists", entryFilename)
+	}
+	// written atomically since the log is read without the CA lock
+	return index, atomicWriteFile(entryFilename, content, os.FileMode(0o644))
+}
+
+func (storage *caStorageFilesystemGitType) signRequestFilename(id string) string {
+	return filepath.Join(storage.signRequestsDir, id+".json")
+}
+
+func (storage *caStorageFilesystemGitType) ListSignRequestIds() ([]string, error) {
+	requestItems, err := os.ReadDir(storage.signRequestsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	ids := []string{}
+	for _, requestItem := range requestItems {
+		if id, isRequest := strings.CutSuffix(requestItem.Name(), ".json"); isRequest {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (storage *caStorageFilesystemGitType) ReadSignRequest(id string) ([]byte, error) {
+	content, err := os.ReadFile(storage.signRequestFilename(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (storage *caStorageFilesystemGitType) WriteSignRequest(id string, content []byte) error {
+	if err := os.MkdirAll(storage.signRequestsDir, os.FileMode(0o755)); err != nil {
+		return err
+	}
+	return atomicWriteFile(storage.signRequestFilename(id), content, os.FileMode(0o644))
+}
+
+func (storage *caStorageFilesystemGitType) History() ([]HistoryEntryType, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	release, err := storage.caLock.acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	repoGit, _, err := gitOpenRepository(storage.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return gitListHistory(repoGit)
+}
+
+func (storage *caStorageFilesystemGitType) RestoreTo(
+	ctx context.Context,
+	revision string,
+	afterRestore func(tx caStorageTxType) error,
+) error {
+	return storage.gitSnapshot(
+		ctx,
+		"restoring to "+revision,
+		func() error {
+			repoGit, _, err := gitOpenRepository(storage.dataDir)
+			if err != nil {
+				return err
+			}
+			targetHash, err := repoGit.ResolveRevision(plumbing.Revision(revision))
+			if err != nil {
+				return fmt.Errorf("invalid revision %q: %w", revision, err)
+			}
+			targetCommit, err := repoGit.CommitObject(*targetHash)
+			if err != nil {
+				return err
+			}
+			targetTree, err := targetCommit.Tree()
+			if err != nil {
+				return err
+			}
+			if err := checkRestoreIsSafe(
+				storage.dataDir,
+				[]string{storage.issuedCertificatesDir, storage.sshCertificatesDir, storage.timestampsDir, storage.transparencyLogDir},
+				storage.crlIndexFilename,
+				targetTree,
+			); err != nil {
+				return err
+			}
+			// the spooled CSRs and the sign requests are kept as they are,
+			// restoring them would drop some or make decided ones pending again
+			if err := gitRestoreWorktree(storage.dataDir, targetTree, []string{storage.csrSpoolDir, storage.signRequestsDir}); err != nil {
+				return err
+			}
+			for _, dir := range []string{storage.csrSpoolDir, storage.issuedCertificatesDir, storage.signRequestsDir} {
+				if err := os.MkdirAll(dir, os.FileMode(0o755)); err != nil {
+					return err
+				}
+			}
+			return afterRestore(storage)
+		},
+	)
+}
@@ -0,0 +1,273 @@
+package caissuingprocess
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+// The types of the names checked by checkNamePolicy.
+const (
+	NameTypeDns        = "dns"
+	NameTypeIp         = "ip"
+	NameTypeEmail      = "email"
+	NameTypeUri        = "uri"
+	NameTypeCommonName = "common_name"
+)
+
+// NameViolationType is a name of a CSR that cannot be in the certificate.
+type NameViolationType struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// NamePolicyError lists every name of a CSR that the name constraints of the
+// CA or the SAN rules of the profile refuse, it is an ErrInvalidCsr.
+type NamePolicyError struct {
+	Violations []NameViolationType
+}
+
+func (err *NamePolicyError) Error() string {
+	descriptions := []string{}
+	for _, violation := range err.Violations {
+		descriptions = append(descriptions, fmt.Sprintf("%s %q %s", violation.Type, violation.Name, violation.Reason))
+	}
+	return fmt.Sprintf("%v: names not allowed: %s", ErrInvalidCsr, strings.Join(descriptions, ", "))
+}
+
+func (err *NamePolicyError) Unwrap() error {
+	return ErrInvalidCsr
+}
+
+// checkNamePolicy evaluates the subject alternative names and the common name
+// of csr against the name constraints of the CA certificate, with the
+// matching rules of crypto/x509, and against the SAN rules of the profile
+// when it has them. The common name is checked as a DNS name or an IP address
+// when it looks like one.
+func checkNamePolicy(caCertificate *x509.Certificate, csr *x509.CertificateRequest, sanRules *types.SanRulesType) error {
+	violations := []NameViolationType{}
+	violate := func(name string, nameType string, format string, args ...any) {
+		violations = append(violations, NameViolationType{Name: name, Type: nameType, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	checkDnsName := func(name string, nameType string) {
+		if sanRules != nil && !sanRules.WildcardAllowed && strings.Contains(name, "*") {
+			violate(name, nameType, "wildcard not allowed by the profile")
+		}
+		if reason := checkDomainConstraints(name, caCertificate.PermittedDNSDomains, caCertificate.ExcludedDNSDomains, "dns_domains"); reason != "" {
+			violate(name, nameType, "%s", reason)
+		}
+	}
+	checkIp := func(ip net.IP, nameType string) {
+		if reason := checkIpConstraints(ip, caCertificate.PermittedIPRanges, caCertificate.ExcludedIPRanges); reason != "" {
+			violate(ip.String(), nameType, "%s", reason)
+		}
+	}
+
+	for _, dnsName := range csr.DNSNames {
+		checkDnsName(dnsName, NameTypeDns)
+	}
+	for _, ip := range csr.IPAddresses {
+		checkIp(ip, NameTypeIp)
+	}
+	for _, emailAddress := range csr.EmailAddresses {
+		domain := emailAddress
+		if at := strings.LastIndex(emailAddress, "@"); at >= 0 {
+			domain = emailAddress[at+1:]
+		}
+		reason := ""
+		for _, excluded := range caCertificate.ExcludedEmailAddresses {
+			if matchEmailConstraint(emailAddress, domain, excluded) {
+				reason = fmt.Sprintf("matches %q of excluded_email_addresses", excluded)
+				break
+			}
+		}
+		if reason == "" && len(caCertificate.PermittedEmailAddresses) > 0 {
+			reason = "not in permitted_email_addresses"
+			for _, permitted := range caCertificate.PermittedEmailAddresses {
+				if matchEmailConstraint(emailAddress, domain, permitted) {
+					reason = ""
+					break
+				}
+			}
+		}
+		if reason != "" {
+			violate(emailAddress, NameTypeEmail, "%s", reason)
+		}
+	}
+	for _, uri := range csr.URIs {
+		if len(caCertificate.PermittedURIDomains) == 0 && len(caCertificate.ExcludedURIDomains) == 0 {
+			break
+		}
+		host := uri.Hostname()
+		if host == "" || net.ParseIP(host) != nil {
+			violate(uri.String(), NameTypeUri, "has no domain name to check against the uri_domains constraints")
+			continue
+		}
+		if reason := checkDomainConstraints(host, caCertificate.PermittedURIDomains, caCertificate.ExcludedURIDomains, "uri_domains"); reason != "" {
+			violate(uri.String(), NameTypeUri, "%s", reason)
+		}
+	}
+
+	commonName := csr.Subject.CommonName
+	if commonName != "" {
+		if ip := net.ParseIP(commonName); ip != nil {
+			checkIp(ip, NameTypeCommonName)
+		} else if looksLikeDnsName(commonName) {
+			checkDnsName(commonName, NameTypeCommonName)
+		}
+	}
+
+	if sanRules != nil {
+		sans := []NameViolationType{}
+		for _, dnsName := range csr.DNSNames {
+			sans = append(sans, NameViolationType{Name: dnsName, Type: NameTypeDns})
+		}
+		for _, ip := range csr.IPAddresses {
+			sans = append(sans, NameViolationType{Name: ip.String(), Type: NameTypeIp})
+		}
+		for _, emailAddress := range csr.EmailAddresses {
+			sans = append(sans, NameViolationType{Name: emailAddress, Type: NameTypeEmail})
+		}
+		for _, uri := range csr.URIs {
+			sans = append(sans, NameViolationType{Name: uri.String(), Type: NameTypeUri})
+		}
+		if sanRules.MaxSans > 0 && len(sans) > sanRules.MaxSans {
+			for _, san := range sans[sanRules.MaxSans:] {
+				violate(san.Name, san.Type, "more than the %d names allowed by the profile", sanRules.MaxSans)
+			}
+		}
+		if sanRules.RequireCnInSan && commonName != "" && !containsName(csr, commonName) {
+			violate(commonName, NameTypeCommonName, "not among the subject alternative names, required by the profile")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &NamePolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func checkDomainConstraints(domain string, permitted []string, excluded []string, constraintName string) string {
+	for _, constraint := range excluded {
+		if matchDomainConstraint(domain, constraint, true) {
+			return fmt.Sprintf("matches %q of excluded_%s", constraint, constraintName)
+		}
+	}
+	if len(permitted) == 0 {
+		return ""
+	}
+	for _, constraint := range permitted {
+		if matchDomainConstraint(domain, constraint, false) {
+			return ""
+		}
+	}
+	return "not in permitted_" + constraintName
+}
+
+func checkIpConstraints(ip net.IP, permitted []*net.IPNet, excluded []*net.IPNet) string {
+	for _, ipRange := range excluded {
+		if ipRange.Contains(ip) {
+			return fmt.Sprintf("in %s of excluded_ip_ranges", ipRange)
+		}
+	}
+	if len(permitted) == 0 {
+		return ""
+	}
+	for _, ipRange := range permitted {
+		if ipRange.Contains(ip) {
+			return ""
+		}
+	}
+	return "not in permitted_ip_ranges"
+}
+
+// matchDomainConstraint is the rule of crypto/x509: example.com matches
+// itself and its subdomains, .example.com only its subdomains. A wildcard
+// domain is excluded when any name it covers would be.
+func matchDomainConstraint(domain string, constraint string, excluded bool) bool {
+	if constraint == "" {
+		return true
+	}
+	mustHaveSubdomains := false
+	if strings.HasPrefix(constraint, ".") {
+		mustHaveSubdomains = true
+		constraint = constraint[1:]
+	}
+	domainLabels := strings.Split(strings.ToLower(strings.TrimSuffix(domain, ".")), ".")
+	constraintLabels := strings.Split(strings.ToLower(constraint), ".")
+	if len(domainLabels) < len(constraintLabels) || mustHaveSubdomains && len(domainLabels) == len(constraintLabels) {
+		return false
+	}
+	offset := len(domainLabels) - len(constraintLabels)
+	for i, constraintLabel := range constraintLabels {
+		// the wildcard stands for the single label of the constraint at its
+		// position, *.example.com covers secret.example.com
+		if excluded && offset == 0 && i == 0 && domainLabels[0] == "*" {
+			continue
+		}
+		if domainLabels[offset+i] != constraintLabel {
+			return false
+		}
+	}
+	return true
+}
+
+// matchEmailConstraint matches a mailbox constraint exactly, with the domain
+// case insensitive, or the domain of the address as a DNS name.
+func matchEmailConstraint(emailAddress string, domain string, constraint string) bool {
+	if at := strings.LastIndex(constraint, "@"); at >= 0 {
+		localPart := strings.TrimSuffix(emailAddress, "@"+domain)
+		return localPart == constraint[:at] && strings.EqualFold(domain, constraint[at+1:])
+	}
+	return matchDomainConstraint(domain, constraint, false)
+}
+
+// looksLikeDnsName tells a common name such as www.example.com from one such
+// as John Doe.
+func looksLikeDnsName(name string) bool {
+	if !strings.Contains(name, ".") {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '*') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func containsName(csr *x509.CertificateRequest, name string) bool {
+	for _, dnsName := range csr.DNSNames {
+		if strings.EqualFold(dnsName, name) {
+			return true
+		}
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		for _, sanIp := range csr.IPAddresses {
+			if sanIp.Equal(ip) {
+				return true
+			}
+		}
+	}
+	for _, emailAddress := range csr.EmailAddresses {
+		if emailAddress == name {
+			return true
+		}
+	}
+	for _, uri := range csr.URIs {
+		if uri.String() == name {
+			return true
+		}
+	}
+	return false
+}
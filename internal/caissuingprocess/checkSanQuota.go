@@ -0,0 +1,198 @@
+package caissuingprocess
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// subjectAltNames returns the subject alternative names of a certificate or
+// CSR in a comparable form.
+func subjectAltNames(
+	dnsNames []string,
+	emailAddresses []string,
+	ipAddresses []net.IP,
+	uris []*url.URL,
+) []string {
+	sans := []string{}
+	for _, dnsName := range dnsNames {
+		sans = append(sans, "DNS:"+strings.ToLower(dnsName))
+	}
+	for _, emailAddress := range emailAddresses {
+		sans = append(sans, "email:"+strings.ToLower(emailAddress))
+	}
+	for _, ipAddress := range ipAddresses {
+		sans = append(sans, "IP:"+ipAddress.String())
+	}
+	for _, uri := range uris {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	return sans
+}
+
+// sanIndexEntryType is an issued certificate with subject alternative names
+// in the SAN index, so that the quota is checked without parsing every
+// certificate of the CA.
+type sanIndexEntryType struct {
+	SerialNumber *big.Int `yaml:"serial_number"`
+	NotAfter     int64    `yaml:"not_after"` // unix time millis
+	Sans         []string `yaml:"sans"`
+}
+
+// readSanIndex returns the SAN index, built from the issued certificates when
+// the CA has none yet.
+func readSanIndex(tx caStorageTxType) ([]sanIndexEntryType, error) {
+	content, err := tx.ReadSanIndex()
+	if err != nil {
+		return nil, err
+	}
+	sanIndex := []sanIndexEntryType{}
+	if content != nil {
+		if err := yaml.Unmarshal(content, &sanIndex); err != nil {
+			return nil, fmt.Errorf("SAN index: %w", err)
+		}
+		return sanIndex, nil
+	}
+
+	serials, err := tx.ListCertificateSerials()
+	if err != nil {
+		return nil, err
+	}
+	for _, serial := range serials {
+		certificate, err := readStoredCertificate(tx, serial)
+		if err != nil {
+			return nil, err
+		}
+		if certificate != nil {
+			sanIndex = appendSanIndexEntry(sanIndex, certificate)
+		}
+	}
+	return sanIndex, nil
+}
+
+func appendSanIndexEntry(sanIndex []sanIndexEntryType, certificate *x509.Certificate) []sanIndexEntryType {
+	sans := subjectAltNames(certificate.DNSNames, certificate.EmailAddresses, certificate.IPAddresses, certificate.URIs)
+	if len(sans) == 0 {
+		return sanIndex
+	}
+	return append(sanIndex, sanIndexEntryType{
+		SerialNumber: certificate.SerialNumber,
+		NotAfter:     certificate.NotAfter.UnixMilli(),
+		Sans:         sans,
+	})
+}
+
+// updateSanIndex adds an issued certificate to the SAN index when the CA has
+// a quota. Without one the index is removed rather than kept, and built again
+// from the issued certificates once a quota is configured.
+func updateSanIndex(tx caStorageTxType, certificate *x509.Certificate, maxActivePerSan int, now time.Time) error {
+	if maxActivePerSan <= 0 {
+		return tx.RemoveSanIndex()
+	}
+	return addToSanIndex(tx, certificate, now)
+}
+
+// addToSanIndex adds an issued certificate to the SAN index, the expired and
+// revoked certificates are dropped from it on the way. An index built from
+// the issued certificates already has it.
+func addToSanIndex(tx caStorageTxType, certificate *x509.Certificate, now time.Time) error {
+	sanIndex, err := readSanIndex(tx)
+	if err != nil {
+		return err
+	}
+	revokedSerials, err := listRevokedSerials(tx)
+	if err != nil {
+		return err
+	}
+	activeSanIndex := []sanIndexEntryType{}
+	for _, entry := range sanIndex {
+		if entry.NotAfter >= now.UnixMilli() && !revokedSerials[entry.SerialNumber.String()] && entry.SerialNumber.Cmp(certificate.SerialNumber) != 0 {
+			activeSanIndex = append(activeSanIndex, entry)
+		}
+	}
+	content, err := yaml.Marshal(appendSanIndexEntry(activeSanIndex, certificate))
+	if err != nil {
+		return err
+	}
+	return tx.WriteSanIndex(content)
+}
+
+func listRevokedSerials(tx caStorageTxType) (map[string]bool, error) {
+	revocations, err := tx.ListRevocations()
+	if err != nil {
+		return nil, err
+	}
+	revokedSerials := map[string]bool{}
+	for _, revocation := range revocations {
+		revokedSerials[revocation.SerialNumber.String()] = true
+	}
+	return revokedSerials, nil
+}
+
+// checkSanQuota refuses a CSR when one of its SANs is already in
+// maxActivePerSan certificates neither expired nor revoked.
+func checkSanQuota(
+	tx caStorageTxType,
+	csr *x509.CertificateRequest,
+	maxActivePerSan int,
+	now time.Time,
+) error {
+	if maxActivePerSan <= 0 {
+		return nil
+	}
+	requestedSans := map[string]int{}
+	for _, san := range subjectAltNames(csr.DNSNames, csr.EmailAddresses, csr.IPAddresses, csr.URIs) {
+		requestedSans[san] = 0
+	}
+	if len(requestedSans) == 0 {
+		return nil
+	}
+
+	revokedSerials, err := listRevokedSerials(tx)
+	if err != nil {
+		return err
+	}
+	sanIndex, err := readSanIndex(tx)
+	if err != nil {
+		return err
+	}
+	for _, entry := range sanIndex {
+		if entry.NotAfter < now.UnixMilli() || revokedSerials[entry.SerialNumber.String()] {
+			continue
+		}
+		for _, san := range entry.Sans {
+			count, requested := requestedSans[san]
+			if !requested {
+				continue
+			}
+			count++
+			requestedSans[san] = count
+			if count >= maxActivePerSan {
+				return fmt.Errorf("%w: %d active certificates for %s", ErrQuotaExceeded, count, san)
+			}
+		}
+	}
+	return nil
+}
+
+func readStoredCertificate(tx caStorageTxType, serial *big.Int) (*x509.Certificate, error) {
+	pemBytes, err := tx.ReadCertificate(serial)
+	if err != nil || pemBytes == nil {
+		return nil, err
+	}
+	certificate, err := pemhelper.FromPemToCertificate(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s: %w", serial.String(), err)
+	}
+	return certificate, nil
+}
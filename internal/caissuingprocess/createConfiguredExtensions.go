@@ -0,0 +1,102 @@
+package caissuingprocess
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"slices"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+// createCaExtensions returns the policy and static extensions configured for
+// the certificate of the CA.
+func createCaExtensions(caConfig types.CertificateAuthorityType) ([]pkix.Extension, error) {
+	extensions := []pkix.Extension{}
+	if len(caConfig.CertificatePolicies) > 0 {
+		extension, err := createCertificatePoliciesExtension(caConfig.CertificatePolicies)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, extension)
+	}
+	if len(caConfig.PolicyMappings) > 0 {
+		extension, err := createPolicyMappingsExtension(caConfig.PolicyMappings)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, extension)
+	}
+	if caConfig.PolicyConstraints != nil {
+		extension, err := createPolicyConstraintsExtension(*caConfig.PolicyConstraints)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, extension)
+	}
+	if caConfig.InhibitAnyPolicy != nil {
+		extension, err := createInhibitAnyPolicyExtension(*caConfig.InhibitAnyPolicy)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, extension)
+	}
+	for _, staticExtension := range caConfig.StaticExtensions {
+		extension, err := staticExtension.Extension()
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, extension)
+	}
+	return extensions, nil
+}
+
+// policyAndStaticExtensions returns the extensions of createCaExtensions found
+// in caCertificate, to be copied in its cross certificates.
+func policyAndStaticExtensions(caCertificate *x509.Certificate) []pkix.Extension {
+	policyOids := []asn1.ObjectIdentifier{
+		oidExtensionCertificatePolicies,
+		oidExtensionPolicyMappings,
+		oidExtensionPolicyConstraints,
+		oidExtensionInhibitAnyPolicy,
+	}
+	extensions := []pkix.Extension{}
+	for _, extension := range caCertificate.Extensions {
+		if slices.ContainsFunc(policyOids, extension.Id.Equal) || !slices.Contains(types.ReservedExtensionOids, extension.Id.String()) {
+			extensions = append(extensions, extension)
+		}
+	}
+	return extensions
+}
+
+// createProfileExtensions returns the policy and static extensions
+// configured for the certificates of a profile.
+func createProfileExtensions(profile types.ProfileType) ([]pkix.Extension, error) {
+	extensions := []pkix.Extension{}
+	if len(profile.CertificatePolicies) > 0 {
+		extension, err := createCertificatePoliciesExtension(profile.CertificatePolicies)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, extension)
+	}
+	for _, staticExtension := range profile.StaticExtensions {
+		extension, err := staticExtension.Extension()
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, extension)
+	}
+	return extensions, nil
+}
+
+// replaceExtensions returns extensions without those having the OID of one
+// of replacements, followed by replacements.
+func replaceExtensions(extensions []pkix.Extension, replacements []pkix.Extension) []pkix.Extension {
+	kept := slices.DeleteFunc(slices.Clone(extensions), func(extension pkix.Extension) bool {
+		return slices.ContainsFunc(replacements, func(replacement pkix.Extension) bool {
+			return replacement.Id.Equal(extension.Id)
+		})
+	})
+	return append(kept, replacements...)
+}
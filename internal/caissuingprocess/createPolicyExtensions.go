@@ -0,0 +1,151 @@
+package caissuingprocess
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyteasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// The policy extensions of RFC 5280 section 4.2.1, crypto/x509 writes the
+// certificate policies without qualifiers and none of the others.
+var (
+	oidExtensionCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidExtensionPolicyMappings      = asn1.ObjectIdentifier{2, 5, 29, 33}
+	oidExtensionPolicyConstraints   = asn1.ObjectIdentifier{2, 5, 29, 36}
+	oidExtensionInhibitAnyPolicy    = asn1.ObjectIdentifier{2, 5, 29, 54}
+	oidPolicyQualifierCps           = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+	oidPolicyQualifierUserNotice    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 2}
+)
+
+// createCertificatePoliciesExtension returns the certificate policies
+// extension with the CPS URIs and the user notices of each policy, the
+// display texts are UTF8String as recommended.
+func createCertificatePoliciesExtension(policies []types.CertificatePolicyType) (pkix.Extension, error) {
+	policyOids := []asn1.ObjectIdentifier{}
+	for _, policy := range policies {
+		policyOid, err := types.ParseOid(policy.Oid)
+		if err != nil {
+			return pkix.Extension{}, fmt.Errorf("certificate policy: %w", err)
+		}
+		policyOids = append(policyOids, policyOid)
+	}
+	addUtf8String := func(b *cryptobyte.Builder, text string) {
+		b.AddASN1(cryptobyteasn1.UTF8String, func(b *cryptobyte.Builder) {
+			b.AddBytes([]byte(text))
+		})
+	}
+
+	b := cryptobyte.NewBuilder(nil)
+	b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for i, policy := range policies {
+			b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1ObjectIdentifier(policyOids[i])
+				if len(policy.CpsUris) == 0 && len(policy.UserNotices) == 0 {
+					return
+				}
+				b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for _, cpsUri := range policy.CpsUris {
+						b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+							b.AddASN1ObjectIdentifier(oidPolicyQualifierCps)
+							b.AddASN1(cryptobyteasn1.IA5String, func(b *cryptobyte.Builder) {
+								b.AddBytes([]byte(cpsUri))
+							})
+						})
+					}
+					for _, userNotice := range policy.UserNotices {
+						b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+							b.AddASN1ObjectIdentifier(oidPolicyQualifierUserNotice)
+							b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+								if userNotice.Organization != "" {
+									b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+										addUtf8String(b, userNotice.Organization)
+										b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+											for _, noticeNumber := range userNotice.NoticeNumbers {
+												b.AddASN1Int64(int64(noticeNumber))
+											}
+										})
+									})
+								}
+								if userNotice.ExplicitText != "" {
+									addUtf8String(b, userNotice.ExplicitText)
+								}
+							})
+						})
+					}
+				})
+			})
+		}
+	})
+	value, err := b.Bytes()
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionCertificatePolicies, Value: value}, nil
+}
+
+// createPolicyMappingsExtension returns the policy mappings extension,
+// critical as RFC 5280 recommends.
+func createPolicyMappingsExtension(policyMappings []types.PolicyMappingType) (pkix.Extension, error) {
+	policyOids := [][2]asn1.ObjectIdentifier{}
+	for _, policyMapping := range policyMappings {
+		issuerDomainPolicy, err := types.ParseOid(policyMapping.IssuerDomainPolicy)
+		if err != nil {
+			return pkix.Extension{}, fmt.Errorf("policy mapping: %w", err)
+		}
+		subjectDomainPolicy, err := types.ParseOid(policyMapping.SubjectDomainPolicy)
+		if err != nil {
+			return pkix.Extension{}, fmt.Errorf("policy mapping: %w", err)
+		}
+		policyOids = append(policyOids, [2]asn1.ObjectIdentifier{issuerDomainPolicy, subjectDomainPolicy})
+	}
+
+	b := cryptobyte.NewBuilder(nil)
+	b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for _, policyOid := range policyOids {
+			b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1ObjectIdentifier(policyOid[0])
+				b.AddASN1ObjectIdentifier(policyOid[1])
+			})
+		}
+	})
+	value, err := b.Bytes()
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionPolicyMappings, Critical: true, Value: value}, nil
+}
+
+// createPolicyConstraintsExtension returns the policy constraints extension,
+// which RFC 5280 requires to be critical.
+func createPolicyConstraintsExtension(policyConstraints types.PolicyConstraintsType) (pkix.Extension, error) {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		if policyConstraints.RequireExplicitPolicy != nil {
+			b.AddASN1Int64WithTag(int64(*policyConstraints.RequireExplicitPolicy), cryptobyteasn1.Tag(0).ContextSpecific())
+		}
+		if policyConstraints.InhibitPolicyMapping != nil {
+			b.AddASN1Int64WithTag(int64(*policyConstraints.InhibitPolicyMapping), cryptobyteasn1.Tag(1).ContextSpecific())
+		}
+	})
+	value, err := b.Bytes()
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionPolicyConstraints, Critical: true, Value: value}, nil
+}
+
+// createInhibitAnyPolicyExtension returns the inhibit anyPolicy extension,
+// which RFC 5280 requires to be critical.
+func createInhibitAnyPolicyExtension(skipCerts int) (pkix.Extension, error) {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddASN1Int64(int64(skipCerts))
+	value, err := b.Bytes()
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionInhibitAnyPolicy, Critical: true, Value: value}, nil
+}
@@ -0,0 +1,148 @@
+package caissuingprocess
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyteasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// oidExtensionSctList is the extension of RFC 6962 section 3.3 that embeds
+// signed certificate timestamps in a certificate.
+var oidExtensionSctList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// The HashAlgorithm and SignatureAlgorithm of RFC 5246 section 7.4.1.4.1.
+const (
+	tlsHashAlgorithmSha256     = 4
+	tlsSignatureAlgorithmRsa   = 1
+	tlsSignatureAlgorithmEcdsa = 3
+)
+
+// signTransparencyLogStruct returns the TLS digitally-signed struct of
+// content, signed by the CA key with SHA-256.
+func signTransparencyLogStruct(caPrivateKey crypto.Signer, content []byte) ([]byte, error) {
+	var signatureAlgorithm uint8
+	switch caPrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		signatureAlgorithm = tlsSignatureAlgorithmRsa
+	case *ecdsa.PublicKey:
+		signatureAlgorithm = tlsSignatureAlgorithmEcdsa
+	default:
+		return nil, fmt.Errorf("unsupported key %T for transparency log signatures", caPrivateKey.Public())
+	}
+	digest := sha256.Sum256(content)
+	signature, err := caPrivateKey.Sign(cryptorand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	var b cryptobyte.Builder
+	b.AddUint8(tlsHashAlgorithmSha256)
+	b.AddUint8(signatureAlgorithm)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(signature)
+	})
+	return b.Bytes()
+}
+
+// createSctListExtension signs the SCT of leafInput, a precert_entry leaf,
+// and wraps it in a SignedCertificateTimestampList extension. The log id is
+// the hash of the CA public key, which signs the log.
+func createSctListExtension(caCertificate *x509.Certificate, caPrivateKey crypto.Signer, timestamp uint64, leafInput []byte) (pkix.Extension, error) {
+	signature, err := signTransparencyLogStruct(caPrivateKey, leafInput)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	logId := sha256.Sum256(caCertificate.RawSubjectPublicKeyInfo)
+
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint8(ctVersionV1)
+			b.AddBytes(logId[:])
+			b.AddUint64(timestamp)
+			// no CtExtensions
+			b.AddUint16(0)
+			b.AddBytes(signature)
+		})
+	})
+	sctList, err := b.Bytes()
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	value, err := asn1.Marshal(sctList)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionSctList, Value: value}, nil
+}
+
+// removeSctListExtension returns tbsCertificate without the SCT list
+// extension, the TBSCertificate of the precert_entry of RFC 6962 section
+// 3.2.
+func removeSctListExtension(tbsCertificate []byte) ([]byte, error) {
+	input := cryptobyte.String(tbsCertificate)
+	var tbs cryptobyte.String
+	if !input.ReadASN1(&tbs, cryptobyteasn1.SEQUENCE) || !input.Empty() {
+		return nil, fmt.Errorf("malformed TBSCertificate")
+	}
+	extensionsTag := cryptobyteasn1.Tag(3).Constructed().ContextSpecific()
+
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for !tbs.Empty() {
+			var element cryptobyte.String
+			var tag cryptobyteasn1.Tag
+			if !tbs.ReadAnyASN1Element(&element, &tag) {
+				b.SetError(fmt.Errorf("malformed TBSCertificate"))
+				return
+			}
+			if tag != extensionsTag {
+				b.AddBytes(element)
+				continue
+			}
+			var extensionsElement, extensions cryptobyte.String
+			if !element.ReadASN1(&extensionsElement, extensionsTag) ||
+				!extensionsElement.ReadASN1(&extensions, cryptobyteasn1.SEQUENCE) {
+				b.SetError(fmt.Errorf("malformed TBSCertificate extensions"))
+				return
+			}
+			keptExtensions := [][]byte{}
+			for !extensions.Empty() {
+				var extension, extensionFields cryptobyte.String
+				var extensionId asn1.ObjectIdentifier
+				if !extensions.ReadASN1Element(&extension, cryptobyteasn1.SEQUENCE) {
+					b.SetError(fmt.Errorf("malformed TBSCertificate extension"))
+					return
+				}
+				extensionFields = extension
+				if !extensionFields.ReadASN1(&extensionFields, cryptobyteasn1.SEQUENCE) ||
+					!extensionFields.ReadASN1ObjectIdentifier(&extensionId) {
+					b.SetError(fmt.Errorf("malformed TBSCertificate extension"))
+					return
+				}
+				if !extensionId.Equal(oidExtensionSctList) {
+					keptExtensions = append(keptExtensions, extension)
+				}
+			}
+			if len(keptExtensions) == 0 {
+				continue
+			}
+			b.AddASN1(extensionsTag, func(b *cryptobyte.Builder) {
+				b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for _, extension := range keptExtensions {
+						b.AddBytes(extension)
+					}
+				})
+			})
+		}
+	})
+	return b.Bytes()
+}
@@ -0,0 +1,367 @@
+package caissuingprocess
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+)
+
+var ErrInvalidTimestampQuery = errors.New("invalid time-stamp query")
+
+// RFC 3161 and RFC 5652 object identifiers.
+var (
+	oidContentTypeSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentTypeTstInfo       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttributeContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningCertV2   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidDigestSha256             = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSha384             = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSha512             = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidSignatureSha256WithRsa   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSignatureEcdsaWithSha256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidExtKeyUsageTimeStamping  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+	oidExtensionExtKeyUsage     = asn1.ObjectIdentifier{2, 5, 29, 37}
+)
+
+// timestampHashAlgorithms are the digests accepted in a message imprint, with
+// the length of the hashed message.
+var timestampHashAlgorithms = map[string]struct {
+	name string
+	size int
+}{
+	oidDigestSha256.String(): {name: "sha256", size: sha256.Size},
+	oidDigestSha384.String(): {name: "sha384", size: 48},
+	oidDigestSha512.String(): {name: "sha512", size: 64},
+}
+
+// PKIStatus and PKIFailureInfo values of RFC 3161.
+const (
+	timestampStatusGranted   = 0
+	timestampStatusRejection = 2
+
+	timestampFailBadAlg              = 0
+	timestampFailBadRequest          = 2
+	timestampFailBadDataFormat       = 5
+	timestampFailUnacceptedPolicy    = 15
+	timestampFailUnacceptedExtension = 16
+)
+
+const (
+	timestampRequestVersion    = 1
+	timestampTstInfoVersion    = 1
+	timestampSignedDataVersion = 3
+	timestampSignerInfoVersion = 1
+)
+
+type timestampMessageImprintType struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timestampRequestType struct {
+	Version        int
+	MessageImprint timestampMessageImprintType
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+	Extensions     []pkix.Extension      `asn1:"tag:0,optional"`
+}
+
+type timestampAccuracyType struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"tag:0,optional"`
+	Micros  int `asn1:"tag:1,optional"`
+}
+
+type timestampTstInfoType struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint timestampMessageImprintType
+	SerialNumber   *big.Int
+	GenTime        time.Time             `asn1:"generalized"`
+	Accuracy       timestampAccuracyType `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+}
+
+type timestampStatusInfoType struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+type timestampResponseType struct {
+	Status         timestampStatusInfoType
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type cmsContentInfoType struct {
+	ContentType asn1.ObjectIdentifier
+	// Content is the [0] EXPLICIT wrapper, encoding/asn1 ignores the tags of
+	// a RawValue.
+	Content asn1.RawValue
+}
+
+type cmsEncapsulatedContentInfoType struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,tag:0"`
+}
+
+type cmsIssuerAndSerialNumberType struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type cmsSignerInfoType struct {
+	Version            int
+	Sid                cmsIssuerAndSerialNumberType
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type cmsSignedDataType struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapsulatedContentInfoType
+	Certificates     asn1.RawValue       `asn1:"optional"`
+	SignerInfos      []cmsSignerInfoType `asn1:"set"`
+}
+
+type cmsAttributeType struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type essCertIdV2Type struct {
+	CertHash []byte
+}
+
+type essSigningCertificateV2Type struct {
+	Certs []essCertIdV2Type
+}
+
+// parseTimestampRequest decodes a TimeStampReq, a rejection response is
+// returned when the request cannot be served.
+func parseTimestampRequest(queryContent []byte, policy asn1.ObjectIdentifier) (*timestampRequestType, []byte, error) {
+	reject := func(failInfo int, format string, a ...any) (*timestampRequestType, []byte, error) {
+		reason := fmt.Errorf("%w: "+format, append([]any{ErrInvalidTimestampQuery}, a...)...)
+		responseContent, err := createTimestampRejection(failInfo, reason.Error())
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, responseContent, reason
+	}
+
+	var request timestampRequestType
+	rest, err := asn1.Unmarshal(queryContent, &request)
+	if err != nil {
+		return reject(timestampFailBadDataFormat, "%v", err)
+	}
+	if len(rest) > 0 {
+		return reject(timestampFailBadDataFormat, "trailing data")
+	}
+	if request.Version != timestampRequestVersion {
+		return reject(timestampFailBadRequest, "unsupported version %d", request.Version)
+	}
+	hashAlgorithm, found := timestampHashAlgorithms[request.MessageImprint.HashAlgorithm.Algorithm.String()]
+	if !found {
+		return reject(timestampFailBadAlg, "unsupported hash algorithm %s", request.MessageImprint.HashAlgorithm.Algorithm.String())
+	}
+	if len(request.MessageImprint.HashedMessage) != hashAlgorithm.size {
+		return reject(timestampFailBadDataFormat, "%s hashed message of %d bytes", hashAlgorithm.name, len(request.MessageImprint.HashedMessage))
+	}
+	if len(request.ReqPolicy) > 0 && !request.ReqPolicy.Equal(policy) {
+		return reject(timestampFailUnacceptedPolicy, "unsupported policy %s", request.ReqPolicy.String())
+	}
+	if len(request.Extensions) > 0 {
+		return reject(timestampFailUnacceptedExtension, "extensions are not supported")
+	}
+	return &request, nil, nil
+}
+
+// createTimestampRejection returns a TimeStampResp without token.
+func createTimestampRejection(failInfo int, reason string) ([]byte, error) {
+	failInfoBits := asn1.BitString{
+		Bytes:     make([]byte, failInfo/8+1),
+		BitLength: failInfo + 1,
+	}
+	failInfoBits.Bytes[failInfo/8] = 0x80 >> (failInfo % 8)
+	return asn1.Marshal(timestampResponseType{
+		Status: timestampStatusInfoType{
+			Status: timestampStatusRejection,
+			StatusString: []asn1.RawValue{
+				{Tag: asn1.TagUTF8String, Bytes: []byte(reason)},
+			},
+			FailInfo: failInfoBits,
+		},
+	})
+}
+
+// createTimestampResponse signs a TSTInfo for request with the TSA key and
+// wraps it in a granted TimeStampResp.
+func createTimestampResponse(
+	request *timestampRequestType,
+	policy asn1.ObjectIdentifier,
+	accuracy time.Duration,
+	serialNumber *big.Int,
+	genTime time.Time,
+	tsaCertificate *x509.Certificate,
+	tsaPrivateKey crypto.Signer,
+) ([]byte, error) {
+	tstInfo := timestampTstInfoType{
+		Version:        timestampTstInfoVersion,
+		Policy:         policy,
+		MessageImprint: request.MessageImprint,
+		SerialNumber:   serialNumber,
+		GenTime:        genTime.UTC().Truncate(time.Second),
+		Nonce:          request.Nonce,
+	}
+	if accuracy > 0 {
+		tstInfo.Accuracy = timestampAccuracyType{
+			Seconds: int(accuracy / time.Second),
+			Millis:  int(accuracy % time.Second / time.Millisecond),
+			Micros:  int(accuracy % time.Millisecond / time.Microsecond),
+		}
+	}
+	tstInfoContent, err := asn1.Marshal(tstInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	signedData, err := createCmsSignedData(tstInfoContent, request.CertReq, tsaCertificate, tsaPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	tokenContent, err := asn1.Marshal(cmsContentInfoType{
+		ContentType: oidContentTypeSignedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      signedData,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(timestampResponseType{
+		Status:         timestampStatusInfoType{Status: timestampStatusGranted},
+		TimeStampToken: asn1.RawValue{FullBytes: tokenContent},
+	})
+}
+
+// createCmsSignedData signs tstInfoContent with the signed attributes that
+// RFC 3161 requires: content type, message digest and signing certificate.
+func createCmsSignedData(
+	tstInfoContent []byte,
+	includeCertificate bool,
+	tsaCertificate *x509.Certificate,
+	tsaPrivateKey crypto.Signer,
+) ([]byte, error) {
+	var signatureAlgorithm pkix.AlgorithmIdentifier
+	switch tsaPrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidSignatureSha256WithRsa, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidSignatureEcdsaWithSha256}
+	default:
+		return nil, fmt.Errorf("unsupported TSA key %T", tsaPrivateKey.Public())
+	}
+	digestAlgorithm := pkix.AlgorithmIdentifier{Algorithm: oidDigestSha256}
+
+	contentDigest := sha256.Sum256(tstInfoContent)
+	certificateDigest := sha256.Sum256(tsaCertificate.Raw)
+	contentTypeValue, err := asn1.Marshal(oidContentTypeTstInfo)
+	if err != nil {
+		return nil, err
+	}
+	messageDigestValue, err := asn1.Marshal(contentDigest[:])
+	if err != nil {
+		return nil, err
+	}
+	signingCertificateValue, err := asn1.Marshal(essSigningCertificateV2Type{
+		Certs: []essCertIdV2Type{{CertHash: certificateDigest[:]}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	signedAttrs := [][]byte{}
+	for _, attribute := range []cmsAttributeType{
+		{Type: oidAttributeContentType, Values: []asn1.RawValue{{FullBytes: contentTypeValue}}},
+		{Type: oidAttributeMessageDigest, Values: []asn1.RawValue{{FullBytes: messageDigestValue}}},
+		{Type: oidAttributeSigningCertV2, Values: []asn1.RawValue{{FullBytes: signingCertificateValue}}},
+	} {
+		attributeContent, err := asn1.Marshal(attribute)
+		if err != nil {
+			return nil, err
+		}
+		signedAttrs = append(signedAttrs, attributeContent)
+	}
+	// A DER SET OF is sorted by encoding.
+	slices.SortFunc(signedAttrs, bytes.Compare)
+	signedAttrsContent := bytes.Join(signedAttrs, nil)
+
+	// The signature covers the attributes encoded as a SET, they are stored
+	// with the implicit [0] tag.
+	signedAttrsSet, err := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      signedAttrsContent,
+	})
+	if err != nil {
+		return nil, err
+	}
+	signedAttrsDigest := sha256.Sum256(signedAttrsSet)
+	signature, err := tsaPrivateKey.Sign(cryptorand.Reader, signedAttrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	signedData := cmsSignedDataType{
+		Version:          timestampSignedDataVersion,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgorithm},
+		EncapContentInfo: cmsEncapsulatedContentInfoType{
+			EContentType: oidContentTypeTstInfo,
+			EContent:     tstInfoContent,
+		},
+		SignerInfos: []cmsSignerInfoType{{
+			Version: timestampSignerInfoVersion,
+			Sid: cmsIssuerAndSerialNumberType{
+				Issuer:       asn1.RawValue{FullBytes: tsaCertificate.RawIssuer},
+				SerialNumber: tsaCertificate.SerialNumber,
+			},
+			DigestAlgorithm: digestAlgorithm,
+			SignedAttrs: asn1.RawValue{
+				Class:      asn1.ClassContextSpecific,
+				Tag:        0,
+				IsCompound: true,
+				Bytes:      signedAttrsContent,
+			},
+			SignatureAlgorithm: signatureAlgorithm,
+			Signature:          signature,
+		}},
+	}
+	if includeCertificate {
+		signedData.Certificates = asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      tsaCertificate.Raw,
+		}
+	}
+	return asn1.Marshal(signedData)
+}
@@ -0,0 +1,73 @@
+package caissuingprocess
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// The values of the TLS structures of RFC 6962 section 3.
+const (
+	ctVersionV1 = 0
+	// ctLeafTypeTimestampedEntry has the value of the certificate_timestamp
+	// signature type, so that a leaf is also the content signed by its SCT.
+	ctLeafTypeTimestampedEntry = 0
+	ctSignatureTypeTreeHash    = 1
+	ctEntryTypeX509            = 0
+	ctEntryTypePrecert         = 1
+)
+
+// TransparencyLogEntryType is one leaf of the transparency log of a CA.
+// LeafInput is the MerkleTreeLeaf of RFC 6962 section 3.4, an x509_entry
+// with the certificate or, for a certificate with an embedded SCT, a
+// precert_entry with its TBSCertificate without the SCT list extension.
+type TransparencyLogEntryType struct {
+	Serial    *big.Int `json:"serial"`
+	LeafInput []byte   `json:"leaf_input"`
+}
+
+func createTransparencyLogX509Leaf(timestamp uint64, certificateDer []byte) ([]byte, error) {
+	return createTransparencyLogLeaf(timestamp, ctEntryTypeX509, func(b *cryptobyte.Builder) {
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(certificateDer)
+		})
+	})
+}
+
+func createTransparencyLogPrecertLeaf(timestamp uint64, issuerPublicKeyInfo []byte, tbsCertificate []byte) ([]byte, error) {
+	issuerKeyHash := sha256.Sum256(issuerPublicKeyInfo)
+	return createTransparencyLogLeaf(timestamp, ctEntryTypePrecert, func(b *cryptobyte.Builder) {
+		b.AddBytes(issuerKeyHash[:])
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(tbsCertificate)
+		})
+	})
+}
+
+func createTransparencyLogLeaf(timestamp uint64, entryType uint16, signedEntry cryptobyte.BuilderContinuation) ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint8(ctVersionV1)
+	b.AddUint8(ctLeafTypeTimestampedEntry)
+	b.AddUint64(timestamp)
+	b.AddUint16(entryType)
+	signedEntry(&b)
+	// no CtExtensions
+	b.AddUint16(0)
+	return b.Bytes()
+}
+
+// appendTransparencyLogEntry adds the leaf of a certificate being issued in
+// tx to the log.
+func appendTransparencyLogEntry(tx caStorageTxType, serial *big.Int, leafInput []byte) error {
+	content, err := json.Marshal(TransparencyLogEntryType{
+		Serial:    serial,
+		LeafInput: leafInput,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tx.AppendTransparencyLogEntry(content)
+	return err
+}
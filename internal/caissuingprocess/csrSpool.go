@@ -0,0 +1,160 @@
+package caissuingprocess
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCsrName = errors.New("invalid CSR spool name")
+
+// The subdirectories of the CSR spool that are not profiles.
+const (
+	csrSpoolOutDir      = "out"
+	csrSpoolRejectedDir = "rejected"
+)
+
+// csrSpoolType is the directory where CSRs are dropped to be signed. It is
+// shared by all storages since it is the input interface of the CLI.
+//
+// A CSR in a subdirectory is requested with the profile named after it, its
+// results go to out/ and a CSR that cannot be signed is moved to rejected/,
+// both under the same subdirectory. Names are slash separated and relative
+// to the spool, hidden files are not part of the queue so that a CSR can be
+// written under a dot name and renamed when complete.
+type csrSpoolType struct {
+	csrSpoolDir string
+}
+
+func (csrSpool csrSpoolType) ListCsrQueue() ([]string, error) {
+	csrItems, err := os.ReadDir(csrSpool.csrSpoolDir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, csrItem := range csrItems {
+		if strings.HasPrefix(csrItem.Name(), ".") {
+			continue
+		}
+		if !csrItem.IsDir() {
+			names = append(names, csrItem.Name())
+			continue
+		}
+		if csrItem.Name() == csrSpoolOutDir || csrItem.Name() == csrSpoolRejectedDir {
+			continue
+		}
+		profileItems, err := os.ReadDir(filepath.Join(csrSpool.csrSpoolDir, csrItem.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, profileItem := range profileItems {
+			if profileItem.IsDir() || strings.HasPrefix(profileItem.Name(), ".") {
+				continue
+			}
+			names = append(names, csrItem.Name()+"/"+profileItem.Name())
+		}
+	}
+	return names, nil
+}
+
+// csrSpoolFilename resolves name, a file of the spool or of one of its
+// profile subdirectories.
+func (csrSpool csrSpoolType) csrSpoolFilename(name string) (string, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) > 2 || len(parts) == 2 && (parts[0] == csrSpoolOutDir || parts[0] == csrSpoolRejectedDir) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCsrName, name)
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.ContainsRune(part, filepath.Separator) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidCsrName, name)
+		}
+	}
+	return filepath.Join(csrSpool.csrSpoolDir, filepath.FromSlash(name)), nil
+}
+
+func (csrSpool csrSpoolType) CsrModTime(name string) (time.Time, error) {
+	filename, err := csrSpool.csrSpoolFilename(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fileInfo.ModTime(), nil
+}
+
+func (csrSpool csrSpoolType) ReadCsr(name string) ([]byte, error) {
+	filename, err := csrSpool.csrSpoolFilename(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (csrSpool csrSpoolType) RemoveCsr(name string) error {
+	filename, err := csrSpool.csrSpoolFilename(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+// WriteCsrOutput writes a result, name is relative to out/.
+func (csrSpool csrSpoolType) WriteCsrOutput(name string, content []byte) error {
+	if _, err := csrSpool.csrSpoolFilename(name); err != nil {
+		return err
+	}
+	outFilename := filepath.Join(csrSpool.csrSpoolDir, csrSpoolOutDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(outFilename), os.FileMode(0o755)); err != nil {
+		return err
+	}
+	return atomicWriteFile(outFilename, content, os.FileMode(0o644))
+}
+
+// RejectCsr moves a CSR to rejected/, next to a .error file with reason.
+func (csrSpool csrSpoolType) RejectCsr(name string, reason string) error {
+	filename, err := csrSpool.csrSpoolFilename(name)
+	if err != nil {
+		return err
+	}
+	rejectedFilename := filepath.Join(csrSpool.csrSpoolDir, csrSpoolRejectedDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(rejectedFilename), os.FileMode(0o755)); err != nil {
+		return err
+	}
+	if err := atomicWriteFile(rejectedFilename+".error", []byte(reason+"\n"), os.FileMode(0o644)); err != nil {
+		return err
+	}
+	return os.Rename(filename, rejectedFilename)
+}
+
+// splitCsrSpoolName returns the profile of a spooled CSR and the name of its
+// results without extension, such as server/www for server/www.csr.pem or
+// server/www.req.der.
+func splitCsrSpoolName(name string) (string, string) {
+	profile := ""
+	if dir := path.Dir(name); dir != "." {
+		profile = dir
+	}
+	stem := name
+	for _, extensions := range [][]string{{".pem", ".der", ".b64"}, {".csr", ".req"}} {
+		for _, extension := range extensions {
+			if trimmed, found := strings.CutSuffix(stem, extension); found {
+				stem = trimmed
+				break
+			}
+		}
+	}
+	return profile, stem
+}
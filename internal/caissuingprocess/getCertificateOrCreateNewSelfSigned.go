@@ -1,12 +1,13 @@
 package caissuingprocess
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/rand"
 	"crypto/x509"
 	"fmt"
-	"os"
-	"path/filepath"
+	"slices"
+	"time"
 
 	"github.com/tomaluca95/simple-ca/internal/pemhelper"
 	"github.com/tomaluca95/simple-ca/internal/types"
@@ -14,55 +15,89 @@ import (
 
 func getCertificateOrCreateNewSelfSigned(
 	logger types.Logger,
-	issuedCertificatesDir string,
+	tx caStorageTxType,
 	templateCertificate *x509.Certificate,
 	caCertificate *x509.Certificate,
 	caPrivateKey crypto.Signer,
 ) (*x509.Certificate, error) {
-	certificateFilename := filepath.Join(issuedCertificatesDir, templateCertificate.SerialNumber.String()+".crt.pem")
-
-	if _, err := os.Stat(certificateFilename); err != nil {
-		if !os.IsNotExist(err) {
-			return nil, err
-		}
-
-		if _, err := certificateCreateNew(
+	certificateContent, err := tx.ReadCertificate(templateCertificate.SerialNumber)
+	if err != nil {
+		return nil, err
+	}
+	if certificateContent == nil {
+		newCertificateContent, err := certificateCreateNew(
 			logger,
-			issuedCertificatesDir,
+			tx,
 			templateCertificate,
 			caCertificate,
 			extractPublicKeyFromSigner(caPrivateKey),
 			caPrivateKey,
-		); err != nil {
+			false,
+		)
+		if err != nil {
 			return nil, err
 		}
+		certificateContent = newCertificateContent
 	} else {
-		logger.Debug("File %s exists", certificateFilename)
+		logger.Debug("Certificate exists", "serial", templateCertificate.SerialNumber.String())
 	}
 
-	logger.Debug("Reading file %s", certificateFilename)
-	certificateContent, err := os.ReadFile(certificateFilename)
-	if err != nil {
-		return nil, err
-	}
 	return pemhelper.FromPemToCertificate(certificateContent)
 }
 
+// certificateCreateNew issues templateCertificate and adds it to the
+// transparency log. With embedSct the certificate is first built without the
+// SCT list extension, whose TBSCertificate is logged and signed by the SCT
+// added to the certificate issued.
 func certificateCreateNew(
 	logger types.Logger,
-	issuedCertificatesDir string,
+	tx caStorageTxType,
 	templateCertificate *x509.Certificate,
 	caCertificate *x509.Certificate,
 	newCertificatePublicKey any,
 	caPrivateKey crypto.Signer,
+	embedSct bool,
 ) ([]byte, error) {
-	certificateFilename := filepath.Join(issuedCertificatesDir, templateCertificate.SerialNumber.String()+".crt.pem")
+	existingContent, err := tx.ReadCertificate(templateCertificate.SerialNumber)
+	if err != nil {
+		return nil, err
+	}
+	if existingContent != nil {
+		return nil, fmt.Errorf("certificate %s exists", templateCertificate.SerialNumber.String())
+	}
 
-	if _, err := os.Stat(certificateFilename); err == nil {
-		return nil, fmt.Errorf("file %s exists", certificateFilename)
+	timestamp := uint64(time.Now().UnixMilli())
+	var precertificateTbs, leafInput []byte
+	if embedSct {
+		precertificateDer, err := x509.CreateCertificate(
+			rand.Reader,
+			templateCertificate,
+			caCertificate,
+			newCertificatePublicKey,
+			caPrivateKey,
+		)
+		if err != nil {
+			return nil, err
+		}
+		precertificate, err := x509.ParseCertificate(precertificateDer)
+		if err != nil {
+			return nil, err
+		}
+		precertificateTbs = precertificate.RawTBSCertificate
+		leafInput, err = createTransparencyLogPrecertLeaf(timestamp, caCertificate.RawSubjectPublicKeyInfo, precertificateTbs)
+		if err != nil {
+			return nil, err
+		}
+		sctListExtension, err := createSctListExtension(caCertificate, caPrivateKey, timestamp, leafInput)
+		if err != nil {
+			return nil, err
+		}
+		templateWithSct := *templateCertificate
+		templateWithSct.ExtraExtensions = append(slices.Clone(templateCertificate.ExtraExtensions), sctListExtension)
+		templateCertificate = &templateWithSct
 	}
 
-	logger.Debug("Generate new file for %s", certificateFilename)
+	logger.Info("Generate new certificate", "serial", templateCertificate.SerialNumber.String())
 	caDerBytes, err := x509.CreateCertificate(
 		rand.Reader,
 		templateCertificate,
@@ -78,11 +113,33 @@ func certificateCreateNew(
 		return nil, err
 	}
 
+	if embedSct {
+		// the SCT signs the precertificate, which must be exactly the
+		// certificate without the extension
+		tbsWithoutSct, err := removeSctListExtension(createdCert.RawTBSCertificate)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(tbsWithoutSct, precertificateTbs) {
+			return nil, fmt.Errorf("certificate %s differs from its precertificate", templateCertificate.SerialNumber.String())
+		}
+	} else {
+		leafInput, err = createTransparencyLogX509Leaf(timestamp, createdCert.Raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	pemBytes, err := pemhelper.ToPem(createdCert)
 	if err != nil {
 		return nil, err
 	}
-	if err := os.WriteFile(certificateFilename, pemBytes, os.FileMode(0o644)); err != nil {
+	// logged first, an interrupted operation may leave a logged certificate
+	// that was not issued but never an issued certificate that is not logged
+	if err := appendTransparencyLogEntry(tx, templateCertificate.SerialNumber, leafInput); err != nil {
+		return nil, err
+	}
+	if err := tx.WriteCertificate(templateCertificate.SerialNumber, pemBytes); err != nil {
 		return nil, err
 	}
 	return pemBytes, nil
@@ -0,0 +1,126 @@
+package caissuingprocess
+
+import (
+	"bytes"
+	"crypto"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+// getCrossCertificateOrCreateNew returns the certificate kept in filename
+// with the subject and key of subjectCertificate, signed by the issuer CA. A
+// new one is issued when it is missing, does not match any more or expires
+// within renewBefore. The serial is only returned for a new certificate.
+func getCrossCertificateOrCreateNew(
+	logger types.Logger,
+	tx caStorageTxType,
+	filename string,
+	validity types.CertificateAuthorityValidityType,
+	renewBefore time.Duration,
+	issuerCertificate *x509.Certificate,
+	issuerPrivateKey crypto.Signer,
+	subjectCertificate *x509.Certificate,
+) (*x509.Certificate, *big.Int, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if err == nil {
+		crossCertificate, err := pemhelper.FromPemToCertificate(content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		// A short lived certificate is renewed at half of its validity.
+		if lifetime := crossCertificate.NotAfter.Sub(crossCertificate.NotBefore); renewBefore > lifetime/2 {
+			renewBefore = lifetime / 2
+		}
+		publicKey := subjectCertificate.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+		if publicKey.Equal(crossCertificate.PublicKey) &&
+			bytes.Equal(crossCertificate.RawSubject, subjectCertificate.RawSubject) &&
+			crossCertificate.CheckSignatureFrom(issuerCertificate) == nil &&
+			time.Now().Add(renewBefore).Before(crossCertificate.NotAfter) {
+			return crossCertificate, nil, nil
+		}
+		logger.Info("Renewing cross certificate", "serial", crossCertificate.SerialNumber.String(), "not_after", crossCertificate.NotAfter)
+	}
+
+	serialLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := cryptorand.Int(cryptorand.Reader, serialLimit)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate certificate serial: %w", err)
+	}
+	if serialNumber.Sign() == 0 {
+		return nil, nil, fmt.Errorf("generated invalid certificate serial: zero")
+	}
+
+	notBefore := time.Now()
+	notAfter := subjectCertificate.NotAfter
+	if validity.Years != 0 || validity.Months != 0 || validity.Days != 0 {
+		if configuredNotAfter := notBefore.AddDate(validity.Years, validity.Months, validity.Days); configuredNotAfter.Before(notAfter) {
+			notAfter = configuredNotAfter
+		}
+	}
+	if notAfter.After(issuerCertificate.NotAfter) {
+		notAfter = issuerCertificate.NotAfter
+	}
+	// Everything but the issuer, the serial and the validity is the one of the
+	// certificate of the subject CA, so that both build the same paths.
+	crtTemplate := &x509.Certificate{
+		RawSubject:   subjectCertificate.RawSubject,
+		SerialNumber: serialNumber,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		SubjectKeyId: subjectCertificate.SubjectKeyId,
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            subjectCertificate.MaxPathLen,
+		MaxPathLenZero:        subjectCertificate.MaxPathLenZero,
+		KeyUsage:              subjectCertificate.KeyUsage,
+		ExtKeyUsage:           subjectCertificate.ExtKeyUsage,
+		Policies:              subjectCertificate.Policies,
+
+		PermittedDNSDomainsCritical: subjectCertificate.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         subjectCertificate.PermittedDNSDomains,
+		ExcludedDNSDomains:          subjectCertificate.ExcludedDNSDomains,
+		PermittedIPRanges:           subjectCertificate.PermittedIPRanges,
+		ExcludedIPRanges:            subjectCertificate.ExcludedIPRanges,
+		PermittedEmailAddresses:     subjectCertificate.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      subjectCertificate.ExcludedEmailAddresses,
+		PermittedURIDomains:         subjectCertificate.PermittedURIDomains,
+		ExcludedURIDomains:          subjectCertificate.ExcludedURIDomains,
+
+		ExtraExtensions: policyAndStaticExtensions(subjectCertificate),
+	}
+	pemBytes, err := certificateCreateNew(
+		logger,
+		tx,
+		crtTemplate,
+		issuerCertificate,
+		subjectCertificate.PublicKey,
+		issuerPrivateKey,
+		false,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), os.FileMode(0o755)); err != nil {
+		return nil, nil, err
+	}
+	if err := atomicWriteFile(filename, pemBytes, os.FileMode(0o644)); err != nil {
+		return nil, nil, err
+	}
+	crossCertificate, err := pemhelper.FromPemToCertificate(pemBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return crossCertificate, serialNumber, nil
+}
@@ -21,7 +21,7 @@ func getEcdsaPrivateKeyOrCreateNew(
 		if !os.IsNotExist(err) {
 			return nil, err
 		}
-		logger.Debug("Generate new key for %s", filename)
+		logger.Info("Generate new key", "filename", filename)
 		var c elliptic.Curve
 		switch curveName {
 		case "P-224":
@@ -49,7 +49,7 @@ func getEcdsaPrivateKeyOrCreateNew(
 		}
 	}
 
-	logger.Debug("Reading file %s", filename)
+	logger.Debug("Reading key", "filename", filename)
 	privateKeyContent, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
@@ -20,7 +20,7 @@ func getRsaPrivateKeyOrCreateNew(
 		if !os.IsNotExist(err) {
 			return nil, err
 		}
-		logger.Debug("Generate new key for %s", filename)
+		logger.Info("Generate new key", "filename", filename)
 		newPrivateKey, err := rsa.GenerateKey(rand.Reader, keySize)
 		if err != nil {
 			return nil, err
@@ -34,7 +34,7 @@ func getRsaPrivateKeyOrCreateNew(
 		}
 	}
 
-	logger.Debug("Reading file %s", filename)
+	logger.Debug("Reading key", "filename", filename)
 	privateKeyContent, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,123 @@
+package caissuingprocess
+
+import (
+	"crypto"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+// getTsaCertificateOrCreateNew returns the certificate of the TSA key kept in
+// filename, a new one is issued by the CA when it is missing, belongs to
+// another key or issuer, or expires within renewBefore. The serial is only
+// returned for a new certificate. When the CA itself expires within
+// renewBefore a new certificate could only be renewed again and again with a
+// shorter validity: the current one is kept until it expires, then an error
+// is returned.
+func getTsaCertificateOrCreateNew(
+	logger types.Logger,
+	tx caStorageTxType,
+	filename string,
+	tsaConfig types.TsaConfigType,
+	renewBefore time.Duration,
+	caCertificate *x509.Certificate,
+	caPrivateKey crypto.Signer,
+	tsaPrivateKey crypto.Signer,
+) (*x509.Certificate, *big.Int, error) {
+	caCoversRenewal := time.Now().Add(renewBefore).Before(caCertificate.NotAfter)
+	content, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if err == nil {
+		tsaCertificate, err := pemhelper.FromPemToCertificate(content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		// A short lived certificate is renewed at half of its validity.
+		if lifetime := tsaCertificate.NotAfter.Sub(tsaCertificate.NotBefore); renewBefore > lifetime/2 {
+			renewBefore = lifetime / 2
+		}
+		publicKey := tsaPrivateKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+		if publicKey.Equal(tsaCertificate.PublicKey) && tsaCertificate.CheckSignatureFrom(caCertificate) == nil {
+			if time.Now().Add(renewBefore).Before(tsaCertificate.NotAfter) ||
+				(!caCoversRenewal && time.Now().Before(tsaCertificate.NotAfter)) {
+				return tsaCertificate, nil, nil
+			}
+		}
+		logger.Info("Renewing TSA certificate", "serial", tsaCertificate.SerialNumber.String(), "not_after", tsaCertificate.NotAfter)
+	}
+	if !caCoversRenewal {
+		return nil, nil, fmt.Errorf("%w: no TSA certificate can be issued, not after %s", ErrCaCertificateExpiring, caCertificate.NotAfter.Format(time.RFC3339))
+	}
+
+	serialLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := cryptorand.Int(cryptorand.Reader, serialLimit)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate certificate serial: %w", err)
+	}
+	if serialNumber.Sign() == 0 {
+		return nil, nil, fmt.Errorf("generated invalid certificate serial: zero")
+	}
+
+	// RFC 3161 requires timeStamping as the only extended key usage, in a
+	// critical extension.
+	extKeyUsageContent, err := asn1.Marshal([]asn1.ObjectIdentifier{oidExtKeyUsageTimeStamping})
+	if err != nil {
+		return nil, nil, err
+	}
+	validity := tsaConfig.CertificateValidity
+	if validity.Years == 0 && validity.Months == 0 && validity.Days == 0 {
+		validity.Years = 1
+	}
+	notBefore := time.Now()
+	notAfter := notBefore.AddDate(validity.Years, validity.Months, validity.Days)
+	if notAfter.After(caCertificate.NotAfter) {
+		notAfter = caCertificate.NotAfter
+	}
+	crtTemplate := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         caCertificate.Subject.CommonName + " TSA",
+			Country:            caCertificate.Subject.Country,
+			Organization:       caCertificate.Subject.Organization,
+			OrganizationalUnit: caCertificate.Subject.OrganizationalUnit,
+		},
+		SerialNumber: serialNumber,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{{
+			Id:       oidExtensionExtKeyUsage,
+			Critical: true,
+			Value:    extKeyUsageContent,
+		}},
+	}
+	pemBytes, err := certificateCreateNew(
+		logger,
+		tx,
+		crtTemplate,
+		caCertificate,
+		extractPublicKeyFromSigner(tsaPrivateKey),
+		caPrivateKey,
+		false,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := atomicWriteFile(filename, pemBytes, os.FileMode(0o644)); err != nil {
+		return nil, nil, err
+	}
+	tsaCertificate, err := pemhelper.FromPemToCertificate(pemBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tsaCertificate, serialNumber, nil
+}
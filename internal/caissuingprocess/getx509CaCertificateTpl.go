@@ -29,6 +29,11 @@ func getx509CaCertificateTpl(caConfig types.CertificateAuthorityType) (*x509.Cer
 		excludedIPRanges = append(excludedIPRanges, parsed)
 	}
 
+	extensions, err := createCaExtensions(caConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	tpl := &x509.Certificate{
 		Subject: pkix.Name{
 			CommonName:         caConfig.Subject.CommonName,
@@ -64,6 +69,8 @@ func getx509CaCertificateTpl(caConfig types.CertificateAuthorityType) (*x509.Cer
 			x509.ExtKeyUsageAny,
 		},
 		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+
+		ExtraExtensions: extensions,
 	}
 	return tpl, nil
 }
@@ -3,30 +3,21 @@ package caissuingprocess
 import (
 	"os"
 	"os/user"
+	"strings"
 	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/tomaluca95/simple-ca/internal/types"
 )
 
 func gitAddAndCommitGitWorktree(
 	gitWorktree *git.Worktree,
 	msg string,
+	author *object.Signature,
+	committer *object.Signature,
+	signer git.Signer,
 ) error {
-	thisHostname, err := os.Hostname()
-	if err != nil {
-		return err
-	}
-	currentUser, err := user.Current()
-	if err != nil {
-		return err
-	}
-
-	author := &object.Signature{
-		Name:  currentUser.Name,
-		Email: currentUser.Username + "@" + thisHostname,
-		When:  time.Now(),
-	}
 	if _, err := gitWorktree.Add("."); err != nil {
 		return err
 	}
@@ -40,7 +31,9 @@ func gitAddAndCommitGitWorktree(
 		commit, err := gitWorktree.Commit(
 			msg,
 			&git.CommitOptions{
-				Author: author,
+				Author:    author,
+				Committer: committer,
+				Signer:    signer,
 			},
 		)
 		if err != nil {
@@ -50,3 +43,48 @@ func gitAddAndCommitGitWorktree(
 	}
 	return nil
 }
+
+// gitCommitSignatures returns the author and committer of a snapshot. The
+// committer is always the CA service identity, the author can be the
+// requester of the operation.
+func gitCommitSignatures(
+	gitConfig *types.GitRepositoryType,
+	requester types.RequesterType,
+) (*object.Signature, *object.Signature, error) {
+	thisHostname, err := os.Hostname()
+	if err != nil {
+		return nil, nil, err
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	committer := &object.Signature{
+		Name:  currentUser.Name,
+		Email: currentUser.Username + "@" + thisHostname,
+		When:  time.Now(),
+	}
+	if gitConfig == nil {
+		return committer, committer, nil
+	}
+	if gitConfig.AuthorName != nil {
+		committer.Name = *gitConfig.AuthorName
+	}
+	if gitConfig.AuthorEmail != nil {
+		committer.Email = *gitConfig.AuthorEmail
+	}
+	if !gitConfig.AuthorFromRequester || requester.Identity == "" {
+		return committer, committer, nil
+	}
+
+	author := &object.Signature{
+		Name:  requester.Identity,
+		Email: committer.Email,
+		When:  committer.When,
+	}
+	if strings.Contains(requester.Identity, "@") {
+		author.Email = requester.Identity
+	}
+	return author, committer, nil
+}
@@ -0,0 +1,151 @@
+package caissuingprocess
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// loadGitCommitSigner returns nil when commits must not be signed.
+func loadGitCommitSigner(gitConfig *types.GitRepositoryType) (git.Signer, error) {
+	if gitConfig == nil || gitConfig.SigningKeyFile == nil {
+		return nil, nil
+	}
+	keyContent, err := os.ReadFile(*gitConfig.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	passphrase := []byte{}
+	if gitConfig.SigningKeyPassphrase != nil {
+		passphrase = []byte(*gitConfig.SigningKeyPassphrase)
+	}
+
+	switch gitConfig.SigningKeyType {
+	case "openpgp":
+		return newGitOpenpgpSigner(keyContent, passphrase)
+	case "ssh":
+		return newGitSshSigner(keyContent, passphrase)
+	default:
+		return nil, fmt.Errorf("%w: %q", types.ErrInvalidSigningKeyType, gitConfig.SigningKeyType)
+	}
+}
+
+type gitOpenpgpSignerType struct {
+	entity *openpgp.Entity
+}
+
+func newGitOpenpgpSigner(keyContent []byte, passphrase []byte) (*gitOpenpgpSignerType, error) {
+	entityList, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyContent))
+	if err != nil {
+		return nil, err
+	}
+	if len(entityList) != 1 {
+		return nil, fmt.Errorf("expected one OpenPGP key, found %d", len(entityList))
+	}
+	entity := entityList[0]
+	if entity.PrivateKey == nil {
+		return nil, fmt.Errorf("OpenPGP key %X has no private key", entity.PrimaryKey.Fingerprint)
+	}
+	if entity.PrivateKey.Encrypted {
+		if err := entity.DecryptPrivateKeys(passphrase); err != nil {
+			return nil, err
+		}
+	}
+	return &gitOpenpgpSignerType{entity: entity}, nil
+}
+
+func (signer *gitOpenpgpSignerType) Sign(message io.Reader) ([]byte, error) {
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, signer.entity, message, nil); err != nil {
+		return nil, err
+	}
+	return signature.Bytes(), nil
+}
+
+// gitSshSignerType produces signatures in the SSHSIG format used by
+// "git config gpg.format ssh".
+type gitSshSignerType struct {
+	signer ssh.Signer
+}
+
+const gitSshSignatureNamespace = "git"
+
+func newGitSshSigner(keyContent []byte, passphrase []byte) (*gitSshSignerType, error) {
+	var signer ssh.Signer
+	var err error
+	if len(passphrase) > 0 {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyContent, passphrase)
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyContent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gitSshSignerType{signer: signer}, nil
+}
+
+func (signer *gitSshSignerType) Sign(message io.Reader) ([]byte, error) {
+	messageHash := sha512.New()
+	if _, err := io.Copy(messageHash, message); err != nil {
+		return nil, err
+	}
+
+	signedData := []byte("SSHSIG")
+	signedData = append(signedData, ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          string
+	}{
+		Namespace:     gitSshSignatureNamespace,
+		HashAlgorithm: "sha512",
+		Hash:          string(messageHash.Sum(nil)),
+	})...)
+
+	var signature *ssh.Signature
+	var err error
+	if algorithmSigner, ok := signer.signer.(ssh.AlgorithmSigner); ok && signer.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, signedData, ssh.KeyAlgoRSASHA512)
+	} else {
+		signature, err = signer.signer.Sign(rand.Reader, signedData)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signatureBlob := []byte("SSHSIG")
+	signatureBlob = append(signatureBlob, ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     string
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     string
+	}{
+		Version:       1,
+		PublicKey:     string(signer.signer.PublicKey().Marshal()),
+		Namespace:     gitSshSignatureNamespace,
+		HashAlgorithm: "sha512",
+		Signature:     string(ssh.Marshal(signature)),
+	})...)
+
+	encoded := base64.StdEncoding.EncodeToString(signatureBlob)
+	var armored bytes.Buffer
+	armored.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n")
+	armored.WriteString("-----END SSH SIGNATURE-----\n")
+	return armored.Bytes(), nil
+}
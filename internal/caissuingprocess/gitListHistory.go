@@ -0,0 +1,45 @@
+package caissuingprocess
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type HistoryEntryType struct {
+	Hash    string
+	When    time.Time
+	Author  string
+	Message string
+}
+
+func gitListHistory(repoGit *git.Repository) ([]HistoryEntryType, error) {
+	commitIter, err := repoGit.Log(&git.LogOptions{
+		Order: git.LogOrderCommitterTime,
+	})
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return []HistoryEntryType{}, nil
+		}
+		return nil, err
+	}
+	defer commitIter.Close()
+
+	historyEntries := []HistoryEntryType{}
+	if err := commitIter.ForEach(func(commit *object.Commit) error {
+		historyEntries = append(historyEntries, HistoryEntryType{
+			Hash:    commit.Hash.String(),
+			When:    commit.Author.When,
+			Author:  commit.Author.Name + " <" + commit.Author.Email + ">",
+			Message: strings.SplitN(commit.Message, "\n", 2)[0],
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return historyEntries, nil
+}
@@ -0,0 +1,54 @@
+package caissuingprocess
+
+import (
+	"errors"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+const gitDefaultRemoteName = "origin"
+
+// gitPushRepository pushes all branches to the configured remote, creating
+// or updating the remote definition when needed.
+func gitPushRepository(
+	repoGit *git.Repository,
+	gitConfig *types.GitRepositoryType,
+) error {
+	if gitConfig == nil || gitConfig.RemoteUrl == nil {
+		return nil
+	}
+	remoteName := gitDefaultRemoteName
+	if gitConfig.RemoteName != nil {
+		remoteName = *gitConfig.RemoteName
+	}
+
+	remote, err := repoGit.Remote(remoteName)
+	if err != nil && !errors.Is(err, git.ErrRemoteNotFound) {
+		return err
+	}
+	if remote == nil || len(remote.Config().URLs) != 1 || remote.Config().URLs[0] != *gitConfig.RemoteUrl {
+		if remote != nil {
+			if err := repoGit.DeleteRemote(remoteName); err != nil {
+				return err
+			}
+		}
+		if _, err := repoGit.CreateRemote(&config.RemoteConfig{
+			Name: remoteName,
+			URLs: []string{*gitConfig.RemoteUrl},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := repoGit.Push(&git.PushOptions{
+		RemoteName: remoteName,
+		RefSpecs: []config.RefSpec{
+			"refs/heads/*:refs/heads/*",
+		},
+	}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package caissuingprocess
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// The CSR spool is filled from outside the CA, its changes are not left by an
+// interrupted operation.
+const gitCsrSpoolPrefix = "csr/"
+
+// gitInterruptedChanges lists the changes to the CA data that are not
+// committed, ignoring the CSR spool.
+func gitInterruptedChanges(gitWorktree *git.Worktree) ([]string, git.Status, error) {
+	gitStatus, err := gitWorktree.Status()
+	if err != nil {
+		return nil, nil, err
+	}
+	changedFilenames := []string{}
+	for filename, fileStatus := range gitStatus {
+		if fileStatus.Staging == git.Unmodified && fileStatus.Worktree == git.Unmodified {
+			continue
+		}
+		if strings.HasPrefix(filename, gitCsrSpoolPrefix) {
+			continue
+		}
+		changedFilenames = append(changedFilenames, filename)
+	}
+	sort.Strings(changedFilenames)
+	return changedFilenames, gitStatus, nil
+}
+
+// gitRecoverWorktree brings back to a consistent state the data left by an
+// operation interrupted between its "Before" and "After" commits. Complete
+// certificates and revocation index are kept, since they may already be
+// published; files that are truncated or deleted are restored from HEAD, or
+// removed when HEAD does not have them. The rolled back files are returned.
+func gitRecoverWorktree(repoGit *git.Repository, gitWorktree *git.Worktree, dataDir string) ([]string, error) {
+	changedFilenames, gitStatus, err := gitInterruptedChanges(gitWorktree)
+	if err != nil {
+		return nil, err
+	}
+	if len(changedFilenames) == 0 {
+		return nil, nil
+	}
+
+	var headTree *object.Tree
+	if headRef, err := repoGit.Head(); err == nil {
+		headCommit, err := repoGit.CommitObject(headRef.Hash())
+		if err != nil {
+			return nil, err
+		}
+		headTree, err = headCommit.Tree()
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, err
+	}
+
+	rolledBackFilenames := []string{}
+	for _, filename := range changedFilenames {
+		absFilename := filepath.Join(dataDir, filepath.FromSlash(filename))
+		deleted := gitStatus[filename].Worktree == git.Deleted
+		if !deleted {
+			content, err := os.ReadFile(absFilename)
+			if err != nil {
+				return nil, err
+			}
+			if isCompleteDataFile(filename, content) {
+				continue
+			}
+		}
+
+		rolledBackFilenames = append(rolledBackFilenames, filename)
+		var headFile *object.File
+		if headTree != nil {
+			headFile, err = headTree.File(filename)
+			if err != nil && !errors.Is(err, object.ErrFileNotFound) {
+				return nil, err
+			}
+		}
+		if headFile == nil {
+			if err := os.Remove(absFilename); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		headContent, err := headFile.Contents()
+		if err != nil {
+			return nil, err
+		}
+		if err := atomicWriteFile(absFilename, []byte(headContent), os.FileMode(0o644)); err != nil {
+			return nil, err
+		}
+	}
+	return rolledBackFilenames, nil
+}
+
+// isCompleteDataFile reports whether a certificate, the revocation index, a
+// sign request, a time-stamp record or a transparency log entry can be
+// parsed, other files are always kept.
+func isCompleteDataFile(filename string, content []byte) bool {
+	switch {
+	case filename == "crl.yml":
+		var revokedCertsInfo []crlIndexEntryType
+		return yaml.Unmarshal(content, &revokedCertsInfo) == nil
+	case strings.HasPrefix(filename, "crt/"):
+		certificate, err := pemhelper.FromPemToCertificate(content)
+		if err != nil {
+			return false
+		}
+		serial, isInt := new(big.Int).SetString(strings.TrimSuffix(path.Base(filename), ".crt.pem"), 10)
+		return isInt && serial.Cmp(certificate.SerialNumber) == 0
+	case strings.HasPrefix(filename, "ssh/"):
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey(content)
+		if err != nil {
+			return false
+		}
+		sshCertificate, isCertificate := publicKey.(*ssh.Certificate)
+		serial, isInt := new(big.Int).SetString(strings.TrimSuffix(path.Base(filename), "-cert.pub"), 10)
+		return isCertificate && isInt && serial.IsUint64() && serial.Uint64() == sshCertificate.Serial
+	case strings.HasPrefix(filename, "requests/"), strings.HasPrefix(filename, "tsa/"), strings.HasPrefix(filename, "ct/"):
+		return json.Valid(content)
+	default:
+		return true
+	}
+}
+
+func describeFilenames(filenames []string) string {
+	return fmt.Sprintf("%d files: %s", len(filenames), strings.Join(filenames, ", "))
+}
@@ -0,0 +1,172 @@
+package caissuingprocess
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrRestoreWouldUnissue = errors.New("restore would remove issued certificates")
+var ErrRestoreWouldUnrevoke = errors.New("restore would remove published revocations")
+
+// checkRestoreIsSafe refuses a restore that would forget certificates already
+// issued or revocations already published in a CRL.
+func checkRestoreIsSafe(
+	dataDir string,
+	issuedCertificatesDirs []string,
+	crlIndexFilename string,
+	targetTree *object.Tree,
+) error {
+	targetFiles := map[string]bool{}
+	if err := targetTree.Files().ForEach(func(f *object.File) error {
+		targetFiles[f.Name] = true
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	missingSerials := []string{}
+	for _, issuedCertificatesDir := range issuedCertificatesDirs {
+		issuedItems, err := os.ReadDir(issuedCertificatesDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, issuedItem := range issuedItems {
+			relativeFilename, err := filepath.Rel(dataDir, filepath.Join(issuedCertificatesDir, issuedItem.Name()))
+			if err != nil {
+				return err
+			}
+			if !targetFiles[filepath.ToSlash(relativeFilename)] {
+				serial := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(issuedItem.Name(), ".crt.pem"), "-cert.pub"), ".json")
+				missingSerials = append(missingSerials, serial)
+			}
+		}
+	}
+	if len(missingSerials) > 0 {
+		return fmt.Errorf("%w: %s", ErrRestoreWouldUnissue, strings.Join(missingSerials, ", "))
+	}
+
+	currentRevoked, err := readRevokedSerials(func() ([]byte, error) {
+		return os.ReadFile(crlIndexFilename)
+	})
+	if err != nil {
+		return err
+	}
+	relativeCrlIndexFilename, err := filepath.Rel(dataDir, crlIndexFilename)
+	if err != nil {
+		return err
+	}
+	targetRevoked, err := readRevokedSerials(func() ([]byte, error) {
+		f, err := targetTree.File(filepath.ToSlash(relativeCrlIndexFilename))
+		if err != nil {
+			if errors.Is(err, object.ErrFileNotFound) {
+				return nil, os.ErrNotExist
+			}
+			return nil, err
+		}
+		content, err := f.Contents()
+		return []byte(content), err
+	})
+	if err != nil {
+		return err
+	}
+	unrevokedSerials := []string{}
+	for serial := range currentRevoked {
+		if !targetRevoked[serial] {
+			unrevokedSerials = append(unrevokedSerials, serial)
+		}
+	}
+	if len(unrevokedSerials) > 0 {
+		return fmt.Errorf("%w: %s", ErrRestoreWouldUnrevoke, strings.Join(unrevokedSerials, ", "))
+	}
+	return nil
+}
+
+func readRevokedSerials(readContent func() ([]byte, error)) (map[string]bool, error) {
+	revokedSerials := map[string]bool{}
+	content, err := readContent()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return revokedSerials, nil
+		}
+		return nil, err
+	}
+	var revokedCertsInfo []crlIndexEntryType
+	if err := yaml.Unmarshal(content, &revokedCertsInfo); err != nil {
+		return nil, err
+	}
+	for _, revokedCertInfo := range revokedCertsInfo {
+		if revokedCertInfo.SerialNumber == nil {
+			continue
+		}
+		revokedSerials[new(big.Int).Set(revokedCertInfo.SerialNumber).String()] = true
+	}
+	return revokedSerials, nil
+}
+
+// gitRestoreWorktree makes the content of dataDir equal to targetTree,
+// leaving the .git directory, the directories themselves and everything under
+// keptDirs untouched.
+func gitRestoreWorktree(dataDir string, targetTree *object.Tree, keptDirs []string) error {
+	isKept := func(filename string) bool {
+		return slices.ContainsFunc(keptDirs, func(keptDir string) bool {
+			relativeFilename, err := filepath.Rel(keptDir, filename)
+			return err == nil && relativeFilename != ".." && !strings.HasPrefix(relativeFilename, ".."+string(filepath.Separator))
+		})
+	}
+
+	targetFiles := map[string]bool{}
+	if err := targetTree.Files().ForEach(func(f *object.File) error {
+		filename := filepath.Join(dataDir, filepath.FromSlash(f.Name))
+		if isKept(filename) {
+			return nil
+		}
+		targetFiles[f.Name] = true
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(0o644)
+		if f.Mode == filemode.Executable {
+			mode = os.FileMode(0o755)
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), os.FileMode(0o755)); err != nil {
+			return err
+		}
+		return atomicWriteFile(filename, []byte(content), mode)
+	}); err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || isKept(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relativeFilename, err := filepath.Rel(dataDir, path)
+		if err != nil {
+			return err
+		}
+		if !targetFiles[filepath.ToSlash(relativeFilename)] {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
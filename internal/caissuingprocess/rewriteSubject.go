@@ -0,0 +1,209 @@
+package caissuingprocess
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+// subjectTemplateDataType is given to the values of a subject template.
+type subjectTemplateDataType struct {
+	Csr      pkix.Name
+	FirstSan string
+	Values   map[string]string
+}
+
+// rewriteSubject returns the subject of the certificate issued for csr by the
+// CA with caSubject, following the subject policy of the profile.
+func rewriteSubject(
+	caSubject pkix.Name,
+	csr *x509.CertificateRequest,
+	subjectPolicy *types.SubjectPolicyType,
+	subjectValues map[string]string,
+) (pkix.Name, error) {
+	if subjectPolicy == nil {
+		return csr.Subject, nil
+	}
+	firstSan := firstSubjectAlternativeName(csr)
+
+	subject := pkix.Name{}
+	if subjectPolicy.Template == nil {
+		for _, attribute := range types.SubjectAttributes {
+			setSubjectAttribute(&subject, attribute, subjectAttribute(csr.Subject, attribute))
+		}
+		// the attributes a subject policy cannot name, such as DC or UID,
+		// are carried over unless allowed_attributes restricts the subject
+		if len(subjectPolicy.AllowedAttributes) == 0 {
+			subject.ExtraNames = otherSubjectAttributes(csr.Subject)
+		}
+	} else {
+		data := subjectTemplateDataType{
+			Csr:      csr.Subject,
+			FirstSan: firstSan,
+			Values:   subjectValues,
+		}
+		render := func(attribute string, texts []string) error {
+			values := []string{}
+			for _, text := range texts {
+				tmpl, err := types.ParseSubjectTemplate(text)
+				if err != nil {
+					return fmt.Errorf("invalid subject template of %s: %w", attribute, err)
+				}
+				value := strings.Builder{}
+				if err := tmpl.Execute(&value, data); err != nil {
+					return fmt.Errorf("%w: subject template of %s: %v", ErrInvalidCsr, attribute, err)
+				}
+				if strings.TrimSpace(value.String()) != "" {
+					values = append(values, value.String())
+				}
+			}
+			setSubjectAttribute(&subject, attribute, values)
+			return nil
+		}
+		template := subjectPolicy.Template
+		for _, attribute := range []struct {
+			name  string
+			texts []string
+		}{
+			{"common_name", []string{template.CommonName}},
+			{"country", template.Country},
+			{"organization", template.Organization},
+			{"organizational_unit", template.OrganizationalUnit},
+			{"locality", template.Locality},
+			{"province", template.Province},
+			{"street_address", template.StreetAddress},
+			{"postal_code", template.PostalCode},
+		} {
+			if err := render(attribute.name, attribute.texts); err != nil {
+				return pkix.Name{}, err
+			}
+		}
+	}
+
+	if len(subjectPolicy.AllowedAttributes) > 0 {
+		for _, attribute := range types.SubjectAttributes {
+			if !slices.Contains(subjectPolicy.AllowedAttributes, attribute) {
+				setSubjectAttribute(&subject, attribute, nil)
+			}
+		}
+	}
+	if subjectPolicy.CommonNameFromSan && firstSan != "" {
+		subject.CommonName = firstSan
+	}
+	for _, attribute := range subjectPolicy.DefaultFromCa {
+		if len(subjectAttribute(subject, attribute)) == 0 {
+			setSubjectAttribute(&subject, attribute, subjectAttribute(caSubject, attribute))
+		}
+	}
+	for _, attribute := range subjectPolicy.ForceFromCa {
+		setSubjectAttribute(&subject, attribute, subjectAttribute(caSubject, attribute))
+	}
+	return subject, nil
+}
+
+// firstSubjectAlternativeName is the first DNS name, else IP address, email
+// address or URI of csr.
+func firstSubjectAlternativeName(csr *x509.CertificateRequest) string {
+	switch {
+	case len(csr.DNSNames) > 0:
+		return csr.DNSNames[0]
+	case len(csr.IPAddresses) > 0:
+		return csr.IPAddresses[0].String()
+	case len(csr.EmailAddresses) > 0:
+		return csr.EmailAddresses[0]
+	case len(csr.URIs) > 0:
+		return csr.URIs[0].String()
+	}
+	return ""
+}
+
+// subjectAttributeOids are the types of types.SubjectAttributes, in pkix.Name
+// order.
+var subjectAttributeOids = []string{
+	"2.5.4.3",  // common name
+	"2.5.4.5",  // serial number
+	"2.5.4.6",  // country
+	"2.5.4.10", // organization
+	"2.5.4.11", // organizational unit
+	"2.5.4.7",  // locality
+	"2.5.4.8",  // province
+	"2.5.4.9",  // street address
+	"2.5.4.17", // postal code
+}
+
+// otherSubjectAttributes returns the attributes of subject that are not one
+// of types.SubjectAttributes, in their order.
+func otherSubjectAttributes(subject pkix.Name) []pkix.AttributeTypeAndValue {
+	others := []pkix.AttributeTypeAndValue{}
+	for _, name := range subject.Names {
+		if !slices.Contains(subjectAttributeOids, name.Type.String()) {
+			others = append(others, name)
+		}
+	}
+	return others
+}
+
+// subjectAttribute returns the values of one of types.SubjectAttributes.
+func subjectAttribute(subject pkix.Name, attribute string) []string {
+	switch attribute {
+	case "common_name":
+		if subject.CommonName == "" {
+			return nil
+		}
+		return []string{subject.CommonName}
+	case "serial_number":
+		if subject.SerialNumber == "" {
+			return nil
+		}
+		return []string{subject.SerialNumber}
+	case "country":
+		return subject.Country
+	case "organization":
+		return subject.Organization
+	case "organizational_unit":
+		return subject.OrganizationalUnit
+	case "locality":
+		return subject.Locality
+	case "province":
+		return subject.Province
+	case "street_address":
+		return subject.StreetAddress
+	case "postal_code":
+		return subject.PostalCode
+	}
+	return nil
+}
+
+// setSubjectAttribute replaces the values of one of types.SubjectAttributes,
+// the common name and the serial number take the first value.
+func setSubjectAttribute(subject *pkix.Name, attribute string, values []string) {
+	values = slices.Clone(values)
+	first := ""
+	if len(values) > 0 {
+		first = values[0]
+	}
+	switch attribute {
+	case "common_name":
+		subject.CommonName = first
+	case "serial_number":
+		subject.SerialNumber = first
+	case "country":
+		subject.Country = values
+	case "organization":
+		subject.Organization = values
+	case "organizational_unit":
+		subject.OrganizationalUnit = values
+	case "locality":
+		subject.Locality = values
+	case "province":
+		subject.Province = values
+	case "street_address":
+		subject.StreetAddress = values
+	case "postal_code":
+		subject.PostalCode = values
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"crypto"
 	cryptorand "crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"errors"
 	"fmt"
 	"math/big"
-	"os"
+	"slices"
 	"time"
 
 	"github.com/tomaluca95/simple-ca/internal/pemhelper"
@@ -20,35 +22,50 @@ func signOneCsr(
 	logger types.Logger,
 	caCertificate *x509.Certificate,
 	caPrivateKey crypto.Signer,
-	csrFilename string,
-	issuedCertificatesDir string,
-) ([]byte, error) {
-	csrFileContent, err := os.ReadFile(csrFilename)
+	csrContent []byte,
+	profile types.ProfileType,
+	subjectValues map[string]string,
+	maxActivePerSan int,
+	embedSct bool,
+	tx caStorageTxType,
+) ([]byte, *big.Int, error) {
+	csr, err := pemhelper.FromAnyToCertificateRequest(csrContent)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCsr, err)
 	}
-	csr, err := pemhelper.FromPemToCertificateRequest(csrFileContent)
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid CSR signature: %v", ErrInvalidCsr, err)
+	}
+
+	logger.Debug("Loading CSR", "subject", csr.Subject.String())
+
+	// the names are checked in the subject of the certificate
+	csr.Subject, err = rewriteSubject(caCertificate.Subject, csr, profile.Subject, subjectValues)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrInvalidCsr, err)
+		return nil, nil, err
 	}
-	if err := csr.CheckSignature(); err != nil {
-		return nil, fmt.Errorf("%w: invalid CSR signature: %v", ErrInvalidCsr, err)
+
+	if err := checkNamePolicy(caCertificate, csr, profile.SanRules); err != nil {
+		return nil, nil, err
 	}
 
-	logger.Debug("Loading CSR: %s", csr.Subject.String())
+	if err := checkSanQuota(tx, csr, maxActivePerSan, time.Now()); err != nil {
+		return nil, nil, err
+	}
 
 	serialLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := cryptorand.Int(cryptorand.Reader, serialLimit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate certificate serial: %w", err)
+		return nil, nil, fmt.Errorf("failed to generate certificate serial: %w", err)
 	}
 	if serialNumber.Sign() == 0 {
-		return nil, fmt.Errorf("generated invalid certificate serial: zero")
+		return nil, nil, fmt.Errorf("generated invalid certificate serial: zero")
 	}
 
 	if csr.PublicKey == nil {
-		return nil, fmt.Errorf("%w: %w: %T", ErrInvalidCsr, types.ErrInvalidKeyTypeInCsr, csr.PublicKey)
+		return nil, nil, fmt.Errorf("%w: %w: %T", ErrInvalidCsr, types.ErrInvalidKeyTypeInCsr, csr.PublicKey)
 	}
+	csrExtensions := requestedExtensions(csr)
 	crtTemplate := &x509.Certificate{
 		Subject:      csr.Subject,
 		SerialNumber: serialNumber,
@@ -58,34 +75,56 @@ func signOneCsr(
 		// Mirror CSR-provided metadata/extensions so policy validation can decide
 		// whether the resulting certificate is acceptable for this CA.
 		Version:         csr.Version,
-		Extensions:      append(csr.Extensions, csr.ExtraExtensions...),
-		ExtraExtensions: append(csr.Extensions, csr.ExtraExtensions...),
+		Extensions:      csrExtensions,
+		ExtraExtensions: slices.Clone(csrExtensions),
 		DNSNames:        csr.DNSNames,
 		EmailAddresses:  csr.EmailAddresses,
 		IPAddresses:     csr.IPAddresses,
 		URIs:            csr.URIs,
 	}
-	if err := validateCertificateTemplateAgainstCa(crtTemplate, caCertificate, csr.PublicKey, caPrivateKey); err != nil {
-		return nil, err
+	profileExtensions, err := createProfileExtensions(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	crtTemplate.ExtraExtensions = replaceExtensions(crtTemplate.ExtraExtensions, profileExtensions)
+	if err := validateCertificateTemplateAgainstCa(crtTemplate, caCertificate, csr.PublicKey, caPrivateKey, profileExtensions); err != nil {
+		return nil, nil, err
 	}
 
 	pemBlock, err := certificateCreateNew(
 		logger,
-		issuedCertificatesDir,
+		tx,
 		crtTemplate,
 		caCertificate,
 		csr.PublicKey,
 		caPrivateKey,
+		embedSct,
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	if err := os.Remove(csrFilename); err != nil {
-		return nil, err
+	certificate, err := pemhelper.FromPemToCertificate(pemBlock)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := updateSanIndex(tx, certificate, maxActivePerSan, time.Now()); err != nil {
+		return nil, nil, err
 	}
 
-	return pemBlock, nil
+	return pemBlock, serialNumber, nil
+}
+
+// requestedExtensions returns the extensions of csr the CA copies in the
+// certificate: the reserved ones are written by the CA only, a requester
+// cannot make its certificate a CA or choose its policies.
+func requestedExtensions(csr *x509.CertificateRequest) []pkix.Extension {
+	extensions := []pkix.Extension{}
+	for _, extension := range append(slices.Clone(csr.Extensions), csr.ExtraExtensions...) {
+		if !slices.Contains(types.ReservedExtensionOids, extension.Id.String()) {
+			extensions = append(extensions, extension)
+		}
+	}
+	return extensions
 }
 
 func validateCertificateTemplateAgainstCa(
@@ -93,6 +132,7 @@ func validateCertificateTemplateAgainstCa(
 	caCertificate *x509.Certificate,
 	csrPublicKey any,
 	caPrivateKey crypto.Signer,
+	configuredExtensions []pkix.Extension,
 ) error {
 	derBytes, err := x509.CreateCertificate(
 		cryptorand.Reader,
@@ -109,8 +149,19 @@ func validateCertificateTemplateAgainstCa(
 		return fmt.Errorf("unable to parse generated certificate: %w", err)
 	}
 
+	// The configured extensions are written on purpose even when critical and
+	// unknown to crypto/x509, which would refuse them; the ones of the CA
+	// certificate are all configured.
+	issuedCertificate.UnhandledCriticalExtensions = slices.DeleteFunc(issuedCertificate.UnhandledCriticalExtensions, func(oid asn1.ObjectIdentifier) bool {
+		return slices.ContainsFunc(configuredExtensions, func(extension pkix.Extension) bool {
+			return extension.Id.Equal(oid)
+		})
+	})
+	caCertificateCopy := *caCertificate
+	caCertificateCopy.UnhandledCriticalExtensions = nil
+
 	roots := x509.NewCertPool()
-	roots.AddCert(caCertificate)
+	roots.AddCert(&caCertificateCopy)
 	verifyAt := issuedCertificate.NotBefore
 	if verifyAt.IsZero() {
 		verifyAt = time.Now()
@@ -0,0 +1,153 @@
+package caissuingprocess
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"golang.org/x/crypto/ssh"
+)
+
+var ErrInvalidSshSignRequest = errors.New("invalid SSH sign request")
+
+const defaultSshMaxValidity = 24 * time.Hour
+
+// defaultSshUserExtensions are the extensions ssh-keygen sets on user
+// certificates.
+var defaultSshUserExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// SshSignRequestType asks for an OpenSSH certificate of PublicKey, given in
+// the authorized_keys format. Validity is a duration such as 8h.
+type SshSignRequestType struct {
+	PublicKey       string            `json:"public_key"`
+	KeyId           string            `json:"key_id"`
+	Principals      []string          `json:"principals"`
+	CertType        string            `json:"cert_type"`
+	Validity        string            `json:"validity"`
+	CriticalOptions map[string]string `json:"critical_options"`
+	Extensions      map[string]string `json:"extensions"`
+}
+
+func signOneSshPublicKey(
+	logger types.Logger,
+	sshSigner ssh.Signer,
+	sshConfig types.SshConfigType,
+	request SshSignRequestType,
+	tx caStorageTxType,
+) ([]byte, *big.Int, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(request.PublicKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: public_key: %v", ErrInvalidSshSignRequest, err)
+	}
+	if _, isCertificate := publicKey.(*ssh.Certificate); isCertificate {
+		return nil, nil, fmt.Errorf("%w: public_key is a certificate", ErrInvalidSshSignRequest)
+	}
+	// A certificate without principals is valid for any user or host.
+	if len(request.Principals) == 0 {
+		return nil, nil, fmt.Errorf("%w: principals are required", ErrInvalidSshSignRequest)
+	}
+
+	certType := request.CertType
+	if certType == "" {
+		certType = sshConfig.CertType
+	}
+	var sshCertType uint32
+	switch certType {
+	case "", types.SshCertTypeUser:
+		certType = types.SshCertTypeUser
+		sshCertType = ssh.UserCert
+	case types.SshCertTypeHost:
+		sshCertType = ssh.HostCert
+	default:
+		return nil, nil, fmt.Errorf("%w: %w: %q", ErrInvalidSshSignRequest, types.ErrInvalidSshCertType, certType)
+	}
+
+	maxValidity := defaultSshMaxValidity
+	if sshConfig.MaxValidity > 0 {
+		maxValidity = sshConfig.MaxValidity
+	}
+	validity := maxValidity
+	if request.Validity != "" {
+		validity, err = time.ParseDuration(request.Validity)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: validity: %v", ErrInvalidSshSignRequest, err)
+		}
+		if validity <= 0 || validity > maxValidity {
+			return nil, nil, fmt.Errorf("%w: validity must be positive and at most %s, got %s", ErrInvalidSshSignRequest, maxValidity, validity)
+		}
+	}
+
+	criticalOptions := map[string]string{}
+	maps.Copy(criticalOptions, request.CriticalOptions)
+	maps.Copy(criticalOptions, sshConfig.CriticalOptions)
+	extensions := request.Extensions
+	if len(extensions) == 0 {
+		extensions = sshConfig.Extensions
+	}
+	if len(extensions) == 0 && sshCertType == ssh.UserCert {
+		extensions = defaultSshUserExtensions
+	}
+
+	serial, err := newSshSerial(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	sshCertificate := &ssh.Certificate{
+		Key:             publicKey,
+		Serial:          serial,
+		CertType:        sshCertType,
+		KeyId:           request.KeyId,
+		ValidPrincipals: request.Principals,
+		ValidAfter:      uint64(now.Unix()),
+		ValidBefore:     uint64(now.Add(validity).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: criticalOptions,
+			Extensions:      maps.Clone(extensions),
+		},
+	}
+	if err := sshCertificate.SignCert(cryptorand.Reader, sshSigner); err != nil {
+		return nil, nil, err
+	}
+
+	serialNumber := new(big.Int).SetUint64(serial)
+	logger.Info("Generate new SSH certificate", "serial", serialNumber.String(), "key_id", request.KeyId, "cert_type", certType, "principals", request.Principals)
+	content := ssh.MarshalAuthorizedKey(sshCertificate)
+	if err := tx.WriteSshCertificate(serialNumber, content); err != nil {
+		return nil, nil, err
+	}
+	return content, serialNumber, nil
+}
+
+// newSshSerial returns a random serial not used by the CA, 0 is avoided since
+// a KRL cannot revoke it.
+func newSshSerial(tx caStorageTxType) (uint64, error) {
+	serialBytes := make([]byte, 8)
+	for {
+		if _, err := cryptorand.Read(serialBytes); err != nil {
+			return 0, fmt.Errorf("failed to generate certificate serial: %w", err)
+		}
+		serial := binary.BigEndian.Uint64(serialBytes) >> 1
+		if serial == 0 {
+			continue
+		}
+		existing, err := tx.ReadSshCertificate(new(big.Int).SetUint64(serial))
+		if err != nil {
+			return 0, err
+		}
+		if existing == nil {
+			return serial, nil
+		}
+	}
+}
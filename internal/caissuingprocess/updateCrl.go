@@ -10,15 +10,11 @@ import (
 	"math/big"
 	"os"
 	"path/filepath"
-	"sort"
 	"time"
-
-	"gopkg.in/yaml.v3"
 )
 
 func updateCrl(
-	crlIndexFilename string,
-	caFilenameCrl string,
+	tx caStorageTxType,
 	crlTtl time.Duration,
 	caCertificate *x509.Certificate,
 	caPrivateKey crypto.Signer,
@@ -27,65 +23,21 @@ func updateCrl(
 	crlList := []pkix.RevokedCertificate{}
 
 	{
-		type oneRevokedCertInfoType struct {
-			SerialNumber   *big.Int `yaml:"serial_number"`
-			RevocationTime int64    `yaml:"revocation_time"`
-		}
-		var revokedCertsInfo []oneRevokedCertInfoType
-
-		crlIndexContent, err := os.ReadFile(crlIndexFilename)
-		if err != nil && !os.IsNotExist(err) {
-			return err
-		} else {
-			if err := yaml.Unmarshal(crlIndexContent, &revokedCertsInfo); err != nil {
-				return err
-			}
-		}
+		newRevokedCertsInfo := []crlIndexEntryType{}
 		for _, oneSerialToRevoke := range addSerialsToRevoked {
-			revokedCertsInfo = append(revokedCertsInfo, oneRevokedCertInfoType{
+			newRevokedCertsInfo = append(newRevokedCertsInfo, crlIndexEntryType{
 				SerialNumber:   oneSerialToRevoke,
 				RevocationTime: time.Now().UnixMilli(),
 			})
 		}
-
-		{
-			sort.Slice(revokedCertsInfo, func(i, j int) bool {
-				cmpResult := revokedCertsInfo[i].SerialNumber.Cmp(revokedCertsInfo[j].SerialNumber)
-				if cmpResult < 0 {
-					return true
-				} else if cmpResult == 0 {
-					return revokedCertsInfo[i].RevocationTime < revokedCertsInfo[j].RevocationTime
-				} else {
-					return false
-				}
-			})
-
-			{
-				i := 0
-				for i < len(revokedCertsInfo)-1 {
-					if revokedCertsInfo[i].SerialNumber.Cmp(revokedCertsInfo[i+1].SerialNumber) == 0 {
-						revokedCertsInfo = append(revokedCertsInfo[:i+1], revokedCertsInfo[i+2:]...)
-					} else {
-						i++
-					}
-				}
-			}
-
-			commentPrefixToIndex := []byte(`# - serial_number: "1"
-#   revocation_time: 1714575000000 # unix time millis
-`)
-			newCrlIndexYamlContent, err := yaml.Marshal(revokedCertsInfo)
-			if err != nil {
-				return err
-			}
-
-			newCrlIndexContent := append(commentPrefixToIndex, newCrlIndexYamlContent...)
-
-			if err := atomicWriteFile(crlIndexFilename, newCrlIndexContent, os.FileMode(0o644)); err != nil {
-				return err
-			}
+		if err := tx.AddRevocations(newRevokedCertsInfo); err != nil {
+			return err
 		}
 
+		revokedCertsInfo, err := tx.ListRevocations()
+		if err != nil {
+			return err
+		}
 		for _, revokedCertInfo := range revokedCertsInfo {
 			crlList = append(crlList, pkix.RevokedCertificate{
 				SerialNumber:   revokedCertInfo.SerialNumber,
@@ -94,7 +46,11 @@ func updateCrl(
 		}
 	}
 
-	nextCrlNumber, err := getNextCrlNumber(caFilenameCrl)
+	currentCrlPemBytes, err := tx.ReadCrl()
+	if err != nil {
+		return err
+	}
+	nextCrlNumber, err := getNextCrlNumber(currentCrlPemBytes)
 	if err != nil {
 		return err
 	}
@@ -122,31 +78,19 @@ func updateCrl(
 		Bytes: crlBytes,
 	})
 
-	if err := atomicWriteFile(caFilenameCrl, pemBlockBytes, os.FileMode(0o644)); err != nil {
-		return err
-	}
-	return nil
+	return tx.WriteCrl(pemBlockBytes)
 }
 
-func getNextCrlNumber(caFilenameCrl string) (*big.Int, error) {
+func getNextCrlNumber(crlPemBytes []byte) (*big.Int, error) {
 	defaultCrlNumber := big.NewInt(time.Now().UnixMilli())
 
-	crlPemBytes, err := os.ReadFile(caFilenameCrl)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return defaultCrlNumber, nil
-		}
-		return nil, err
-	}
-
-	pemBlock, _ := pem.Decode(crlPemBytes)
-	if pemBlock == nil {
-		return nil, fmt.Errorf("invalid CRL PEM content in %s", caFilenameCrl)
+	if crlPemBytes == nil {
+		return defaultCrlNumber, nil
 	}
 
-	parsedCrl, err := x509.ParseRevocationList(pemBlock.Bytes)
+	parsedCrl, err := parseCrlPem(crlPemBytes)
 	if err != nil {
-		return nil, fmt.Errorf("invalid CRL content in %s: %w", caFilenameCrl, err)
+		return nil, err
 	}
 
 	if parsedCrl.Number == nil {
@@ -158,6 +102,19 @@ func getNextCrlNumber(caFilenameCrl string) (*big.Int, error) {
 	return nextCrlNumber, nil
 }
 
+func parseCrlPem(crlPemBytes []byte) (*x509.RevocationList, error) {
+	pemBlock, _ := pem.Decode(crlPemBytes)
+	if pemBlock == nil {
+		return nil, fmt.Errorf("invalid CRL PEM content")
+	}
+
+	parsedCrl, err := x509.ParseRevocationList(pemBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRL content: %w", err)
+	}
+	return parsedCrl, nil
+}
+
 func atomicWriteFile(filename string, content []byte, mode os.FileMode) error {
 	dir := filepath.Dir(filename)
 	tmpFile, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp-*")
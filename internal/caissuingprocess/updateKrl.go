@@ -0,0 +1,113 @@
+package caissuingprocess
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The KRL format is described in PROTOCOL.krl of OpenSSH.
+const (
+	krlMagic         = 0x5353484b524c0a00
+	krlFormatVersion = 1
+
+	krlSectionCertificates   = 1
+	krlSectionCertSerialList = 0x20
+
+	// krlHeaderVersionOffset is where krl_version starts, after the magic
+	// and the format version.
+	krlHeaderVersionOffset = 8 + 4
+)
+
+func updateKrl(
+	tx caStorageTxType,
+	caPublicKey ssh.PublicKey,
+	comment string,
+	addSerialsToRevoked []*big.Int,
+) error {
+	newRevokedCertsInfo := []crlIndexEntryType{}
+	for _, oneSerialToRevoke := range addSerialsToRevoked {
+		newRevokedCertsInfo = append(newRevokedCertsInfo, crlIndexEntryType{
+			SerialNumber:   oneSerialToRevoke,
+			RevocationTime: time.Now().UnixMilli(),
+		})
+	}
+	if err := tx.AddRevocations(newRevokedCertsInfo); err != nil {
+		return err
+	}
+
+	revokedCertsInfo, err := tx.ListRevocations()
+	if err != nil {
+		return err
+	}
+	revokedSerials := []uint64{}
+	for _, revokedCertInfo := range revokedCertsInfo {
+		if !revokedCertInfo.SerialNumber.IsUint64() {
+			return fmt.Errorf("invalid SSH certificate serial %s", revokedCertInfo.SerialNumber.String())
+		}
+		revokedSerials = append(revokedSerials, revokedCertInfo.SerialNumber.Uint64())
+	}
+	slices.Sort(revokedSerials)
+	revokedSerials = slices.Compact(revokedSerials)
+
+	currentKrl, err := tx.ReadKrl()
+	if err != nil {
+		return err
+	}
+	nextKrlVersion, err := getNextKrlVersion(currentKrl)
+	if err != nil {
+		return err
+	}
+
+	return tx.WriteKrl(marshalKrl(nextKrlVersion, time.Now(), comment, caPublicKey, revokedSerials))
+}
+
+func marshalKrl(
+	krlVersion uint64,
+	generatedDate time.Time,
+	comment string,
+	caPublicKey ssh.PublicKey,
+	revokedSerials []uint64,
+) []byte {
+	krl := binary.BigEndian.AppendUint64(nil, krlMagic)
+	krl = binary.BigEndian.AppendUint32(krl, krlFormatVersion)
+	krl = binary.BigEndian.AppendUint64(krl, krlVersion)
+	krl = binary.BigEndian.AppendUint64(krl, uint64(generatedDate.Unix()))
+	krl = binary.BigEndian.AppendUint64(krl, 0) // flags
+	krl = appendSshString(krl, nil)             // reserved
+	krl = appendSshString(krl, []byte(comment))
+
+	if len(revokedSerials) == 0 {
+		return krl
+	}
+	serialList := []byte{}
+	for _, revokedSerial := range revokedSerials {
+		serialList = binary.BigEndian.AppendUint64(serialList, revokedSerial)
+	}
+	certificatesSection := appendSshString(nil, caPublicKey.Marshal())
+	certificatesSection = appendSshString(certificatesSection, nil) // reserved
+	certificatesSection = append(certificatesSection, krlSectionCertSerialList)
+	certificatesSection = appendSshString(certificatesSection, serialList)
+
+	krl = append(krl, krlSectionCertificates)
+	return appendSshString(krl, certificatesSection)
+}
+
+func appendSshString(b []byte, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func getNextKrlVersion(krl []byte) (uint64, error) {
+	if krl == nil {
+		return uint64(time.Now().UnixMilli()), nil
+	}
+	if len(krl) < krlHeaderVersionOffset+8 || binary.BigEndian.Uint64(krl) != krlMagic {
+		return 0, fmt.Errorf("invalid KRL content")
+	}
+	return binary.BigEndian.Uint64(krl[krlHeaderVersionOffset:]) + 1, nil
+}
@@ -0,0 +1,128 @@
+package configfile
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envReference matches ${NAME}, $${NAME} is kept as the literal ${NAME}.
+var envReference = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the references to environment variables in every scalar
+// value of the document, keys are left as they are.
+func expandEnv(node *yaml.Node) []ProblemType {
+	problems := []ProblemType{}
+	var expand func(node *yaml.Node)
+	expand = func(node *yaml.Node) {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			if !envReference.MatchString(node.Value) {
+				return
+			}
+			node.Value = envReference.ReplaceAllStringFunc(node.Value, func(reference string) string {
+				match := envReference.FindStringSubmatch(reference)
+				if match[1] != "" {
+					return reference[1:]
+				}
+				value, found := os.LookupEnv(match[2])
+				if !found {
+					problems = append(problems, ProblemType{
+						Line:    node.Line,
+						Column:  node.Column,
+						Message: fmt.Sprintf("environment variable %s is not set", match[2]),
+					})
+					return reference
+				}
+				return value
+			})
+			// The type of a plain value is resolved again from the expanded
+			// value, so that for example a port can come from a variable.
+			if node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle|yaml.TaggedStyle) == 0 {
+				node.Tag = ""
+			}
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, child := range node.Content {
+				expand(child)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				expand(node.Content[i+1])
+			}
+		}
+	}
+	expand(node)
+	return problems
+}
+
+// mergeDefaults returns the configuration of a CA completed with the settings
+// of defaultsNode it does not set itself. As with YAML merge keys, a setting
+// of the CA replaces the default one as a whole.
+func mergeDefaults(defaultsNode *yaml.Node, caNode *yaml.Node) *yaml.Node {
+	if defaultsNode.Kind == yaml.AliasNode {
+		defaultsNode = defaultsNode.Alias
+	}
+	if caNode.Kind == yaml.AliasNode {
+		caNode = caNode.Alias
+	}
+	if defaultsNode.Kind != yaml.MappingNode || caNode.Kind != yaml.MappingNode {
+		return caNode
+	}
+	caKeys := mappingKeys(caNode)
+	merged := *caNode
+	merged.Content = append([]*yaml.Node{}, caNode.Content...)
+	for key, valueNode := range mappingValues(defaultsNode) {
+		if caKeys[key] {
+			continue
+		}
+		merged.Content = append(merged.Content, &yaml.Node{
+			Kind:  yaml.ScalarNode,
+			Tag:   "!!str",
+			Value: key,
+			Line:  valueNode.Line,
+		}, valueNode)
+	}
+	return &merged
+}
+
+// mappingKeys returns the keys of a mapping, including the merged ones.
+func mappingKeys(node *yaml.Node) map[string]bool {
+	keys := map[string]bool{}
+	for key := range mappingValues(node) {
+		keys[key] = true
+	}
+	return keys
+}
+
+// mappingValues returns the values of a mapping by key, resolving YAML merge
+// keys.
+func mappingValues(node *yaml.Node) map[string]*yaml.Node {
+	values := map[string]*yaml.Node{}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.MappingNode {
+		return values
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		if keyNode.ShortTag() != mergeTag {
+			values[keyNode.Value] = valueNode
+			continue
+		}
+		mergedNodes := []*yaml.Node{valueNode}
+		if valueNode.Kind == yaml.SequenceNode {
+			mergedNodes = valueNode.Content
+		}
+		for _, mergedNode := range mergedNodes {
+			for key, mergedValueNode := range mappingValues(mergedNode) {
+				if _, found := values[key]; !found {
+					values[key] = mergedValueNode
+				}
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,147 @@
+package configfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/configfile"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), os.FileMode(0o700)); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), os.FileMode(0o600)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "config.yml")
+}
+
+func TestIncludeDefaultsAndEnv(t *testing.T) {
+	t.Setenv("SIMPLE_CA_TEST_OPA_TOKEN", "secret-token")
+	t.Setenv("SIMPLE_CA_TEST_RETRIES", "3")
+	filename := writeConfigFiles(t, map[string]string{
+		"config.yml": `data_directory: ./tmp/
+include:
+    - cas.d/*.yml
+defaults:
+    validity:
+        years: 1
+    key_config:
+        type: ecdsa
+        config:
+            curve_name: P-256
+    crl_ttl: 12h
+    opa_client:
+        bearer_token: ${SIMPLE_CA_TEST_OPA_TOKEN}
+        retries: ${SIMPLE_CA_TEST_RETRIES}
+all_ca_configs:
+    ca_main:
+        subject:
+            common_name: Main
+`,
+		"cas.d/a.yml": `all_ca_configs:
+    ca_a:
+        subject:
+            common_name: A $${LITERAL}
+        crl_ttl: 1h
+        key_config:
+            type: rsa
+            config:
+                size: 2048
+`,
+	})
+	configFile, err := configfile.Read(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configFile.AllCaConfigs) != 2 {
+		t.Fatalf("expected 2 CAs, got %v", configFile.AllCaConfigs)
+	}
+
+	caMain := configFile.AllCaConfigs["ca_main"]
+	if caMain.Validity.Years != 1 || caMain.CrlTtl.Hours() != 12 {
+		t.Fatalf("defaults not applied: %+v", caMain)
+	}
+	if _, isEcdsa := caMain.KeyConfig.Config.(types.KeyTypeEcdsaConfigType); !isEcdsa {
+		t.Fatalf("expected the default ecdsa key, got %+v", caMain.KeyConfig)
+	}
+	if caMain.OpaClient == nil || *caMain.OpaClient.BearerToken != "secret-token" || *caMain.OpaClient.Retries != 3 {
+		t.Fatalf("environment variables not expanded: %+v", caMain.OpaClient)
+	}
+
+	caA := configFile.AllCaConfigs["ca_a"]
+	if caA.Subject.CommonName != "A ${LITERAL}" {
+		t.Fatalf("unexpected common name %q", caA.Subject.CommonName)
+	}
+	if caA.CrlTtl.Hours() != 1 || caA.Validity.Years != 1 {
+		t.Fatalf("CA settings must replace the defaults: %+v", caA)
+	}
+	if keyConfig, isRsa := caA.KeyConfig.Config.(types.KeyTypeRsaConfigType); !isRsa || keyConfig.Size != 2048 {
+		t.Fatalf("expected the rsa key of the CA, got %+v", caA.KeyConfig)
+	}
+}
+
+func TestIncludeReportsProblemsOfEveryFile(t *testing.T) {
+	filename := writeConfigFiles(t, map[string]string{
+		"config.yml": `data_directory: ./tmp/
+include:
+    - cas.d/*.yml
+defaults:
+    validity:
+        years: 1
+    key_config:
+        type: ecdsa
+        config:
+            curve_name: P-999
+    crl_ttl: 12h
+all_ca_configs:
+    ca_main:
+        subject:
+            common_name: Main
+`,
+		"cas.d/a.yml": `all_ca_configs:
+    ca_a:
+        subject:
+            common_name: A ${SIMPLE_CA_TEST_UNSET}
+        unknown_field: 1
+    ca_main:
+        subject:
+            common_name: Duplicate
+`,
+	})
+	_, problems, err := configfile.Check(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	includedFilename := filepath.Join(filepath.Dir(filename), "cas.d", "a.yml")
+	expectedProblems := []struct {
+		filename string
+		line     int
+		contains string
+	}{
+		// the invalid default curve is reported once for every CA
+		{filename, 10, "all_ca_configs.ca_a.key_config.config.curve_name: invalid curve name"},
+		{filename, 10, "all_ca_configs.ca_main.key_config.config.curve_name: invalid curve name"},
+		{includedFilename, 4, "environment variable SIMPLE_CA_TEST_UNSET is not set"},
+		{includedFilename, 5, "field unknown_field not found in type types.CertificateAuthorityType"},
+		{includedFilename, 6, "CA already configured in " + filename + ":13"},
+	}
+	if len(problems) != len(expectedProblems) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expectedProblems), len(problems), problems)
+	}
+	for i, expectedProblem := range expectedProblems {
+		problem := problems[i]
+		if problem.Filename != expectedProblem.filename || problem.Line != expectedProblem.line || !strings.Contains(problem.String(), expectedProblem.contains) {
+			t.Errorf("problem %d: expected %s:%d %q, got %s", i, expectedProblem.filename, expectedProblem.line, expectedProblem.contains, problem)
+		}
+	}
+}
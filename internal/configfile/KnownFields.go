@@ -0,0 +1,103 @@
+package configfile
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	reflectConfigFileType         = reflect.TypeFor[types.ConfigFileType]()
+	reflectIncludedConfigFileType = reflect.TypeFor[includedConfigFileType]()
+)
+
+const mergeTag = "!!merge"
+
+// keyConfigTypes are the types of the config of KeyConfigType by key type.
+var keyConfigTypes = map[string]reflect.Type{
+	"rsa":   reflect.TypeFor[types.KeyTypeRsaConfigType](),
+	"ecdsa": reflect.TypeFor[types.KeyTypeEcdsaConfigType](),
+}
+
+// checkKnownFields reports the mapping keys that do not match a field of t,
+// with the same message as the decoder.
+func checkKnownFields(node *yaml.Node, t reflect.Type) []ProblemType {
+	problems := []ProblemType{}
+	visited := map[*yaml.Node]bool{}
+
+	var check func(node *yaml.Node, t reflect.Type)
+	check = func(node *yaml.Node, t reflect.Type) {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+		// An anchor is checked where it is defined.
+		if visited[node] {
+			return
+		}
+		visited[node] = true
+
+		switch {
+		case node.Kind == yaml.MappingNode && t == keyConfigType:
+			check(node, reflect.TypeFor[struct {
+				Type   string `yaml:"type"`
+				Config any    `yaml:"config"`
+			}]())
+			if configType, found := keyConfigTypes[scalarValue(childNode(node, "type"))]; found {
+				if configNode := childNode(node, "config"); configNode != nil {
+					check(configNode, configType)
+				}
+			}
+		case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+			fieldTypes := map[string]reflect.Type{}
+			for i := 0; i < t.NumField(); i++ {
+				if name, skip := yamlFieldName(t.Field(i)); !skip {
+					fieldTypes[name] = t.Field(i).Type
+				}
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				keyNode, valueNode := node.Content[i], node.Content[i+1]
+				if keyNode.ShortTag() == mergeTag {
+					check(valueNode, t)
+					continue
+				}
+				fieldType, found := fieldTypes[keyNode.Value]
+				if !found {
+					problems = append(problems, ProblemType{
+						Line:    keyNode.Line,
+						Column:  keyNode.Column,
+						Message: fmt.Sprintf("field %s not found in type %s", keyNode.Value, t.String()),
+					})
+					continue
+				}
+				check(valueNode, fieldType)
+			}
+		case node.Kind == yaml.MappingNode && t.Kind() == reflect.Map:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				check(node.Content[i+1], t.Elem())
+			}
+		case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Struct:
+			// A list of merged mappings.
+			for _, itemNode := range node.Content {
+				check(itemNode, t)
+			}
+		case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+			for _, itemNode := range node.Content {
+				check(itemNode, t.Elem())
+			}
+		}
+	}
+	check(node, t)
+	return problems
+}
+
+func scalarValue(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
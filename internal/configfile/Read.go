@@ -0,0 +1,310 @@
+package configfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// includedConfigFileType is the content of a file listed in include.
+type includedConfigFileType struct {
+	AllCaConfigs map[string]types.CertificateAuthorityType `yaml:"all_ca_configs"`
+}
+
+// caSourceType records where a CA is configured, to report its problems.
+type caSourceType struct {
+	filename string
+	keyNode  *yaml.Node
+	node     *yaml.Node
+}
+
+type problemLineType struct {
+	filename string
+	line     int
+}
+
+type loaderType struct {
+	problems      []ProblemType
+	reportedLines map[problemLineType]bool
+}
+
+func (loader *loaderType) report(problem ProblemType) {
+	if problem.Line > 0 {
+		loader.reportedLines[problemLineType{problem.Filename, problem.Line}] = true
+	}
+	loader.problems = append(loader.problems, problem)
+}
+
+// Check reads filename and the files it includes and reports every problem of
+// the configuration, with the file and line where it is found.
+func Check(filename string) (types.ConfigFileType, []ProblemType, error) {
+	configFile := types.ConfigFileType{}
+	configFileBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return configFile, nil, err
+	}
+
+	loader := &loaderType{
+		problems:      []ProblemType{},
+		reportedLines: map[problemLineType]bool{},
+	}
+	rootNode := loader.parse(filename, configFileBytes, reflectConfigFileType)
+	if rootNode == nil {
+		return configFile, loader.problems, nil
+	}
+	loader.decode(filename, rootNode, &configFile)
+
+	caSources := documentCaSources(filename, rootNode)
+	for _, includedFilename := range loader.includedFilenames(filename, rootNode, configFile.Include) {
+		loader.include(includedFilename, &configFile, caSources)
+	}
+
+	if defaultsNode := childNode(documentRoot(rootNode), "defaults"); defaultsNode != nil {
+		for caId, caSource := range caSources {
+			var caConfig types.CertificateAuthorityType
+			// Type errors were already reported decoding each file.
+			_ = mergeDefaults(defaultsNode, caSource.node).Decode(&caConfig)
+			configFile.AllCaConfigs[caId] = caConfig
+		}
+	}
+
+	for _, problem := range Validate(configFile) {
+		problem.Filename = filename
+		problem.Line, problem.Column = locate(rootNode, problem.Path)
+		if len(problem.Path) >= 2 && problem.Path[0] == "all_ca_configs" {
+			if caSource, found := caSources[problem.Path[1]]; found {
+				problem.Filename, problem.Line, problem.Column = locateCa(caSource, filename, rootNode, problem.Path[2:])
+			}
+		}
+		// The decoder already explained why this value is wrong.
+		if loader.reportedLines[problemLineType{problem.Filename, problem.Line}] {
+			continue
+		}
+		loader.problems = append(loader.problems, problem)
+	}
+	sort.SliceStable(loader.problems, func(i, j int) bool {
+		problemI, problemJ := loader.problems[i], loader.problems[j]
+		if problemI.Filename != problemJ.Filename {
+			if problemI.Filename == filename || problemJ.Filename == filename {
+				return problemI.Filename == filename
+			}
+			return problemI.Filename < problemJ.Filename
+		}
+		return problemI.Line < problemJ.Line
+	})
+	return configFile, loader.problems, nil
+}
+
+// Read parses and validates filename.
+func Read(filename string) (types.ConfigFileType, error) {
+	configFile, problems, err := Check(filename)
+	if err != nil {
+		return configFile, err
+	}
+	if len(problems) > 0 {
+		descriptions := make([]string, 0, len(problems))
+		for _, problem := range problems {
+			descriptions = append(descriptions, problem.String())
+		}
+		return configFile, fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(descriptions, "; "))
+	}
+	return configFile, nil
+}
+
+// parse returns the document of one file with environment variables
+// expanded, nil when it cannot be parsed. Unknown fields are reported against
+// rootType, as the decoder does with KnownFields(true): the decoder cannot be
+// used since documents are decoded from nodes once expanded and merged.
+func (loader *loaderType) parse(filename string, content []byte, rootType reflect.Type) *yaml.Node {
+	var rootNode yaml.Node
+	if err := yaml.Unmarshal(content, &rootNode); err != nil {
+		loader.report(yamlErrorProblem(filename, err.Error()))
+		return nil
+	}
+	for _, problem := range expandEnv(&rootNode) {
+		problem.Filename = filename
+		loader.report(problem)
+	}
+	for _, problem := range checkKnownFields(documentRoot(&rootNode), rootType) {
+		problem.Filename = filename
+		loader.report(problem)
+	}
+	return &rootNode
+}
+
+func (loader *loaderType) decode(filename string, rootNode *yaml.Node, out any) {
+	if documentRoot(rootNode).Kind == 0 {
+		// An empty file.
+		return
+	}
+	if err := rootNode.Decode(out); err != nil {
+		var typeError *yaml.TypeError
+		if !errors.As(err, &typeError) {
+			loader.report(yamlErrorProblem(filename, err.Error()))
+			return
+		}
+		for _, msg := range typeError.Errors {
+			loader.report(yamlErrorProblem(filename, msg))
+		}
+	}
+}
+
+func (loader *loaderType) includedFilenames(filename string, rootNode *yaml.Node, includes []string) []string {
+	includedFilenames := []string{}
+	for i, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filename), include)
+		}
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			line, column := locate(rootNode, []string{"include", strconv.Itoa(i)})
+			loader.report(ProblemType{
+				Filename: filename,
+				Path:     []string{"include", strconv.Itoa(i)},
+				Line:     line,
+				Column:   column,
+				Message:  fmt.Sprintf("invalid glob %q: %v", include, err),
+			})
+			continue
+		}
+		includedFilenames = append(includedFilenames, matches...)
+	}
+	return includedFilenames
+}
+
+func (loader *loaderType) include(
+	includedFilename string,
+	configFile *types.ConfigFileType,
+	caSources map[string]caSourceType,
+) {
+	content, err := os.ReadFile(includedFilename)
+	if err != nil {
+		loader.report(ProblemType{Filename: includedFilename, Message: err.Error()})
+		return
+	}
+	rootNode := loader.parse(includedFilename, content, reflectIncludedConfigFileType)
+	if rootNode == nil {
+		return
+	}
+	includedConfigFile := includedConfigFileType{}
+	loader.decode(includedFilename, rootNode, &includedConfigFile)
+
+	if configFile.AllCaConfigs == nil {
+		configFile.AllCaConfigs = map[string]types.CertificateAuthorityType{}
+	}
+	for caId, caSource := range documentCaSources(includedFilename, rootNode) {
+		if previousSource, found := caSources[caId]; found {
+			loader.report(ProblemType{
+				Filename: includedFilename,
+				Path:     []string{"all_ca_configs", caId},
+				Line:     caSource.keyNode.Line,
+				Column:   caSource.keyNode.Column,
+				Message:  fmt.Sprintf("CA already configured in %s:%d", previousSource.filename, previousSource.keyNode.Line),
+			})
+			continue
+		}
+		caSources[caId] = caSource
+		configFile.AllCaConfigs[caId] = includedConfigFile.AllCaConfigs[caId]
+	}
+}
+
+func yamlErrorProblem(filename string, msg string) ProblemType {
+	problem := ProblemType{
+		Filename: filename,
+		Message:  msg,
+	}
+	if match := yamlErrorLine.FindStringSubmatch(msg); match != nil {
+		problem.Line, _ = strconv.Atoi(match[1])
+		problem.Message = match[2]
+	}
+	return problem
+}
+
+func documentRoot(rootNode *yaml.Node) *yaml.Node {
+	if rootNode.Kind == yaml.DocumentNode && len(rootNode.Content) > 0 {
+		return rootNode.Content[0]
+	}
+	return rootNode
+}
+
+// documentCaSources returns where every CA of a document is configured.
+func documentCaSources(filename string, rootNode *yaml.Node) map[string]caSourceType {
+	caSources := map[string]caSourceType{}
+	allCaConfigsNode := childNode(documentRoot(rootNode), "all_ca_configs")
+	if allCaConfigsNode == nil || allCaConfigsNode.Kind != yaml.MappingNode {
+		return caSources
+	}
+	for i := 0; i+1 < len(allCaConfigsNode.Content); i += 2 {
+		caSources[allCaConfigsNode.Content[i].Value] = caSourceType{
+			filename: filename,
+			keyNode:  allCaConfigsNode.Content[i],
+			node:     allCaConfigsNode.Content[i+1],
+		}
+	}
+	return caSources
+}
+
+// locate returns the position of the node at path, or of its deepest
+// existing parent when the value is missing.
+func locate(rootNode *yaml.Node, path []string) (int, int) {
+	node, _ := walk(documentRoot(rootNode), path)
+	return node.Line, node.Column
+}
+
+// locateCa finds a value of a CA either in its own configuration or, when it
+// comes from there, in the defaults block.
+func locateCa(caSource caSourceType, filename string, rootNode *yaml.Node, path []string) (string, int, int) {
+	node, depth := walk(caSource.node, path)
+	if defaultsNode := childNode(documentRoot(rootNode), "defaults"); defaultsNode != nil {
+		if defaultNode, defaultDepth := walk(defaultsNode, path); defaultDepth > depth {
+			return filename, defaultNode.Line, defaultNode.Column
+		}
+	}
+	return caSource.filename, node.Line, node.Column
+}
+
+func walk(node *yaml.Node, path []string) (*yaml.Node, int) {
+	depth := 0
+	for _, key := range path {
+		next := childNode(node, key)
+		if next == nil {
+			break
+		}
+		node = next
+		depth++
+	}
+	return node, depth
+}
+
+func childNode(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1]
+			}
+		}
+	case yaml.SequenceNode:
+		index, err := strconv.Atoi(key)
+		if err == nil && index >= 0 && index < len(node.Content) {
+			return node.Content[index]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package configfile_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/configfile"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(filename, []byte(content), os.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestSampleConfigIsValid(t *testing.T) {
+	if _, err := configfile.Read(filepath.Join("..", "..", "config.yml")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckReportsEveryProblemWithLine(t *testing.T) {
+	filename := writeConfigFile(t, `data_directory: ./tmp/
+http_server:
+    listen_port: 5000
+    unknown_field: 1
+all_ca_configs:
+    ca_1:
+        subject:
+            country: [IT]
+        validity:
+            years: 0
+        key_config:
+            type: ecdsa
+            config:
+                curve_name: P-999
+        crl_ttl: -1h
+        permitted_ip_ranges:
+            - 10.0.0.0/8
+            - 10.0.0.0/33
+        opa_url_sign: http://localhost:8181/v1/data/simple_ca/allow
+        opa_url_revoke: localhost
+    ca_2:
+        subject:
+            common_name: CA 2
+        validity:
+            days: 1
+        key_config:
+            type: dsa
+        crl_ttl: 1h
+        opa_url_sign: http://localhost:8181/v1/data/simple_ca/allow
+        opa_url_revoke: http://localhost:8181/v1/data/simple_ca/allow
+        tsa:
+            policy: not-an-oid
+        cross_signing:
+            - issuer: ca_3
+            - issuer: ca_2
+`)
+	_, problems, err := configfile.Check(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedProblems := []struct {
+		line   int
+		prefix string
+	}{
+		{4, "field unknown_field not found"},
+		{8, "all_ca_configs.ca_1.subject.common_name: is required"},
+		{10, "all_ca_configs.ca_1.validity: "},
+		{14, "all_ca_configs.ca_1.key_config.config.curve_name: invalid curve name"},
+		{15, "all_ca_configs.ca_1.crl_ttl: must be positive"},
+		{18, "all_ca_configs.ca_1.permitted_ip_ranges.1: invalid CIDR"},
+		{20, "all_ca_configs.ca_1.opa_url_revoke: must be an absolute"},
+		{27, "invalid key type"},
+		{32, "all_ca_configs.ca_2.tsa.policy: invalid TSA policy"},
+		{32, "all_ca_configs.ca_2.tsa.opa_url: is required"},
+		{34, "all_ca_configs.ca_2.cross_signing.0.issuer: invalid ca id"},
+		{35, "all_ca_configs.ca_2.cross_signing.1.issuer: a CA cannot cross-sign itself"},
+	}
+	if len(problems) != len(expectedProblems) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expectedProblems), len(problems), problems)
+	}
+	for i, expectedProblem := range expectedProblems {
+		problem := problems[i]
+		if problem.Line != expectedProblem.line {
+			t.Errorf("problem %d: expected line %d, got %s", i, expectedProblem.line, problem)
+		}
+		if !strings.Contains(problem.String(), expectedProblem.prefix) {
+			t.Errorf("problem %d: expected %q, got %s", i, expectedProblem.prefix, problem)
+		}
+	}
+}
+
+func TestReadRefusesInvalidConfig(t *testing.T) {
+	filename := writeConfigFile(t, `data_directory: ./tmp/
+all_ca_configs:
+    ca_1:
+        subject:
+            common_name: CA 1
+        validity:
+            days: 1
+        key_config:
+            type: rsa
+            config:
+                size: 1024
+        crl_ttl: 1h
+`)
+	_, err := configfile.Read(filename)
+	if !errors.Is(err, configfile.ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	if !strings.Contains(err.Error(), ":11:") {
+		t.Fatalf("expected the line of the key size, got %v", err)
+	}
+}
+
+func TestCheckReportsSyntaxError(t *testing.T) {
+	filename := writeConfigFile(t, "data_directory: ./tmp/\nall_ca_configs: [\n")
+	_, problems, err := configfile.Check(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Line == 0 {
+		t.Fatalf("expected one syntax error with its line, got %v", problems)
+	}
+}
@@ -0,0 +1,157 @@
+package configfile
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// durationPattern matches the values accepted by time.ParseDuration.
+const durationPattern = `^[-+]?(0|([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+$`
+
+// schemaEnums lists the accepted values of string fields, keyed by type and
+// YAML field name.
+var schemaEnums = map[string][]string{
+	"LogConfigType.level":                {"debug", "info", "warn", "error"},
+	"LogConfigType.format":               {types.LogFormatLogfmt, types.LogFormatJson},
+	"GitRepositoryType.signing_key_type": {"", "openpgp", "ssh"},
+	"StorageConfigType.type":             {"", "filesystem", "bbolt"},
+	"KeyTypeEcdsaConfigType.curve_name":  {"P-224", "P-256", "P-384", "P-521"},
+	"CertificateAuthorityType.kind":      {"", types.CaKindX509, types.CaKindSsh},
+	"SshConfigType.cert_type":            {"", types.SshCertTypeUser, types.SshCertTypeHost},
+}
+
+var (
+	durationType  = reflect.TypeFor[time.Duration]()
+	keyConfigType = reflect.TypeFor[types.KeyConfigType]()
+)
+
+// Schema returns the JSON Schema of the configuration file, generated from
+// types.ConfigFileType.
+func Schema() ([]byte, error) {
+	schema := schemaOf(reflect.TypeFor[types.ConfigFileType]())
+	schema["$schema"] = schemaDialect
+	schema["title"] = "simple-ca configuration file"
+	schema["required"] = []string{"data_directory"}
+	allCaConfigs := schema["properties"].(map[string]any)["all_ca_configs"].(map[string]any)
+	allCaConfigs["propertyNames"] = map[string]any{"pattern": types.CaIdPattern.String()}
+
+	schemaBytes, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(schemaBytes, '\n'), nil
+}
+
+func schemaOf(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		return nullable(schemaOf(t.Elem()))
+	}
+	switch t {
+	case durationType:
+		return map[string]any{"type": "string", "pattern": durationPattern}
+	case keyConfigType:
+		return keyConfigSchema()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, skip := yamlFieldName(field)
+			if skip {
+				continue
+			}
+			fieldSchema := schemaOf(field.Type)
+			if enum, found := schemaEnums[t.Name()+"."+name]; found {
+				fieldSchema["enum"] = enum
+			}
+			properties[name] = fieldSchema
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Map:
+		return nullable(map[string]any{
+			"type":                 "object",
+			"additionalProperties": schemaOf(t.Elem()),
+		})
+	case reflect.Slice:
+		return nullable(map[string]any{
+			"type":  "array",
+			"items": schemaOf(t.Elem()),
+		})
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{
+			"type":    "integer",
+			"minimum": 0,
+			"maximum": uint64(1)<<(t.Bits()) - 1,
+		}
+	case reflect.Uint, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	default:
+		return map[string]any{}
+	}
+}
+
+// nullable accepts an empty YAML value, decoded as nil, in place of the
+// value described by schema.
+func nullable(schema map[string]any) map[string]any {
+	if schemaType, isString := schema["type"].(string); isString {
+		schema["type"] = []string{schemaType, "null"}
+	}
+	return schema
+}
+
+// yamlFieldName follows the naming rules of gopkg.in/yaml.v3.
+func yamlFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", true
+	}
+	tag := field.Tag.Get("yaml")
+	if tag == "-" {
+		return "", true
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, false
+}
+
+// keyConfigSchema describes KeyConfigType, whose config depends on its type.
+func keyConfigSchema() map[string]any {
+	variants := []any{}
+	for _, variant := range []struct {
+		keyType    string
+		configType reflect.Type
+	}{
+		{"rsa", reflect.TypeFor[types.KeyTypeRsaConfigType]()},
+		{"ecdsa", reflect.TypeFor[types.KeyTypeEcdsaConfigType]()},
+	} {
+		variants = append(variants, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"type":   map[string]any{"const": variant.keyType},
+				"config": schemaOf(variant.configType),
+			},
+			"required":             []string{"type", "config"},
+			"additionalProperties": false,
+		})
+	}
+	return map[string]any{"oneOf": variants}
+}
@@ -0,0 +1,55 @@
+package configfile_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/configfile"
+)
+
+func TestSchemaIsUpToDate(t *testing.T) {
+	schemaBytes, err := configfile.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishedSchemaBytes, err := os.ReadFile(filepath.Join("..", "..", "config.schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(schemaBytes, publishedSchemaBytes) {
+		t.Fatal("config.schema.json is outdated, run: ./simple-ca config schema > config.schema.json")
+	}
+}
+
+func TestSchemaDescribesKeyConfig(t *testing.T) {
+	schemaBytes, err := configfile.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties struct {
+			AllCaConfigs struct {
+				AdditionalProperties struct {
+					Properties map[string]struct {
+						OneOf []any `json:"oneOf"`
+					} `json:"properties"`
+				} `json:"additionalProperties"`
+			} `json:"all_ca_configs"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(schemaBytes, &schema); err != nil {
+		t.Fatal(err)
+	}
+	caProperties := schema.Properties.AllCaConfigs.AdditionalProperties.Properties
+	if len(caProperties["key_config"].OneOf) != 2 {
+		t.Fatalf("expected rsa and ecdsa variants of key_config, got %v", caProperties["key_config"])
+	}
+	for _, name := range []string{"subject", "validity", "crl_ttl", "opa_client", "git", "storage"} {
+		if _, found := caProperties[name]; !found {
+			t.Errorf("missing property %s", name)
+		}
+	}
+}
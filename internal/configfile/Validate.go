@@ -0,0 +1,448 @@
+package configfile
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+// ProblemType is one mistake in the configuration. Path lists the YAML keys
+// leading to the value; Line and Column are 0 when unknown.
+type ProblemType struct {
+	Filename string
+	Path     []string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (problem ProblemType) String() string {
+	location := problem.Filename
+	if problem.Line > 0 {
+		location += fmt.Sprintf(":%d", problem.Line)
+		if problem.Column > 0 {
+			location += fmt.Sprintf(":%d", problem.Column)
+		}
+	}
+	if len(problem.Path) > 0 {
+		location += ": " + strings.Join(problem.Path, ".")
+	}
+	if location == "" {
+		return problem.Message
+	}
+	return location + ": " + problem.Message
+}
+
+type problemsType []ProblemType
+
+func (problems *problemsType) add(path []string, format string, a ...any) {
+	*problems = append(*problems, ProblemType{
+		Path:    append([]string{}, path...),
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (problems *problemsType) checkDuration(path []string, value time.Duration) {
+	if value < 0 {
+		problems.add(path, "must not be negative, got %s", value)
+	}
+}
+
+func (problems *problemsType) checkUrl(path []string, value *string, required bool) {
+	if value == nil || strings.TrimSpace(*value) == "" {
+		if required {
+			problems.add(path, "is required")
+		}
+		return
+	}
+	parsedUrl, err := url.Parse(*value)
+	if err != nil {
+		problems.add(path, "invalid URL: %v", err)
+		return
+	}
+	if parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https" || parsedUrl.Host == "" {
+		problems.add(path, "must be an absolute http or https URL, got %q", *value)
+	}
+}
+
+// Validate checks the whole configuration without touching the disk.
+func Validate(configFile types.ConfigFileType) []ProblemType {
+	problems := problemsType{}
+
+	if strings.TrimSpace(configFile.DataDirectory) == "" {
+		problems.add([]string{"data_directory"}, "is required")
+	}
+	if configFile.Log != nil {
+		if _, err := types.NewLogger(io.Discard, configFile.Log); err != nil {
+			problems.add([]string{"log"}, "%v", err)
+		}
+	}
+	if configFile.HttpServer != nil {
+		path := []string{"http_server"}
+		httpServer := configFile.HttpServer
+		if httpServer.ListenPort == 0 {
+			problems.add(append(path, "listen_port"), "is required")
+		}
+		problems.checkDuration(append(path, "read_header_timeout"), httpServer.ReadHeaderTimeout)
+		problems.checkDuration(append(path, "read_timeout"), httpServer.ReadTimeout)
+		problems.checkDuration(append(path, "write_timeout"), httpServer.WriteTimeout)
+		problems.checkDuration(append(path, "idle_timeout"), httpServer.IdleTimeout)
+		problems.checkDuration(append(path, "shutdown_timeout"), httpServer.ShutdownTimeout)
+		problems.checkUrl(append(path, "opa_url_admin"), httpServer.OpaUrlAdmin, false)
+		problems.validateOpaClient(append(path, "opa_client"), httpServer.OpaClient)
+	}
+	if len(configFile.AllCaConfigs) == 0 {
+		problems.add([]string{"all_ca_configs"}, "no CA configured")
+	}
+	for _, caId := range slices.Sorted(maps.Keys(configFile.AllCaConfigs)) {
+		caConfig := configFile.AllCaConfigs[caId]
+		path := []string{"all_ca_configs", caId}
+		if !types.CaIdPattern.MatchString(caId) {
+			problems.add(path, "invalid CA ID, it must match %s", types.CaIdPattern.String())
+		}
+		problems.validateCa(path, caConfig, configFile.HttpServer != nil)
+		problems.validateCrossSigning(path, caId, caConfig, configFile.AllCaConfigs)
+	}
+	return problems
+}
+
+func (problems *problemsType) validateCa(path []string, caConfig types.CertificateAuthorityType, httpServer bool) {
+	switch caConfig.Kind {
+	case "", types.CaKindX509:
+		problems.validateX509Ca(path, caConfig, httpServer)
+	case types.CaKindSsh:
+		problems.validateSshCa(path, caConfig)
+	default:
+		problems.add(append(path, "kind"), "%v: %q, use x509 or ssh", types.ErrInvalidCaKind, caConfig.Kind)
+	}
+
+	keyConfigPath := append(path, "key_config")
+	switch keyConfigData := caConfig.KeyConfig.Config.(type) {
+	case types.KeyTypeRsaConfigType:
+		if keyConfigData.Size < 2048 {
+			problems.add(append(keyConfigPath, "config", "size"), "must be at least 2048, got %d", keyConfigData.Size)
+		}
+	case types.KeyTypeEcdsaConfigType:
+		switch keyConfigData.CurveName {
+		case "P-224", "P-256", "P-384", "P-521":
+		default:
+			problems.add(append(keyConfigPath, "config", "curve_name"), "%v: %q, use P-224, P-256, P-384 or P-521", types.ErrInvalidCurve, keyConfigData.CurveName)
+		}
+	default:
+		problems.add(append(keyConfigPath, "type"), "%v: %q, use rsa or ecdsa", types.ErrInvalidKeyType, caConfig.KeyConfig.Type)
+	}
+
+	problems.checkDuration(append(path, "lock_timeout"), caConfig.LockTimeout)
+	problems.checkDuration(append(path, "expiry_warning"), caConfig.ExpiryWarning)
+
+	for fieldName, ipRanges := range map[string][]string{
+		"permitted_ip_ranges": caConfig.PermittedIPRanges,
+		"excluded_ip_ranges":  caConfig.ExcludedIPRanges,
+	} {
+		for i, ipRange := range ipRanges {
+			if _, _, err := net.ParseCIDR(ipRange); err != nil {
+				problems.add(append(path, fieldName, fmt.Sprint(i)), "invalid CIDR %q", ipRange)
+			}
+		}
+	}
+
+	problems.checkUrl(append(path, "opa_url_sign"), caConfig.OpaUrlSign, httpServer)
+	problems.checkUrl(append(path, "opa_url_revoke"), caConfig.OpaUrlRevoke, httpServer)
+	problems.validateOpaClient(append(path, "opa_client"), caConfig.OpaClient)
+
+	if caConfig.Git != nil {
+		gitPath := append(path, "git")
+		switch caConfig.Git.SigningKeyType {
+		case "":
+		case "openpgp", "ssh":
+			if caConfig.Git.SigningKeyFile == nil || *caConfig.Git.SigningKeyFile == "" {
+				problems.add(append(gitPath, "signing_key_file"), "is required with signing_key_type %q", caConfig.Git.SigningKeyType)
+			}
+		default:
+			problems.add(append(gitPath, "signing_key_type"), "%v: %q, use openpgp or ssh", types.ErrInvalidSigningKeyType, caConfig.Git.SigningKeyType)
+		}
+	}
+	if caConfig.Limits != nil {
+		limitsPath := append(path, "limits")
+		problems.validateRateLimit(append(limitsPath, "ca"), caConfig.Limits.Ca)
+		problems.validateRateLimit(append(limitsPath, "requester"), caConfig.Limits.Requester)
+		if caConfig.Limits.MaxActiveCertificatesPerSan < 0 {
+			problems.add(append(limitsPath, "max_active_certificates_per_san"), "must not be negative, got %d", caConfig.Limits.MaxActiveCertificatesPerSan)
+		}
+	}
+	for _, profileName := range slices.Sorted(maps.Keys(caConfig.Profiles)) {
+		if !types.ProfileNamePattern.MatchString(profileName) {
+			problems.add(append(path, "profiles", profileName), "invalid profile name, it must match %s", types.ProfileNamePattern.String())
+		}
+		if sanRules := caConfig.Profiles[profileName].SanRules; sanRules != nil && sanRules.MaxSans < 0 {
+			problems.add(append(path, "profiles", profileName, "san_rules", "max_sans"), "must not be negative, got %d", sanRules.MaxSans)
+		}
+		problems.validateSubjectPolicy(append(path, "profiles", profileName, "subject"), caConfig.Profiles[profileName].Subject)
+		problems.validateCertificatePolicies(append(path, "profiles", profileName, "certificate_policies"), caConfig.Profiles[profileName].CertificatePolicies)
+		problems.validateStaticExtensions(append(path, "profiles", profileName, "static_extensions"), caConfig.Profiles[profileName].StaticExtensions)
+	}
+	if caConfig.Storage != nil {
+		switch caConfig.Storage.Type {
+		case "", "filesystem", "bbolt":
+		default:
+			problems.add(append(path, "storage", "type"), "%v: %q, use filesystem or bbolt", types.ErrInvalidStorageType, caConfig.Storage.Type)
+		}
+	}
+}
+
+func (problems *problemsType) validateX509Ca(path []string, caConfig types.CertificateAuthorityType, httpServer bool) {
+	if strings.TrimSpace(caConfig.Subject.CommonName) == "" {
+		problems.add(append(path, "subject", "common_name"), "is required")
+	}
+
+	validity := caConfig.Validity
+	if validity.Years < 0 || validity.Months < 0 || validity.Days < 0 {
+		problems.add(append(path, "validity"), "must not be negative")
+	} else if validity.Years == 0 && validity.Months == 0 && validity.Days == 0 {
+		problems.add(append(path, "validity"), "the CA certificate would expire immediately, set years, months or days")
+	}
+
+	if caConfig.CrlTtl <= 0 {
+		problems.add(append(path, "crl_ttl"), "must be positive, got %s", caConfig.CrlTtl)
+	}
+	if caConfig.Ssh != nil {
+		problems.add(append(path, "ssh"), "is only used by a CA of kind ssh")
+	}
+	if caConfig.Tsa != nil {
+		tsaPath := append(path, "tsa")
+		if _, err := caConfig.Tsa.PolicyOid(); err != nil {
+			problems.add(append(tsaPath, "policy"), "%v, use a dotted OID such as 1.3.6.1.4.1.99999.1", err)
+		}
+		problems.checkUrl(append(tsaPath, "opa_url"), caConfig.Tsa.OpaUrl, httpServer)
+		problems.checkDuration(append(tsaPath, "accuracy"), caConfig.Tsa.Accuracy)
+		certificateValidity := caConfig.Tsa.CertificateValidity
+		if certificateValidity.Years < 0 || certificateValidity.Months < 0 || certificateValidity.Days < 0 {
+			problems.add(append(tsaPath, "certificate_validity"), "must not be negative")
+		}
+	}
+
+	problems.validateCertificatePolicies(append(path, "certificate_policies"), caConfig.CertificatePolicies)
+	for i, policyMapping := range caConfig.PolicyMappings {
+		for _, policy := range []struct {
+			key string
+			oid string
+		}{
+			{"issuer_domain_policy", policyMapping.IssuerDomainPolicy},
+			{"subject_domain_policy", policyMapping.SubjectDomainPolicy},
+		} {
+			if policyOid, err := types.ParseOid(policy.oid); err != nil {
+				problems.add(append(path, "policy_mappings", fmt.Sprint(i), policy.key), "%v", err)
+			} else if policyOid.String() == types.AnyPolicyOid {
+				problems.add(append(path, "policy_mappings", fmt.Sprint(i), policy.key), "anyPolicy cannot be mapped")
+			}
+		}
+	}
+	if policyConstraints := caConfig.PolicyConstraints; policyConstraints != nil {
+		constraintsPath := append(path, "policy_constraints")
+		if policyConstraints.RequireExplicitPolicy == nil && policyConstraints.InhibitPolicyMapping == nil {
+			problems.add(constraintsPath, "set require_explicit_policy or inhibit_policy_mapping")
+		}
+		if skipCerts := policyConstraints.RequireExplicitPolicy; skipCerts != nil && *skipCerts < 0 {
+			problems.add(append(constraintsPath, "require_explicit_policy"), "must not be negative, got %d", *skipCerts)
+		}
+		if skipCerts := policyConstraints.InhibitPolicyMapping; skipCerts != nil && *skipCerts < 0 {
+			problems.add(append(constraintsPath, "inhibit_policy_mapping"), "must not be negative, got %d", *skipCerts)
+		}
+	}
+	if skipCerts := caConfig.InhibitAnyPolicy; skipCerts != nil && *skipCerts < 0 {
+		problems.add(append(path, "inhibit_any_policy"), "must not be negative, got %d", *skipCerts)
+	}
+	problems.validateStaticExtensions(append(path, "static_extensions"), caConfig.StaticExtensions)
+}
+
+func (problems *problemsType) validateCertificatePolicies(path []string, policies []types.CertificatePolicyType) {
+	policyOids := map[string]bool{}
+	for i, policy := range policies {
+		policyPath := append(path, fmt.Sprint(i))
+		if policyOid, err := types.ParseOid(policy.Oid); err != nil {
+			problems.add(append(policyPath, "oid"), "%v, use a dotted OID such as 1.3.6.1.4.1.99999.1", err)
+		} else if policyOids[policyOid.String()] {
+			problems.add(append(policyPath, "oid"), "%s is already listed", policyOid.String())
+		} else {
+			policyOids[policyOid.String()] = true
+		}
+		for j, cpsUri := range policy.CpsUris {
+			problems.checkUrl(append(policyPath, "cps_uris", fmt.Sprint(j)), &cpsUri, true)
+		}
+		for j, userNotice := range policy.UserNotices {
+			noticePath := append(policyPath, "user_notices", fmt.Sprint(j))
+			if userNotice.Organization == "" && userNotice.ExplicitText == "" {
+				problems.add(noticePath, "set organization or explicit_text")
+			}
+			if userNotice.Organization == "" && len(userNotice.NoticeNumbers) > 0 {
+				problems.add(append(noticePath, "notice_numbers"), "need an organization")
+			}
+			for k, noticeNumber := range userNotice.NoticeNumbers {
+				if noticeNumber < 0 {
+					problems.add(append(noticePath, "notice_numbers", fmt.Sprint(k)), "must not be negative, got %d", noticeNumber)
+				}
+			}
+			if length := len([]rune(userNotice.ExplicitText)); length > 200 {
+				problems.add(append(noticePath, "explicit_text"), "must be at most 200 characters, got %d", length)
+			}
+		}
+	}
+}
+
+func (problems *problemsType) validateStaticExtensions(path []string, staticExtensions []types.StaticExtensionType) {
+	oids := map[string]bool{}
+	for i, staticExtension := range staticExtensions {
+		extension, err := staticExtension.Extension()
+		if err != nil {
+			problems.add(append(path, fmt.Sprint(i)), "%v", err)
+			continue
+		}
+		if oids[extension.Id.String()] {
+			problems.add(append(path, fmt.Sprint(i), "oid"), "%s is already listed", extension.Id.String())
+		}
+		oids[extension.Id.String()] = true
+	}
+}
+
+func (problems *problemsType) validateCrossSigning(
+	path []string,
+	caId string,
+	caConfig types.CertificateAuthorityType,
+	allCaConfigs map[string]types.CertificateAuthorityType,
+) {
+	if len(caConfig.CrossSigning) == 0 {
+		return
+	}
+	if caConfig.Kind == types.CaKindSsh {
+		problems.add(append(path, "cross_signing"), "not supported by a CA of kind ssh")
+		return
+	}
+	issuers := map[string]bool{}
+	for i, crossSigning := range caConfig.CrossSigning {
+		crossSigningPath := append(path, "cross_signing", fmt.Sprint(i))
+		issuerConfig, found := allCaConfigs[crossSigning.Issuer]
+		switch {
+		case crossSigning.Issuer == caId:
+			problems.add(append(crossSigningPath, "issuer"), "a CA cannot cross-sign itself")
+		case !found:
+			problems.add(append(crossSigningPath, "issuer"), "%v: %q", types.ErrInvalidCaId, crossSigning.Issuer)
+		case issuerConfig.Kind == types.CaKindSsh:
+			problems.add(append(crossSigningPath, "issuer"), "%q is a CA of kind ssh", crossSigning.Issuer)
+		case issuers[crossSigning.Issuer]:
+			problems.add(append(crossSigningPath, "issuer"), "%q already cross-signs this CA", crossSigning.Issuer)
+		}
+		issuers[crossSigning.Issuer] = true
+		validity := crossSigning.Validity
+		if validity.Years < 0 || validity.Months < 0 || validity.Days < 0 {
+			problems.add(append(crossSigningPath, "validity"), "must not be negative")
+		}
+	}
+}
+
+func (problems *problemsType) validateSshCa(path []string, caConfig types.CertificateAuthorityType) {
+	if len(caConfig.Profiles) > 0 {
+		problems.add(append(path, "profiles"), "not supported by a CA of kind ssh")
+	}
+	if caConfig.Tsa != nil {
+		problems.add(append(path, "tsa"), "not supported by a CA of kind ssh")
+	}
+	if caConfig.TransparencyLog != nil {
+		problems.add(append(path, "transparency_log"), "not supported by a CA of kind ssh")
+	}
+	if len(caConfig.CertificatePolicies) > 0 || len(caConfig.PolicyMappings) > 0 || caConfig.PolicyConstraints != nil ||
+		caConfig.InhibitAnyPolicy != nil || len(caConfig.StaticExtensions) > 0 {
+		problems.add(path, "certificate policies and static extensions are not supported by a CA of kind ssh")
+	}
+	if caConfig.Ssh == nil {
+		return
+	}
+	sshPath := append(path, "ssh")
+	switch caConfig.Ssh.CertType {
+	case "", types.SshCertTypeUser, types.SshCertTypeHost:
+	default:
+		problems.add(append(sshPath, "cert_type"), "%v: %q, use user or host", types.ErrInvalidSshCertType, caConfig.Ssh.CertType)
+	}
+	problems.checkDuration(append(sshPath, "max_validity"), caConfig.Ssh.MaxValidity)
+}
+
+func (problems *problemsType) validateSubjectPolicy(path []string, subjectPolicy *types.SubjectPolicyType) {
+	if subjectPolicy == nil {
+		return
+	}
+	if template := subjectPolicy.Template; template != nil {
+		templatePath := append(path, "template")
+		checkTemplate := func(attributePath []string, text string) {
+			if _, err := types.ParseSubjectTemplate(text); err != nil {
+				problems.add(attributePath, "invalid template: %v", err)
+			}
+		}
+		checkTemplate(append(templatePath, "common_name"), template.CommonName)
+		for _, attribute := range []struct {
+			name   string
+			values []string
+		}{
+			{"country", template.Country},
+			{"organization", template.Organization},
+			{"organizational_unit", template.OrganizationalUnit},
+			{"locality", template.Locality},
+			{"province", template.Province},
+			{"street_address", template.StreetAddress},
+			{"postal_code", template.PostalCode},
+		} {
+			for i, value := range attribute.values {
+				checkTemplate(append(templatePath, attribute.name, fmt.Sprint(i)), value)
+			}
+		}
+	}
+	checkAttributes := func(key string, attributes []string, fromCa bool) {
+		for i, attribute := range attributes {
+			switch {
+			case !slices.Contains(types.SubjectAttributes, attribute):
+				problems.add(append(path, key, fmt.Sprint(i)), "unknown attribute %q, use one of %s", attribute, strings.Join(types.SubjectAttributes, ", "))
+			case fromCa && (attribute == "common_name" || attribute == "serial_number"):
+				problems.add(append(path, key, fmt.Sprint(i)), "%s cannot be taken from the CA", attribute)
+			}
+		}
+	}
+	checkAttributes("allowed_attributes", subjectPolicy.AllowedAttributes, false)
+	checkAttributes("default_from_ca", subjectPolicy.DefaultFromCa, true)
+	checkAttributes("force_from_ca", subjectPolicy.ForceFromCa, true)
+}
+
+func (problems *problemsType) validateOpaClient(path []string, opaClient *types.OpaClientType) {
+	if opaClient == nil {
+		return
+	}
+	problems.checkDuration(append(path, "timeout"), opaClient.Timeout)
+	if opaClient.Retries != nil && *opaClient.Retries < 0 {
+		problems.add(append(path, "retries"), "must not be negative, got %d", *opaClient.Retries)
+	}
+	problems.checkDuration(append(path, "retry_backoff"), opaClient.RetryBackoff)
+	if opaClient.CircuitBreakerThreshold < 0 {
+		problems.add(append(path, "circuit_breaker_threshold"), "must not be negative, got %d", opaClient.CircuitBreakerThreshold)
+	}
+	problems.checkDuration(append(path, "circuit_breaker_cooldown"), opaClient.CircuitBreakerCooldown)
+	problems.checkDuration(append(path, "cache_ttl"), opaClient.CacheTtl)
+}
+
+func (problems *problemsType) validateRateLimit(path []string, rateLimit *types.RateLimitType) {
+	if rateLimit == nil {
+		return
+	}
+	if rateLimit.Requests < 0 {
+		problems.add(append(path, "requests"), "must not be negative, got %d", rateLimit.Requests)
+	}
+	if rateLimit.Burst < 0 {
+		problems.add(append(path, "burst"), "must not be negative, got %d", rateLimit.Burst)
+	}
+	if rateLimit.Requests > 0 && rateLimit.Per <= 0 {
+		problems.add(append(path, "per"), "must be positive, got %s", rateLimit.Per)
+	}
+}
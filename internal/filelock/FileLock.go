@@ -0,0 +1,135 @@
+package filelock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	ErrLockTimeout  = errors.New("timeout acquiring lock")
+	ErrNotSupported = errors.New("file locking not supported")
+)
+
+const lockPollInterval = 20 * time.Millisecond
+
+// HolderType is written into the lock file by the process holding the lock
+// and cleared on unlock, so a lock acquired while it is still set was left
+// by a process that died while holding it.
+type HolderType struct {
+	Pid      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Since    time.Time `json:"since"`
+}
+
+func (holder HolderType) String() string {
+	return fmt.Sprintf("pid %d on %s since %s", holder.Pid, holder.Hostname, holder.Since.Format(time.RFC3339))
+}
+
+type FileLockType struct {
+	file *os.File
+}
+
+// Lock acquires an exclusive lock on filename, waiting up to timeout. When
+// the previous holder did not release the lock cleanly its details are
+// returned as staleHolder.
+func Lock(
+	ctx context.Context,
+	filename string,
+	timeout time.Duration,
+) (*FileLockType, *HolderType, error) {
+	lockFile, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, os.FileMode(0o600))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		acquired, err := tryLockFile(lockFile)
+		if err != nil {
+			lockFile.Close()
+			return nil, nil, err
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			holder, _ := readHolder(lockFile)
+			lockFile.Close()
+			if holder != nil {
+				return nil, nil, fmt.Errorf("%w %s: held by %s", ErrLockTimeout, filename, holder)
+			}
+			return nil, nil, fmt.Errorf("%w %s", ErrLockTimeout, filename)
+		}
+		select {
+		case <-ctx.Done():
+			lockFile.Close()
+			return nil, nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	staleHolder, err := readHolder(lockFile)
+	if err != nil {
+		unlockFile(lockFile)
+		lockFile.Close()
+		return nil, nil, err
+	}
+
+	thisHostname, _ := os.Hostname()
+	holderBytes, err := json.Marshal(HolderType{
+		Pid:      os.Getpid(),
+		Hostname: thisHostname,
+		Since:    time.Now().UTC(),
+	})
+	if err == nil {
+		err = writeHolder(lockFile, holderBytes)
+	}
+	if err != nil {
+		unlockFile(lockFile)
+		lockFile.Close()
+		return nil, nil, err
+	}
+
+	return &FileLockType{file: lockFile}, staleHolder, nil
+}
+
+func (fileLock *FileLockType) Unlock() error {
+	truncateErr := writeHolder(fileLock.file, nil)
+	unlockErr := unlockFile(fileLock.file)
+	closeErr := fileLock.file.Close()
+	return errors.Join(truncateErr, unlockErr, closeErr)
+}
+
+func readHolder(lockFile *os.File) (*HolderType, error) {
+	if _, err := lockFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	holderBytes, err := io.ReadAll(lockFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(holderBytes) == 0 {
+		return nil, nil
+	}
+	var holder HolderType
+	if err := json.Unmarshal(holderBytes, &holder); err != nil {
+		// A holder killed while writing its details is still a stale holder.
+		return &HolderType{}, nil
+	}
+	return &holder, nil
+}
+
+func writeHolder(lockFile *os.File, holderBytes []byte) error {
+	if err := lockFile.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := lockFile.WriteAt(holderBytes, 0); err != nil {
+		return err
+	}
+	return lockFile.Sync()
+}
@@ -0,0 +1,59 @@
+//go:build unix
+
+package filelock_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/filelock"
+)
+
+func TestLockTimeoutReportsHolder(t *testing.T) {
+	lockFilename := filepath.Join(t.TempDir(), "test.lock")
+
+	firstLock, staleHolder, err := filelock.Lock(context.Background(), lockFilename, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staleHolder != nil {
+		t.Fatalf("unexpected stale holder %s", staleHolder)
+	}
+
+	if _, _, err := filelock.Lock(context.Background(), lockFilename, 100*time.Millisecond); !errors.Is(err, filelock.ErrLockTimeout) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	if err := firstLock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	secondLock, staleHolder, err := filelock.Lock(context.Background(), lockFilename, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondLock.Unlock()
+	if staleHolder != nil {
+		t.Fatalf("clean unlock reported as stale holder %s", staleHolder)
+	}
+}
+
+func TestLockDetectsStaleHolder(t *testing.T) {
+	lockFilename := filepath.Join(t.TempDir(), "test.lock")
+	// A process killed while holding the lock leaves its details behind.
+	if err := os.WriteFile(lockFilename, []byte(`{"pid":4242,"hostname":"other","since":"2024-05-01T00:00:00Z"}`), os.FileMode(0o600)); err != nil {
+		t.Fatal(err)
+	}
+
+	fileLock, staleHolder, err := filelock.Lock(context.Background(), lockFilename, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileLock.Unlock()
+	if staleHolder == nil || staleHolder.Pid != 4242 || staleHolder.Hostname != "other" {
+		t.Fatalf("stale holder not detected: %v", staleHolder)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !unix && !windows
+
+package filelock
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Cross-process locking is implemented with flock and LockFileEx, elsewhere
+// Lock fails rather than letting two processes hold the lock.
+func tryLockFile(lockFile *os.File) (bool, error) {
+	return false, fmt.Errorf("%w on %s", ErrNotSupported, runtime.GOOS)
+}
+
+func unlockFile(lockFile *os.File) error {
+	return fmt.Errorf("%w on %s", ErrNotSupported, runtime.GOOS)
+}
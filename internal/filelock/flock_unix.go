@@ -0,0 +1,23 @@
+//go:build unix
+
+package filelock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func tryLockFile(lockFile *os.File) (bool, error) {
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func unlockFile(lockFile *os.File) error {
+	return syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+}
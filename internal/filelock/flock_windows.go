@@ -0,0 +1,39 @@
+//go:build windows
+
+package filelock
+
+import (
+	"errors"
+	"math"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockRange is a byte far beyond the holder details, LockFileEx locks are
+// mandatory and the next process must still be able to read who holds it.
+func lockRange() *windows.Overlapped {
+	return &windows.Overlapped{Offset: math.MaxUint32, OffsetHigh: math.MaxInt32}
+}
+
+func tryLockFile(lockFile *os.File) (bool, error) {
+	err := windows.LockFileEx(
+		windows.Handle(lockFile.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0,
+		1,
+		0,
+		lockRange(),
+	)
+	if err != nil {
+		if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func unlockFile(lockFile *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(lockFile.Fd()), 0, 1, 0, lockRange())
+}
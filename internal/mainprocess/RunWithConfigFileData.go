@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"time"
 
 	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
 	"github.com/tomaluca95/simple-ca/internal/types"
@@ -18,7 +20,9 @@ func RunWithConfigFileData(
 	if err := os.MkdirAll(configFile.DataDirectory, os.FileMode(0o711)); err != nil {
 		return err
 	}
+	ctx = types.ContextWithRequester(ctx, types.LocalRequester())
 	allErrors := []error{}
+	allCa := map[string]*caissuingprocess.OneCaType{}
 	for caId, caConfig := range configFile.AllCaConfigs {
 		oneCa, err := caissuingprocess.LoadOneCa(
 			ctx,
@@ -32,21 +36,235 @@ func RunWithConfigFileData(
 				fmt.Errorf("error in %s: %w", caId, err),
 			)
 		} else {
-			if err := oneCa.IssueAllCsrInQueue(); err != nil {
+			allCa[caId] = oneCa
+			if err := oneCa.IssueAllCsrInQueue(ctx); err != nil {
 				allErrors = append(allErrors,
 					fmt.Errorf("error in %s: %w", caId, err),
 				)
 			}
 
-			if err := oneCa.UpdateCrl(); err != nil {
+			if err := oneCa.UpdateCrl(ctx); err != nil {
 				allErrors = append(allErrors,
 					fmt.Errorf("error in %s: %w", caId, err),
 				)
 			}
 		}
 	}
+	if err := caissuingprocess.CrossSignAll(ctx, allCa); err != nil {
+		allErrors = append(allErrors, err)
+	}
 	if len(allErrors) > 0 {
 		return errors.Join(allErrors...)
 	}
 	return nil
 }
+
+func VerifyAuditLogs(
+	ctx context.Context,
+	logger types.Logger,
+	configFile types.ConfigFileType,
+) error {
+	allErrors := []error{}
+	for caId, caConfig := range configFile.AllCaConfigs {
+		count, head, err := caissuingprocess.VerifyAuditLog(logger, configFile.DataDirectory, caId, caConfig)
+		if err != nil {
+			allErrors = append(allErrors,
+				fmt.Errorf("error in %s: %w", caId, err),
+			)
+			continue
+		}
+		logger.Info("Audit log verified", "ca_id", caId, "entries", count, "head", head)
+	}
+	if len(allErrors) > 0 {
+		return errors.Join(allErrors...)
+	}
+	return nil
+}
+
+func loadConfiguredCa(
+	ctx context.Context,
+	logger types.Logger,
+	configFile types.ConfigFileType,
+	caId string,
+) (*caissuingprocess.OneCaType, error) {
+	caConfig, found := configFile.AllCaConfigs[caId]
+	if !found {
+		return nil, fmt.Errorf("%w %#v", types.ErrInvalidCaId, caId)
+	}
+	return caissuingprocess.LoadOneCa(
+		ctx,
+		logger,
+		caId,
+		configFile.DataDirectory,
+		caConfig,
+	)
+}
+
+func PrintHistory(
+	ctx context.Context,
+	logger types.Logger,
+	configFile types.ConfigFileType,
+	caId string,
+	w io.Writer,
+) error {
+	ctx = types.ContextWithRequester(ctx, types.LocalRequester())
+	oneCa, err := loadConfiguredCa(ctx, logger, configFile, caId)
+	if err != nil {
+		return err
+	}
+	historyEntries, err := oneCa.History()
+	if err != nil {
+		return err
+	}
+	for _, historyEntry := range historyEntries {
+		if _, err := fmt.Fprintf(
+			w,
+			"%s %s %s %s\n",
+			historyEntry.Hash,
+			historyEntry.When.Format(time.RFC3339),
+			historyEntry.Author,
+			historyEntry.Message,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func RestoreCa(
+	ctx context.Context,
+	logger types.Logger,
+	configFile types.ConfigFileType,
+	caId string,
+	revision string,
+) error {
+	ctx = types.ContextWithRequester(ctx, types.LocalRequester())
+	oneCa, err := loadConfiguredCa(ctx, logger, configFile, caId)
+	if err != nil {
+		return err
+	}
+	return oneCa.RestoreTo(ctx, revision)
+}
+
+func PrintSignRequests(
+	ctx context.Context,
+	logger types.Logger,
+	configFile types.ConfigFileType,
+	caId string,
+	w io.Writer,
+) error {
+	ctx = types.ContextWithRequester(ctx, types.LocalRequester())
+	oneCa, err := loadConfiguredCa(ctx, logger, configFile, caId)
+	if err != nil {
+		return err
+	}
+	signRequests, err := oneCa.ListSignRequests(caissuingprocess.SignRequestPending)
+	if err != nil {
+		return err
+	}
+	for _, signRequest := range signRequests {
+		if _, err := fmt.Fprintf(
+			w,
+			"%s %s %s %q %s\n",
+			signRequest.Id,
+			signRequest.RequestedAt.Format(time.RFC3339),
+			signRequest.RequestedBy.Identity,
+			signRequest.Subject,
+			signRequest.Profile,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ApproveSignRequest(
+	ctx context.Context,
+	logger types.Logger,
+	configFile types.ConfigFileType,
+	caId string,
+	id string,
+) error {
+	ctx = types.ContextWithRequester(ctx, types.LocalRequester())
+	oneCa, err := loadConfiguredCa(ctx, logger, configFile, caId)
+	if err != nil {
+		return err
+	}
+	signRequest, err := oneCa.ApproveSignRequest(ctx, id)
+	if err != nil {
+		return err
+	}
+	logger.Info("Sign request approved", "ca_id", caId, "sign_request_id", id, "serial", signRequest.Serial)
+	return nil
+}
+
+func RejectSignRequest(
+	ctx context.Context,
+	logger types.Logger,
+	configFile types.ConfigFileType,
+	caId string,
+	id string,
+	reason string,
+) error {
+	ctx = types.ContextWithRequester(ctx, types.LocalRequester())
+	oneCa, err := loadConfiguredCa(ctx, logger, configFile, caId)
+	if err != nil {
+		return err
+	}
+	if _, err := oneCa.RejectSignRequest(ctx, id, reason); err != nil {
+		return err
+	}
+	logger.Info("Sign request rejected", "ca_id", caId, "sign_request_id", id)
+	return nil
+}
+
+// WatchCsrSpool processes the CSR spool of every x509 CA each interval until
+// ctx is done. A CSR is only picked up once it was not modified for a whole
+// interval.
+func WatchCsrSpool(
+	ctx context.Context,
+	logger types.Logger,
+	configFile types.ConfigFileType,
+	interval time.Duration,
+) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid spool watch interval %s", interval)
+	}
+	if err := os.MkdirAll(configFile.DataDirectory, os.FileMode(0o711)); err != nil {
+		return err
+	}
+	ctx = types.ContextWithRequester(ctx, types.LocalRequester())
+	allCa := map[string]*caissuingprocess.OneCaType{}
+	for caId, caConfig := range configFile.AllCaConfigs {
+		if caConfig.Kind == types.CaKindSsh {
+			continue
+		}
+		oneCa, err := caissuingprocess.LoadOneCa(
+			ctx,
+			logger,
+			caId,
+			configFile.DataDirectory,
+			caConfig,
+		)
+		if err != nil {
+			return fmt.Errorf("error in %s: %w", caId, err)
+		}
+		allCa[caId] = oneCa
+	}
+	logger.Info("Watching CSR spool", "interval", interval.String(), "ca_count", len(allCa))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for caId, oneCa := range allCa {
+			if _, err := oneCa.ProcessCsrSpool(ctx, interval); err != nil {
+				logger.Error("Failed reading CSR spool", "ca_id", caId, "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ConsistencyProof proves that the tree of the first leaves of leafHashes is
+// a prefix of the tree made of all of them.
+func ConsistencyProof(first uint64, leafHashes [][]byte) ([][]byte, error) {
+	if first == 0 || first > uint64(len(leafHashes)) {
+		return nil, fmt.Errorf("%w: %d then %d", ErrInvalidTreeSize, first, len(leafHashes))
+	}
+	return consistencySubproof(int(first), leafHashes, true), nil
+}
+
+func consistencySubproof(m int, leafHashes [][]byte, complete bool) [][]byte {
+	n := len(leafHashes)
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{RootHash(leafHashes)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(consistencySubproof(m, leafHashes[:k], complete), RootHash(leafHashes[k:]))
+	}
+	return append(consistencySubproof(m-k, leafHashes[k:], false), RootHash(leafHashes[:k]))
+}
+
+// VerifyConsistency checks that the tree of first leaves with firstRootHash
+// is a prefix of the tree of second leaves with secondRootHash, as described
+// in RFC 9162 section 2.1.4.2.
+func VerifyConsistency(first uint64, second uint64, firstRootHash []byte, secondRootHash []byte, proof [][]byte) error {
+	if first == 0 || first > second {
+		return fmt.Errorf("%w: %d then %d", ErrInvalidTreeSize, first, second)
+	}
+	if first == second {
+		if len(proof) != 0 {
+			return fmt.Errorf("%w: not empty for trees of the same size", ErrInvalidProof)
+		}
+		if !bytes.Equal(firstRootHash, secondRootHash) {
+			return fmt.Errorf("%w: root hash mismatch", ErrInvalidProof)
+		}
+		return nil
+	}
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRootHash}, proof...)
+	}
+	if len(proof) == 0 {
+		return fmt.Errorf("%w: empty", ErrInvalidProof)
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	firstHash, secondHash := proof[0], proof[0]
+	for _, sibling := range proof[1:] {
+		if sn == 0 {
+			return fmt.Errorf("%w: too long", ErrInvalidProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			firstHash = nodeHash(sibling, firstHash)
+			secondHash = nodeHash(sibling, secondHash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			secondHash = nodeHash(secondHash, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("%w: too short", ErrInvalidProof)
+	}
+	if !bytes.Equal(firstHash, firstRootHash) || !bytes.Equal(secondHash, secondRootHash) {
+		return fmt.Errorf("%w: root hash mismatch", ErrInvalidProof)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// InclusionProof is the audit path of the leaf at index in the tree made of
+// leafHashes.
+func InclusionProof(index uint64, leafHashes [][]byte) ([][]byte, error) {
+	if index >= uint64(len(leafHashes)) {
+		return nil, fmt.Errorf("%w: leaf %d of a tree of %d", ErrInvalidTreeSize, index, len(leafHashes))
+	}
+	return inclusionPath(int(index), leafHashes), nil
+}
+
+func inclusionPath(m int, leafHashes [][]byte) [][]byte {
+	if len(leafHashes) <= 1 {
+		return [][]byte{}
+	}
+	k := splitPoint(len(leafHashes))
+	if m < k {
+		return append(inclusionPath(m, leafHashes[:k]), RootHash(leafHashes[k:]))
+	}
+	return append(inclusionPath(m-k, leafHashes[k:]), RootHash(leafHashes[:k]))
+}
+
+// VerifyInclusion checks that the leaf at index is part of the tree of
+// treeSize leaves with rootHash, as described in RFC 9162 section 2.1.3.2.
+func VerifyInclusion(index uint64, treeSize uint64, leafHash []byte, proof [][]byte, rootHash []byte) error {
+	if index >= treeSize {
+		return fmt.Errorf("%w: leaf %d of a tree of %d", ErrInvalidTreeSize, index, treeSize)
+	}
+	fn, sn := index, treeSize-1
+	hash := leafHash
+	for _, sibling := range proof {
+		if sn == 0 {
+			return fmt.Errorf("%w: too long", ErrInvalidProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			hash = nodeHash(sibling, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = nodeHash(hash, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("%w: too short", ErrInvalidProof)
+	}
+	if !bytes.Equal(hash, rootHash) {
+		return fmt.Errorf("%w: root hash mismatch", ErrInvalidProof)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package merkletree_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/merkletree"
+)
+
+func testLeafHashes(n int) [][]byte {
+	leafHashes := [][]byte{}
+	for i := range n {
+		leafHashes = append(leafHashes, merkletree.LeafHash([]byte(fmt.Sprintf("leaf %d", i))))
+	}
+	return leafHashes
+}
+
+func TestRootHash(t *testing.T) {
+	// reference values of RFC 6962 for the empty tree and the empty leaf
+	if root := hex.EncodeToString(merkletree.RootHash(nil)); root != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Fatalf("unexpected empty root %s", root)
+	}
+	if leaf := hex.EncodeToString(merkletree.LeafHash(nil)); leaf != "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d" {
+		t.Fatalf("unexpected empty leaf hash %s", leaf)
+	}
+}
+
+func TestInclusionProof(t *testing.T) {
+	for n := 1; n <= 33; n++ {
+		leafHashes := testLeafHashes(n)
+		rootHash := merkletree.RootHash(leafHashes)
+		for index := range n {
+			proof, err := merkletree.InclusionProof(uint64(index), leafHashes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := merkletree.VerifyInclusion(uint64(index), uint64(n), leafHashes[index], proof, rootHash); err != nil {
+				t.Fatalf("leaf %d of %d: %v", index, n, err)
+			}
+			otherLeaf := leafHashes[(index+1)%n]
+			if n > 1 {
+				if err := merkletree.VerifyInclusion(uint64(index), uint64(n), otherLeaf, proof, rootHash); !errors.Is(err, merkletree.ErrInvalidProof) {
+					t.Fatalf("leaf %d of %d: expected ErrInvalidProof, got %v", index, n, err)
+				}
+			}
+		}
+		if _, err := merkletree.InclusionProof(uint64(n), leafHashes); !errors.Is(err, merkletree.ErrInvalidTreeSize) {
+			t.Fatalf("expected ErrInvalidTreeSize, got %v", err)
+		}
+	}
+}
+
+func TestConsistencyProof(t *testing.T) {
+	allLeafHashes := testLeafHashes(33)
+	for second := 1; second <= len(allLeafHashes); second++ {
+		secondRootHash := merkletree.RootHash(allLeafHashes[:second])
+		for first := 1; first <= second; first++ {
+			firstRootHash := merkletree.RootHash(allLeafHashes[:first])
+			proof, err := merkletree.ConsistencyProof(uint64(first), allLeafHashes[:second])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := merkletree.VerifyConsistency(uint64(first), uint64(second), firstRootHash, secondRootHash, proof); err != nil {
+				t.Fatalf("%d then %d: %v", first, second, err)
+			}
+			if first < second {
+				otherRootHash := merkletree.RootHash(testLeafHashes(first + 1)[1:])
+				if err := merkletree.VerifyConsistency(uint64(first), uint64(second), otherRootHash, secondRootHash, proof); !errors.Is(err, merkletree.ErrInvalidProof) {
+					t.Fatalf("%d then %d: expected ErrInvalidProof, got %v", first, second, err)
+				}
+			}
+		}
+	}
+	if _, err := merkletree.ConsistencyProof(0, allLeafHashes); !errors.Is(err, merkletree.ErrInvalidTreeSize) {
+		t.Fatalf("expected ErrInvalidTreeSize, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"math/bits"
+)
+
+// The prefixes of RFC 6962 that make a leaf hash different from any node
+// hash.
+const (
+	leafHashPrefix = 0x00
+	nodeHashPrefix = 0x01
+)
+
+// LeafHash is the RFC 6962 hash of one entry of the log.
+func LeafHash(leafInput []byte) []byte {
+	hash := sha256.Sum256(append([]byte{leafHashPrefix}, leafInput...))
+	return hash[:]
+}
+
+func nodeHash(left []byte, right []byte) []byte {
+	content := make([]byte, 0, 1+len(left)+len(right))
+	content = append(content, nodeHashPrefix)
+	content = append(content, left...)
+	content = append(content, right...)
+	hash := sha256.Sum256(content)
+	return hash[:]
+}
+
+// RootHash is the Merkle tree hash of the leaves, given by their LeafHash.
+func RootHash(leafHashes [][]byte) []byte {
+	switch len(leafHashes) {
+	case 0:
+		hash := sha256.Sum256(nil)
+		return hash[:]
+	case 1:
+		return leafHashes[0]
+	default:
+		k := splitPoint(len(leafHashes))
+		return nodeHash(RootHash(leafHashes[:k]), RootHash(leafHashes[k:]))
+	}
+}
+
+// splitPoint is the largest power of two smaller than n, n > 1.
+func splitPoint(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
@@ -0,0 +1,6 @@
+package merkletree
+
+import "fmt"
+
+var ErrInvalidTreeSize = fmt.Errorf("invalid tree size")
+var ErrInvalidProof = fmt.Errorf("invalid proof")
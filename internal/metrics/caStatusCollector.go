@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CaStatusType is read from every CA at each scrape, so the gauges never
+// report a stale value.
+type CaStatusType struct {
+	CertificateNotAfter time.Time
+	CrlNextUpdate       time.Time
+	RevokedEntries      int
+	CsrQueueDepth       int
+	PendingSignRequests int
+}
+
+type caStatusCollectorType struct {
+	mu      sync.Mutex
+	sources map[string]func() (CaStatusType, error)
+
+	certificateNotAfter *prometheus.Desc
+	crlNextUpdate       *prometheus.Desc
+	revokedEntries      *prometheus.Desc
+	csrQueueDepth       *prometheus.Desc
+	pendingSignRequests *prometheus.Desc
+	statusErrors        *prometheus.Desc
+}
+
+var caStatusCollector = &caStatusCollectorType{
+	sources: map[string]func() (CaStatusType, error){},
+
+	certificateNotAfter: prometheus.NewDesc(
+		"simple_ca_certificate_not_after_timestamp_seconds",
+		"Expiry of the CA certificate.",
+		[]string{"ca_id"}, nil,
+	),
+	crlNextUpdate: prometheus.NewDesc(
+		"simple_ca_crl_next_update_timestamp_seconds",
+		"NextUpdate of the published CRL.",
+		[]string{"ca_id"}, nil,
+	),
+	revokedEntries: prometheus.NewDesc(
+		"simple_ca_crl_revoked_entries",
+		"Entries of the published CRL.",
+		[]string{"ca_id"}, nil,
+	),
+	csrQueueDepth: prometheus.NewDesc(
+		"simple_ca_csr_queue_depth",
+		"CSRs waiting in the spool directory.",
+		[]string{"ca_id"}, nil,
+	),
+	pendingSignRequests: prometheus.NewDesc(
+		"simple_ca_pending_sign_requests",
+		"Sign requests waiting for approval.",
+		[]string{"ca_id"}, nil,
+	),
+	statusErrors: prometheus.NewDesc(
+		"simple_ca_status_error",
+		"1 when the status of the CA could not be read.",
+		[]string{"ca_id"}, nil,
+	),
+}
+
+// SetCaStatusSource registers the function reading the status of caId,
+// replacing the previous one.
+func SetCaStatusSource(caId string, source func() (CaStatusType, error)) {
+	caStatusCollector.mu.Lock()
+	defer caStatusCollector.mu.Unlock()
+	caStatusCollector.sources[caId] = source
+}
+
+func RemoveCaStatusSource(caId string) {
+	caStatusCollector.mu.Lock()
+	defer caStatusCollector.mu.Unlock()
+	delete(caStatusCollector.sources, caId)
+}
+
+func (collector *caStatusCollectorType) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.certificateNotAfter
+	ch <- collector.crlNextUpdate
+	ch <- collector.revokedEntries
+	ch <- collector.csrQueueDepth
+	ch <- collector.pendingSignRequests
+	ch <- collector.statusErrors
+}
+
+func (collector *caStatusCollectorType) Collect(ch chan<- prometheus.Metric) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for caId, source := range collector.sources {
+		status, err := source()
+		if err != nil {
+			ch <- prometheus.MustNewConstMetric(collector.statusErrors, prometheus.GaugeValue, 1, caId)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(collector.statusErrors, prometheus.GaugeValue, 0, caId)
+		// A CA of kind ssh has neither a certificate nor a CRL.
+		if !status.CertificateNotAfter.IsZero() {
+			ch <- prometheus.MustNewConstMetric(collector.certificateNotAfter, prometheus.GaugeValue, float64(status.CertificateNotAfter.Unix()), caId)
+		}
+		if !status.CrlNextUpdate.IsZero() {
+			ch <- prometheus.MustNewConstMetric(collector.crlNextUpdate, prometheus.GaugeValue, float64(status.CrlNextUpdate.Unix()), caId)
+		}
+		ch <- prometheus.MustNewConstMetric(collector.revokedEntries, prometheus.GaugeValue, float64(status.RevokedEntries), caId)
+		ch <- prometheus.MustNewConstMetric(collector.csrQueueDepth, prometheus.GaugeValue, float64(status.CsrQueueDepth), caId)
+		ch <- prometheus.MustNewConstMetric(collector.pendingSignRequests, prometheus.GaugeValue, float64(status.PendingSignRequests), caId)
+	}
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var Registry = prometheus.NewRegistry()
+
+var OpaDecisionDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "simple_ca",
+		Subsystem: "opa",
+		Name:      "decision_duration_seconds",
+		Help:      "Latency of OPA decision requests, retries included.",
+		Buckets:   prometheus.DefBuckets,
+	},
+	[]string{"ca_id", "action"},
+)
+
+var OpaDecisionsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "simple_ca",
+		Subsystem: "opa",
+		Name:      "decisions_total",
+		Help:      "OPA decisions by outcome (allowed, denied, error, circuit_open).",
+	},
+	[]string{"ca_id", "action", "outcome"},
+)
+
+var OpaCacheHitsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "simple_ca",
+		Subsystem: "opa",
+		Name:      "cache_hits_total",
+		Help:      "OPA decisions served from the local decision cache.",
+	},
+	[]string{"ca_id", "action"},
+)
+
+var CertificatesIssuedTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "simple_ca",
+		Name:      "certificates_issued_total",
+		Help:      "Certificates issued.",
+	},
+	[]string{"ca_id"},
+)
+
+var TimestampsIssuedTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "simple_ca",
+		Name:      "timestamps_issued_total",
+		Help:      "RFC 3161 time-stamp tokens issued.",
+	},
+	[]string{"ca_id"},
+)
+
+var SignDeniedTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "simple_ca",
+		Name:      "sign_denied_total",
+		Help:      "Sign requests denied by OPA.",
+	},
+	[]string{"ca_id"},
+)
+
+var InvalidCsrTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "simple_ca",
+		Name:      "invalid_csr_total",
+		Help:      "CSRs refused because they could not be parsed or violate the CA constraints.",
+	},
+	[]string{"ca_id"},
+)
+
+var RevocationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "simple_ca",
+		Name:      "revocations_total",
+		Help:      "Certificates revoked.",
+	},
+	[]string{"ca_id"},
+)
+
+const (
+	ThrottledReasonCaRateLimit        = "ca_rate_limit"
+	ThrottledReasonRequesterRateLimit = "requester_rate_limit"
+	ThrottledReasonSanQuota           = "san_quota"
+)
+
+var ThrottledTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "simple_ca",
+		Name:      "throttled_total",
+		Help:      "Requests refused by a rate limit or quota (ca_rate_limit, requester_rate_limit, san_quota).",
+	},
+	[]string{"ca_id", "reason"},
+)
+
+var SignDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "simple_ca",
+		Name:      "sign_duration_seconds",
+		Help:      "Latency of signing one CSR, storage included.",
+		Buckets:   prometheus.DefBuckets,
+	},
+	[]string{"ca_id"},
+)
+
+var GitCommitDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "simple_ca",
+		Subsystem: "git",
+		Name:      "commit_duration_seconds",
+		Help:      "Latency of committing the CA data repository.",
+		Buckets:   prometheus.DefBuckets,
+	},
+	[]string{"ca_id"},
+)
+
+func init() {
+	Registry.MustRegister(
+		OpaDecisionDuration,
+		OpaDecisionsTotal,
+		OpaCacheHitsTotal,
+		CertificatesIssuedTotal,
+		TimestampsIssuedTotal,
+		SignDeniedTotal,
+		InvalidCsrTotal,
+		RevocationsTotal,
+		ThrottledTotal,
+		SignDuration,
+		GitCommitDuration,
+		caStatusCollector,
+	)
+}
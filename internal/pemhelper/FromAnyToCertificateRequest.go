@@ -0,0 +1,69 @@
+package pemhelper
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"slices"
+)
+
+// pemCertificateRequestTypes are the PEM types of a PKCS#10 request, NEW
+// CERTIFICATE REQUEST is still written by some Windows and Java tools.
+var pemCertificateRequestTypes = []string{"CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST"}
+
+// FromAnyToCertificateRequest parses a CSR sent as PEM, also surrounded by
+// text or other PEM blocks, as DER, or as the base64 of the DER.
+func FromAnyToCertificateRequest(rawData []byte) (*x509.CertificateRequest, error) {
+	derBytes, err := extractCertificateRequestBytes(rawData)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(derBytes)
+	if err != nil {
+		return nil, err
+	}
+	return csr, nil
+}
+
+func extractCertificateRequestBytes(rawData []byte) ([]byte, error) {
+	// a DER SEQUENCE, the PEM and base64 forms start with printable text
+	if len(rawData) > 0 && rawData[0] == 0x30 {
+		return rawData, nil
+	}
+	trimmedData := bytes.TrimSpace(rawData)
+	if len(trimmedData) == 0 {
+		return nil, ErrPemEmpty
+	}
+
+	if bytes.Contains(trimmedData, []byte("-----BEGIN ")) {
+		var found []byte
+		rest := trimmedData
+		for {
+			pemBlock, next := pem.Decode(rest)
+			if pemBlock == nil {
+				break
+			}
+			rest = next
+			if !slices.Contains(pemCertificateRequestTypes, pemBlock.Type) {
+				continue
+			}
+			if found != nil {
+				return nil, fmt.Errorf("%w: more than one CERTIFICATE REQUEST", ErrPemMultipleBlocks)
+			}
+			found = pemBlock.Bytes
+		}
+		if found == nil {
+			return nil, fmt.Errorf("%w: expected CERTIFICATE REQUEST", ErrPemInvalidTypeFound)
+		}
+		return found, nil
+	}
+
+	base64Data := bytes.Join(bytes.Fields(trimmedData), nil)
+	derBytes, err := base64.StdEncoding.DecodeString(string(base64Data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownFormat, err)
+	}
+	return derBytes, nil
+}
@@ -1,7 +1,10 @@
 package pemhelper_test
 
 import (
+	"encoding/base64"
+	"encoding/pem"
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/tomaluca95/simple-ca/internal/pemhelper"
@@ -266,3 +269,36 @@ func TestFromPemToCertificateRequestInvalidBlock(t *testing.T) {
 		t.Errorf("invalid result data")
 	}
 }
+
+func TestFromAnyToCertificateRequest(t *testing.T) {
+	pemBlock, _ := pem.Decode([]byte(pemBlockCertificateRequest))
+	for name, rawData := range map[string]string{
+		"pem":             pemBlockCertificateRequest,
+		"pem_with_text":   "Certificate request for www.example.com\r\n" + pemBlockCertificateRequest + "\n" + pemBlockCertificate,
+		"new_request_pem": strings.ReplaceAll(pemBlockCertificateRequest, "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST"),
+		"der":             string(pemBlock.Bytes),
+		"base64":          "\n" + base64.StdEncoding.EncodeToString(pemBlock.Bytes) + "\n",
+	} {
+		csr, err := pemhelper.FromAnyToCertificateRequest([]byte(rawData))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if csr.Subject.CommonName != "www.example.com" {
+			t.Fatalf("%s: invalid subject %s", name, csr.Subject)
+		}
+	}
+
+	for name, testCase := range map[string]struct {
+		rawData     string
+		expectedErr error
+	}{
+		"empty":       {"\n", pemhelper.ErrPemEmpty},
+		"certificate": {pemBlockCertificate, pemhelper.ErrPemInvalidTypeFound},
+		"two":         {pemBlockCertificateRequest + pemBlockCertificateRequest, pemhelper.ErrPemMultipleBlocks},
+		"garbage":     {"not a request", pemhelper.ErrUnknownFormat},
+	} {
+		if _, err := pemhelper.FromAnyToCertificateRequest([]byte(testCase.rawData)); !errors.Is(err, testCase.expectedErr) {
+			t.Fatalf("%s: expected %v, got %v", name, testCase.expectedErr, err)
+		}
+	}
+}
@@ -36,6 +36,13 @@ func ToPem(object interface{}) ([]byte, error) {
 		}), nil
 	case x509.Certificate:
 		return ToPem(&typedObject)
+	case *x509.CertificateRequest:
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE REQUEST",
+			Bytes: typedObject.Raw,
+		}), nil
+	case x509.CertificateRequest:
+		return ToPem(&typedObject)
 	default:
 		typeOf := reflect.TypeOf(typedObject)
 		return nil, fmt.Errorf("%w : %#v", ErrPemInvalidObject, typeOf)
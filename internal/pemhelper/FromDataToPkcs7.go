@@ -0,0 +1,62 @@
+package pemhelper
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+)
+
+var (
+	oidPkcs7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPkcs7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+type pkcs7ContentInfoType struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type pkcs7EncapsulatedContentInfoType struct {
+	ContentType asn1.ObjectIdentifier
+}
+
+type pkcs7SignedDataType struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo pkcs7EncapsulatedContentInfoType
+	Certificates     asn1.RawValue
+	SignerInfos      asn1.RawValue
+}
+
+// ToPkcs7CertsOnly encodes certificates as a degenerate PKCS#7 SignedData,
+// the .p7b bundle of Windows and Java tooling, in DER.
+func ToPkcs7CertsOnly(certificates ...*x509.Certificate) ([]byte, error) {
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	certificatesContent := []byte{}
+	for _, certificate := range certificates {
+		certificatesContent = append(certificatesContent, certificate.Raw...)
+	}
+	signedDataContent, err := asn1.Marshal(pkcs7SignedDataType{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		EncapContentInfo: pkcs7EncapsulatedContentInfoType{ContentType: oidPkcs7Data},
+		Certificates: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      certificatesContent,
+		},
+		SignerInfos: emptySet,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfoType{
+		ContentType: oidPkcs7SignedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      signedDataContent,
+		},
+	})
+}
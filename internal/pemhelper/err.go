@@ -6,3 +6,5 @@ var ErrPemEmpty = fmt.Errorf("no pem block")
 var ErrPemInvalidReminder = fmt.Errorf("invalid rest length")
 var ErrPemInvalidTypeFound = fmt.Errorf("invalid pem type found")
 var ErrPemInvalidObject = fmt.Errorf("invalid object type")
+var ErrPemMultipleBlocks = fmt.Errorf("ambiguous pem blocks")
+var ErrUnknownFormat = fmt.Errorf("unknown data format")
@@ -2,19 +2,44 @@ package types
 
 import (
 	"fmt"
+	"regexp"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// CaIdPattern is the syntax of the keys of all_ca_configs, they are used as
+// directory names.
+var CaIdPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
 type CertificateAuthorityType struct {
+	// Kind is x509 (default) or ssh, a CA of kind ssh only uses key_config
+	// and ssh of the certificate settings.
+	Kind string         `yaml:"kind"`
+	Ssh  *SshConfigType `yaml:"ssh"`
+	Tsa  *TsaConfigType `yaml:"tsa"`
+
+	TransparencyLog *TransparencyLogType `yaml:"transparency_log"`
+
 	Subject   CertificateAuthoritySubjectType
 	Validity  CertificateAuthorityValidityType
 	KeyConfig KeyConfigType `yaml:"key_config"`
 	CrlTtl    time.Duration `yaml:"crl_ttl"`
 
-	OpaUrlSign   *string `yaml:"opa_url_sign"`
-	OpaUrlRevoke *string `yaml:"opa_url_revoke"`
+	OpaUrlSign   *string        `yaml:"opa_url_sign"`
+	OpaUrlRevoke *string        `yaml:"opa_url_revoke"`
+	OpaClient    *OpaClientType `yaml:"opa_client"`
+
+	Git     *GitRepositoryType `yaml:"git"`
+	Storage *StorageConfigType `yaml:"storage"`
+	Limits  *LimitsType        `yaml:"limits"`
+
+	Profiles map[string]ProfileType `yaml:"profiles"`
+
+	CrossSigning []CrossSigningType `yaml:"cross_signing"`
+
+	LockTimeout   time.Duration `yaml:"lock_timeout"`
+	ExpiryWarning time.Duration `yaml:"expiry_warning"`
 
 	PermittedDNSDomainsCritical bool     `yaml:"permitted_dns_domains_critical"`
 	PermittedDNSDomains         []string `yaml:"permitted_dns_domains"`
@@ -25,6 +50,14 @@ type CertificateAuthorityType struct {
 	ExcludedEmailAddresses      []string `yaml:"excluded_email_addresses"`
 	PermittedURIDomains         []string `yaml:"permitted_uri_domains"`
 	ExcludedURIDomains          []string `yaml:"excluded_uri_domains"`
+
+	// The policies and the static extensions are written in the certificate
+	// of the CA when it is created.
+	CertificatePolicies []CertificatePolicyType `yaml:"certificate_policies"`
+	PolicyMappings      []PolicyMappingType     `yaml:"policy_mappings"`
+	PolicyConstraints   *PolicyConstraintsType  `yaml:"policy_constraints"`
+	InhibitAnyPolicy    *int                    `yaml:"inhibit_any_policy"`
+	StaticExtensions    []StaticExtensionType   `yaml:"static_extensions"`
 }
 
 type KeyConfigType struct {
@@ -32,26 +65,22 @@ type KeyConfigType struct {
 	Config any    `yaml:"config"`
 }
 
-func (e *KeyConfigType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+func (e *KeyConfigType) UnmarshalYAML(value *yaml.Node) error {
 	var internalNode struct {
 		Type   string    `yaml:"type"`
 		Config yaml.Node `yaml:"config"`
 	}
 
-	if err := unmarshal(&internalNode); err != nil {
+	if err := value.Decode(&internalNode); err != nil {
 		return err
 	}
 
 	e.Type = internalNode.Type
 
-	configYamlBytes, err := yaml.Marshal(internalNode.Config)
-	if err != nil {
-		return err
-	}
 	switch e.Type {
 	case "rsa":
 		var keyConfig KeyTypeRsaConfigType
-		if err := yaml.Unmarshal(configYamlBytes, &keyConfig); err != nil {
+		if err := internalNode.Config.Decode(&keyConfig); err != nil {
 			return err
 		}
 		e.Config = keyConfig
@@ -59,7 +88,7 @@ func (e *KeyConfigType) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return nil
 	case "ecdsa":
 		var keyConfig KeyTypeEcdsaConfigType
-		if err := yaml.Unmarshal(configYamlBytes, &keyConfig); err != nil {
+		if err := internalNode.Config.Decode(&keyConfig); err != nil {
 			return err
 		}
 		e.Config = keyConfig
@@ -67,7 +96,11 @@ func (e *KeyConfigType) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return nil
 
 	default:
-		return fmt.Errorf("%w: %s", ErrInvalidKeyType, e.Type)
+		// A type error lets the decoder go on and report the other problems
+		// of the file.
+		return &yaml.TypeError{Errors: []string{
+			fmt.Sprintf("line %d: %v: %q", value.Line, ErrInvalidKeyType, e.Type),
+		}}
 	}
 
 }
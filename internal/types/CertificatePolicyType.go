@@ -0,0 +1,34 @@
+package types
+
+// AnyPolicyOid is the special policy of RFC 5280 section 4.2.1.4.
+const AnyPolicyOid = "2.5.29.32.0"
+
+// CertificatePolicyType is one policy of the certificate policies
+// extension, with its qualifiers.
+type CertificatePolicyType struct {
+	Oid         string           `yaml:"oid"`
+	CpsUris     []string         `yaml:"cps_uris"`
+	UserNotices []UserNoticeType `yaml:"user_notices"`
+}
+
+// UserNoticeType is the text shown to relying parties for a policy, the
+// notice reference is written when Organization is set.
+type UserNoticeType struct {
+	Organization  string `yaml:"organization"`
+	NoticeNumbers []int  `yaml:"notice_numbers"`
+	ExplicitText  string `yaml:"explicit_text"`
+}
+
+// PolicyMappingType declares the policy of the issuer domain equivalent to
+// a policy of the subject domain, it is only meaningful in CA certificates.
+type PolicyMappingType struct {
+	IssuerDomainPolicy  string `yaml:"issuer_domain_policy"`
+	SubjectDomainPolicy string `yaml:"subject_domain_policy"`
+}
+
+// PolicyConstraintsType are the skip certs of the policy constraints
+// extension, nil ones are left out.
+type PolicyConstraintsType struct {
+	RequireExplicitPolicy *int `yaml:"require_explicit_policy"`
+	InhibitPolicyMapping  *int `yaml:"inhibit_policy_mapping"`
+}
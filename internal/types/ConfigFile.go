@@ -3,5 +3,13 @@ package types
 type ConfigFileType struct {
 	DataDirectory string                              `yaml:"data_directory"`
 	HttpServer    *HttpServerType                     `yaml:"http_server"`
+	Log           *LogConfigType                      `yaml:"log"`
 	AllCaConfigs  map[string]CertificateAuthorityType `yaml:"all_ca_configs"`
+
+	// Include lists globs of files adding more all_ca_configs, relative to
+	// the directory of the configuration file.
+	Include []string `yaml:"include"`
+	// Defaults are the settings of every CA that does not set them itself,
+	// they are already merged in AllCaConfigs once the file is loaded.
+	Defaults *CertificateAuthorityType `yaml:"defaults"`
 }
@@ -0,0 +1,16 @@
+package types
+
+// CrossSigningType declares that the CA of Issuer signs the certificate of
+// this CA, with the same subject and key, so that clients trusting only the
+// issuer also trust this CA.
+type CrossSigningType struct {
+	// Issuer is the ID of the x509 CA that signs the cross certificate.
+	Issuer string `yaml:"issuer"`
+	// Validity of the cross certificate, by default until the first of the
+	// two CA certificates expires. It is issued again when it gets close to
+	// its expiry.
+	Validity CertificateAuthorityValidityType `yaml:"validity"`
+	// IncludeInChain adds the cross certificate to the chains returned with
+	// the issued certificates.
+	IncludeInChain bool `yaml:"include_in_chain"`
+}
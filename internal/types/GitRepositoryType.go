@@ -0,0 +1,14 @@
+package types
+
+type GitRepositoryType struct {
+	AuthorName          *string `yaml:"author_name"`
+	AuthorEmail         *string `yaml:"author_email"`
+	AuthorFromRequester bool    `yaml:"author_from_requester"`
+
+	SigningKeyType       string  `yaml:"signing_key_type"`
+	SigningKeyFile       *string `yaml:"signing_key_file"`
+	SigningKeyPassphrase *string `yaml:"signing_key_passphrase"`
+
+	RemoteName *string `yaml:"remote_name"`
+	RemoteUrl  *string `yaml:"remote_url"`
+}
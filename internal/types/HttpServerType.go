@@ -1,6 +1,17 @@
 package types
 
+import "time"
+
 type HttpServerType struct {
 	ListenAddress string `yaml:"listen_address"`
 	ListenPort    uint16 `yaml:"listen_port"`
+
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+
+	OpaUrlAdmin *string        `yaml:"opa_url_admin"`
+	OpaClient   *OpaClientType `yaml:"opa_client"`
 }
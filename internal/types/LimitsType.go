@@ -0,0 +1,23 @@
+package types
+
+import "time"
+
+type LimitsType struct {
+	// Ca limits the requests to the whole CA, Requester the requests of each
+	// requester identity.
+	Ca        *RateLimitType `yaml:"ca"`
+	Requester *RateLimitType `yaml:"requester"`
+
+	// MaxActiveCertificatesPerSan refuses a CSR when one of its SANs is
+	// already in this many certificates neither expired nor revoked, 0
+	// disables the quota.
+	MaxActiveCertificatesPerSan int `yaml:"max_active_certificates_per_san"`
+}
+
+// RateLimitType allows Requests every Per, with bursts up to Burst requests
+// (default Requests).
+type RateLimitType struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+	Burst    int           `yaml:"burst"`
+}
@@ -0,0 +1,6 @@
+package types
+
+type LogConfigType struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
@@ -1,16 +1,118 @@
 package types
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
 )
 
+// Logger takes a message and alternating key/value pairs, like log/slog.
 type Logger interface {
-	Debug(format string, a ...any)
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+	With(args ...any) Logger
 }
 
+const (
+	LogFormatLogfmt = "logfmt"
+	LogFormatJson   = "json"
+)
+
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	return &SlogLogger{logger: logger}
+}
+
+// NewLogger writes to w at the level and in the format of config, the default
+// is logfmt at info level.
+func NewLogger(w io.Writer, config *LogConfigType) (*SlogLogger, error) {
+	if config == nil {
+		config = &LogConfigType{}
+	}
+	level := slog.LevelInfo
+	if config.Level != "" {
+		if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidLogLevel, config.Level)
+		}
+	}
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(config.Format) {
+	case "", LogFormatLogfmt:
+		return NewSlogLogger(slog.New(slog.NewTextHandler(w, handlerOptions))), nil
+	case LogFormatJson:
+		return NewSlogLogger(slog.New(slog.NewJSONHandler(w, handlerOptions))), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidLogFormat, config.Format)
+	}
+}
+
+func (l *SlogLogger) Debug(msg string, args ...any) {
+	l.logger.Debug(msg, args...)
+}
+
+func (l *SlogLogger) Info(msg string, args ...any) {
+	l.logger.Info(msg, args...)
+}
+
+func (l *SlogLogger) Warn(msg string, args ...any) {
+	l.logger.Warn(msg, args...)
+}
+
+func (l *SlogLogger) Error(msg string, args ...any) {
+	l.logger.Error(msg, args...)
+}
+
+func (l *SlogLogger) With(args ...any) Logger {
+	return &SlogLogger{logger: l.logger.With(args...)}
+}
+
+var stdSlogLogger = NewSlogLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+	Level: slog.LevelDebug,
+})))
+
+// StdLogger writes every level as logfmt to stderr.
 type StdLogger struct {
 }
 
-func (l *StdLogger) Debug(format string, a ...any) {
-	log.Printf(format, a...)
+func (l *StdLogger) Debug(msg string, args ...any) {
+	stdSlogLogger.Debug(msg, args...)
+}
+
+func (l *StdLogger) Info(msg string, args ...any) {
+	stdSlogLogger.Info(msg, args...)
+}
+
+func (l *StdLogger) Warn(msg string, args ...any) {
+	stdSlogLogger.Warn(msg, args...)
+}
+
+func (l *StdLogger) Error(msg string, args ...any) {
+	stdSlogLogger.Error(msg, args...)
+}
+
+func (l *StdLogger) With(args ...any) Logger {
+	return stdSlogLogger.With(args...)
+}
+
+type loggerContextKeyType struct{}
+
+// ContextWithLogger carries a logger with request scoped fields, such as the
+// request ID, down to the CA operations.
+func ContextWithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKeyType{}, logger)
+}
+
+func LoggerFromContext(ctx context.Context, fallback Logger) Logger {
+	if logger, ok := ctx.Value(loggerContextKeyType{}).(Logger); ok {
+		return logger
+	}
+	return fallback
 }
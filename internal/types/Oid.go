@@ -0,0 +1,28 @@
+package types
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseOid parses a dotted OID such as 1.3.6.1.4.1.99999.1.
+func ParseOid(text string) (asn1.ObjectIdentifier, error) {
+	arcs := strings.Split(text, ".")
+	if len(arcs) < 2 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidOid, text)
+	}
+	oid := asn1.ObjectIdentifier{}
+	for _, arc := range arcs {
+		value, err := strconv.Atoi(arc)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidOid, text)
+		}
+		oid = append(oid, value)
+	}
+	if oid[0] > 2 || oid[0] < 2 && oid[1] > 39 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidOid, text)
+	}
+	return oid, nil
+}
@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+type OpaClientType struct {
+	Timeout      time.Duration `yaml:"timeout"`
+	Retries      *int          `yaml:"retries"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+
+	CircuitBreakerThreshold int           `yaml:"circuit_breaker_threshold"`
+	CircuitBreakerCooldown  time.Duration `yaml:"circuit_breaker_cooldown"`
+
+	CacheTtl time.Duration `yaml:"cache_ttl"`
+
+	BearerToken  *string `yaml:"bearer_token"`
+	CaBundleFile *string `yaml:"ca_bundle_file"`
+}
@@ -0,0 +1,79 @@
+package types
+
+import (
+	"regexp"
+	"text/template"
+)
+
+// ProfileNamePattern is the syntax of the keys of profiles.
+var ProfileNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// ProfileType tunes how the certificates requested with it are issued.
+type ProfileType struct {
+	// ApprovalRequired keeps every sign request pending until an
+	// administrator approves it.
+	ApprovalRequired bool `yaml:"approval_required"`
+	// SanRules restrict the names of the certificates, in addition to the
+	// name constraints of the CA.
+	SanRules *SanRulesType `yaml:"san_rules"`
+	// Subject rewrites the subject of the CSR, which is copied as is when
+	// nil.
+	Subject *SubjectPolicyType `yaml:"subject"`
+	// CertificatePolicies and StaticExtensions are written in the
+	// certificates, replacing the extensions of the CSR with the same OID.
+	CertificatePolicies []CertificatePolicyType `yaml:"certificate_policies"`
+	StaticExtensions    []StaticExtensionType   `yaml:"static_extensions"`
+}
+
+// SanRulesType are the rules of a profile on the subject alternative names
+// and the common name of a CSR.
+type SanRulesType struct {
+	// WildcardAllowed permits DNS names such as *.example.com.
+	WildcardAllowed bool `yaml:"wildcard_allowed"`
+	// MaxSans is the most subject alternative names of any type, 0 for no
+	// limit.
+	MaxSans int `yaml:"max_sans"`
+	// RequireCnInSan refuses a common name that is not also a subject
+	// alternative name.
+	RequireCnInSan bool `yaml:"require_cn_in_san"`
+}
+
+// SubjectAttributes are the names of the subject attributes a subject
+// policy refers to.
+var SubjectAttributes = []string{
+	"common_name",
+	"serial_number",
+	"country",
+	"organization",
+	"organizational_unit",
+	"locality",
+	"province",
+	"street_address",
+	"postal_code",
+}
+
+// SubjectPolicyType builds the subject of the certificates of a profile, the
+// steps are applied in the order of the fields.
+type SubjectPolicyType struct {
+	// Template replaces the subject of the CSR, each value is a text/template
+	// given .Csr (the subject of the CSR), .FirstSan and .Values (the
+	// subject_values returned by OPA); empty values are left out.
+	Template *CertificateAuthoritySubjectType `yaml:"template"`
+	// AllowedAttributes are kept, the others are dropped, including the
+	// attributes that are not one of SubjectAttributes; all of them are kept
+	// when empty.
+	AllowedAttributes []string `yaml:"allowed_attributes"`
+	// CommonNameFromSan sets the common name to the first subject
+	// alternative name, when there is one.
+	CommonNameFromSan bool `yaml:"common_name_from_san"`
+	// DefaultFromCa are set from the subject of the CA when missing.
+	DefaultFromCa []string `yaml:"default_from_ca"`
+	// ForceFromCa are always set from the subject of the CA.
+	ForceFromCa []string `yaml:"force_from_ca"`
+}
+
+// ParseSubjectTemplate parses one value of a subject template, a missing
+// key of .Values is an error when it is executed.
+func ParseSubjectTemplate(text string) (*template.Template, error) {
+	return template.New("subject").Option("missingkey=error").Parse(text)
+}
@@ -0,0 +1,39 @@
+package types
+
+import (
+	"context"
+	"os"
+	"os/user"
+)
+
+type RequesterType struct {
+	RemoteAddr  string `json:"remote_addr,omitempty"`
+	Identity    string `json:"identity,omitempty"`
+	OpaDecision string `json:"opa_decision,omitempty"`
+	RequestId   string `json:"request_id,omitempty"`
+}
+
+type requesterContextKeyType struct{}
+
+func ContextWithRequester(ctx context.Context, requester RequesterType) context.Context {
+	return context.WithValue(ctx, requesterContextKeyType{}, requester)
+}
+
+func RequesterFromContext(ctx context.Context) RequesterType {
+	if requester, ok := ctx.Value(requesterContextKeyType{}).(RequesterType); ok {
+		return requester
+	}
+	return RequesterType{}
+}
+
+// LocalRequester identifies operations started from the command line.
+func LocalRequester() RequesterType {
+	identity := "cli"
+	if currentUser, err := user.Current(); err == nil {
+		identity += ":" + currentUser.Username
+		if thisHostname, err := os.Hostname(); err == nil {
+			identity += "@" + thisHostname
+		}
+	}
+	return RequesterType{Identity: identity}
+}
@@ -0,0 +1,28 @@
+package types
+
+import "time"
+
+const (
+	CaKindX509 = "x509"
+	CaKindSsh  = "ssh"
+)
+
+const (
+	SshCertTypeUser = "user"
+	SshCertTypeHost = "host"
+)
+
+// SshConfigType tunes the OpenSSH certificates issued by a CA of kind ssh.
+type SshConfigType struct {
+	// CertType is used when the request does not choose, default user.
+	CertType string `yaml:"cert_type"`
+	// MaxValidity bounds the validity of the certificates and is used when
+	// the request does not choose, default 24h.
+	MaxValidity time.Duration `yaml:"max_validity"`
+	// CriticalOptions are added to every certificate, over the ones of the
+	// request.
+	CriticalOptions map[string]string `yaml:"critical_options"`
+	// Extensions are used when the request has none, user certificates
+	// default to the extensions of ssh-keygen.
+	Extensions map[string]string `yaml:"extensions"`
+}
@@ -0,0 +1,116 @@
+package types
+
+import (
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// ReservedExtensionOids are the extensions written by the CA itself, a static
+// extension cannot replace them.
+var ReservedExtensionOids = []string{
+	"2.5.29.14",               // subject key identifier
+	"2.5.29.15",               // key usage
+	"2.5.29.17",               // subject alternative name
+	"2.5.29.19",               // basic constraints
+	"2.5.29.30",               // name constraints
+	"2.5.29.31",               // CRL distribution points
+	"2.5.29.32",               // certificate policies
+	"2.5.29.33",               // policy mappings
+	"2.5.29.35",               // authority key identifier
+	"2.5.29.36",               // policy constraints
+	"2.5.29.37",               // extended key usage
+	"2.5.29.54",               // inhibit any policy
+	"1.3.6.1.5.5.7.1.1",       // authority information access
+	"1.3.6.1.4.1.11129.2.4.2", // signed certificate timestamps
+	"1.3.6.1.4.1.11129.2.4.3", // precertificate poison
+}
+
+// StaticExtensionType is an extension written as is in the certificates,
+// its value is given as hex DER or as exactly one of the typed values.
+type StaticExtensionType struct {
+	Oid      string `yaml:"oid"`
+	Critical bool   `yaml:"critical"`
+
+	// Der is the hex of the DER value, colons and spaces are ignored.
+	Der             *string `yaml:"der"`
+	Utf8String      *string `yaml:"utf8_string"`
+	PrintableString *string `yaml:"printable_string"`
+	Ia5String       *string `yaml:"ia5_string"`
+	Integer         *int64  `yaml:"integer"`
+	Boolean         *bool   `yaml:"boolean"`
+}
+
+// Extension encodes the static extension.
+func (staticExtension StaticExtensionType) Extension() (pkix.Extension, error) {
+	oid, err := ParseOid(staticExtension.Oid)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("%w: %v", ErrInvalidStaticExtension, err)
+	}
+	if slices.Contains(ReservedExtensionOids, oid.String()) {
+		return pkix.Extension{}, fmt.Errorf("%w: %s is written by the CA", ErrInvalidStaticExtension, oid.String())
+	}
+
+	values := [][]byte{}
+	addString := func(tag cryptobyte_asn1.Tag, value *string, validRune func(r rune) bool) error {
+		if value == nil {
+			return nil
+		}
+		if validRune != nil {
+			if i := strings.IndexFunc(*value, func(r rune) bool { return !validRune(r) }); i >= 0 {
+				return fmt.Errorf("%w: %q not allowed in %q", ErrInvalidStaticExtension, (*value)[i:i+1], *value)
+			}
+		}
+		b := cryptobyte.NewBuilder(nil)
+		b.AddASN1(tag, func(child *cryptobyte.Builder) {
+			child.AddBytes([]byte(*value))
+		})
+		values = append(values, b.BytesOrPanic())
+		return nil
+	}
+	if staticExtension.Der != nil {
+		der, err := hex.DecodeString(strings.NewReplacer(":", "", " ", "", "\n", "").Replace(*staticExtension.Der))
+		if err != nil {
+			return pkix.Extension{}, fmt.Errorf("%w: der: %v", ErrInvalidStaticExtension, err)
+		}
+		input := cryptobyte.String(der)
+		var element cryptobyte.String
+		var tag cryptobyte_asn1.Tag
+		if !input.ReadAnyASN1Element(&element, &tag) || !input.Empty() {
+			return pkix.Extension{}, fmt.Errorf("%w: der is not a single DER element", ErrInvalidStaticExtension)
+		}
+		values = append(values, der)
+	}
+	if err := addString(cryptobyte_asn1.UTF8String, staticExtension.Utf8String, nil); err != nil {
+		return pkix.Extension{}, err
+	}
+	if err := addString(cryptobyte_asn1.PrintableString, staticExtension.PrintableString, func(r rune) bool {
+		return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(" '()+,-./:=?", r)
+	}); err != nil {
+		return pkix.Extension{}, err
+	}
+	if err := addString(cryptobyte_asn1.IA5String, staticExtension.Ia5String, func(r rune) bool {
+		return r < 128
+	}); err != nil {
+		return pkix.Extension{}, err
+	}
+	if staticExtension.Integer != nil {
+		b := cryptobyte.NewBuilder(nil)
+		b.AddASN1Int64(*staticExtension.Integer)
+		values = append(values, b.BytesOrPanic())
+	}
+	if staticExtension.Boolean != nil {
+		b := cryptobyte.NewBuilder(nil)
+		b.AddASN1Boolean(*staticExtension.Boolean)
+		values = append(values, b.BytesOrPanic())
+	}
+	if len(values) != 1 {
+		return pkix.Extension{}, fmt.Errorf("%w: %s needs exactly one value, got %d", ErrInvalidStaticExtension, oid.String(), len(values))
+	}
+	return pkix.Extension{Id: oid, Critical: staticExtension.Critical, Value: values[0]}, nil
+}
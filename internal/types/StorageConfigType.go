@@ -0,0 +1,6 @@
+package types
+
+type StorageConfigType struct {
+	Type string  `yaml:"type"`
+	Path *string `yaml:"path"`
+}
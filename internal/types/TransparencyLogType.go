@@ -0,0 +1,10 @@
+package types
+
+// TransparencyLogType configures the RFC 6962 style log of the certificates
+// issued by a CA of kind x509, the log is kept even without it.
+type TransparencyLogType struct {
+	// EmbedSct adds to the certificates issued from a CSR a signed
+	// certificate timestamp, the promise of the CA that the certificate is in
+	// its log.
+	EmbedSct bool `yaml:"embed_sct"`
+}
@@ -0,0 +1,30 @@
+package types
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"time"
+)
+
+// TsaConfigType enables the RFC 3161 time-stamping authority of a CA of kind
+// x509, its tokens are signed by a dedicated certificate issued by the CA.
+type TsaConfigType struct {
+	// Policy is the OID of the TSA policy put in every token.
+	Policy string `yaml:"policy"`
+	// OpaUrl authorizes the time-stamp requests received by the web server.
+	OpaUrl *string `yaml:"opa_url"`
+	// Accuracy is announced in every token when not zero.
+	Accuracy time.Duration `yaml:"accuracy"`
+	// CertificateValidity of the TSA certificate, default 1 year. The
+	// certificate is issued again when it gets close to its expiry.
+	CertificateValidity CertificateAuthorityValidityType `yaml:"certificate_validity"`
+}
+
+// PolicyOid parses Policy, a dotted OID such as 1.3.6.1.4.1.99999.1.
+func (tsaConfig TsaConfigType) PolicyOid() (asn1.ObjectIdentifier, error) {
+	oid, err := ParseOid(tsaConfig.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTsaPolicy, tsaConfig.Policy)
+	}
+	return oid, nil
+}
@@ -8,3 +8,12 @@ var ErrUnsupportedChangeToCurve = fmt.Errorf("invalid change for curve name")
 var ErrInvalidCurve = fmt.Errorf("invalid curve name")
 var ErrInvalidCaId = fmt.Errorf("invalid ca id")
 var ErrInvalidKeyType = fmt.Errorf("invalid key type")
+var ErrInvalidSigningKeyType = fmt.Errorf("invalid signing key type")
+var ErrInvalidStorageType = fmt.Errorf("invalid storage type")
+var ErrInvalidLogLevel = fmt.Errorf("invalid log level")
+var ErrInvalidLogFormat = fmt.Errorf("invalid log format")
+var ErrInvalidCaKind = fmt.Errorf("invalid CA kind")
+var ErrInvalidSshCertType = fmt.Errorf("invalid SSH certificate type")
+var ErrInvalidTsaPolicy = fmt.Errorf("invalid TSA policy")
+var ErrInvalidStaticExtension = fmt.Errorf("invalid static extension")
+var ErrInvalidOid = fmt.Errorf("invalid OID")
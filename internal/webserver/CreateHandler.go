@@ -5,83 +5,327 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 	"net/http"
-	"os"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tomaluca95/simple-ca/internal/auditlog"
 	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/metrics"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
 	"github.com/tomaluca95/simple-ca/internal/types"
 )
 
+var ErrUnsafeConfigChange = errors.New("unsafe configuration change")
+
+// HandlerType serves the CAs of the configuration, which Reload replaces
+// without interrupting the requests being served.
+type HandlerType struct {
+	httpHandler *gin.Engine
+	logger      types.Logger
+
+	configLoader func() (types.ConfigFileType, error)
+	reloadMu     sync.Mutex
+
+	mu             sync.RWMutex
+	configFile     types.ConfigFileType
+	httpWrappers   map[string]*httpWrapperType
+	adminOpaUrl    string
+	adminOpaClient *opaClientType
+}
+
 func CreateHandler(
 	ctx context.Context,
 	logger types.Logger,
 	configFile types.ConfigFileType,
-) (http.Handler, error) {
-	httpHandler := gin.New()
-	httpHandler.Use(gin.Logger())
+) (*HandlerType, error) {
+	handler := &HandlerType{
+		httpHandler: gin.New(),
+		logger:      logger,
+	}
+	if err := handler.applyConfig(ctx, configFile); err != nil {
+		return nil, err
+	}
+
+	httpHandler := handler.httpHandler
+	httpHandler.Use(accessLog(logger))
 	httpHandler.Use(gin.Recovery())
 
+	healthCheck := &healthCheckType{httpWrappers: handler.allHttpWrappers}
+	httpHandler.GET("/metrics", gin.WrapH(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})))
+	httpHandler.GET("/healthz", healthCheck.Healthz)
+	httpHandler.GET("/readyz", healthCheck.Readyz)
+
+	httpHandler.POST("/admin/reload", handler.AdminReload)
+
+	caHttpGroup := httpHandler.Group("/ca/:caId")
+	caHttpGroup.GET("/issuer.pem", handler.withCaOfKind(types.CaKindX509, (*httpWrapperType).Issuer))
+	caHttpGroup.POST("/csr/sign", handler.withCaOfKind(types.CaKindX509, (*httpWrapperType).CsrSign))
+	caHttpGroup.POST("/crt/revoke/:crtSerial", handler.withCa((*httpWrapperType).CrtRevokeCrtSerial))
+	caHttpGroup.GET("/crt/crl.pem", handler.withCaOfKind(types.CaKindX509, (*httpWrapperType).CrtCrlPem))
+	caHttpGroup.GET("/cross/:filename", handler.withCaOfKind(types.CaKindX509, (*httpWrapperType).CrossCertificate))
+	caHttpGroup.GET("/requests/:requestId", handler.withCaOfKind(types.CaKindX509, (*httpWrapperType).SignRequestStatus))
+	caHttpGroup.POST("/tsa", handler.withCaOfKind(types.CaKindX509, (*httpWrapperType).Timestamp))
+	caHttpGroup.GET("/tsa/certificate.pem", handler.withCaOfKind(types.CaKindX509, (*httpWrapperType).TsaCertificate))
+	caHttpGroup.GET("/ct/v1/get-sth", handler.withCaOfKind(types.CaKindX509, (*httpWrapperType).TransparencyLogSth))
+	caHttpGroup.GET("/ct/v1/get-sth-consistency", handler.withCaOfKind(types.CaKindX509, (*httpWrapperType).TransparencyLogSthConsistency))
+	caHttpGroup.GET("/ct/v1/get-proof-by-hash", handler.withCaOfKind(types.CaKindX509, (*httpWrapperType).TransparencyLogProofByHash))
+	caHttpGroup.GET("/ct/v1/get-entries", handler.withCaOfKind(types.CaKindX509, (*httpWrapperType).TransparencyLogEntries))
+	caHttpGroup.GET("/ssh/ca.pub", handler.withCaOfKind(types.CaKindSsh, (*httpWrapperType).SshCaPub))
+	caHttpGroup.POST("/ssh/sign", handler.withCaOfKind(types.CaKindSsh, (*httpWrapperType).SshSign))
+	caHttpGroup.GET("/ssh/krl", handler.withCaOfKind(types.CaKindSsh, (*httpWrapperType).SshKrl))
+
+	adminCaHttpGroup := httpHandler.Group("/admin/ca/:caId")
+	adminCaHttpGroup.GET("/requests", handler.withCaOfKind(types.CaKindX509, handler.AdminListSignRequests))
+	adminCaHttpGroup.POST("/requests/:requestId/approve", handler.withCaOfKind(types.CaKindX509, handler.AdminApproveSignRequest))
+	adminCaHttpGroup.POST("/requests/:requestId/reject", handler.withCaOfKind(types.CaKindX509, handler.AdminRejectSignRequest))
+
+	return handler, nil
+}
+
+func (handler *HandlerType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler.httpHandler.ServeHTTP(w, r)
+}
+
+// SetConfigLoader sets how Reload reads the configuration again.
+func (handler *HandlerType) SetConfigLoader(configLoader func() (types.ConfigFileType, error)) {
+	handler.reloadMu.Lock()
+	defer handler.reloadMu.Unlock()
+	handler.configLoader = configLoader
+}
+
+// Reload reads the configuration again and serves it once every CA has been
+// loaded; on error the current configuration is kept.
+func (handler *HandlerType) Reload(ctx context.Context) error {
+	handler.reloadMu.Lock()
+	defer handler.reloadMu.Unlock()
+	if handler.configLoader == nil {
+		return fmt.Errorf("no configuration loader")
+	}
+	configFile, err := handler.configLoader()
+	if err != nil {
+		return err
+	}
+
+	handler.mu.RLock()
+	currentConfigFile := handler.configFile
+	handler.mu.RUnlock()
+	if err := checkReloadIsSafe(currentConfigFile, configFile); err != nil {
+		return err
+	}
+	return handler.applyConfig(ctx, configFile)
+}
+
+// checkReloadIsSafe refuses the changes that need a restart; changes to the
+// CA keys are refused by LoadOneCa.
+func checkReloadIsSafe(currentConfigFile types.ConfigFileType, configFile types.ConfigFileType) error {
+	if configFile.DataDirectory != currentConfigFile.DataDirectory {
+		return fmt.Errorf("%w: data_directory requires a restart", ErrUnsafeConfigChange)
+	}
+	if (configFile.HttpServer == nil) != (currentConfigFile.HttpServer == nil) ||
+		configFile.HttpServer != nil &&
+			(configFile.HttpServer.ListenAddress != currentConfigFile.HttpServer.ListenAddress ||
+				configFile.HttpServer.ListenPort != currentConfigFile.HttpServer.ListenPort) {
+		return fmt.Errorf("%w: http_server listen address and port require a restart", ErrUnsafeConfigChange)
+	}
+	if !reflect.DeepEqual(configFile.Log, currentConfigFile.Log) {
+		return fmt.Errorf("%w: log requires a restart", ErrUnsafeConfigChange)
+	}
 	for caId, caConfig := range configFile.AllCaConfigs {
-		missingConfig := []string{}
-		if caConfig.OpaUrlSign == nil || strings.TrimSpace(*caConfig.OpaUrlSign) == "" {
-			missingConfig = append(missingConfig, "opa_url_sign")
+		currentCaConfig, found := currentConfigFile.AllCaConfigs[caId]
+		if found && caKind(caConfig) != caKind(currentCaConfig) {
+			return fmt.Errorf("%w: kind of CA %q requires a restart", ErrUnsafeConfigChange, caId)
 		}
-		if caConfig.OpaUrlRevoke == nil || strings.TrimSpace(*caConfig.OpaUrlRevoke) == "" {
-			missingConfig = append(missingConfig, "opa_url_revoke")
+	}
+	return nil
+}
+
+func caKind(caConfig types.CertificateAuthorityType) string {
+	if caConfig.Kind == "" {
+		return types.CaKindX509
+	}
+	return caConfig.Kind
+}
+
+// applyConfig loads the CAs that are new or whose configuration changed, the
+// others are kept as they are so that a reload does not issue a new CRL for
+// each of them.
+func (handler *HandlerType) applyConfig(ctx context.Context, configFile types.ConfigFileType) error {
+	ctx = types.ContextWithRequester(ctx, types.LocalRequester())
+	handler.mu.RLock()
+	currentConfigFile := handler.configFile
+	currentHttpWrappers := handler.httpWrappers
+	handler.mu.RUnlock()
+
+	httpWrappers := map[string]*httpWrapperType{}
+	for caId, caConfig := range configFile.AllCaConfigs {
+		if currentHttpWrapper, found := currentHttpWrappers[caId]; found &&
+			reflect.DeepEqual(currentConfigFile.AllCaConfigs[caId], caConfig) {
+			httpWrappers[caId] = currentHttpWrapper
+			continue
 		}
-		if len(missingConfig) > 0 {
-			return nil, fmt.Errorf(
-				"missing OPA URL configuration for CA %q: %s",
-				caId,
-				strings.Join(missingConfig, ", "),
-			)
+		httpWrapper, err := loadHttpWrapper(ctx, handler.logger, configFile.DataDirectory, caId, caConfig)
+		if err != nil {
+			return fmt.Errorf("CA %q: %w", caId, err)
 		}
+		httpWrappers[caId] = httpWrapper
+	}
+	allCa := map[string]*caissuingprocess.OneCaType{}
+	for caId, httpWrapper := range httpWrappers {
+		allCa[caId] = httpWrapper.oneCa
+	}
+	if err := caissuingprocess.CrossSignAll(ctx, allCa); err != nil {
+		return err
+	}
 
-		oneCa, err := caissuingprocess.LoadOneCa(
-			ctx,
-			logger,
-			caId,
-			configFile.DataDirectory,
-			caConfig,
-		)
+	adminOpaUrl := ""
+	var adminOpaClient *opaClientType
+	if configFile.HttpServer != nil && configFile.HttpServer.OpaUrlAdmin != nil {
+		adminOpaUrl = strings.TrimSpace(*configFile.HttpServer.OpaUrlAdmin)
+		opaClient, err := newOpaClient(adminOpaClientId, configFile.HttpServer.OpaClient)
 		if err != nil {
-			return nil, err
-		}
-		if err := oneCa.UpdateCrl(); err != nil {
-			return nil, err
+			return err
 		}
-		httpWrapper := &httpWrapperType{
-			caId:  caId,
-			oneCa: oneCa,
-
-			OpaUrlSign:   *caConfig.OpaUrlSign,
-			OpaUrlRevoke: *caConfig.OpaUrlRevoke,
+		adminOpaClient = opaClient
+	}
 
-			logger: logger,
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	for caId := range handler.httpWrappers {
+		if _, found := httpWrappers[caId]; !found {
+			metrics.RemoveCaStatusSource(caId)
 		}
+	}
+	for caId, httpWrapper := range httpWrappers {
+		metrics.SetCaStatusSource(caId, httpWrapper.oneCa.Status)
+	}
+	handler.configFile = configFile
+	handler.httpWrappers = httpWrappers
+	handler.adminOpaUrl = adminOpaUrl
+	handler.adminOpaClient = adminOpaClient
+	return nil
+}
 
-		caHttpGroup := httpHandler.Group(
-			"/ca/" + caId,
+func loadHttpWrapper(
+	ctx context.Context,
+	logger types.Logger,
+	dataDirectory string,
+	caId string,
+	caConfig types.CertificateAuthorityType,
+) (*httpWrapperType, error) {
+	missingConfig := []string{}
+	if caConfig.OpaUrlSign == nil || strings.TrimSpace(*caConfig.OpaUrlSign) == "" {
+		missingConfig = append(missingConfig, "opa_url_sign")
+	}
+	if caConfig.OpaUrlRevoke == nil || strings.TrimSpace(*caConfig.OpaUrlRevoke) == "" {
+		missingConfig = append(missingConfig, "opa_url_revoke")
+	}
+	opaUrlTimestamp := ""
+	if caConfig.Tsa != nil {
+		if caConfig.Tsa.OpaUrl == nil || strings.TrimSpace(*caConfig.Tsa.OpaUrl) == "" {
+			missingConfig = append(missingConfig, "tsa.opa_url")
+		} else {
+			opaUrlTimestamp = *caConfig.Tsa.OpaUrl
+		}
+	}
+	if len(missingConfig) > 0 {
+		return nil, fmt.Errorf(
+			"missing OPA URL configuration for CA %q: %s",
+			caId,
+			strings.Join(missingConfig, ", "),
 		)
+	}
+
+	oneCa, err := caissuingprocess.LoadOneCa(
+		ctx,
+		logger,
+		caId,
+		dataDirectory,
+		caConfig,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := oneCa.UpdateCrl(ctx); err != nil {
+		return nil, err
+	}
+	opaClient, err := newOpaClient(caId, caConfig.OpaClient)
+	if err != nil {
+		return nil, err
+	}
+	limits := caConfig.Limits
+	if limits == nil {
+		limits = &types.LimitsType{}
+	}
+	return &httpWrapperType{
+		caId:      caId,
+		oneCa:     oneCa,
+		opaClient: opaClient,
+
+		caRateLimiter:        newRateLimiter(limits.Ca),
+		requesterRateLimiter: newRateLimiter(limits.Requester),
+
+		OpaUrlSign:      *caConfig.OpaUrlSign,
+		OpaUrlRevoke:    *caConfig.OpaUrlRevoke,
+		OpaUrlTimestamp: opaUrlTimestamp,
+
+		logger: logger,
+	}, nil
+}
 
-		caHttpGroup.GET("/issuer.pem", httpWrapper.Issuer)
-		caHttpGroup.POST("/csr/sign", httpWrapper.CsrSign)
-		caHttpGroup.POST("/crt/revoke/:crtSerial", httpWrapper.CrtRevokeCrtSerial)
-		caHttpGroup.GET("/crt/crl.pem", httpWrapper.CrtCrlPem)
+func (handler *HandlerType) allHttpWrappers() []*httpWrapperType {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	httpWrappers := make([]*httpWrapperType, 0, len(handler.httpWrappers))
+	for _, httpWrapper := range handler.httpWrappers {
+		httpWrappers = append(httpWrappers, httpWrapper)
+	}
+	return httpWrappers
+}
+
+// withCa serves the request with the CA of the caId path parameter, as loaded
+// when the request arrived.
+func (handler *HandlerType) withCa(caHandler func(httpWrapper *httpWrapperType, c *gin.Context)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handler.mu.RLock()
+		httpWrapper, found := handler.httpWrappers[c.Param("caId")]
+		handler.mu.RUnlock()
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown CA"})
+			return
+		}
+		caHandler(httpWrapper, c)
 	}
+}
 
-	return httpHandler, nil
+// withCaOfKind serves the request only with a CA of kind, x509 or ssh.
+func (handler *HandlerType) withCaOfKind(kind string, caHandler func(httpWrapper *httpWrapperType, c *gin.Context)) gin.HandlerFunc {
+	return handler.withCa(func(httpWrapper *httpWrapperType, c *gin.Context) {
+		if httpWrapper.oneCa.Kind() != kind {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not available on a CA of kind " + httpWrapper.oneCa.Kind()})
+			return
+		}
+		caHandler(httpWrapper, c)
+	})
 }
 
 type httpWrapperType struct {
-	caId  string
-	oneCa *caissuingprocess.OneCaType
+	caId      string
+	oneCa     *caissuingprocess.OneCaType
+	opaClient *opaClientType
+
+	caRateLimiter        *rateLimiterType
+	requesterRateLimiter *rateLimiterType
 
-	OpaUrlSign   string
-	OpaUrlRevoke string
+	OpaUrlSign      string
+	OpaUrlRevoke    string
+	OpaUrlTimestamp string
 
 	logger types.Logger
 }
@@ -95,6 +339,27 @@ func (httpWrapper *httpWrapperType) Issuer(c *gin.Context) {
 	c.Writer.Write(fileContent)
 }
 
+// CrossCertificate serves /cross/<issuer>.pem, the certificate of the CA
+// signed by the CA issuer.
+func (httpWrapper *httpWrapperType) CrossCertificate(c *gin.Context) {
+	issuerCaId, isPem := strings.CutSuffix(c.Param("filename"), ".pem")
+	if !isPem {
+		c.JSON(http.StatusNotFound, gin.H{"error": "cross certificate not found"})
+		return
+	}
+	fileContent, err := httpWrapper.oneCa.GetCrossCertificatePem(issuerCaId)
+	if err != nil {
+		if errors.Is(err, caissuingprocess.ErrUnknownCrossCertificate) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "cross certificate not found"})
+			return
+		}
+		types.LoggerFromContext(c.Request.Context(), httpWrapper.logger).Error("Failed reading cross certificate", "issuer", issuerCaId, "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in getting cross certificate"})
+		return
+	}
+	c.Data(http.StatusOK, mimeTypePemFile, fileContent)
+}
+
 func (httpWrapper *httpWrapperType) CrtCrlPem(c *gin.Context) {
 	fileContent, err := httpWrapper.oneCa.GetCrlPem()
 	if err != nil {
@@ -104,7 +369,46 @@ func (httpWrapper *httpWrapperType) CrtCrlPem(c *gin.Context) {
 	c.Writer.Write(fileContent)
 }
 
+// throttle answers 429 and returns true when a rate limit refuses the
+// request before authorization. The limit of the requester is checked first
+// so that a requester over its limit does not consume the requests of the
+// whole CA.
+func (httpWrapper *httpWrapperType) throttle(c *gin.Context) bool {
+	return httpWrapper.throttleWith(c, httpWrapper.requesterRateLimiter, clientKey(c), metrics.ThrottledReasonRequesterRateLimit) ||
+		httpWrapper.throttleWith(c, httpWrapper.caRateLimiter, "", metrics.ThrottledReasonCaRateLimit)
+}
+
+// throttleIdentity applies the limit of the requester to the identity
+// returned by OPA once the request is authorized, whatever address or
+// Authorization header it comes with.
+func (httpWrapper *httpWrapperType) throttleIdentity(c *gin.Context, identity string) bool {
+	if identity == "" {
+		return false
+	}
+	return httpWrapper.throttleWith(c, httpWrapper.requesterRateLimiter, "identity:"+identity, metrics.ThrottledReasonRequesterRateLimit)
+}
+
+func (httpWrapper *httpWrapperType) throttleWith(c *gin.Context, rateLimiter *rateLimiterType, key string, reason string) bool {
+	allowed, retryAfter := rateLimiter.allow(key)
+	if allowed {
+		return false
+	}
+	metrics.ThrottledTotal.WithLabelValues(httpWrapper.caId, reason).Inc()
+	types.LoggerFromContext(c.Request.Context(), httpWrapper.logger).Info("Request rate limited", "ca_id", httpWrapper.caId, "reason", reason)
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+	return true
+}
+
 func (httpWrapper *httpWrapperType) CsrSign(c *gin.Context) {
+	logger := types.LoggerFromContext(c.Request.Context(), httpWrapper.logger)
+	if httpWrapper.throttle(c) {
+		return
+	}
+	format, acceptable := negotiateCertificateFormat(c)
+	if !acceptable {
+		return
+	}
 	defer c.Request.Body.Close()
 	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 32*1024)
 	csrContent, err := io.ReadAll(c.Request.Body)
@@ -112,54 +416,118 @@ func (httpWrapper *httpWrapperType) CsrSign(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unexpected error in signing CSR (reading CSR content)"})
 		return
 	}
+	// DER, base64 or PEM with text around it are passed on as plain PEM, an
+	// unreadable CSR is left as is to be refused when signing.
+	if csr, err := pemhelper.FromAnyToCertificateRequest(csrContent); err == nil {
+		if csrPem, err := pemhelper.ToPem(csr); err == nil {
+			csrContent = csrPem
+		}
+	}
+	profileName := c.Query("profile")
+	profile, err := httpWrapper.oneCa.Profile(profileName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if err := httpWrapper.opaWrapper(c.Request.Context(), httpWrapper.OpaUrlSign, map[string]string{
+	opaInput := map[string]string{
 		"remote_addr":   c.Request.RemoteAddr,
 		"authorization": c.GetHeader("Authorization"),
 		"csr_content":   string(csrContent),
-	}); err != nil {
-		if errors.Is(err, ErrNotAuthorized) {
-			httpWrapper.logger.Debug("OPA denied the sign request: %v", err)
-			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
-			return
-		} else {
-			httpWrapper.logger.Debug("Unexpected error: %v", err)
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "unexpected error in authorization check"})
-			return
+		"profile":       profileName,
+	}
+	// OPA is shown the subject of the certificate, or asked again once the
+	// values it returned are in the subject built by the template.
+	subjectTemplated := profile.Subject != nil && profile.Subject.Template != nil
+	if !subjectTemplated {
+		if subject, err := httpWrapper.oneCa.CertificateSubject(csrContent, profileName, nil); err == nil {
+			opaInput["subject"] = subject.String()
 		}
 	}
-
-	csrFile, err := os.CreateTemp("", "csr-*.pem")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in signing CSR (create CSR file)"})
+	opaDecision, ctx, authorized := httpWrapper.authorizeSign(c, opaInput)
+	if !authorized || httpWrapper.throttleIdentity(c, opaDecision.Identity) {
 		return
 	}
-	csrFilename := csrFile.Name()
-	defer os.Remove(csrFilename)
+	if subjectTemplated {
+		subject, err := httpWrapper.oneCa.CertificateSubject(csrContent, profileName, opaDecision.SubjectValues)
+		if err != nil {
+			if errors.Is(err, caissuingprocess.ErrInvalidCsr) {
+				writeInvalidCsr(c, err)
+				return
+			}
+			logger.Error("Failed building certificate subject", "err", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in signing CSR"})
+			return
+		}
+		opaInput["subject"] = subject.String()
+		if _, _, authorized := httpWrapper.authorizeSign(c, opaInput); !authorized {
+			return
+		}
+	}
 
-	if _, err := csrFile.Write(csrContent); err != nil {
-		csrFile.Close()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in signing CSR (writing CSR file)"})
+	if opaDecision.Pending || profile.ApprovalRequired {
+		httpWrapper.submitSignRequest(ctx, c, csrContent, profileName, opaDecision.SubjectValues)
 		return
 	}
-	csrFile.Close()
 
-	pemBytes, err := httpWrapper.oneCa.SignCsrFile(csrFilename)
+	pemBytes, err := httpWrapper.oneCa.SignCsrWithProfile(ctx, csrContent, profileName, opaDecision.SubjectValues)
 	if err != nil {
 		if errors.Is(err, caissuingprocess.ErrInvalidCsr) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid CSR"})
+			writeInvalidCsr(c, err)
+			return
+		}
+		if errors.Is(err, caissuingprocess.ErrQuotaExceeded) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in signing CSR (signing CSR file)"})
+		logger.Error("Failed signing CSR", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in signing CSR"})
 		return
 	}
 
-	c.Writer.Write(pemBytes)
+	httpWrapper.writeCertificate(c, format, pemBytes)
+}
+
+// authorizeSign asks OPA whether the CSR of opaInput may be signed, the
+// response is written when it may not.
+func (httpWrapper *httpWrapperType) authorizeSign(c *gin.Context, opaInput map[string]string) (opaDecisionType, context.Context, bool) {
+	logger := types.LoggerFromContext(c.Request.Context(), httpWrapper.logger)
+	opaDecision, err := httpWrapper.opaWrapper(c.Request.Context(), "sign", httpWrapper.OpaUrlSign, opaInput)
+	ctx := requesterContext(c, opaDecision, err)
+	if err != nil {
+		if errors.Is(err, ErrNotAuthorized) {
+			logger.Info("OPA denied the sign request", "err", err)
+			metrics.SignDeniedTotal.WithLabelValues(httpWrapper.caId).Inc()
+			httpWrapper.oneCa.AuditRecord(ctx, auditlog.OperationSign, auditlog.ResultDenied, nil, err)
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else {
+			logger.Error("OPA authorization check failed", "err", err)
+			httpWrapper.oneCa.AuditRecord(ctx, auditlog.OperationSign, auditlog.ResultError, nil, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "unexpected error in authorization check"})
+		}
+		return opaDecision, ctx, false
+	}
+	return opaDecision, ctx, true
+}
+
+// writeInvalidCsr answers 400, with the names refused by the name policy
+// when that is the reason.
+func writeInvalidCsr(c *gin.Context, err error) {
+	var namePolicyErr *caissuingprocess.NamePolicyError
+	if errors.As(err, &namePolicyErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid CSR", "violations": namePolicyErr.Violations})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "invalid CSR"})
 }
 
 func (httpWrapper *httpWrapperType) CrtRevokeCrtSerial(c *gin.Context) {
+	logger := types.LoggerFromContext(c.Request.Context(), httpWrapper.logger)
+	if httpWrapper.throttle(c) {
+		return
+	}
 	crtSerial := c.Param("crtSerial")
-	httpWrapper.logger.Debug("Request revoking: %s", crtSerial)
+	logger.Debug("Request revoking", "serial", crtSerial)
 
 	n := new(big.Int)
 	if _, isInt := n.SetString(crtSerial, 10); !isInt {
@@ -167,30 +535,63 @@ func (httpWrapper *httpWrapperType) CrtRevokeCrtSerial(c *gin.Context) {
 		return
 	}
 
-	if err := httpWrapper.opaWrapper(c.Request.Context(), httpWrapper.OpaUrlRevoke, map[string]string{
+	opaDecision, err := httpWrapper.opaWrapper(c.Request.Context(), "revoke", httpWrapper.OpaUrlRevoke, map[string]string{
 		"remote_addr":   c.Request.RemoteAddr,
 		"authorization": c.GetHeader("Authorization"),
 		"serial":        crtSerial,
-	}); err != nil {
+	})
+	ctx := requesterContext(c, opaDecision, err)
+	if err != nil {
 		if errors.Is(err, ErrNotAuthorized) {
-			httpWrapper.logger.Debug("OPA denied the revoke request: %v", err)
+			logger.Info("OPA denied the revoke request", "serial", crtSerial, "err", err)
+			httpWrapper.oneCa.AuditRecord(ctx, auditlog.OperationRevoke, auditlog.ResultDenied, n, err)
 			c.JSON(http.StatusForbidden, gin.H{"error": "not authorized to revoke certificate"})
 			return
 		} else {
-			httpWrapper.logger.Debug("Unexpected error: %v", err)
+			logger.Error("OPA authorization check failed", "err", err)
+			httpWrapper.oneCa.AuditRecord(ctx, auditlog.OperationRevoke, auditlog.ResultError, n, err)
 			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "unexpected error in authorization check"})
 			return
 		}
 	}
+	if httpWrapper.throttleIdentity(c, opaDecision.Identity) {
+		return
+	}
 
-	if err := httpWrapper.oneCa.RevokeOneSerial(n); err != nil {
+	if err := httpWrapper.oneCa.RevokeOneSerial(ctx, n); err != nil {
 		if errors.Is(err, caissuingprocess.ErrUnknownSerial) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "certificate serial not found"})
 			return
 		}
+		logger.Error("Failed revoking certificate", "serial", crtSerial, "err", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in revoking certificate"})
 		return
 	}
 
 	c.Status(http.StatusAccepted)
 }
+
+// requesterContext describes the HTTP requester for the audit log. The
+// identity returned by OPA is preferred; otherwise a fingerprint of the
+// Authorization header is used so that tokens never reach the log.
+func requesterContext(c *gin.Context, opaDecision opaDecisionType, opaErr error) context.Context {
+	requester := types.RequesterType{
+		RemoteAddr: c.Request.RemoteAddr,
+		Identity:   opaDecision.Identity,
+		RequestId:  c.GetString(requestIdKey),
+	}
+	if requester.Identity == "" {
+		if authorization := c.GetHeader("Authorization"); authorization != "" {
+			requester.Identity = "authorization-sha256:" + shortSha256([]byte(authorization))
+		}
+	}
+	switch {
+	case opaErr == nil:
+		requester.OpaDecision = "allowed"
+	case errors.Is(opaErr, ErrNotAuthorized):
+		requester.OpaDecision = "denied"
+	default:
+		requester.OpaDecision = "error"
+	}
+	return types.ContextWithRequester(c.Request.Context(), requester)
+}
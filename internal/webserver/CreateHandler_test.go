@@ -41,6 +41,9 @@ func TestRsaSignCsr(t *testing.T) {
 					Subject: types.CertificateAuthoritySubjectType{
 						CommonName: "test_ca_1",
 					},
+					Validity: types.CertificateAuthorityValidityType{
+						Days: 1,
+					},
 					KeyConfig: types.KeyConfigType{
 						Type: "rsa",
 						Config: types.KeyTypeRsaConfigType{
@@ -134,6 +137,9 @@ func TestRsaSignCsrAndRevoke(t *testing.T) {
 					Subject: types.CertificateAuthoritySubjectType{
 						CommonName: "test_ca_1",
 					},
+					Validity: types.CertificateAuthorityValidityType{
+						Days: 1,
+					},
 					KeyConfig: types.KeyConfigType{
 						Type: "rsa",
 						Config: types.KeyTypeRsaConfigType{
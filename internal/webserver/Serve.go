@@ -0,0 +1,70 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+const (
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+)
+
+func durationOrDefault(value time.Duration, defaultValue time.Duration) time.Duration {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// Serve serves httpHandler on netListen until ctx is done, then stops
+// accepting connections and waits for the requests being served, such as a
+// sign running its git commits, up to the shutdown timeout.
+func Serve(
+	ctx context.Context,
+	logger types.Logger,
+	netListen net.Listener,
+	httpHandler http.Handler,
+	httpServerConfig types.HttpServerType,
+) error {
+	httpServer := &http.Server{
+		Handler:           httpHandler,
+		ReadHeaderTimeout: durationOrDefault(httpServerConfig.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       durationOrDefault(httpServerConfig.ReadTimeout, defaultReadTimeout),
+		WriteTimeout:      durationOrDefault(httpServerConfig.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       durationOrDefault(httpServerConfig.IdleTimeout, defaultIdleTimeout),
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(netListen)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownTimeout := durationOrDefault(httpServerConfig.ShutdownTimeout, defaultShutdownTimeout)
+	logger.Info("Shutting down, waiting for the requests being served", "timeout", shutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		httpServer.Close()
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Info("HTTP server stopped")
+	return nil
+}
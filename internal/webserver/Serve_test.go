@@ -0,0 +1,57 @@
+package webserver_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func TestServeDrainsRequestsOnShutdown(t *testing.T) {
+	netListen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestStarted := make(chan struct{})
+	httpHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requestStarted)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- webserver.Serve(ctx, &types.StdLogger{}, netListen, httpHandler, types.HttpServerType{})
+	}()
+
+	responseBody := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + netListen.Addr().String() + "/")
+		if err != nil {
+			responseBody <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		responseBody <- string(body)
+	}()
+
+	<-requestStarted
+	cancel()
+
+	if body := <-responseBody; body != "done" {
+		t.Fatalf("in-flight request not completed: %q", body)
+	}
+	if err := <-serveErr; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.DialTimeout("tcp", netListen.Addr().String(), time.Second); err == nil {
+		t.Fatal("listener still accepting connections")
+	}
+}
@@ -0,0 +1,53 @@
+package webserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+const requestIdHeader = "X-Request-Id"
+const requestIdKey = "request_id"
+
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// accessLog assigns a request ID, reusing the one sent by a proxy when valid,
+// and logs every request once it is served. The request context carries a
+// logger with the request ID for the CA operations.
+func accessLog(logger types.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now()
+
+		requestId := c.GetHeader(requestIdHeader)
+		if !validRequestId.MatchString(requestId) {
+			requestId = newRequestId()
+		}
+		c.Set(requestIdKey, requestId)
+		c.Header(requestIdHeader, requestId)
+
+		requestLogger := logger.With(requestIdKey, requestId)
+		c.Request = c.Request.WithContext(types.ContextWithLogger(c.Request.Context(), requestLogger))
+
+		c.Next()
+
+		requestLogger.Info(
+			"HTTP request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"size", c.Writer.Size(),
+			"latency", time.Since(startTime).String(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+func newRequestId() string {
+	randomBytes := make([]byte, 8)
+	rand.Read(randomBytes)
+	return hex.EncodeToString(randomBytes)
+}
@@ -0,0 +1,104 @@
+package webserver_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func TestAccessLogRequestId(t *testing.T) {
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": false}`))
+	}))
+	defer opaServer.Close()
+	opaUrl := opaServer.URL
+
+	logOutput := &bytes.Buffer{}
+	logger, err := types.NewLogger(logOutput, &types.LogConfigType{Level: "debug", Format: types.LogFormatJson})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDirectory := t.TempDir()
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		logger,
+		types.ConfigFileType{
+			DataDirectory: dataDirectory,
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"test_ca_1": {
+					Subject: types.CertificateAuthoritySubjectType{
+						CommonName: "test_ca_1",
+					},
+					KeyConfig: types.KeyConfigType{
+						Type: "ecdsa",
+						Config: types.KeyTypeEcdsaConfigType{
+							CurveName: "P-256",
+						},
+					},
+					CrlTtl:       12 * time.Hour,
+					OpaUrlSign:   &opaUrl,
+					OpaUrlRevoke: &opaUrl,
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/ca/test_ca_1/crt/revoke/12345", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-Id", "req-0001")
+	h.ServeHTTP(rr, req)
+	if rr.Result().StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status %d", rr.Result().StatusCode)
+	}
+	if requestId := rr.Result().Header.Get("X-Request-Id"); requestId != "req-0001" {
+		t.Fatalf("request ID not echoed: %q", requestId)
+	}
+
+	foundAccessLog := false
+	foundDenied := false
+	scanner := bufio.NewScanner(logOutput)
+	for scanner.Scan() {
+		var logLine map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &logLine); err != nil {
+			t.Fatalf("invalid JSON log line %q: %v", scanner.Text(), err)
+		}
+		if logLine["request_id"] != "req-0001" {
+			continue
+		}
+		switch logLine["msg"] {
+		case "HTTP request":
+			foundAccessLog = logLine["status"] == float64(http.StatusForbidden)
+		case "OPA denied the revoke request":
+			foundDenied = logLine["level"] == "INFO"
+		}
+	}
+	if !foundAccessLog || !foundDenied {
+		t.Fatalf("missing log lines with request ID:\n%s", logOutput.String())
+	}
+
+	auditContent, err := os.ReadFile(filepath.Join(dataDirectory, "test_ca_1", "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(auditContent), `"request_id":"req-0001"`) {
+		t.Fatalf("request ID missing from the audit log:\n%s", auditContent)
+	}
+}
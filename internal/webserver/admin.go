@@ -0,0 +1,66 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+// adminOpaClientId labels the admin OPA metrics, it is not a valid CA ID.
+const adminOpaClientId = "_admin"
+
+// adminAuthorize asks OPA whether the request may run an admin action and
+// returns the context identifying the administrator. The admin API is
+// disabled unless http_server.opa_url_admin is configured.
+func (handler *HandlerType) adminAuthorize(c *gin.Context, action string) (context.Context, bool) {
+	logger := types.LoggerFromContext(c.Request.Context(), handler.logger)
+
+	handler.mu.RLock()
+	adminOpaUrl := handler.adminOpaUrl
+	adminOpaClient := handler.adminOpaClient
+	handler.mu.RUnlock()
+	if adminOpaUrl == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "admin API disabled"})
+		return nil, false
+	}
+
+	opaInput := map[string]string{
+		"remote_addr":   c.Request.RemoteAddr,
+		"authorization": c.GetHeader("Authorization"),
+		"action":        action,
+	}
+	for _, param := range []string{"caId", "requestId"} {
+		if value := c.Param(param); value != "" {
+			opaInput[param] = value
+		}
+	}
+	opaDecision, err := adminOpaClient.query(c.Request.Context(), action, adminOpaUrl, opaInput)
+	if err != nil {
+		if errors.Is(err, ErrNotAuthorized) {
+			logger.Info("OPA denied the admin request", "action", action, "err", err)
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else {
+			logger.Error("OPA authorization check failed", "action", action, "err", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "unexpected error in authorization check"})
+		}
+		return nil, false
+	}
+	return requesterContext(c, opaDecision, nil), true
+}
+
+func (handler *HandlerType) AdminReload(c *gin.Context) {
+	if _, authorized := handler.adminAuthorize(c, "reload"); !authorized {
+		return
+	}
+	logger := types.LoggerFromContext(c.Request.Context(), handler.logger)
+	if err := handler.Reload(c.Request.Context()); err != nil {
+		logger.Error("Configuration reload failed", "err", err)
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Info("Configuration reloaded")
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,196 @@
+package webserver
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+const (
+	mimeTypePemFile  = "application/x-pem-file"
+	mimeTypePemChain = "application/pem-certificate-chain"
+	mimeTypePkixCert = "application/pkix-cert"
+	mimeTypePkcs7    = "application/pkcs7-mime"
+	mimeTypeXPkcs7   = "application/x-pkcs7-certificates"
+	mimeTypeJson     = "application/json"
+)
+
+// The formats of an issued certificate, selected with the format query
+// parameter or the Accept header.
+const (
+	certificateFormatPem         = "pem"
+	certificateFormatChain       = "chain"
+	certificateFormatDer         = "der"
+	certificateFormatPkcs7       = "p7b"
+	certificateFormatPkcs7Base64 = "p7b_base64"
+	certificateFormatJson        = "json"
+)
+
+var certificateFormats = []string{
+	certificateFormatPem,
+	certificateFormatChain,
+	certificateFormatDer,
+	certificateFormatPkcs7,
+	certificateFormatPkcs7Base64,
+	certificateFormatJson,
+}
+
+// certificateMimeTypes are matched against the Accept header, the first one
+// is used when the request has none.
+var certificateMimeTypes = []string{mimeTypePemFile, mimeTypePemChain, mimeTypePkixCert, mimeTypePkcs7, mimeTypeXPkcs7, mimeTypeJson}
+
+var certificateFormatByMimeType = map[string]string{
+	mimeTypePemFile:  certificateFormatPem,
+	mimeTypePemChain: certificateFormatChain,
+	mimeTypePkixCert: certificateFormatDer,
+	mimeTypePkcs7:    certificateFormatPkcs7,
+	mimeTypeXPkcs7:   certificateFormatPkcs7,
+	mimeTypeJson:     certificateFormatJson,
+}
+
+// certificateEnvelopeType is the JSON answer with everything a client needs
+// to install and later revoke its certificate.
+type certificateEnvelopeType struct {
+	Certificate string    `json:"certificate"`
+	Chain       []string  `json:"chain"`
+	Serial      string    `json:"serial"`
+	SerialHex   string    `json:"serial_hex"`
+	NotAfter    time.Time `json:"not_after"`
+	CrlUrl      string    `json:"crl_url"`
+	RevokeUrl   string    `json:"revoke_url"`
+
+	CrlDistributionPoints []string `json:"crl_distribution_points,omitempty"`
+}
+
+// negotiateCertificateFormat picks the format of the issued certificate,
+// the format query parameter wins over the Accept header. It answers 400 or
+// 406 and returns false when no format fits, before signing so that no
+// certificate is issued to a client that cannot read it.
+func negotiateCertificateFormat(c *gin.Context) (string, bool) {
+	if format := c.Query("format"); format != "" {
+		if !slices.Contains(certificateFormats, format) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown format %q", format), "formats": certificateFormats})
+			return "", false
+		}
+		return format, true
+	}
+	mimeType := c.NegotiateFormat(certificateMimeTypes...)
+	if mimeType == "" {
+		c.JSON(http.StatusNotAcceptable, gin.H{"error": "not acceptable", "accepted": certificateMimeTypes})
+		return "", false
+	}
+	return certificateFormatByMimeType[mimeType], true
+}
+
+// writeCertificate answers with the certificate in format, the chain is
+// made of the certificates above it up to the CA certificate.
+func (httpWrapper *httpWrapperType) writeCertificate(c *gin.Context, format string, pemBytes []byte) {
+	mimeType, content, err := httpWrapper.encodeCertificate(c, format, pemBytes)
+	if err != nil {
+		types.LoggerFromContext(c.Request.Context(), httpWrapper.logger).Error("Failed encoding certificate", "format", format, "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in encoding certificate"})
+		return
+	}
+	if format == certificateFormatPkcs7Base64 {
+		c.Header("Content-Transfer-Encoding", "base64")
+	}
+	c.Data(http.StatusOK, mimeType, content)
+}
+
+func (httpWrapper *httpWrapperType) encodeCertificate(c *gin.Context, format string, pemBytes []byte) (string, []byte, error) {
+	if format == certificateFormatPem {
+		return mimeTypePemFile, pemBytes, nil
+	}
+	certificate, err := pemhelper.FromPemToCertificate(pemBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	if format == certificateFormatDer {
+		return mimeTypePkixCert, certificate.Raw, nil
+	}
+	chain, err := httpWrapper.certificateChain()
+	if err != nil {
+		return "", nil, err
+	}
+	chainPem := [][]byte{}
+	for _, chainCertificate := range chain {
+		chainCertificatePem, err := pemhelper.ToPem(chainCertificate)
+		if err != nil {
+			return "", nil, err
+		}
+		chainPem = append(chainPem, chainCertificatePem)
+	}
+
+	switch format {
+	case certificateFormatChain:
+		return mimeTypePemChain, slices.Concat(append([][]byte{pemBytes}, chainPem...)...), nil
+	case certificateFormatPkcs7, certificateFormatPkcs7Base64:
+		pkcs7Content, err := pemhelper.ToPkcs7CertsOnly(append([]*x509.Certificate{certificate}, chain...)...)
+		if err != nil {
+			return "", nil, err
+		}
+		if format == certificateFormatPkcs7Base64 {
+			return mimeTypePkcs7, []byte(base64.StdEncoding.EncodeToString(pkcs7Content) + "\n"), nil
+		}
+		return mimeTypePkcs7, pkcs7Content, nil
+	case certificateFormatJson:
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		baseUrl := scheme + "://" + c.Request.Host + "/ca/" + httpWrapper.caId
+		envelope := certificateEnvelopeType{
+			Certificate: string(pemBytes),
+			Chain:       []string{},
+			Serial:      certificate.SerialNumber.String(),
+			SerialHex:   hex.EncodeToString(certificate.SerialNumber.Bytes()),
+			NotAfter:    certificate.NotAfter.UTC(),
+			CrlUrl:      baseUrl + "/crt/crl.pem",
+			RevokeUrl:   baseUrl + "/crt/revoke/" + certificate.SerialNumber.String(),
+
+			CrlDistributionPoints: certificate.CRLDistributionPoints,
+		}
+		for _, chainCertificatePem := range chainPem {
+			envelope.Chain = append(envelope.Chain, string(chainCertificatePem))
+		}
+		content, err := json.Marshal(envelope)
+		if err != nil {
+			return "", nil, err
+		}
+		return mimeTypeJson, content, nil
+	default:
+		return "", nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// certificateChain returns the certificates that complete the chain of an
+// issued certificate: the CA certificate, then the cross certificates with
+// include_in_chain.
+func (httpWrapper *httpWrapperType) certificateChain() ([]*x509.Certificate, error) {
+	issuerPem, err := httpWrapper.oneCa.GetIssuerPem()
+	if err != nil {
+		return nil, err
+	}
+	crossCertificatesPem, err := httpWrapper.oneCa.GetChainCrossCertificatesPem()
+	if err != nil {
+		return nil, err
+	}
+	chain := []*x509.Certificate{}
+	for _, certificatePem := range append([][]byte{issuerPem}, crossCertificatesPem...) {
+		certificate, err := pemhelper.FromPemToCertificate(certificatePem)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, certificate)
+	}
+	return chain, nil
+}
@@ -0,0 +1,201 @@
+package webserver_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func TestSignCsrFormats(t *testing.T) {
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer opaServer.Close()
+
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		&types.StdLogger{},
+		types.ConfigFileType{
+			DataDirectory: t.TempDir(),
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"test_ca": reloadTestCaConfig(opaServer.URL, 2048),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callWithQuery := func(query string, accept string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodPost, "http://ca.example.com/ca/test_ca/csr/sign"+query, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	call := func(accept string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		return callWithQuery("", accept, body)
+	}
+
+	csrPem := signRequestTestCsr(t, "www.example.com")
+	csrBlock, _ := pem.Decode(csrPem)
+
+	for name, body := range map[string][]byte{
+		"pem":             csrPem,
+		"pem_with_text":   append(append([]byte("Subject: www.example.com\n\n"), csrPem...), []byte("\n-----BEGIN COMMENT-----\n-----END COMMENT-----\n")...),
+		"new_request_pem": pem.EncodeToMemory(&pem.Block{Type: "NEW CERTIFICATE REQUEST", Bytes: csrBlock.Bytes}),
+		"der":             csrBlock.Bytes,
+		"base64_der":      []byte(base64.StdEncoding.EncodeToString(csrBlock.Bytes) + "\n"),
+	} {
+		rr := call("", body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d %s", name, rr.Code, rr.Body.String())
+		}
+		if contentType := rr.Header().Get("Content-Type"); contentType != "application/x-pem-file" {
+			t.Fatalf("%s: unexpected content type %s", name, contentType)
+		}
+		if _, err := pemhelper.FromPemToCertificate(rr.Body.Bytes()); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	rr := call("application/pkix-cert", csrPem)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/pkix-cert" {
+		t.Fatalf("DER: unexpected response %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	certificate, err := x509.ParseCertificate(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certificate.Subject.CommonName != "www.example.com" {
+		t.Fatalf("unexpected subject %s", certificate.Subject)
+	}
+
+	rr = call("text/html;q=0.9, application/pkcs7-mime", csrPem)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/pkcs7-mime" {
+		t.Fatalf("PKCS#7: unexpected response %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     struct {
+			Version          int
+			DigestAlgorithms asn1.RawValue
+			EncapContentInfo asn1.RawValue
+			Certificates     []asn1.RawValue `asn1:"tag:0"`
+		} `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(rr.Body.Bytes(), &contentInfo); err != nil {
+		t.Fatal(err)
+	}
+	if !contentInfo.ContentType.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}) || len(contentInfo.Content.Certificates) != 2 {
+		t.Fatalf("unexpected PKCS#7 %v with %d certificates", contentInfo.ContentType, len(contentInfo.Content.Certificates))
+	}
+
+	if rr := call("text/html", csrPem); rr.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406, got %d", rr.Code)
+	}
+	if rr := call("", []byte("-----BEGIN CERTIFICATE REQUEST-----\n-----END CERTIFICATE REQUEST-----\n")); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+
+	issuerPem, err := pemhelper.ToPem(issuerTestCertificate(t, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rr := range []*httptest.ResponseRecorder{
+		call("application/pem-certificate-chain", csrPem),
+		callWithQuery("?format=chain", "application/pkix-cert", csrPem),
+	} {
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/pem-certificate-chain" {
+			t.Fatalf("chain: unexpected response %d %s", rr.Code, rr.Header().Get("Content-Type"))
+		}
+		leafBlock, rest := pem.Decode(rr.Body.Bytes())
+		if leafBlock == nil || !bytes.Equal(rest, issuerPem) {
+			t.Fatalf("chain: unexpected content %s", rr.Body.String())
+		}
+	}
+
+	rr = callWithQuery("?format=p7b_base64", "", csrPem)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Transfer-Encoding") != "base64" {
+		t.Fatalf("PKCS#7 base64: unexpected response %d %v", rr.Code, rr.Header())
+	}
+	pkcs7Content, err := base64.StdEncoding.DecodeString(rr.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(pkcs7Content, &contentInfo); err != nil || len(contentInfo.Content.Certificates) != 2 {
+		t.Fatalf("PKCS#7 base64: %v", err)
+	}
+
+	rr = call("application/json", csrPem)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("JSON: unexpected response %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var envelope struct {
+		Certificate string    `json:"certificate"`
+		Chain       []string  `json:"chain"`
+		Serial      string    `json:"serial"`
+		SerialHex   string    `json:"serial_hex"`
+		NotAfter    time.Time `json:"not_after"`
+		CrlUrl      string    `json:"crl_url"`
+		RevokeUrl   string    `json:"revoke_url"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	certificate, err = pemhelper.FromPemToCertificate([]byte(envelope.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialHex, isInt := new(big.Int).SetString(envelope.SerialHex, 16)
+	if envelope.Serial != certificate.SerialNumber.String() || !isInt || serialHex.Cmp(certificate.SerialNumber) != 0 {
+		t.Fatalf("JSON: unexpected serial %s %s", envelope.Serial, envelope.SerialHex)
+	}
+	if len(envelope.Chain) != 1 || envelope.Chain[0] != string(issuerPem) || !envelope.NotAfter.Equal(certificate.NotAfter) {
+		t.Fatalf("JSON: unexpected envelope %#v", envelope)
+	}
+	if envelope.CrlUrl != "http://ca.example.com/ca/test_ca/crt/crl.pem" || envelope.RevokeUrl != "http://ca.example.com/ca/test_ca/crt/revoke/"+envelope.Serial {
+		t.Fatalf("JSON: unexpected URLs %s %s", envelope.CrlUrl, envelope.RevokeUrl)
+	}
+
+	if rr := callWithQuery("?format=xml", "", csrPem); rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format: expected 400, got %d", rr.Code)
+	}
+}
+
+func issuerTestCertificate(t *testing.T, h http.Handler) *x509.Certificate {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/ca/test_ca/issuer.pem", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(rr, req)
+	certificate, err := pemhelper.FromPemToCertificate(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
@@ -0,0 +1,73 @@
+package webserver_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func TestCrossCertificate(t *testing.T) {
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer opaServer.Close()
+
+	newRootConfig := reloadTestCaConfig(opaServer.URL, 2048)
+	newRootConfig.Subject.CommonName = "New Root"
+	newRootConfig.CrossSigning = []types.CrossSigningType{{Issuer: "old_root", IncludeInChain: true}}
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		&types.StdLogger{},
+		types.ConfigFileType{
+			DataDirectory: t.TempDir(),
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"old_root": reloadTestCaConfig(opaServer.URL, 2048),
+				"new_root": newRootConfig,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method string, path string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := call(http.MethodGet, "/ca/new_root/cross/old_root.pem", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	crossCertificatePem := rr.Body.Bytes()
+	crossCertificate, err := pemhelper.FromPemToCertificate(crossCertificatePem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crossCertificate.Subject.CommonName != "New Root" {
+		t.Fatalf("unexpected subject %s", crossCertificate.Subject)
+	}
+	for _, path := range []string{"/ca/new_root/cross/other.pem", "/ca/new_root/cross/old_root", "/ca/old_root/cross/new_root.pem"} {
+		if rr := call(http.MethodGet, path, nil); rr.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", path, rr.Code)
+		}
+	}
+
+	rr = call(http.MethodPost, "/ca/new_root/csr/sign?format=chain", signRequestTestCsr(t, "www.example.com"))
+	if rr.Code != http.StatusOK || !bytes.HasSuffix(rr.Body.Bytes(), crossCertificatePem) {
+		t.Fatalf("cross certificate not in chain: %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,76 @@
+package webserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const healthCheckTimeout = 10 * time.Second
+
+const healthCheckOpa = "opa"
+
+type healthCheckType struct {
+	httpWrappers func() []*httpWrapperType
+}
+
+type healthCaReportType struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+type healthReportType struct {
+	Status string                        `json:"status"`
+	Cas    map[string]healthCaReportType `json:"cas"`
+}
+
+// Healthz always answers 200 while the process is able to serve requests,
+// the report tells which CA is degraded.
+func (healthCheck *healthCheckType) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, healthCheck.report(c.Request.Context()))
+}
+
+// Readyz answers 503 when any CA is degraded.
+func (healthCheck *healthCheckType) Readyz(c *gin.Context) {
+	report := healthCheck.report(c.Request.Context())
+	if report.Status != "ok" {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (healthCheck *healthCheckType) report(ctx context.Context) healthReportType {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	report := healthReportType{
+		Status: "ok",
+		Cas:    map[string]healthCaReportType{},
+	}
+	for _, httpWrapper := range healthCheck.httpWrappers() {
+		checkErrors := httpWrapper.oneCa.SelfCheck(ctx)
+		checkErrors[healthCheckOpa] = httpWrapper.opaClient.checkReachable(ctx, httpWrapper.OpaUrlSign)
+
+		caReport := healthCaReportType{
+			Status: "ok",
+			Checks: map[string]string{},
+		}
+		for checkName, err := range checkErrors {
+			if err != nil {
+				httpWrapper.logger.Warn("Health check failed", "ca_id", httpWrapper.caId, "check", checkName, "err", err)
+				caReport.Status = "degraded"
+				caReport.Checks[checkName] = err.Error()
+			} else {
+				caReport.Checks[checkName] = "ok"
+			}
+		}
+		if caReport.Status != "ok" {
+			report.Status = "degraded"
+		}
+		report.Cas[httpWrapper.caId] = caReport
+	}
+	return report
+}
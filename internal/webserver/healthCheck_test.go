@@ -0,0 +1,109 @@
+package webserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+type healthTestReportType struct {
+	Status string `json:"status"`
+	Cas    map[string]struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	} `json:"cas"`
+}
+
+func healthTestGet(t *testing.T, h http.Handler, path string) (int, healthTestReportType) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(rr, req)
+	var report healthTestReportType
+	if err := json.NewDecoder(rr.Result().Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return rr.Result().StatusCode, report
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer opaServer.Close()
+	opaUrl := opaServer.URL + "/v1/data/simple_ca/allow"
+
+	dataDirectory := t.TempDir()
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		&types.StdLogger{},
+		types.ConfigFileType{
+			DataDirectory: dataDirectory,
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"test_ca_1": {
+					Subject: types.CertificateAuthoritySubjectType{
+						CommonName: "test_ca_1",
+					},
+					Validity: types.CertificateAuthorityValidityType{
+						Years: 1,
+					},
+					KeyConfig: types.KeyConfigType{
+						Type: "ecdsa",
+						Config: types.KeyTypeEcdsaConfigType{
+							CurveName: "P-256",
+						},
+					},
+					CrlTtl:       12 * time.Hour,
+					OpaUrlSign:   &opaUrl,
+					OpaUrlRevoke: &opaUrl,
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if statusCode, report := healthTestGet(t, h, "/readyz"); statusCode != http.StatusOK || report.Status != "ok" {
+		t.Fatalf("expected ready, got %d %+v", statusCode, report)
+	}
+
+	// A file left behind by an interrupted operation makes the CA degraded.
+	if err := os.WriteFile(filepath.Join(dataDirectory, "test_ca_1", "data", "stray.txt"), []byte("x"), os.FileMode(0o644)); err != nil {
+		t.Fatal(err)
+	}
+	statusCode, report := healthTestGet(t, h, "/readyz")
+	if statusCode != http.StatusServiceUnavailable || report.Cas["test_ca_1"].Checks["storage"] == "ok" {
+		t.Fatalf("expected not ready because of storage, got %d %+v", statusCode, report)
+	}
+	if statusCode, report := healthTestGet(t, h, "/healthz"); statusCode != http.StatusOK || report.Status != "degraded" {
+		t.Fatalf("expected degraded liveness report, got %d %+v", statusCode, report)
+	}
+}
+
+func TestReadinessFailsWithoutOpa(t *testing.T) {
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	opaUrl := opaServer.URL
+	opaServer.Close()
+
+	h := createHandlerWithOpa(t, opaUrl, nil)
+	statusCode, report := healthTestGet(t, h, "/readyz")
+	if statusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected not ready, got %d", statusCode)
+	}
+	if report.Cas["test_ca_1"].Checks["opa"] == "ok" {
+		t.Fatalf("expected OPA check failure: %+v", report)
+	}
+}
@@ -0,0 +1,60 @@
+package webserver_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": false}`))
+	}))
+	defer opaServer.Close()
+
+	h := createHandlerWithOpa(t, opaServer.URL, nil)
+
+	{
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodPost, "/ca/test_ca_1/csr/sign", bytes.NewBufferString("not a csr"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.ServeHTTP(rr, req)
+		if rr.Result().StatusCode != http.StatusForbidden {
+			t.Fatalf("expected denied request, got %d", rr.Result().StatusCode)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(rr, req)
+	if rr.Result().StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", rr.Result().StatusCode)
+	}
+	body, err := io.ReadAll(rr.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`simple_ca_sign_denied_total{ca_id="test_ca_1"}`,
+		`simple_ca_opa_decisions_total{action="sign",ca_id="test_ca_1",outcome="denied"}`,
+		`simple_ca_certificate_not_after_timestamp_seconds{ca_id="test_ca_1"}`,
+		`simple_ca_crl_next_update_timestamp_seconds{ca_id="test_ca_1"}`,
+		`simple_ca_crl_revoked_entries{ca_id="test_ca_1"} 0`,
+		`simple_ca_csr_queue_depth{ca_id="test_ca_1"} 0`,
+		`simple_ca_status_error{ca_id="test_ca_1"} 0`,
+		`simple_ca_git_commit_duration_seconds_count{ca_id="test_ca_1"}`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("missing %s", expected)
+		}
+	}
+}
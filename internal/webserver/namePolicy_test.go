@@ -0,0 +1,78 @@
+package webserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func TestSignCsrNamePolicy(t *testing.T) {
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer opaServer.Close()
+
+	caConfig := reloadTestCaConfig(opaServer.URL, 2048)
+	caConfig.Profiles = map[string]types.ProfileType{
+		"server": {
+			SanRules: &types.SanRulesType{RequireCnInSan: true},
+		},
+	}
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		&types.StdLogger{},
+		types.ConfigFileType{
+			DataDirectory: t.TempDir(),
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"test_ca": caConfig,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(query string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodPost, "http://ca.example.com/ca/test_ca/csr/sign"+query, bytes.NewReader(signRequestTestCsr(t, "www.example.com")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := call(""); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 without profile, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr := call("?profile=server")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Error      string `json:"error"`
+		Violations []struct {
+			Name   string `json:"name"`
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"violations"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Violations) != 1 ||
+		response.Violations[0].Name != "www.example.com" ||
+		response.Violations[0].Type != "common_name" ||
+		response.Violations[0].Reason == "" {
+		t.Fatalf("unexpected violations %s", rr.Body.String())
+	}
+}
@@ -3,58 +3,367 @@ package webserver
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"sync"
 	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/metrics"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+var ErrNotAuthorized = fmt.Errorf("not authorized")
+var ErrOpaCircuitOpen = fmt.Errorf("OPA circuit breaker open")
+
+const (
+	opaDefaultTimeout                 = 5 * time.Second
+	opaDefaultRetries                 = 2
+	opaDefaultRetryBackoff            = 200 * time.Millisecond
+	opaDefaultCircuitBreakerThreshold = 5
+	opaDefaultCircuitBreakerCooldown  = 30 * time.Second
 )
 
-var opaHTTPClient = &http.Client{
-	Timeout: 5 * time.Second,
+type opaDecisionType struct {
+	Allowed  bool
+	Identity string
+	// Pending asks an administrator to approve the request.
+	Pending bool
+	// SubjectValues are given to the subject template of the profile.
+	SubjectValues map[string]string
 }
 
-var ErrNotAuthorized = fmt.Errorf("not authorized")
+type opaCacheEntryType struct {
+	decision  opaDecisionType
+	expiresAt time.Time
+}
+
+type opaClientType struct {
+	caId       string
+	httpClient *http.Client
+
+	retries      int
+	retryBackoff time.Duration
+
+	circuitBreakerThreshold int
+	circuitBreakerCooldown  time.Duration
+
+	cacheTtl    time.Duration
+	bearerToken string
+
+	mu                  sync.Mutex
+	consecutiveFailures int
+	circuitOpenUntil    time.Time
+	cache               map[string]opaCacheEntryType
+}
+
+func newOpaClient(caId string, config *types.OpaClientType) (*opaClientType, error) {
+	if config == nil {
+		config = &types.OpaClientType{}
+	}
+	opaClient := &opaClientType{
+		caId:                    caId,
+		retries:                 opaDefaultRetries,
+		retryBackoff:            opaDefaultRetryBackoff,
+		circuitBreakerThreshold: opaDefaultCircuitBreakerThreshold,
+		circuitBreakerCooldown:  opaDefaultCircuitBreakerCooldown,
+		cacheTtl:                config.CacheTtl,
+		cache:                   map[string]opaCacheEntryType{},
+	}
+	timeout := opaDefaultTimeout
+	if config.Timeout > 0 {
+		timeout = config.Timeout
+	}
+	if config.Retries != nil {
+		if *config.Retries < 0 {
+			return nil, fmt.Errorf("invalid OPA retries for CA %q: %d", caId, *config.Retries)
+		}
+		opaClient.retries = *config.Retries
+	}
+	if config.RetryBackoff > 0 {
+		opaClient.retryBackoff = config.RetryBackoff
+	}
+	if config.CircuitBreakerThreshold < 0 {
+		return nil, fmt.Errorf("invalid OPA circuit breaker threshold for CA %q: %d", caId, config.CircuitBreakerThreshold)
+	} else if config.CircuitBreakerThreshold > 0 {
+		opaClient.circuitBreakerThreshold = config.CircuitBreakerThreshold
+	}
+	if config.CircuitBreakerCooldown > 0 {
+		opaClient.circuitBreakerCooldown = config.CircuitBreakerCooldown
+	}
+	if config.BearerToken != nil {
+		opaClient.bearerToken = *config.BearerToken
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.CaBundleFile != nil {
+		caBundleBytes, err := os.ReadFile(*config.CaBundleFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading OPA CA bundle for CA %q: %w", caId, err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caBundleBytes) {
+			return nil, fmt.Errorf("no certificates found in OPA CA bundle %s", *config.CaBundleFile)
+		}
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    rootCAs,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	opaClient.httpClient = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+	return opaClient, nil
+}
 
 func (httpWrapper *httpWrapperType) opaWrapper(
 	ctx context.Context,
+	action string,
 	opaUrl string,
 	data map[string]string,
-) error {
+) (opaDecisionType, error) {
+	return httpWrapper.opaClient.query(ctx, action, opaUrl, data)
+}
+
+func (opaClient *opaClientType) query(
+	ctx context.Context,
+	action string,
+	opaUrl string,
+	data map[string]string,
+) (opaDecisionType, error) {
 	input := map[string]any{
 		"input": data,
 	}
 
 	body, err := json.Marshal(input)
 	if err != nil {
-		return fmt.Errorf("failed to marshal OPA input: %w", err)
+		return opaDecisionType{}, fmt.Errorf("failed to marshal OPA input: %w", err)
+	}
+
+	cacheKey := ""
+	if opaClient.cacheTtl > 0 {
+		inputHash := sha256.Sum256(append([]byte(opaUrl+"\n"), body...))
+		cacheKey = hex.EncodeToString(inputHash[:])
+		if decision, found := opaClient.cacheLookup(cacheKey); found {
+			metrics.OpaCacheHitsTotal.WithLabelValues(opaClient.caId, action).Inc()
+			if !decision.Allowed {
+				return decision, ErrNotAuthorized
+			}
+			return decision, nil
+		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, opaUrl, bytes.NewBuffer(body))
+	if !opaClient.circuitAllow() {
+		metrics.OpaDecisionsTotal.WithLabelValues(opaClient.caId, action, "circuit_open").Inc()
+		return opaDecisionType{}, ErrOpaCircuitOpen
+	}
+
+	startTime := time.Now()
+	decision, err := opaClient.queryWithRetries(ctx, opaUrl, body)
+	metrics.OpaDecisionDuration.WithLabelValues(opaClient.caId, action).Observe(time.Since(startTime).Seconds())
+	opaClient.circuitRecord(err == nil)
 	if err != nil {
-		return fmt.Errorf("failed to create OPA request: %w", err)
+		metrics.OpaDecisionsTotal.WithLabelValues(opaClient.caId, action, "error").Inc()
+		return decision, err
+	}
+
+	if cacheKey != "" {
+		opaClient.cacheStore(cacheKey, decision)
+	}
+
+	if !decision.Allowed {
+		metrics.OpaDecisionsTotal.WithLabelValues(opaClient.caId, action, "denied").Inc()
+		return decision, ErrNotAuthorized
+	}
+	metrics.OpaDecisionsTotal.WithLabelValues(opaClient.caId, action, "allowed").Inc()
+	return decision, nil
+}
+
+func (opaClient *opaClientType) queryWithRetries(
+	ctx context.Context,
+	opaUrl string,
+	body []byte,
+) (opaDecisionType, error) {
+	backoff := opaClient.retryBackoff
+	var lastErr error
+	for attempt := 0; attempt <= opaClient.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return opaDecisionType{}, errors.Join(lastErr, ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		decision, retryable, err := opaClient.queryOnce(ctx, opaUrl, body)
+		if err == nil {
+			return decision, nil
+		}
+		lastErr = err
+		if !retryable {
+			break
+		}
+	}
+	return opaDecisionType{}, lastErr
+}
+
+func (opaClient *opaClientType) queryOnce(
+	ctx context.Context,
+	opaUrl string,
+	body []byte,
+) (opaDecisionType, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, opaUrl, bytes.NewReader(body))
+	if err != nil {
+		return opaDecisionType{}, false, fmt.Errorf("failed to create OPA request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if opaClient.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+opaClient.bearerToken)
+	}
 
-	resp, err := opaHTTPClient.Do(req)
+	resp, err := opaClient.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to request OPA: %w", err)
+		return opaDecisionType{}, ctx.Err() == nil, fmt.Errorf("failed to request OPA: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("OPA returned unexpected status: %s", resp.Status)
+		io.Copy(io.Discard, resp.Body)
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return opaDecisionType{}, retryable, fmt.Errorf("OPA returned unexpected status: %s", resp.Status)
 	}
 
 	var result struct {
-		Result bool `json:"result"`
+		Result json.RawMessage `json:"result"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return fmt.Errorf("failed to decode OPA response: %w", err)
+		return opaDecisionType{}, false, fmt.Errorf("failed to decode OPA response: %w", err)
+	}
+
+	decision, err := parseOpaResult(result.Result)
+	if err != nil {
+		return opaDecisionType{}, false, err
 	}
+	return decision, false, nil
+}
 
-	if !result.Result {
-		return ErrNotAuthorized
+// parseOpaResult accepts either a plain boolean or an object such as
+// {"allow": true, "identity": "team-a", "pending": true, "subject_values":
+// {"team": "a"}} as the policy result.
+func parseOpaResult(rawResult json.RawMessage) (opaDecisionType, error) {
+	if len(rawResult) == 0 {
+		return opaDecisionType{}, nil
+	}
+	var allowed bool
+	if err := json.Unmarshal(rawResult, &allowed); err == nil {
+		return opaDecisionType{Allowed: allowed}, nil
+	}
+	var resultObject struct {
+		Allow         bool              `json:"allow"`
+		Identity      string            `json:"identity"`
+		Pending       bool              `json:"pending"`
+		SubjectValues map[string]string `json:"subject_values"`
 	}
+	if err := json.Unmarshal(rawResult, &resultObject); err != nil {
+		return opaDecisionType{}, fmt.Errorf("failed to decode OPA result: %w", err)
+	}
+	return opaDecisionType{
+		Allowed:       resultObject.Allow,
+		Identity:      resultObject.Identity,
+		Pending:       resultObject.Pending,
+		SubjectValues: resultObject.SubjectValues,
+	}, nil
+}
 
+// circuitAllow reports whether a request may be sent to OPA. Once the cooldown
+// has elapsed a single trial request is let through (half-open state).
+func (opaClient *opaClientType) circuitAllow() bool {
+	opaClient.mu.Lock()
+	defer opaClient.mu.Unlock()
+	if opaClient.consecutiveFailures < opaClient.circuitBreakerThreshold {
+		return true
+	}
+	if time.Now().Before(opaClient.circuitOpenUntil) {
+		return false
+	}
+	opaClient.circuitOpenUntil = time.Now().Add(opaClient.circuitBreakerCooldown)
+	return true
+}
+
+func (opaClient *opaClientType) circuitRecord(success bool) {
+	opaClient.mu.Lock()
+	defer opaClient.mu.Unlock()
+	if success {
+		opaClient.consecutiveFailures = 0
+		return
+	}
+	opaClient.consecutiveFailures++
+	if opaClient.consecutiveFailures >= opaClient.circuitBreakerThreshold {
+		opaClient.circuitOpenUntil = time.Now().Add(opaClient.circuitBreakerCooldown)
+	}
+}
+
+func (opaClient *opaClientType) cacheLookup(cacheKey string) (opaDecisionType, bool) {
+	opaClient.mu.Lock()
+	defer opaClient.mu.Unlock()
+	entry, found := opaClient.cache[cacheKey]
+	if !found {
+		return opaDecisionType{}, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(opaClient.cache, cacheKey)
+		return opaDecisionType{}, false
+	}
+	return entry.decision, true
+}
+
+func (opaClient *opaClientType) cacheStore(cacheKey string, decision opaDecisionType) {
+	opaClient.mu.Lock()
+	defer opaClient.mu.Unlock()
+	now := time.Now()
+	for k, entry := range opaClient.cache {
+		if now.After(entry.expiresAt) {
+			delete(opaClient.cache, k)
+		}
+	}
+	opaClient.cache[cacheKey] = opaCacheEntryType{
+		decision:  decision,
+		expiresAt: now.Add(opaClient.cacheTtl),
+	}
+}
+
+// checkReachable queries the OPA health API on the server of opaUrl, without
+// affecting the circuit breaker.
+func (opaClient *opaClientType) checkReachable(ctx context.Context, opaUrl string) error {
+	parsedUrl, err := url.Parse(opaUrl)
+	if err != nil {
+		return fmt.Errorf("invalid OPA URL: %w", err)
+	}
+	healthUrl := url.URL{Scheme: parsedUrl.Scheme, Host: parsedUrl.Host, Path: "/health"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthUrl.String(), nil)
+	if err != nil {
+		return err
+	}
+	if opaClient.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+opaClient.bearerToken)
+	}
+	resp, err := opaClient.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to request OPA: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OPA health returned unexpected status: %s", resp.Status)
+	}
 	return nil
 }
@@ -0,0 +1,136 @@
+package webserver_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func createHandlerWithOpa(t *testing.T, opaUrl string, opaClient *types.OpaClientType) http.Handler {
+	t.Helper()
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		&types.StdLogger{},
+		types.ConfigFileType{
+			DataDirectory: t.TempDir(),
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"test_ca_1": {
+					Subject: types.CertificateAuthoritySubjectType{
+						CommonName: "test_ca_1",
+					},
+					KeyConfig: types.KeyConfigType{
+						Type: "ecdsa",
+						Config: types.KeyTypeEcdsaConfigType{
+							CurveName: "P-256",
+						},
+					},
+					CrlTtl:       12 * time.Hour,
+					OpaUrlSign:   &opaUrl,
+					OpaUrlRevoke: &opaUrl,
+					OpaClient:    opaClient,
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func revokeUnknownSerial(t *testing.T, h http.Handler) int {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/ca/test_ca_1/crt/revoke/12345", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(rr, req)
+	return rr.Result().StatusCode
+}
+
+func TestOpaRetryAfterFailure(t *testing.T) {
+	var calls atomic.Int32
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer opaServer.Close()
+
+	bearerToken := "secret-token"
+	retries := 1
+	h := createHandlerWithOpa(t, opaServer.URL, &types.OpaClientType{
+		Retries:      &retries,
+		RetryBackoff: time.Millisecond,
+		BearerToken:  &bearerToken,
+	})
+
+	if statusCode := revokeUnknownSerial(t, h); statusCode != http.StatusNotFound {
+		t.Fatalf("invalid status code %d", statusCode)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected 2 OPA calls, got %d", n)
+	}
+}
+
+func TestOpaCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer opaServer.Close()
+
+	retries := 0
+	h := createHandlerWithOpa(t, opaServer.URL, &types.OpaClientType{
+		Retries:                 &retries,
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  time.Hour,
+	})
+
+	for i := 0; i < 4; i++ {
+		if statusCode := revokeUnknownSerial(t, h); statusCode != http.StatusServiceUnavailable {
+			t.Fatalf("invalid status code %d", statusCode)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected 2 OPA calls, got %d", n)
+	}
+}
+
+func TestOpaDecisionCache(t *testing.T) {
+	var calls atomic.Int32
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": false}`))
+	}))
+	defer opaServer.Close()
+
+	h := createHandlerWithOpa(t, opaServer.URL, &types.OpaClientType{
+		CacheTtl: time.Minute,
+	})
+
+	for i := 0; i < 3; i++ {
+		if statusCode := revokeUnknownSerial(t, h); statusCode != http.StatusForbidden {
+			t.Fatalf("invalid status code %d", statusCode)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected 1 OPA call, got %d", n)
+	}
+}
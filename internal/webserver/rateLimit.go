@@ -0,0 +1,112 @@
+package webserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+type tokenBucketType struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// rateLimiterType is a token bucket for each key.
+type rateLimiterType struct {
+	tokensPerSecond float64
+	burst           float64
+	now             func() time.Time
+
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucketType
+	lastCleanup time.Time
+}
+
+// newRateLimiter returns nil when config does not limit anything.
+func newRateLimiter(config *types.RateLimitType) *rateLimiterType {
+	if config == nil || config.Requests <= 0 || config.Per <= 0 {
+		return nil
+	}
+	burst := config.Burst
+	if burst <= 0 {
+		burst = config.Requests
+	}
+	return &rateLimiterType{
+		tokensPerSecond: float64(config.Requests) / config.Per.Seconds(),
+		burst:           float64(burst),
+		now:             time.Now,
+		buckets:         map[string]*tokenBucketType{},
+	}
+}
+
+// allow takes a token from the bucket of key, when it is empty it returns how
+// long until the next token.
+func (rateLimiter *rateLimiterType) allow(key string) (bool, time.Duration) {
+	if rateLimiter == nil {
+		return true, 0
+	}
+	rateLimiter.mu.Lock()
+	defer rateLimiter.mu.Unlock()
+
+	now := rateLimiter.now()
+	rateLimiter.cleanup(now)
+	bucket, found := rateLimiter.buckets[key]
+	if !found {
+		bucket = &tokenBucketType{tokens: rateLimiter.burst, updatedAt: now}
+		rateLimiter.buckets[key] = bucket
+	}
+	bucket.tokens = rateLimiter.refill(bucket, now)
+	bucket.updatedAt = now
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / rateLimiter.tokensPerSecond * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+func (rateLimiter *rateLimiterType) refill(bucket *tokenBucketType, now time.Time) float64 {
+	elapsed := now.Sub(bucket.updatedAt).Seconds()
+	return math.Min(rateLimiter.burst, bucket.tokens+elapsed*rateLimiter.tokensPerSecond)
+}
+
+// cleanup forgets the buckets that are full again, which behave as new ones,
+// so that the map does not grow with every requester ever seen.
+func (rateLimiter *rateLimiterType) cleanup(now time.Time) {
+	fullRefill := time.Duration(rateLimiter.burst / rateLimiter.tokensPerSecond * float64(time.Second))
+	if now.Sub(rateLimiter.lastCleanup) < fullRefill {
+		return
+	}
+	rateLimiter.lastCleanup = now
+	for key, bucket := range rateLimiter.buckets {
+		if rateLimiter.refill(bucket, now) >= rateLimiter.burst {
+			delete(rateLimiter.buckets, key)
+		}
+	}
+}
+
+// clientKey identifies the requester for the rate limit checked before
+// authorization by what it cannot choose freely: its verified TLS client
+// certificate, else its IP address. The Authorization header is not used, a
+// requester could send a new one with each request to get a new bucket.
+func clientKey(c *gin.Context) string {
+	if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+		return "client-certificate-sha256:" + shortSha256(c.Request.TLS.VerifiedChains[0][0].Raw)
+	}
+	remoteIp, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		remoteIp = c.Request.RemoteAddr
+	}
+	return "ip:" + remoteIp
+}
+
+func shortSha256(content []byte) string {
+	contentHash := sha256.Sum256(content)
+	return hex.EncodeToString(contentHash[:8])
+}
@@ -0,0 +1,86 @@
+package webserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func TestRateLimits(t *testing.T) {
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input map[string]string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{
+			"allow":    true,
+			"identity": body.Input["authorization"],
+		}})
+	}))
+	defer opaServer.Close()
+
+	caConfig := reloadTestCaConfig(opaServer.URL, 2048)
+	caConfig.Limits = &types.LimitsType{
+		Ca:        &types.RateLimitType{Requests: 4, Per: time.Hour},
+		Requester: &types.RateLimitType{Requests: 2, Per: time.Hour},
+	}
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		&types.StdLogger{},
+		types.ConfigFileType{
+			DataDirectory: t.TempDir(),
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"test_ca_1": caConfig,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoke := func(remoteAddr string, authorization string) *http.Response {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodPost, "/ca/test_ca_1/crt/revoke/12345", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", authorization)
+		h.ServeHTTP(rr, req)
+		return rr.Result()
+	}
+
+	for _, step := range []struct {
+		remoteAddr    string
+		authorization string
+		statusCode    int
+	}{
+		{"192.0.2.1:1234", "Bearer a", http.StatusNotFound},
+		{"192.0.2.1:1234", "Bearer b", http.StatusNotFound},
+		// over the limit of the address, whatever the Authorization header
+		{"192.0.2.1:1234", "Bearer c", http.StatusTooManyRequests},
+		{"192.0.2.2:1234", "Bearer a", http.StatusNotFound},
+		// over the limit of the identity returned by OPA, from another address
+		{"192.0.2.3:1234", "Bearer a", http.StatusTooManyRequests},
+		// over the limit of the CA
+		{"192.0.2.3:1234", "Bearer d", http.StatusTooManyRequests},
+	} {
+		resp := revoke(step.remoteAddr, step.authorization)
+		if resp.StatusCode != step.statusCode {
+			t.Fatalf("%s %s: expected %d, got %d", step.remoteAddr, step.authorization, step.statusCode, resp.StatusCode)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+			t.Fatal("missing Retry-After header")
+		}
+	}
+}
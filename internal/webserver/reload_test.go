@@ -0,0 +1,143 @@
+package webserver_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func reloadTestCaConfig(opaUrl string, keySize int) types.CertificateAuthorityType {
+	return types.CertificateAuthorityType{
+		Subject: types.CertificateAuthoritySubjectType{
+			CommonName: "test_ca",
+		},
+		Validity: types.CertificateAuthorityValidityType{
+			Days: 1,
+		},
+		KeyConfig: types.KeyConfigType{
+			Type: "rsa",
+			Config: types.KeyTypeRsaConfigType{
+				Size: keySize,
+			},
+		},
+		CrlTtl:       12 * time.Hour,
+		OpaUrlSign:   &opaUrl,
+		OpaUrlRevoke: &opaUrl,
+	}
+}
+
+func reloadTestStatus(t *testing.T, h http.Handler, method string, path string, authorization string) int {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	h.ServeHTTP(rr, req)
+	return rr.Result().StatusCode
+}
+
+func reloadTestCrl(t *testing.T, h http.Handler, caId string) []byte {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/ca/"+caId+"/crt/crl.pem", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	return rr.Body.Bytes()
+}
+
+func TestReloadAddsCaAndRefusesKeyChange(t *testing.T) {
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer opaServer.Close()
+	adminOpaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": false}`))
+	}))
+	defer adminOpaServer.Close()
+	adminOpaUrl := adminOpaServer.URL
+
+	configFile := types.ConfigFileType{
+		DataDirectory: t.TempDir(),
+		HttpServer: &types.HttpServerType{
+			OpaUrlAdmin: &adminOpaUrl,
+		},
+		AllCaConfigs: map[string]types.CertificateAuthorityType{
+			"test_ca_1": reloadTestCaConfig(opaServer.URL, 2048),
+		},
+	}
+	h, err := webserver.CreateHandler(context.Background(), &types.StdLogger{}, configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextConfigFile := configFile
+	h.SetConfigLoader(func() (types.ConfigFileType, error) {
+		return nextConfigFile, nil
+	})
+
+	if statusCode := reloadTestStatus(t, h, http.MethodGet, "/ca/test_ca_2/issuer.pem", ""); statusCode != http.StatusNotFound {
+		t.Fatalf("unexpected CA, got %d", statusCode)
+	}
+	crlBeforeReload := reloadTestCrl(t, h, "test_ca_1")
+
+	nextConfigFile = types.ConfigFileType{
+		DataDirectory: configFile.DataDirectory,
+		HttpServer:    configFile.HttpServer,
+		AllCaConfigs: map[string]types.CertificateAuthorityType{
+			"test_ca_1": reloadTestCaConfig(opaServer.URL, 2048),
+			"test_ca_2": reloadTestCaConfig(opaServer.URL, 2048),
+		},
+	}
+	// OPA denies the admin request, so the new CA is not loaded.
+	if statusCode := reloadTestStatus(t, h, http.MethodPost, "/admin/reload", "Bearer x"); statusCode != http.StatusForbidden {
+		t.Fatalf("expected denied reload, got %d", statusCode)
+	}
+	if err := h.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if statusCode := reloadTestStatus(t, h, http.MethodGet, "/ca/test_ca_2/issuer.pem", ""); statusCode != http.StatusOK {
+		t.Fatalf("new CA not served, got %d", statusCode)
+	}
+	// The CA whose configuration did not change is not loaded again.
+	if crl := reloadTestCrl(t, h, "test_ca_1"); !bytes.Equal(crl, crlBeforeReload) {
+		t.Fatal("CRL of an unchanged CA issued again by the reload")
+	}
+
+	nextConfigFile = types.ConfigFileType{
+		DataDirectory: configFile.DataDirectory,
+		HttpServer:    configFile.HttpServer,
+		AllCaConfigs: map[string]types.CertificateAuthorityType{
+			"test_ca_1": reloadTestCaConfig(opaServer.URL, 3072),
+		},
+	}
+	if err := h.Reload(context.Background()); !errors.Is(err, types.ErrUnsupportedChangeToKeySize) {
+		t.Fatalf("expected refused key change, got %v", err)
+	}
+	// The previous configuration is still served.
+	if statusCode := reloadTestStatus(t, h, http.MethodGet, "/ca/test_ca_2/issuer.pem", ""); statusCode != http.StatusOK {
+		t.Fatalf("previous configuration not kept, got %d", statusCode)
+	}
+
+	nextConfigFile = configFile
+	nextConfigFile.DataDirectory = t.TempDir()
+	if err := h.Reload(context.Background()); !errors.Is(err, webserver.ErrUnsafeConfigChange) {
+		t.Fatalf("expected refused data directory change, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+// signRequestViewType is what the requester sees of its sign request, the
+// requester details are only shown to administrators.
+type signRequestViewType struct {
+	Id          string     `json:"id"`
+	Status      string     `json:"status"`
+	Profile     string     `json:"profile,omitempty"`
+	Subject     string     `json:"subject"`
+	RequestedAt time.Time  `json:"requested_at"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	Serial      string     `json:"serial,omitempty"`
+	Certificate string     `json:"certificate,omitempty"`
+}
+
+func (httpWrapper *httpWrapperType) submitSignRequest(ctx context.Context, c *gin.Context, csrContent []byte, profile string, subjectValues map[string]string) {
+	logger := types.LoggerFromContext(ctx, httpWrapper.logger)
+	signRequest, err := httpWrapper.oneCa.SubmitSignRequest(ctx, csrContent, profile, subjectValues)
+	if err != nil {
+		if errors.Is(err, caissuingprocess.ErrInvalidCsr) {
+			writeInvalidCsr(c, err)
+			return
+		}
+		logger.Error("Failed queuing sign request", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in queuing sign request"})
+		return
+	}
+	logger.Info("Sign request waiting for approval", "ca_id", httpWrapper.caId, "sign_request_id", signRequest.Id)
+	c.Header("Location", "/ca/"+httpWrapper.caId+"/requests/"+signRequest.Id)
+	c.JSON(http.StatusAccepted, gin.H{"id": signRequest.Id, "status": signRequest.Status})
+}
+
+// SignRequestStatus lets the requester poll its sign request, the request ID
+// is random and only known to the requester.
+func (httpWrapper *httpWrapperType) SignRequestStatus(c *gin.Context) {
+	logger := types.LoggerFromContext(c.Request.Context(), httpWrapper.logger)
+	signRequest, err := httpWrapper.oneCa.SignRequest(c.Param("requestId"))
+	if err != nil {
+		if errors.Is(err, caissuingprocess.ErrUnknownSignRequest) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sign request not found"})
+			return
+		}
+		logger.Error("Failed reading sign request", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in reading sign request"})
+		return
+	}
+	signRequestView := signRequestViewType{
+		Id:          signRequest.Id,
+		Status:      signRequest.Status,
+		Profile:     signRequest.Profile,
+		Subject:     signRequest.Subject,
+		RequestedAt: signRequest.RequestedAt,
+		DecidedAt:   signRequest.DecidedAt,
+		Reason:      signRequest.Reason,
+		Serial:      signRequest.Serial,
+	}
+	if signRequest.Status == caissuingprocess.SignRequestApproved {
+		serialNumber, _ := new(big.Int).SetString(signRequest.Serial, 10)
+		certificatePem, err := httpWrapper.oneCa.GetCertificatePem(serialNumber)
+		if err != nil {
+			logger.Error("Failed reading certificate of sign request", "serial", signRequest.Serial, "err", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in reading certificate"})
+			return
+		}
+		signRequestView.Certificate = string(certificatePem)
+	}
+	c.JSON(http.StatusOK, signRequestView)
+}
+
+func (handler *HandlerType) AdminListSignRequests(httpWrapper *httpWrapperType, c *gin.Context) {
+	if _, authorized := handler.adminAuthorize(c, "requests_list"); !authorized {
+		return
+	}
+	status := c.DefaultQuery("status", caissuingprocess.SignRequestPending)
+	if status == "all" {
+		status = ""
+	}
+	signRequests, err := httpWrapper.oneCa.ListSignRequests(status)
+	if err != nil {
+		types.LoggerFromContext(c.Request.Context(), handler.logger).Error("Failed listing sign requests", "ca_id", httpWrapper.caId, "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in listing sign requests"})
+		return
+	}
+	c.JSON(http.StatusOK, signRequests)
+}
+
+func (handler *HandlerType) AdminApproveSignRequest(httpWrapper *httpWrapperType, c *gin.Context) {
+	ctx, authorized := handler.adminAuthorize(c, "request_approve")
+	if !authorized {
+		return
+	}
+	signRequest, err := httpWrapper.oneCa.ApproveSignRequest(ctx, c.Param("requestId"))
+	if err != nil {
+		handler.signRequestError(c, "Failed approving sign request", err)
+		return
+	}
+	types.LoggerFromContext(ctx, handler.logger).Info("Sign request approved", "ca_id", httpWrapper.caId, "sign_request_id", signRequest.Id, "serial", signRequest.Serial)
+	c.JSON(http.StatusOK, signRequest)
+}
+
+func (handler *HandlerType) AdminRejectSignRequest(httpWrapper *httpWrapperType, c *gin.Context) {
+	ctx, authorized := handler.adminAuthorize(c, "request_reject")
+	if !authorized {
+		return
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+			return
+		}
+	}
+	signRequest, err := httpWrapper.oneCa.RejectSignRequest(ctx, c.Param("requestId"), body.Reason)
+	if err != nil {
+		handler.signRequestError(c, "Failed rejecting sign request", err)
+		return
+	}
+	types.LoggerFromContext(ctx, handler.logger).Info("Sign request rejected", "ca_id", httpWrapper.caId, "sign_request_id", signRequest.Id)
+	c.JSON(http.StatusOK, signRequest)
+}
+
+func (handler *HandlerType) signRequestError(c *gin.Context, msg string, err error) {
+	switch {
+	case errors.Is(err, caissuingprocess.ErrUnknownSignRequest):
+		c.JSON(http.StatusNotFound, gin.H{"error": "sign request not found"})
+	case errors.Is(err, caissuingprocess.ErrSignRequestNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, caissuingprocess.ErrQuotaExceeded):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, caissuingprocess.ErrInvalidCsr):
+		writeInvalidCsr(c, err)
+	default:
+		types.LoggerFromContext(c.Request.Context(), handler.logger).Error(msg, "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in handling sign request"})
+	}
+}
@@ -0,0 +1,163 @@
+package webserver_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func signRequestTestCsr(t *testing.T, commonName string) []byte {
+	t.Helper()
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+}
+
+func signRequestTestCall(t *testing.T, h http.Handler, method string, path string, body []byte, response any) *http.Response {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(method, path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(rr, req)
+	if response != nil && rr.Code < 300 {
+		if err := json.Unmarshal(rr.Body.Bytes(), response); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, path, err, rr.Body.String())
+		}
+	}
+	return rr.Result()
+}
+
+func TestSignRequestApproval(t *testing.T) {
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var opaRequest struct {
+			Input map[string]string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&opaRequest); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if opaRequest.Input["profile"] == "" {
+			w.Write([]byte(`{"result": {"allow": true, "pending": true}}`))
+		} else {
+			w.Write([]byte(`{"result": true}`))
+		}
+	}))
+	defer opaServer.Close()
+	adminOpaUrl := opaServer.URL
+
+	caConfig := reloadTestCaConfig(opaServer.URL, 2048)
+	caConfig.Profiles = map[string]types.ProfileType{
+		"server": {ApprovalRequired: true},
+		"client": {},
+	}
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		&types.StdLogger{},
+		types.ConfigFileType{
+			DataDirectory: t.TempDir(),
+			HttpServer: &types.HttpServerType{
+				OpaUrlAdmin: &adminOpaUrl,
+			},
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"test_ca_1": caConfig,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := signRequestTestCall(t, h, http.MethodPost, "/ca/test_ca_1/csr/sign?profile=unknown", signRequestTestCsr(t, "a.example.com"), nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown profile: expected 400, got %d", resp.StatusCode)
+	}
+	if resp := signRequestTestCall(t, h, http.MethodPost, "/ca/test_ca_1/csr/sign?profile=client", signRequestTestCsr(t, "a.example.com"), nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("profile without approval: expected 200, got %d", resp.StatusCode)
+	}
+
+	type signRequestType struct {
+		Id          string `json:"id"`
+		Status      string `json:"status"`
+		Serial      string `json:"serial"`
+		Reason      string `json:"reason"`
+		Certificate string `json:"certificate"`
+	}
+	submit := func(path string, commonName string) signRequestType {
+		t.Helper()
+		var submitted signRequestType
+		resp := signRequestTestCall(t, h, http.MethodPost, path, signRequestTestCsr(t, commonName), &submitted)
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("%s: expected 202, got %d", path, resp.StatusCode)
+		}
+		if location := resp.Header.Get("Location"); location != "/ca/test_ca_1/requests/"+submitted.Id {
+			t.Fatalf("unexpected location %q", location)
+		}
+		if submitted.Status != "pending" {
+			t.Fatalf("unexpected status %q", submitted.Status)
+		}
+		return submitted
+	}
+	// pending because of OPA, then because of the profile
+	byOpa := submit("/ca/test_ca_1/csr/sign", "b.example.com")
+	byProfile := submit("/ca/test_ca_1/csr/sign?profile=server", "c.example.com")
+
+	var polled signRequestType
+	if resp := signRequestTestCall(t, h, http.MethodGet, "/ca/test_ca_1/requests/"+byOpa.Id, nil, &polled); resp.StatusCode != http.StatusOK || polled.Status != "pending" {
+		t.Fatalf("expected pending request, got %d %#v", resp.StatusCode, polled)
+	}
+	if resp := signRequestTestCall(t, h, http.MethodGet, "/ca/test_ca_1/requests/00000000000000000000000000000000", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown request: expected 404, got %d", resp.StatusCode)
+	}
+
+	var pending []signRequestType
+	if resp := signRequestTestCall(t, h, http.MethodGet, "/admin/ca/test_ca_1/requests", nil, &pending); resp.StatusCode != http.StatusOK || len(pending) != 2 {
+		t.Fatalf("expected 2 pending requests, got %d %#v", resp.StatusCode, pending)
+	}
+
+	if resp := signRequestTestCall(t, h, http.MethodPost, "/admin/ca/test_ca_1/requests/"+byOpa.Id+"/approve", nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("approve: expected 200, got %d", resp.StatusCode)
+	}
+	if resp := signRequestTestCall(t, h, http.MethodPost, "/admin/ca/test_ca_1/requests/"+byOpa.Id+"/approve", nil, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("approve twice: expected 409, got %d", resp.StatusCode)
+	}
+	if resp := signRequestTestCall(t, h, http.MethodGet, "/ca/test_ca_1/requests/"+byOpa.Id, nil, &polled); resp.StatusCode != http.StatusOK || polled.Status != "approved" {
+		t.Fatalf("expected approved request, got %d %#v", resp.StatusCode, polled)
+	}
+	certificate, err := pemhelper.FromPemToCertificate([]byte(polled.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certificate.Subject.CommonName != "b.example.com" || certificate.SerialNumber.String() != polled.Serial {
+		t.Fatalf("unexpected certificate %s %s", certificate.Subject, certificate.SerialNumber)
+	}
+
+	if resp := signRequestTestCall(t, h, http.MethodPost, "/admin/ca/test_ca_1/requests/"+byProfile.Id+"/reject", []byte(`{"reason": "not ours"}`), nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("reject: expected 200, got %d", resp.StatusCode)
+	}
+	var rejected signRequestType
+	if resp := signRequestTestCall(t, h, http.MethodGet, "/ca/test_ca_1/requests/"+byProfile.Id, nil, &rejected); resp.StatusCode != http.StatusOK || rejected.Status != "rejected" || rejected.Reason != "not ours" || rejected.Certificate != "" {
+		t.Fatalf("expected rejected request, got %d %#v", resp.StatusCode, rejected)
+	}
+}
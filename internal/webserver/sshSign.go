@@ -0,0 +1,99 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tomaluca95/simple-ca/internal/auditlog"
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/metrics"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+var ErrApprovalNotSupported = errors.New("approval not supported for this request")
+
+func (httpWrapper *httpWrapperType) SshCaPub(c *gin.Context) {
+	fileContent, err := httpWrapper.oneCa.GetSshPublicKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in getting SSH public key"})
+		return
+	}
+	c.Writer.Write(fileContent)
+}
+
+func (httpWrapper *httpWrapperType) SshKrl(c *gin.Context) {
+	fileContent, err := httpWrapper.oneCa.GetKrl()
+	if err != nil || fileContent == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in getting KRL"})
+		return
+	}
+	c.Data(http.StatusOK, "application/octet-stream", fileContent)
+}
+
+func (httpWrapper *httpWrapperType) SshSign(c *gin.Context) {
+	logger := types.LoggerFromContext(c.Request.Context(), httpWrapper.logger)
+	if httpWrapper.throttle(c) {
+		return
+	}
+	defer c.Request.Body.Close()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 32*1024)
+	requestContent, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unexpected error in signing SSH key (reading request content)"})
+		return
+	}
+	var sshSignRequest caissuingprocess.SshSignRequestType
+	if err := json.Unmarshal(requestContent, &sshSignRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	opaDecision, err := httpWrapper.opaWrapper(c.Request.Context(), "ssh_sign", httpWrapper.OpaUrlSign, map[string]string{
+		"remote_addr":   c.Request.RemoteAddr,
+		"authorization": c.GetHeader("Authorization"),
+		"ssh_request":   string(requestContent),
+		"public_key":    sshSignRequest.PublicKey,
+		"key_id":        sshSignRequest.KeyId,
+		"principals":    strings.Join(sshSignRequest.Principals, ","),
+		"cert_type":     sshSignRequest.CertType,
+		"validity":      sshSignRequest.Validity,
+	})
+	ctx := requesterContext(c, opaDecision, err)
+	if err == nil && opaDecision.Pending {
+		err = ErrApprovalNotSupported
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotAuthorized) || errors.Is(err, ErrApprovalNotSupported) {
+			logger.Info("OPA denied the SSH sign request", "err", err)
+			metrics.SignDeniedTotal.WithLabelValues(httpWrapper.caId).Inc()
+			httpWrapper.oneCa.AuditRecord(ctx, auditlog.OperationSign, auditlog.ResultDenied, nil, err)
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		} else {
+			logger.Error("OPA authorization check failed", "err", err)
+			httpWrapper.oneCa.AuditRecord(ctx, auditlog.OperationSign, auditlog.ResultError, nil, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "unexpected error in authorization check"})
+			return
+		}
+	}
+	if httpWrapper.throttleIdentity(c, opaDecision.Identity) {
+		return
+	}
+
+	sshCertificate, err := httpWrapper.oneCa.SignSshPublicKey(ctx, sshSignRequest)
+	if err != nil {
+		if errors.Is(err, caissuingprocess.ErrInvalidSshSignRequest) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Error("Failed signing SSH key", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in signing SSH key"})
+		return
+	}
+
+	c.Writer.Write(sshCertificate)
+}
@@ -0,0 +1,130 @@
+package webserver_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSshSign(t *testing.T) {
+	opaInputs := []map[string]string{}
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var opaRequest struct {
+			Input map[string]string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&opaRequest); err != nil {
+			t.Error(err)
+		}
+		opaInputs = append(opaInputs, opaRequest.Input)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer opaServer.Close()
+
+	caConfig := reloadTestCaConfig(opaServer.URL, 2048)
+	caConfig.Kind = types.CaKindSsh
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		&types.StdLogger{},
+		types.ConfigFileType{
+			DataDirectory: t.TempDir(),
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"test_ssh_ca": caConfig,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method string, path string, body []byte) (int, []byte) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.ServeHTTP(rr, req)
+		responseBody, err := io.ReadAll(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Code, responseBody
+	}
+
+	for _, path := range []string{"/ca/test_ssh_ca/issuer.pem", "/ca/test_ssh_ca/crt/crl.pem"} {
+		if statusCode, _ := call(http.MethodGet, path, nil); statusCode != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", path, statusCode)
+		}
+	}
+
+	statusCode, caPublicKeyContent := call(http.MethodGet, "/ca/test_ssh_ca/ssh/ca.pub", nil)
+	if statusCode != http.StatusOK {
+		t.Fatalf("ca.pub: expected 200, got %d", statusCode)
+	}
+	caPublicKey, _, _, _, err := ssh.ParseAuthorizedKey(caPublicKeyContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestContent, err := json.Marshal(map[string]any{
+		"public_key": string(ssh.MarshalAuthorizedKey(sshPublicKey)),
+		"key_id":     "web01",
+		"principals": []string{"web01.example.com", "web01"},
+		"cert_type":  "host",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode, _ := call(http.MethodPost, "/ca/test_ssh_ca/ssh/sign", []byte(`{"public_key": "x"}`)); statusCode != http.StatusBadRequest {
+		t.Fatalf("invalid request: expected 400, got %d", statusCode)
+	}
+	statusCode, certificateContent := call(http.MethodPost, "/ca/test_ssh_ca/ssh/sign", requestContent)
+	if statusCode != http.StatusOK {
+		t.Fatalf("sign: expected 200, got %d %s", statusCode, certificateContent)
+	}
+	if opaInput := opaInputs[len(opaInputs)-1]; opaInput["principals"] != "web01.example.com,web01" || opaInput["cert_type"] != "host" {
+		t.Fatalf("unexpected OPA input %v", opaInput)
+	}
+	certificatePublicKey, _, _, _, err := ssh.ParseAuthorizedKey(certificateContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshCertificate := certificatePublicKey.(*ssh.Certificate)
+	certChecker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			return bytes.Equal(auth.Marshal(), caPublicKey.Marshal())
+		},
+	}
+	if err := certChecker.CheckHostKey("web01.example.com:22", nil, sshCertificate); err != nil {
+		t.Fatal(err)
+	}
+
+	_, krlBefore := call(http.MethodGet, "/ca/test_ssh_ca/ssh/krl", nil)
+	if statusCode, _ := call(http.MethodPost, "/ca/test_ssh_ca/crt/revoke/"+strconv.FormatUint(sshCertificate.Serial, 10), nil); statusCode != http.StatusAccepted {
+		t.Fatalf("revoke: expected 202, got %d", statusCode)
+	}
+	statusCode, krl := call(http.MethodGet, "/ca/test_ssh_ca/ssh/krl", nil)
+	if statusCode != http.StatusOK || bytes.Equal(krl, krlBefore) {
+		t.Fatalf("expected updated KRL, got %d", statusCode)
+	}
+}
@@ -0,0 +1,107 @@
+package webserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func TestSignCsrSubjectTemplate(t *testing.T) {
+	var mu sync.Mutex
+	shownSubjects := []string{}
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input map[string]string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		shownSubjects = append(shownSubjects, body.Input["subject"])
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		// the subject built with the values is refused for another team
+		allow := body.Input["subject"] != "CN=www.example.com,OU=team-b"
+		json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{
+			"allow":          allow,
+			"subject_values": map[string]string{"team": body.Input["authorization"]},
+		}})
+	}))
+	defer opaServer.Close()
+
+	caConfig := reloadTestCaConfig(opaServer.URL, 2048)
+	caConfig.Profiles = map[string]types.ProfileType{
+		"team": {Subject: &types.SubjectPolicyType{
+			Template: &types.CertificateAuthoritySubjectType{
+				CommonName:         "{{ .Csr.CommonName }}",
+				OrganizationalUnit: []string{"{{ .Values.team }}"},
+			},
+		}},
+		"fixed": {Subject: &types.SubjectPolicyType{
+			AllowedAttributes: []string{"common_name"},
+		}},
+	}
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		&types.StdLogger{},
+		types.ConfigFileType{
+			DataDirectory: t.TempDir(),
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"test_ca": caConfig,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(profile string, authorization string) *httptest.ResponseRecorder {
+		t.Helper()
+		mu.Lock()
+		shownSubjects = []string{}
+		mu.Unlock()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodPost, "http://ca.example.com/ca/test_ca/csr/sign?profile="+profile, bytes.NewReader(signRequestTestCsr(t, "www.example.com")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", authorization)
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := call("team", "team-a")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	crt, err := pemhelper.FromPemToCertificate(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crt.Subject.String() != "CN=www.example.com,OU=team-a" {
+		t.Fatalf("unexpected subject %q", crt.Subject.String())
+	}
+	if len(shownSubjects) != 2 || shownSubjects[0] != "" || shownSubjects[1] != crt.Subject.String() {
+		t.Fatalf("the subject of the certificate was not shown to OPA: %q", shownSubjects)
+	}
+
+	if rr := call("team", "team-b"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for the subject refused by OPA, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// without a template the subject is shown in the only query
+	if rr := call("fixed", "team-a"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	if len(shownSubjects) != 1 || shownSubjects[0] != "CN=www.example.com" {
+		t.Fatalf("unexpected subjects shown to OPA: %q", shownSubjects)
+	}
+}
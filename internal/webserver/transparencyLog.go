@@ -0,0 +1,124 @@
+package webserver
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/merkletree"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+// maxTransparencyLogEntries is the most entries answered by get-entries, a
+// client asks again from the first entry it did not get.
+const maxTransparencyLogEntries = 1000
+
+// The answers of the log client messages of RFC 6962 section 4, binary values
+// are base64 encoded.
+type signedTreeHeadResponseType struct {
+	TreeSize          uint64 `json:"tree_size"`
+	Timestamp         uint64 `json:"timestamp"`
+	Sha256RootHash    []byte `json:"sha256_root_hash"`
+	TreeHeadSignature []byte `json:"tree_head_signature"`
+}
+
+type transparencyLogEntryResponseType struct {
+	LeafInput []byte `json:"leaf_input"`
+	Serial    string `json:"serial"`
+}
+
+func (httpWrapper *httpWrapperType) TransparencyLogSth(c *gin.Context) {
+	treeHead, err := httpWrapper.oneCa.TransparencyLogSignedTreeHead()
+	if err != nil {
+		httpWrapper.transparencyLogError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, signedTreeHeadResponseType{
+		TreeSize:          treeHead.TreeSize,
+		Timestamp:         treeHead.Timestamp,
+		Sha256RootHash:    treeHead.RootHash,
+		TreeHeadSignature: treeHead.Signature,
+	})
+}
+
+func (httpWrapper *httpWrapperType) TransparencyLogSthConsistency(c *gin.Context) {
+	first, firstOk := uintQuery(c, "first")
+	second, secondOk := uintQuery(c, "second")
+	if !firstOk || !secondOk {
+		return
+	}
+	proof, err := httpWrapper.oneCa.TransparencyLogConsistencyProof(first, second)
+	if err != nil {
+		httpWrapper.transparencyLogError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"consistency": proof})
+}
+
+func (httpWrapper *httpWrapperType) TransparencyLogProofByHash(c *gin.Context) {
+	leafHash, err := base64.StdEncoding.DecodeString(c.Query("hash"))
+	if err != nil || len(leafHash) != 32 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hash must be a base64 encoded SHA-256 leaf hash"})
+		return
+	}
+	treeSize, treeSizeOk := uintQuery(c, "tree_size")
+	if !treeSizeOk {
+		return
+	}
+	leafIndex, proof, err := httpWrapper.oneCa.TransparencyLogInclusionProof(leafHash, treeSize)
+	if err != nil {
+		httpWrapper.transparencyLogError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"leaf_index": leafIndex, "audit_path": proof})
+}
+
+func (httpWrapper *httpWrapperType) TransparencyLogEntries(c *gin.Context) {
+	start, startOk := uintQuery(c, "start")
+	end, endOk := uintQuery(c, "end")
+	if !startOk || !endOk {
+		return
+	}
+	if end >= start && end-start >= maxTransparencyLogEntries {
+		end = start + maxTransparencyLogEntries - 1
+	}
+	entries, err := httpWrapper.oneCa.TransparencyLogEntries(start, end)
+	if err != nil {
+		httpWrapper.transparencyLogError(c, err)
+		return
+	}
+	responseEntries := []transparencyLogEntryResponseType{}
+	for _, entry := range entries {
+		responseEntries = append(responseEntries, transparencyLogEntryResponseType{
+			LeafInput: entry.LeafInput,
+			Serial:    entry.Serial.String(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": responseEntries})
+}
+
+func (httpWrapper *httpWrapperType) transparencyLogError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, merkletree.ErrInvalidTreeSize):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, caissuingprocess.ErrUnknownLeaf):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		types.LoggerFromContext(c.Request.Context(), httpWrapper.logger).Error("Failed reading transparency log", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in reading transparency log"})
+	}
+}
+
+// uintQuery parses a required query parameter, answering 400 when it is not
+// a number.
+func uintQuery(c *gin.Context, name string) (uint64, bool) {
+	value, err := strconv.ParseUint(c.Query(name), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a non negative integer"})
+		return 0, false
+	}
+	return value, true
+}
@@ -0,0 +1,135 @@
+package webserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/tomaluca95/simple-ca/internal/merkletree"
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func TestTransparencyLog(t *testing.T) {
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer opaServer.Close()
+
+	caConfig := reloadTestCaConfig(opaServer.URL, 2048)
+	caConfig.TransparencyLog = &types.TransparencyLogType{EmbedSct: true}
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		&types.StdLogger{},
+		types.ConfigFileType{
+			DataDirectory: t.TempDir(),
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"ca": caConfig,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method string, path string, body []byte, response any) int {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.ServeHTTP(rr, req)
+		if rr.Code == http.StatusOK && response != nil {
+			if err := json.Unmarshal(rr.Body.Bytes(), response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code
+	}
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/ca/ca/csr/sign", bytes.NewReader(signRequestTestCsr(t, "www.example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	certificate, err := pemhelper.FromPemToCertificate(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var treeHead struct {
+		TreeSize          uint64 `json:"tree_size"`
+		Sha256RootHash    []byte `json:"sha256_root_hash"`
+		TreeHeadSignature []byte `json:"tree_head_signature"`
+	}
+	if code := call(http.MethodGet, "/ca/ca/ct/v1/get-sth", nil, &treeHead); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if treeHead.TreeSize != 2 || len(treeHead.Sha256RootHash) != 32 || len(treeHead.TreeHeadSignature) == 0 {
+		t.Fatalf("unexpected tree head %#v", treeHead)
+	}
+
+	var entries struct {
+		Entries []struct {
+			LeafInput []byte `json:"leaf_input"`
+			Serial    string `json:"serial"`
+		} `json:"entries"`
+	}
+	if code := call(http.MethodGet, "/ca/ca/ct/v1/get-entries?start=0&end=9", nil, &entries); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(entries.Entries) != 2 || entries.Entries[1].Serial != certificate.SerialNumber.String() {
+		t.Fatalf("unexpected entries %#v", entries)
+	}
+
+	leafHash := merkletree.LeafHash(entries.Entries[1].LeafInput)
+	var inclusion struct {
+		LeafIndex uint64   `json:"leaf_index"`
+		AuditPath [][]byte `json:"audit_path"`
+	}
+	proofPath := "/ca/ca/ct/v1/get-proof-by-hash?tree_size=2&hash=" + url.QueryEscape(base64.StdEncoding.EncodeToString(leafHash))
+	if code := call(http.MethodGet, proofPath, nil, &inclusion); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if err := merkletree.VerifyInclusion(inclusion.LeafIndex, treeHead.TreeSize, leafHash, inclusion.AuditPath, treeHead.Sha256RootHash); err != nil {
+		t.Fatal(err)
+	}
+
+	var consistency struct {
+		Consistency [][]byte `json:"consistency"`
+	}
+	if code := call(http.MethodGet, "/ca/ca/ct/v1/get-sth-consistency?first=1&second=2", nil, &consistency); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	firstRootHash := merkletree.LeafHash(entries.Entries[0].LeafInput)
+	if err := merkletree.VerifyConsistency(1, 2, firstRootHash, treeHead.Sha256RootHash, consistency.Consistency); err != nil {
+		t.Fatal(err)
+	}
+
+	unknownHash := url.QueryEscape(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	for path, expectedCode := range map[string]int{
+		"/ca/ca/ct/v1/get-proof-by-hash?tree_size=2&hash=" + unknownHash: http.StatusNotFound,
+		"/ca/ca/ct/v1/get-proof-by-hash?tree_size=3&hash=" + unknownHash: http.StatusBadRequest,
+		"/ca/ca/ct/v1/get-proof-by-hash?tree_size=2&hash=abc":            http.StatusBadRequest,
+		"/ca/ca/ct/v1/get-sth-consistency?first=0&second=2":              http.StatusBadRequest,
+		"/ca/ca/ct/v1/get-sth-consistency?first=1":                       http.StatusBadRequest,
+		"/ca/ca/ct/v1/get-entries?start=5&end=9":                         http.StatusBadRequest,
+		"/ca/other/ct/v1/get-sth":                                        http.StatusNotFound,
+	} {
+		if code := call(http.MethodGet, path, nil, nil); code != expectedCode {
+			t.Fatalf("%s: expected %d, got %d", path, expectedCode, code)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package webserver
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tomaluca95/simple-ca/internal/auditlog"
+	"github.com/tomaluca95/simple-ca/internal/caissuingprocess"
+	"github.com/tomaluca95/simple-ca/internal/metrics"
+	"github.com/tomaluca95/simple-ca/internal/types"
+)
+
+const (
+	contentTypeTimestampQuery = "application/timestamp-query"
+	contentTypeTimestampReply = "application/timestamp-reply"
+)
+
+func (httpWrapper *httpWrapperType) TsaCertificate(c *gin.Context) {
+	if httpWrapper.OpaUrlTimestamp == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": caissuingprocess.ErrTsaNotEnabled.Error()})
+		return
+	}
+	fileContent, err := httpWrapper.oneCa.GetTsaCertificatePem()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in getting TSA certificate"})
+		return
+	}
+	c.Writer.Write(fileContent)
+}
+
+// Timestamp implements the HTTP transport of RFC 3161, a query that the TSA
+// rejects is still answered with 200 and a TimeStampResp.
+func (httpWrapper *httpWrapperType) Timestamp(c *gin.Context) {
+	logger := types.LoggerFromContext(c.Request.Context(), httpWrapper.logger)
+	if httpWrapper.OpaUrlTimestamp == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": caissuingprocess.ErrTsaNotEnabled.Error()})
+		return
+	}
+	if httpWrapper.throttle(c) {
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type")); err != nil || mediaType != contentTypeTimestampQuery {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "content type must be " + contentTypeTimestampQuery})
+		return
+	}
+	defer c.Request.Body.Close()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 32*1024)
+	queryContent, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unexpected error in time-stamping (reading request content)"})
+		return
+	}
+
+	opaDecision, err := httpWrapper.opaWrapper(c.Request.Context(), "timestamp", httpWrapper.OpaUrlTimestamp, map[string]string{
+		"remote_addr":   c.Request.RemoteAddr,
+		"authorization": c.GetHeader("Authorization"),
+	})
+	ctx := requesterContext(c, opaDecision, err)
+	if err == nil && opaDecision.Pending {
+		err = ErrApprovalNotSupported
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotAuthorized) || errors.Is(err, ErrApprovalNotSupported) {
+			logger.Info("OPA denied the time-stamp request", "err", err)
+			metrics.SignDeniedTotal.WithLabelValues(httpWrapper.caId).Inc()
+			httpWrapper.oneCa.AuditRecord(ctx, auditlog.OperationTimestamp, auditlog.ResultDenied, nil, err)
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		} else {
+			logger.Error("OPA authorization check failed", "err", err)
+			httpWrapper.oneCa.AuditRecord(ctx, auditlog.OperationTimestamp, auditlog.ResultError, nil, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "unexpected error in authorization check"})
+			return
+		}
+	}
+	if httpWrapper.throttleIdentity(c, opaDecision.Identity) {
+		return
+	}
+
+	responseContent, err := httpWrapper.oneCa.Timestamp(ctx, queryContent)
+	if err != nil {
+		if errors.Is(err, caissuingprocess.ErrInvalidTimestampQuery) && responseContent != nil {
+			logger.Info("Rejected time-stamp query", "err", err)
+			c.Data(http.StatusOK, contentTypeTimestampReply, responseContent)
+			return
+		}
+		logger.Error("Failed time-stamping", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error in time-stamping"})
+		return
+	}
+	c.Data(http.StatusOK, contentTypeTimestampReply, responseContent)
+}
@@ -0,0 +1,133 @@
+package webserver_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tomaluca95/simple-ca/internal/pemhelper"
+	"github.com/tomaluca95/simple-ca/internal/types"
+	"github.com/tomaluca95/simple-ca/internal/webserver"
+)
+
+func TestTimestamp(t *testing.T) {
+	allowed := true
+	opaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if allowed {
+			w.Write([]byte(`{"result": true}`))
+		} else {
+			w.Write([]byte(`{"result": false}`))
+		}
+	}))
+	defer opaServer.Close()
+
+	caConfig := reloadTestCaConfig(opaServer.URL, 2048)
+	// the CA of one day covers a TSA certificate
+	caConfig.ExpiryWarning = time.Hour
+	caConfig.Tsa = &types.TsaConfigType{
+		Policy: "1.3.6.1.4.1.99999.1",
+		OpaUrl: &opaServer.URL,
+	}
+	h, err := webserver.CreateHandler(
+		context.Background(),
+		&types.StdLogger{},
+		types.ConfigFileType{
+			DataDirectory: t.TempDir(),
+			AllCaConfigs: map[string]types.CertificateAuthorityType{
+				"test_ca": caConfig,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method string, path string, contentType string, body []byte) (int, string, []byte) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		h.ServeHTTP(rr, req)
+		responseBody, err := io.ReadAll(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Code, rr.Header().Get("Content-Type"), responseBody
+	}
+
+	statusCode, _, tsaCertificatePem := call(http.MethodGet, "/ca/test_ca/tsa/certificate.pem", "", nil)
+	if statusCode != http.StatusOK {
+		t.Fatalf("TSA certificate: expected 200, got %d", statusCode)
+	}
+	if _, err := pemhelper.FromPemToCertificate(tsaCertificatePem); err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte("artifact"))
+	queryContent, err := asn1.Marshal(struct {
+		Version        int
+		MessageImprint struct {
+			HashAlgorithm pkix.AlgorithmIdentifier
+			HashedMessage []byte
+		}
+	}{
+		Version: 1,
+		MessageImprint: struct {
+			HashAlgorithm pkix.AlgorithmIdentifier
+			HashedMessage []byte
+		}{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}},
+			HashedMessage: digest[:],
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if statusCode, _, _ := call(http.MethodPost, "/ca/test_ca/tsa", "application/octet-stream", queryContent); statusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("wrong content type: expected 415, got %d", statusCode)
+	}
+	statusCode, contentType, responseContent := call(http.MethodPost, "/ca/test_ca/tsa", "application/timestamp-query", queryContent)
+	if statusCode != http.StatusOK || contentType != "application/timestamp-reply" {
+		t.Fatalf("time-stamp: expected 200 application/timestamp-reply, got %d %s", statusCode, contentType)
+	}
+	var response struct {
+		Status struct {
+			Status int
+		}
+		TimeStampToken asn1.RawValue `asn1:"optional"`
+	}
+	if _, err := asn1.Unmarshal(responseContent, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Status.Status != 0 || len(response.TimeStampToken.FullBytes) == 0 {
+		t.Fatalf("time-stamp not granted: %d", response.Status.Status)
+	}
+
+	// a query that the TSA cannot parse is answered with a rejection
+	statusCode, _, responseContent = call(http.MethodPost, "/ca/test_ca/tsa", "application/timestamp-query", []byte("not DER"))
+	if statusCode != http.StatusOK {
+		t.Fatalf("invalid query: expected 200, got %d", statusCode)
+	}
+	if _, err := asn1.Unmarshal(responseContent, &response); err != nil || response.Status.Status != 2 {
+		t.Fatalf("invalid query: expected rejection, got %d %v", response.Status.Status, err)
+	}
+
+	allowed = false
+	if statusCode, _, _ := call(http.MethodPost, "/ca/test_ca/tsa", "application/timestamp-query", queryContent); statusCode != http.StatusForbidden {
+		t.Fatalf("denied: expected 403, got %d", statusCode)
+	}
+}
@@ -1,54 +1,65 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"log"
 	"net"
-	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/tomaluca95/simple-ca/internal/configfile"
 	"github.com/tomaluca95/simple-ca/internal/mainprocess"
 	"github.com/tomaluca95/simple-ca/internal/types"
 	"github.com/tomaluca95/simple-ca/internal/webserver"
-	"gopkg.in/yaml.v3"
 )
 
-const exitCodeOperationalFailure = 2
+const (
+	exitCodeInvalidConfig      = 1
+	exitCodeOperationalFailure = 2
+)
 
-func fatalExit(format string, a ...any) {
-	log.Printf("level=error "+format, a...)
+func fatalExit(logger types.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
 	os.Exit(exitCodeOperationalFailure)
 }
 
 func main() {
-	logger := &types.StdLogger{}
+	var logger types.Logger = &types.StdLogger{}
 	ctx := context.Background()
 	configFilename := "config.yml"
 	if configFilenameOverride, overrideDone := os.LookupEnv("SIMPLE_CLI_CA_CONFIG_FILENAME"); overrideDone {
 		configFilename = configFilenameOverride
 	}
-	configFile := types.ConfigFileType{}
+	if len(os.Args) >= 2 && os.Args[1] == "config" {
+		runConfigCommand(logger, configFilename, os.Args[2:])
+		return
+	}
+	configFile, err := configfile.Read(configFilename)
+	if err != nil {
+		fatalExit(logger, "failed reading config file", "config_file", configFilename, "err", err)
+	}
 	{
-		configFileBytes, err := os.ReadFile(configFilename)
+		configuredLogger, err := types.NewLogger(os.Stderr, configFile.Log)
 		if err != nil {
-			fatalExit("msg=%q config_file=%q err=%v", "failed reading config file", configFilename, err)
+			fatalExit(logger, "invalid log configuration", "config_file", configFilename, "err", err)
 		}
-		decoder := yaml.NewDecoder(bytes.NewReader(configFileBytes))
-		decoder.KnownFields(true)
-		if err := decoder.Decode(&configFile); err != nil {
-			fatalExit("msg=%q config_file=%q err=%v", "failed parsing config file", configFilename, err)
+		logger = configuredLogger
+		gin.DebugPrintFunc = func(format string, values ...any) {
+			logger.Debug(strings.TrimSpace(fmt.Sprintf(format, values...)), "component", "gin")
 		}
 	}
 
 	if len(os.Args) == 1 {
 		if err := mainprocess.RunWithConfigFileData(ctx, logger, configFile); err != nil {
-			fatalExit("msg=%q err=%v", "main process failed", err)
+			fatalExit(logger, "main process failed", "err", err)
 		}
 	} else if len(os.Args) == 2 && os.Args[1] == "http" {
 		if configFile.HttpServer == nil {
-			fatalExit("msg=%q", "missing http_server block")
+			fatalExit(logger, "missing http_server block")
 		}
 		netListen, err := net.Listen("tcp",
 			fmt.Sprintf(
@@ -59,24 +70,112 @@ func main() {
 		)
 		if err != nil {
 			fatalExit(
-				"msg=%q listen_address=%q listen_port=%d err=%v",
+				logger,
 				"failed opening HTTP listener",
-				configFile.HttpServer.ListenAddress,
-				configFile.HttpServer.ListenPort,
-				err,
+				"listen_address", configFile.HttpServer.ListenAddress,
+				"listen_port", configFile.HttpServer.ListenPort,
+				"err", err,
 			)
 		}
 		defer netListen.Close()
 
 		httpHandler, err := webserver.CreateHandler(ctx, logger, configFile)
 		if err != nil {
-			fatalExit("msg=%q err=%v", "failed creating HTTP handler", err)
+			fatalExit(logger, "failed creating HTTP handler", "err", err)
 		}
+		httpHandler.SetConfigLoader(func() (types.ConfigFileType, error) {
+			return configfile.Read(configFilename)
+		})
+		go reloadOnSighup(ctx, logger, httpHandler)
 
-		if err := http.Serve(netListen, httpHandler); err != nil {
-			fatalExit("msg=%q err=%v", "http server stopped with error", err)
+		serveCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := webserver.Serve(serveCtx, logger, netListen, httpHandler, *configFile.HttpServer); err != nil {
+			fatalExit(logger, "http server stopped with error", "err", err)
+		}
+	} else if len(os.Args) == 3 && os.Args[1] == "audit" && os.Args[2] == "verify" {
+		if err := mainprocess.VerifyAuditLogs(ctx, logger, configFile); err != nil {
+			fatalExit(logger, "audit log verification failed", "err", err)
+		}
+	} else if len(os.Args) == 3 && os.Args[1] == "history" {
+		if err := mainprocess.PrintHistory(ctx, logger, configFile, os.Args[2], os.Stdout); err != nil {
+			fatalExit(logger, "failed listing history", "ca_id", os.Args[2], "err", err)
+		}
+	} else if len(os.Args) == 5 && os.Args[1] == "restore" && os.Args[3] == "--to" {
+		if err := mainprocess.RestoreCa(ctx, logger, configFile, os.Args[2], os.Args[4]); err != nil {
+			fatalExit(logger, "restore failed", "ca_id", os.Args[2], "revision", os.Args[4], "err", err)
+		}
+	} else if len(os.Args) == 4 && os.Args[1] == "requests" && os.Args[2] == "list" {
+		if err := mainprocess.PrintSignRequests(ctx, logger, configFile, os.Args[3], os.Stdout); err != nil {
+			fatalExit(logger, "failed listing sign requests", "ca_id", os.Args[3], "err", err)
+		}
+	} else if len(os.Args) == 5 && os.Args[1] == "requests" && os.Args[2] == "approve" {
+		if err := mainprocess.ApproveSignRequest(ctx, logger, configFile, os.Args[3], os.Args[4]); err != nil {
+			fatalExit(logger, "failed approving sign request", "ca_id", os.Args[3], "sign_request_id", os.Args[4], "err", err)
+		}
+	} else if (len(os.Args) == 5 || len(os.Args) == 6) && os.Args[1] == "requests" && os.Args[2] == "reject" {
+		reason := ""
+		if len(os.Args) == 6 {
+			reason = os.Args[5]
+		}
+		if err := mainprocess.RejectSignRequest(ctx, logger, configFile, os.Args[3], os.Args[4], reason); err != nil {
+			fatalExit(logger, "failed rejecting sign request", "ca_id", os.Args[3], "sign_request_id", os.Args[4], "err", err)
+		}
+	} else if (len(os.Args) == 3 || len(os.Args) == 4) && os.Args[1] == "spool" && os.Args[2] == "watch" {
+		interval := 5 * time.Second
+		if len(os.Args) == 4 {
+			interval, err = time.ParseDuration(os.Args[3])
+			if err != nil {
+				fatalExit(logger, "invalid spool watch interval", "interval", os.Args[3], "err", err)
+			}
+		}
+		watchCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := mainprocess.WatchCsrSpool(watchCtx, logger, configFile, interval); err != nil {
+			fatalExit(logger, "spool watch failed", "err", err)
 		}
 	} else {
-		fatalExit("msg=%q args=%v", "invalid arguments", os.Args)
+		fatalExit(logger, "invalid arguments", "args", os.Args)
+	}
+}
+
+func reloadOnSighup(ctx context.Context, logger types.Logger, httpHandler *webserver.HandlerType) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	for range sighup {
+		if err := httpHandler.Reload(ctx); err != nil {
+			logger.Error("configuration reload failed, keeping the current configuration", "err", err)
+			continue
+		}
+		logger.Info("configuration reloaded")
+	}
+}
+
+// runConfigCommand runs the commands that work on the configuration file
+// itself, they do not need it to be valid.
+func runConfigCommand(logger types.Logger, configFilename string, args []string) {
+	if len(args) == 2 && args[0] == "validate" {
+		configFilename = args[1]
+	} else if len(args) == 1 && args[0] == "schema" {
+		schemaBytes, err := configfile.Schema()
+		if err != nil {
+			fatalExit(logger, "failed generating schema", "err", err)
+		}
+		os.Stdout.Write(schemaBytes)
+		return
+	} else if len(args) != 1 || args[0] != "validate" {
+		fatalExit(logger, "invalid arguments", "args", os.Args)
+	}
+
+	_, problems, err := configfile.Check(configFilename)
+	if err != nil {
+		fatalExit(logger, "failed reading config file", "config_file", configFilename, "err", err)
+	}
+	for _, problem := range problems {
+		fmt.Println(problem.String())
+	}
+	if len(problems) > 0 {
+		os.Exit(exitCodeInvalidConfig)
 	}
+	fmt.Printf("%s: valid\n", configFilename)
 }